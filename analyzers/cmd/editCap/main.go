@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 
 	"github.com/tony-507/analyzers/src/controller"
 	"github.com/tony-507/analyzers/src/tttKernel"
@@ -27,16 +28,34 @@ func main() {
 
 	readerBuilder:= controller.NewPluginBuilder()
 	readerBuilder.SetName("InputReader_0")
-	readerBuilder.SetProperty("Uri", controller.NewProperty(addr))
-	readerBuilder.SetProperty("Protocols", controller.NewProperty("TS"))
-	readerBuilder.SetProperty("SkipCnt", controller.NewProperty(skipCnt))
-	readerBuilder.SetProperty("MaxInCnt", controller.NewProperty(maxInCnt))
-	readerBuilder.SetProperty("DumpRawInput", controller.NewProperty("true"))
+	if err := readerBuilder.SetProperty("Uri", controller.NewProperty(addr)); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := readerBuilder.SetProperty("Protocols", controller.NewProperty("TS")); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := readerBuilder.SetProperty("SkipCnt", controller.NewProperty(skipCnt)); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := readerBuilder.SetProperty("MaxInCnt", controller.NewProperty(maxInCnt)); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := readerBuilder.SetProperty("DumpRawInput", controller.NewProperty("true")); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
 
-	controller.Start(
+	err := controller.Start(
 		&[]tttKernel.OverallParams{readerBuilder.Build()},
 		&tttKernel.Resource{
 			OutDir: outDir,
 		},
 	)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
 }
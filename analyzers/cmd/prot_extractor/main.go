@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 
 	"github.com/tony-507/analyzers/src/controller"
 	"github.com/tony-507/analyzers/src/tttKernel"
@@ -27,9 +28,18 @@ func main() {
 
 	readerBuilder := controller.NewPluginBuilder()
 	readerBuilder.SetName("InputReader_1")
-	readerBuilder.SetProperty("Uri", controller.NewProperty(uri))
-	readerBuilder.SetProperty("Protocols", controller.NewProperty(protocols))
-	readerBuilder.SetProperty("dumpRawInput", controller.NewProperty("true"))
+	if err := readerBuilder.SetProperty("Uri", controller.NewProperty(uri)); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := readerBuilder.SetProperty("Protocols", controller.NewProperty(protocols)); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := readerBuilder.SetProperty("dumpRawInput", controller.NewProperty("true")); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
 
 	builders = append(builders, readerBuilder.Build())
 
@@ -37,10 +47,13 @@ func main() {
 		&readerBuilder,
 	})
 
-	controller.Start(
+	err := controller.Start(
 		&builders,
 		&tttKernel.Resource{
 			OutDir: output,
 		},
 	)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
 }
\ No newline at end of file
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tony-507/analyzers/src/plugins/avContainer/model"
 )
@@ -31,7 +32,13 @@ func (m *psiCallback) PsiUpdateFinished(pid int, version int, jsonBytes []byte)
 	fmt.Println(string(jsonBytes))
 }
 
-func (m *psiCallback) SpliceEventReceived(dpiPid int, spliceCmdTypeStr string, splicePTS []int, pktCnt int) {}
+func (m *psiCallback) SpliceEventReceived(dpiPid int, spliceCmdTypeStr string, splicePTS []int, descriptors []model.Splice_descriptor, pktCnt int) {}
+
+func (m *psiCallback) StreamTimeReceived(utc time.Time, pktCnt int) {}
+
+func (m *psiCallback) UpdateSiVersion(key string, version int) bool {
+	return true
+}
 
 func main() {
 	if len(os.Args) != 2 {
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tony-507/analyzers/src/plugins/avContainer/model"
+)
+
+// Build a cue from command line options
+func cueFromFlags(cmd string, eventId int, pts int, duration int, outOfNetwork bool, autoReturn bool,
+	segType int, upidType int, upid string, segNum int, segExpected int) (model.Scte35Cue, error) {
+	cue := model.Scte35Cue{Tier: 0xfff, SpliceCmdTypeStr: cmd}
+
+	switch cmd {
+	case "splice_null":
+		cue.SpliceCmd = model.Splice_null{}
+	case "splice_insert":
+		event := model.Splice_event{
+			EventId:             eventId,
+			OutOfNetworkIdr:     outOfNetwork,
+			ProgramSpliceFlag:   true,
+			SpliceImmediateFlag: pts < 0,
+			SpliceTime:          pts,
+		}
+		if duration > 0 {
+			event.DurationFlag = true
+			event.BreakDuration = model.Break_duration{AutoReturn: autoReturn, Duration: duration}
+		}
+		cue.SpliceCmd = event
+	case "time_signal":
+		cue.SpliceCmd = model.Time_signal{SpliceTime: pts}
+	default:
+		return cue, fmt.Errorf("unknown splice command %s", cmd)
+	}
+
+	if segType >= 0 {
+		seg := model.Segmentation_descriptor{
+			EventId:                   eventId,
+			ProgramSegmentationFlag:   true,
+			DeliveryNotRestrictedFlag: true,
+			SegmentationDuration:      -1,
+			Upid:                      model.Segmentation_upid{UpidType: upidType, Upid: upid},
+			SegmentationTypeId:        segType,
+			SegmentNum:                segNum,
+			SegmentsExpected:          segExpected,
+			SubSegmentNum:             -1,
+			SubSegmentsExpected:       -1,
+		}
+		if duration > 0 {
+			seg.SegmentationDurationFlag = true
+			seg.SegmentationDuration = duration
+		}
+		cue.SpliceDescriptors = []model.Splice_descriptor{{
+			Tag:               0x02,
+			DescriptorTypeStr: "segmentation_descriptor",
+			Identifier:        "CUEI",
+			Content:           seg,
+		}}
+	}
+
+	return cue, nil
+}
+
+func main() {
+	var jsonFile string
+	var cmd string
+	var eventId int
+	var pts int
+	var duration int
+	var outOfNetwork bool
+	var autoReturn bool
+	var segType int
+	var upidType int
+	var upid string
+	var segNum int
+	var segExpected int
+
+	flag.StringVar(&jsonFile, "json", "", "JSON file describing the cue. Other options are ignored if set")
+	flag.StringVar(&cmd, "cmd", "splice_insert", "Splice command: splice_null, splice_insert or time_signal")
+	flag.IntVar(&eventId, "eventId", 1, "Splice event ID, also used as segmentation event ID")
+	flag.IntVar(&pts, "pts", -1, "Splice time in 90 kHz. Immediate or unspecified if negative")
+	flag.IntVar(&duration, "duration", 0, "Break and segmentation duration in 90 kHz")
+	flag.BoolVar(&outOfNetwork, "outOfNetwork", true, "Out of network indicator of splice_insert")
+	flag.BoolVar(&autoReturn, "autoReturn", true, "Auto return of break duration")
+	flag.IntVar(&segType, "segType", -1, "Segmentation type ID. No segmentation descriptor if negative")
+	flag.IntVar(&upidType, "upidType", 0, "Segmentation UPID type")
+	flag.StringVar(&upid, "upid", "", "Segmentation UPID")
+	flag.IntVar(&segNum, "segNum", 0, "Segment number")
+	flag.IntVar(&segExpected, "segExpected", 0, "Number of segments expected")
+
+	flag.Parse()
+
+	var cue model.Scte35Cue
+	var err error
+	if jsonFile != "" {
+		var jsonBytes []byte
+		if jsonBytes, err = os.ReadFile(jsonFile); err == nil {
+			cue, err = model.ParseScte35Cue(jsonBytes)
+		}
+	} else {
+		cue, err = cueFromFlags(cmd, eventId, pts, duration, outOfNetwork, autoReturn,
+			segType, upidType, upid, segNum, segExpected)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	section, err := model.EncodeScte35Section(&cue)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	// Hex output starts with pointer_field so that it can be fed to psiparser
+	hexStr := []string{"00"}
+	for _, b := range section {
+		hexStr = append(hexStr, fmt.Sprintf("%02x", b))
+	}
+	fmt.Println(fmt.Sprintf("Base64: %s", base64.StdEncoding.EncodeToString(section)))
+	fmt.Println(fmt.Sprintf("Hex: %s", strings.Join(hexStr, " ")))
+}
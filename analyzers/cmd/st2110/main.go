@@ -32,14 +32,30 @@ func main() {
 	for idx, addr := range strings.Split(addresses, ",") {
 		readerBuilder := controller.NewPluginBuilder()
 		readerBuilder.SetName(fmt.Sprintf("InputReader_%d", idx))
-		readerBuilder.SetProperty("Uri", controller.NewProperty(addr))
-		readerBuilder.SetProperty("Protocols", controller.NewProperty("TS"))
-		readerBuilder.SetProperty("SkipCnt", controller.NewProperty(skipCnt))
-		readerBuilder.SetProperty("MaxInCnt", controller.NewProperty(maxInCnt))
 
 		bbProcBuilder := controller.NewPluginBuilder()
 		bbProcBuilder.SetName(fmt.Sprintf("BasebandProcessor_%d", idx))
-		bbProcBuilder.SetProperty("Mode", controller.NewProperty("_DEMUX_FULL"))
+
+		if err := readerBuilder.SetProperty("Uri", controller.NewProperty(addr)); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		if err := readerBuilder.SetProperty("Protocols", controller.NewProperty("TS")); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		if err := readerBuilder.SetProperty("SkipCnt", controller.NewProperty(skipCnt)); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		if err := readerBuilder.SetProperty("MaxInCnt", controller.NewProperty(maxInCnt)); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		if err := bbProcBuilder.SetProperty("Mode", controller.NewProperty("_DEMUX_FULL")); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
 
 		controller.LinkPlugins([]*controller.PluginBuilder{
 			&readerBuilder,
@@ -50,10 +66,13 @@ func main() {
 		builders = append(builders, bbProcBuilder.Build())
 	}
 
-	controller.Start(
+	err := controller.Start(
 		&builders,
 		&tttKernel.Resource{
 			OutDir: outDir,
 		},
 	)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
 }
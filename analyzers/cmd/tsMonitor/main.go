@@ -1,26 +1,138 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/tony-507/analyzers/src/controller"
 	"github.com/tony-507/analyzers/src/tttKernel"
 )
 
+var skipCnt string
+var maxInCnt string
+
+// Plugins reading and analysing one input, feeding the output monitor
+func inputBranch(idx int, addr string) ([]tttKernel.OverallParams, error) {
+	readerBuilder := controller.NewPluginBuilder()
+	readerBuilder.SetName(fmt.Sprintf("InputReader_%d", idx))
+
+	demuxBuilder := controller.NewPluginBuilder()
+	demuxBuilder.SetName(fmt.Sprintf("TsDemuxer_%d", idx))
+
+	if err := readerBuilder.SetProperty("Uri", controller.NewProperty(addr)); err != nil {
+		return nil, err
+	}
+	if err := readerBuilder.SetProperty("Protocols", controller.NewProperty("TS")); err != nil {
+		return nil, err
+	}
+	if err := readerBuilder.SetProperty("SkipCnt", controller.NewProperty(skipCnt)); err != nil {
+		return nil, err
+	}
+	if err := readerBuilder.SetProperty("MaxInCnt", controller.NewProperty(maxInCnt)); err != nil {
+		return nil, err
+	}
+	if err := demuxBuilder.SetProperty("Mode", controller.NewProperty("_DEMUX_FULL")); err != nil {
+		return nil, err
+	}
+
+	dataHdlrBuilder := controller.NewPluginBuilder()
+	dataHdlrBuilder.SetName(fmt.Sprintf("DataHandler_%d", idx))
+
+	monitorBuilder := controller.NewPluginBuilder()
+	monitorBuilder.SetName("OutputMonitor_0")
+
+	controller.LinkPlugins([]*controller.PluginBuilder{
+		&readerBuilder,
+		&demuxBuilder,
+		&dataHdlrBuilder,
+		&monitorBuilder,
+	})
+
+	return []tttKernel.OverallParams{
+		readerBuilder.Build(),
+		demuxBuilder.Build(),
+		dataHdlrBuilder.Build(),
+	}, nil
+}
+
+/*
+ * Inputs can be changed while monitoring with commands from stdin:
+ *   add <uri>               Monitor a new input
+ *   remove <index or uri>   Stop monitoring an input
+ * Other inputs keep their history.
+ */
+func handleCommands(worker *tttKernel.Worker, inputs map[int]string, nextIdx int) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			if len(fields) != 0 {
+				fmt.Println("Usage: add <uri> | remove <index or uri>")
+			}
+			continue
+		}
+
+		switch fields[0] {
+		case "add":
+			branch, err := inputBranch(nextIdx, fields[1])
+			if err == nil {
+				err = controller.AddBranch(worker, branch)
+			}
+			if err != nil {
+				fmt.Printf("Fail to add %s: %s\n", fields[1], err.Error())
+				continue
+			}
+			fmt.Printf("Input #%d %s is added\n", nextIdx, fields[1])
+			inputs[nextIdx] = fields[1]
+			nextIdx++
+		case "remove":
+			idx, err := strconv.Atoi(fields[1])
+			if err != nil {
+				idx = -1
+				for i, addr := range inputs {
+					if addr == fields[1] {
+						idx = i
+					}
+				}
+			}
+			addr, ok := inputs[idx]
+			if !ok {
+				fmt.Printf("Input %s not found\n", fields[1])
+				continue
+			}
+			names := []string{
+				fmt.Sprintf("InputReader_%d", idx),
+				fmt.Sprintf("TsDemuxer_%d", idx),
+				fmt.Sprintf("DataHandler_%d", idx),
+			}
+			if err := worker.RemoveBranch(names); err != nil {
+				fmt.Printf("Fail to remove %s: %s\n", addr, err.Error())
+				continue
+			}
+			fmt.Printf("Input #%d %s is removed\n", idx, addr)
+			delete(inputs, idx)
+		default:
+			fmt.Println("Usage: add <uri> | remove <index or uri>")
+		}
+	}
+}
+
 func main() {
 	var addresses string
 	var outDir string
-	var skipCnt string
-	var maxInCnt string
 	var redundancy string
+	var metricsAddr string
 
 	flag.StringVar(&addresses, "addr", "", "Comma-separated list of URIs to monitor")
 	flag.StringVar(&outDir, "o", "./output", "Output directory")
 	flag.StringVar(&skipCnt, "skipCnt", "0", "Skip count")
 	flag.StringVar(&maxInCnt, "maxInCnt", "0", "Max input packet count")
 	flag.StringVar(&redundancy, "redundancy", "None", "Redundancy time reference")
+	flag.StringVar(&metricsAddr, "metrics", "", "Address to serve Prometheus metrics at /metrics, e.g. :9100")
 
 	flag.Parse()
 
@@ -34,42 +146,36 @@ func main() {
 	monitorBuilder := controller.NewPluginBuilder()
 	monitorBuilder.SetName("OutputMonitor_0")
 	if redundancy != "None" {
-		monitorBuilder.SetProperty("Redundancy.TimeRef", controller.NewProperty(redundancy))
+		if err := monitorBuilder.SetProperty("Redundancy.TimeRef", controller.NewProperty(redundancy)); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
 	}
 
 	builders = append(builders, monitorBuilder.Build())
 
+	inputs := map[int]string{}
 	for idx, addr := range strings.Split(addresses, ",") {
-		readerBuilder := controller.NewPluginBuilder()
-		readerBuilder.SetName(fmt.Sprintf("InputReader_%d", idx))
-		readerBuilder.SetProperty("Uri", controller.NewProperty(addr))
-		readerBuilder.SetProperty("Protocols", controller.NewProperty("TS"))
-		readerBuilder.SetProperty("SkipCnt", controller.NewProperty(skipCnt))
-		readerBuilder.SetProperty("MaxInCnt", controller.NewProperty(maxInCnt))
-
-		demuxBuilder := controller.NewPluginBuilder()
-		demuxBuilder.SetName(fmt.Sprintf("TsDemuxer_%d", idx))
-		demuxBuilder.SetProperty("Mode", controller.NewProperty("_DEMUX_FULL"))
-
-		dataHdlrBuilder := controller.NewPluginBuilder()
-		dataHdlrBuilder.SetName(fmt.Sprintf("DataHandler_%d", idx))
-
-		controller.LinkPlugins([]*controller.PluginBuilder{
-			&readerBuilder,
-			&demuxBuilder,
-			&dataHdlrBuilder,
-			&monitorBuilder,
-		})
-
-		builders = append(builders, readerBuilder.Build())
-		builders = append(builders, demuxBuilder.Build())
-		builders = append(builders, dataHdlrBuilder.Build())
+		branch, err := inputBranch(idx, addr)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		builders = append(builders, branch...)
+		inputs[idx] = addr
 	}
 
-	controller.Start(
-		&builders,
-		&tttKernel.Resource{
-			OutDir: outDir,
+	worker, result := controller.StartLive(
+		builders,
+		tttKernel.Resource{
+			OutDir:      outDir,
+			MetricsAddr: metricsAddr,
 		},
 	)
+	if worker != nil {
+		go handleCommands(worker, inputs, len(inputs))
+	}
+	if err := <-result; err != nil {
+		fmt.Println(err.Error())
+	}
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"strings"
 
 	"github.com/tony-507/analyzers/src/controller"
 	"github.com/tony-507/analyzers/src/tttKernel"
@@ -12,11 +14,13 @@ func main() {
 	var outDir string
 	var skipCnt string
 	var maxInCnt string
+	var record string
 
 	flag.StringVar(&addr, "addr", "", "URI to analyze")
 	flag.StringVar(&outDir, "o", "./output", "Output directory")
 	flag.StringVar(&skipCnt, "skipCnt", "0", "Skip count")
 	flag.StringVar(&maxInCnt, "maxInCnt", "0", "Max input packet count")
+	flag.StringVar(&record, "record", "", "Edges to record units crossing, separated by commas, e.g. TsDemuxer_0->DataHandler_0")
 
 	flag.Parse()
 
@@ -29,14 +33,30 @@ func main() {
 
 	readerBuilder := controller.NewPluginBuilder()
 	readerBuilder.SetName("InputReader_0")
-	readerBuilder.SetProperty("Uri", controller.NewProperty(addr))
-	readerBuilder.SetProperty("Protocols", controller.NewProperty("TS"))
-	readerBuilder.SetProperty("SkipCnt", controller.NewProperty(skipCnt))
-	readerBuilder.SetProperty("MaxInCnt", controller.NewProperty(maxInCnt))
 
 	demuxBuilder := controller.NewPluginBuilder()
 	demuxBuilder.SetName("TsDemuxer_0")
-	demuxBuilder.SetProperty("Mode", controller.NewProperty("_DEMUX_FULL"))
+
+	if err := readerBuilder.SetProperty("Uri", controller.NewProperty(addr)); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := readerBuilder.SetProperty("Protocols", controller.NewProperty("TS")); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := readerBuilder.SetProperty("SkipCnt", controller.NewProperty(skipCnt)); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := readerBuilder.SetProperty("MaxInCnt", controller.NewProperty(maxInCnt)); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := demuxBuilder.SetProperty("Mode", controller.NewProperty("_DEMUX_FULL")); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
 
 	dataHdlrBuilder := controller.NewPluginBuilder()
 	dataHdlrBuilder.SetName("DataHandler_0")
@@ -51,10 +71,15 @@ func main() {
 	builders = append(builders, demuxBuilder.Build())
 	builders = append(builders, dataHdlrBuilder.Build())
 
-	controller.Start(
-		&builders,
-		&tttKernel.Resource{
-			OutDir: outDir,
-		},
-	)
+	resource := tttKernel.Resource{
+		OutDir: outDir,
+	}
+	if record != "" {
+		resource.Record = strings.Split(record, ",")
+	}
+
+	err := controller.Start(&builders, &resource)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
 }
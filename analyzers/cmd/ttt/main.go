@@ -17,7 +17,26 @@ func setupLogging(appDir string) {
 }
 
 func showHelp() {
-	fmt.Println("Usage: ttt <appName> <parameters>...")
+	fmt.Println("Usage: ttt <command> ...")
+	fmt.Println("  app <appName> <parameters>...    Run an app")
+	fmt.Println("  check [appName] [parameters]...  Check an app, or all apps, without running")
+	fmt.Println("  convert <appName> <format> <parameters>...")
+	fmt.Println("                                   Print an app as ttt, json or yaml")
+	fmt.Println("  graph <appName> <parameters>...  Print the graph of an app in DOT format")
+	fmt.Println("  ls                               List apps")
+	fmt.Println("  plugins                          List plugins and their parameters")
+	fmt.Println("  serve [address] [outDir]         Serve an HTTP API to run jobs, default at 127.0.0.1:8080")
+	fmt.Println("                                   The API has no authentication. Binding to other interfaces, e.g. :8080,")
+	fmt.Println("                                   lets anyone reaching the port run graphs and read files with them")
+	fmt.Println("  version                          Show version")
+}
+
+func getAppParams() []string {
+	params := make([]string, 0)
+	if len(os.Args) > 3 {
+		params = os.Args[3:]
+	}
+	return params
 }
 
 func main() {
@@ -38,12 +57,62 @@ func main() {
 		fmt.Println(controller.Version())
 	case "ls":
 		controller.ListApp(resourceDir)
+	case "plugins":
+		fmt.Print(controller.DescribePlugins())
 	case "app":
-		params := make([]string, 0)
+		if len(os.Args) < 3 {
+			showHelp()
+			return
+		}
+		if err := controller.StartApp(resourceDir, os.Args[2], getAppParams()); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	case "graph":
+		if len(os.Args) < 3 {
+			showHelp()
+			return
+		}
+		dot, err := controller.GraphApp(resourceDir, os.Args[2], getAppParams())
+		fmt.Print(dot)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	case "check":
+		appNames := []string{}
+		if len(os.Args) > 2 {
+			appNames = append(appNames, os.Args[2])
+		}
+		report, ok := controller.CheckApps(resourceDir, appNames, getAppParams())
+		fmt.Print(report)
+		if !ok {
+			os.Exit(1)
+		}
+	case "convert":
+		if len(os.Args) < 4 {
+			showHelp()
+			return
+		}
+		rv, err := controller.ConvertApp(resourceDir, os.Args[2], os.Args[3], os.Args[4:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Print(rv)
+	case "serve":
+		addr := "127.0.0.1:8080"
+		outDir := appDir + "/jobs"
+		if len(os.Args) > 2 {
+			addr = os.Args[2]
+		}
 		if len(os.Args) > 3 {
-			params = os.Args[3:]
+			outDir = os.Args[3]
+		}
+		if err := controller.Serve(addr, resourceDir, outDir); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
 		}
-		controller.StartApp(resourceDir, os.Args[2], params)
 	default:
 		showHelp()
 	}
@@ -2,14 +2,16 @@ module github.com/tony-507/analyzers
 
 go 1.17
 
-require github.com/stretchr/testify v1.8.1
+require (
+	github.com/buger/goterm v1.0.4
+	github.com/stretchr/testify v1.8.1
+	golang.org/x/net v0.7.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/buger/goterm v1.0.4 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/jstemmer/go-junit-report v1.0.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/net v0.7.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
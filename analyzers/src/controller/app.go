@@ -3,6 +3,8 @@ package controller
 import (
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/tony-507/analyzers/src/logging"
@@ -31,44 +33,268 @@ func LinkPlugins(plugins []*PluginBuilder) {
 	}
 }
 
-func Start(pluginParams *[]tttKernel.OverallParams, env *tttKernel.Resource) {
+func Start(pluginParams *[]tttKernel.OverallParams, env *tttKernel.Resource) error {
+	if err := tttKernel.ValidateGraph(*pluginParams, isPluginType); err != nil {
+		return err
+	}
+
 	provider := tttKernel.NewWorker()
 
 	provider.UpdateResource(*env)
 
-	provider.StartService(*pluginParams, selectPlugin)
+	return provider.StartService(*pluginParams, selectPlugin)
+}
+
+// Run a graph in the background so that it can be reconfigured while running.
+// The result is sent to the channel when the graph ends.
+func StartLive(pluginParams []tttKernel.OverallParams, env tttKernel.Resource) (*tttKernel.Worker, <-chan error) {
+	result := make(chan error, 1)
+	if err := tttKernel.ValidateGraph(pluginParams, isPluginType); err != nil {
+		result <- err
+		return nil, result
+	}
+
+	provider := tttKernel.NewWorker()
+	provider.UpdateResource(env)
+	go func() {
+		result <- provider.StartService(pluginParams, selectPlugin)
+	}()
+	return &provider, result
+}
+
+// Check plugin types of a branch before adding it to a running graph
+func AddBranch(worker *tttKernel.Worker, pluginParams []tttKernel.OverallParams) error {
+	for _, param := range pluginParams {
+		if !isPluginType(param.Name()) {
+			return fmt.Errorf("plugin %s does not match any plugin type", param.Name())
+		}
+	}
+	return worker.AddBranch(pluginParams)
 }
 
 func ListApp(resourceDir string) {
-	fileInfo, err := ioutil.ReadDir(resourceDir)
+	appNames, err := listApps(resourceDir)
 	if err != nil {
 		panic(err)
 	}
-	for _, file := range fileInfo {
+	for _, appName := range appNames {
 		ctrl := newController()
-		appName := strings.Split(file.Name(), ".")[0]
-		ctrl.parser.buildParams(getApp(resourceDir, appName), []string{}, 1)
+		ctrl.parser.includeDir = resourceDir
+		script, fileName := getApp(resourceDir, appName)
+		ctrl.buildApp(script, fileName, []string{}, 1)
 		fmt.Println(fmt.Sprintf("%10s%10s%50s", appName, " ", ctrl.parser.description))
 	}
 }
 
-func StartApp(resourceDir string, appName string, input []string) {
+func StartApp(resourceDir string, appName string, input []string) error {
+	pluginParams, env, err := loadApp(resourceDir, appName, input)
+	if err != nil {
+		return err
+	}
+
+	provider := tttKernel.NewWorker()
+
+	provider.UpdateResource(env)
+
+	return provider.StartService(pluginParams, selectPlugin)
+}
+
+// Build a validated graph from an app. Script errors are returned instead of panicking.
+func loadApp(resourceDir string, appName string, input []string) (pluginParams []tttKernel.OverallParams, env tttKernel.Resource, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	script, fileName := getApp(resourceDir, appName)
+	if script == "" {
+		return nil, env, fmt.Errorf("app %s not found", appName)
+	}
+
 	ctrl := newController()
+	ctrl.parser.includeDir = resourceDir
+	ctrl.buildApp(script, fileName, input, -1)
 
-	ctrl.parser.buildParams(getApp(resourceDir, appName), input, -1)
+	pluginParams, err = ctrl.getGraphParams()
+	return pluginParams, ctrl.parser.env, err
+}
 
-	provider := tttKernel.NewWorker()
+// Export the graph of an app in DOT format. The graph is returned even if it is invalid, but not if the script has errors.
+func GraphApp(resourceDir string, appName string, input []string) (dot string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			dot, err = "", fmt.Errorf("%v", r)
+		}
+	}()
+
+	script, fileName := getApp(resourceDir, appName)
+	if script == "" {
+		return "", fmt.Errorf("app %s not found", appName)
+	}
+
+	ctrl := newController()
+	ctrl.parser.includeDir = resourceDir
+	ctrl.buildApp(script, fileName, input, -1)
+
+	pluginParams, err := ctrl.getGraphParams()
+	return tttKernel.ExportDot(appName, pluginParams), err
+}
+
+// Convert an app to a ttt script, or a graph file in JSON or YAML. A ttt script is run with
+// the input, so the graph file has its control flow and arguments expanded.
+func ConvertApp(resourceDir string, appName string, format string, input []string) (rv string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	script, fileName := getApp(resourceDir, appName)
+	srcFormat := getAppFormat(fileName)
+	if script == "" {
+		return "", fmt.Errorf("app %s not found", appName)
+	}
+
+	var gf GraphFile
+	if srcFormat == _FORMAT_TTT {
+		ctrl := newController()
+		ctrl.parser.includeDir = resourceDir
+		ctrl.parser.file = fileName
+		ctrl.parser.buildParams(script, input, -1)
+		if _, err := ctrl.getGraphParams(); err != nil {
+			return "", err
+		}
+		gf, err = ctrl.parser.toGraphFile()
+	} else {
+		gf, err = parseGraphFile(script, srcFormat)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if format == _FORMAT_TTT {
+		return gf.toScript()
+	}
+	return gf.encode(format)
+}
+
+// Build the graph of an app from a ttt script, or a graph file in JSON or YAML
+func (ctrl *tttController) buildApp(script string, fileName string, input []string, lim int) {
+	format := getAppFormat(fileName)
+	ctrl.parser.file = fileName
+	if format == _FORMAT_TTT {
+		ctrl.parser.buildParams(script, input, lim)
+		return
+	}
+
+	gf, err := parseGraphFile(script, format)
+	if err == nil {
+		err = ctrl.parser.loadGraphFile(gf, input)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("%s: %s", fileName, err.Error()))
+	}
+}
+
+/*
+ * Check apps without running them, or all apps in the resource directory if no name is given.
+ * Scripts are run with the input to build their graphs, which are validated as well.
+ * Return a report of the problems found, and whether all apps are free of errors.
+ */
+func CheckApps(resourceDir string, appNames []string, input []string) (string, bool) {
+	if len(appNames) == 0 {
+		var err error
+		if appNames, err = listApps(resourceDir); err != nil {
+			return err.Error() + "\n", false
+		}
+	}
+
+	lines := []string{}
+	failCnt := 0
+	for _, appName := range appNames {
+		problems, ok := checkApp(resourceDir, appName, input)
+		lines = append(lines, problems...)
+		if !ok {
+			failCnt++
+		}
+	}
+	lines = append(lines, fmt.Sprintf("Apps checked: %d, with errors: %d", len(appNames), failCnt))
+	return strings.Join(lines, "\n") + "\n", failCnt == 0
+}
 
-	provider.UpdateResource(ctrl.parser.env)
+func checkApp(resourceDir string, appName string, input []string) (problems []string, ok bool) {
+	fileName := appName
+	defer func() {
+		if r := recover(); r != nil {
+			problems = append(problems, fmt.Sprintf("%s: error: %v", fileName, r))
+			ok = false
+		}
+	}()
+
+	script, fileName := getApp(resourceDir, appName)
+	if script == "" {
+		return []string{fmt.Sprintf("%s: error: app not found", appName)}, false
+	}
+
+	ctrl := newController()
+	ctrl.parser.includeDir = resourceDir
+	if getAppFormat(fileName) == _FORMAT_TTT {
+		ctrl.parser.file = fileName
+		ctrl.parser.checkScript(script, input, -1)
+		for _, diag := range ctrl.parser.diags {
+			problems = append(problems, diag.Error())
+		}
+		if len(ctrl.parser.diags.errors()) != 0 {
+			return problems, false
+		}
+	} else {
+		ctrl.buildApp(script, fileName, input, -1)
+	}
+
+	if _, err := ctrl.getGraphParams(); err != nil {
+		for _, graphErr := range err.(tttKernel.GraphErrors) {
+			problems = append(problems, fmt.Sprintf("%s: error: %s", fileName, graphErr.Error()))
+		}
+		return problems, false
+	}
+	return problems, true
+}
 
+// Collect plugin parameters from the script and validate the graph
+func (ctrl *tttController) getGraphParams() ([]tttKernel.OverallParams, error) {
+	errs := tttKernel.GraphErrors{}
 	pluginParams := make([]tttKernel.OverallParams, 0)
+	isPluginVar := map[string]bool{}
 	for _, v := range ctrl.parser.variables {
 		if v.varType == _VAR_PLUGIN {
+			isPluginVar[v.name] = true
 			pluginParams = append(pluginParams, tttKernel.ConstructOverallParam(v.value, v.getAttributeStr(), ctrl.parser.edgeMap[v.name]))
 		}
 	}
 
-	provider.StartService(pluginParams, selectPlugin)
+	parents := []string{}
+	for parent := range ctrl.parser.edgeMap {
+		parents = append(parents, parent)
+	}
+	sort.Strings(parents)
+	for _, parent := range parents {
+		if !isPluginVar[parent] {
+			errs = append(errs, tttKernel.GraphError{
+				Kind:    tttKernel.UNDEFINED_LINK,
+				Plugins: []string{parent},
+				Msg:     fmt.Sprintf("Link from \"%s\", which is not a plugin variable", parent),
+			})
+		}
+	}
+
+	if err := tttKernel.ValidateGraph(pluginParams, isPluginType); err != nil {
+		errs = append(errs, err.(tttKernel.GraphErrors)...)
+	}
+	if len(errs) != 0 {
+		return pluginParams, errs
+	}
+	return pluginParams, nil
 }
 
 func newController() tttController {
@@ -78,23 +304,64 @@ func newController() tttController {
 	}
 }
 
-func getApp(resourceDir string, appName string) string {
+// Read an app and get its file name
+func getApp(resourceDir string, appName string) (string, string) {
 	fileInfo, err := ioutil.ReadDir(resourceDir)
 	if err != nil {
 		panic(err)
 	}
 	rv := ""
+	fileName := ""
 
 	for _, file := range fileInfo {
 		app := strings.Split(file.Name(), ".")[0]
-		if app == appName {
+		if app == appName && !file.IsDir() {
+			if fileName != "" {
+				panic(fmt.Sprintf("app %s is defined by both %s and %s", appName, fileName, file.Name()))
+			}
 			buf, err := ioutil.ReadFile(resourceDir + file.Name())
 			if err != nil {
 				panic(err)
 			}
 			rv = string(buf)
+			fileName = file.Name()
+		}
+	}
+
+	return rv, fileName
+}
+
+// Names of apps in the resource directory. Scripts included by other scripts are not apps.
+func listApps(resourceDir string) ([]string, error) {
+	fileInfo, err := ioutil.ReadDir(resourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	included := map[string]bool{}
+	for _, file := range fileInfo {
+		if file.IsDir() || getAppFormat(file.Name()) != _FORMAT_TTT {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(resourceDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lexScript(string(buf), file.Name()) {
+			if tokens := lexLine(line); len(tokens) == 2 && tokens[0].text == "include" {
+				included[strings.Split(filepath.Clean(tokens[1].text), ".")[0]] = true
+			}
 		}
 	}
 
-	return rv
+	// An app in several formats is listed once, and reported by getApp
+	appNames := []string{}
+	for _, file := range fileInfo {
+		appName := strings.Split(file.Name(), ".")[0]
+		if !file.IsDir() && !included[appName] {
+			appNames = append(appNames, appName)
+			included[appName] = true
+		}
+	}
+	return appNames, nil
 }
@@ -1,9 +1,18 @@
 package controller
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tony-507/analyzers/src/tttKernel"
 )
 
 func TestDeclarePlugin(t *testing.T) {
@@ -103,3 +112,430 @@ func TestGetRecursiveAttributeString(t *testing.T) {
 
 	assert.Equal(t, "{\"x\":{\"a\":\"abc\"},\"y\":3}", s, "Fail to get correct attribute string for a plugin with recursive parameters")
 }
+
+func TestGraphParamsValidation(t *testing.T) {
+	script := "reader = #InputReader_1; demux = #TsDemuxer_1; link(reader, demux); link(demux, handler); link(rdr, demux);"
+	ctrl := newController()
+
+	ctrl.parser.buildParams(script, []string{}, -1)
+	params, err := ctrl.getGraphParams()
+
+	assert.Equal(t, 2, len(params))
+	errs, ok := err.(tttKernel.GraphErrors)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, []string{"rdr"}, errs[0].Plugins)
+	assert.Equal(t, []string{"TsDemuxer_1", "handler"}, errs[1].Plugins)
+
+	script = "reader = #InputReader_1; demux = #TsDemuxer_1; link(reader, demux);"
+	ctrl = newController()
+	ctrl.parser.buildParams(script, []string{}, -1)
+	_, err = ctrl.getGraphParams()
+	assert.Equal(t, nil, err)
+}
+
+func TestJobServer(t *testing.T) {
+	resourceDir := t.TempDir() + "/"
+	outDir := t.TempDir()
+	os.WriteFile(resourceDir+"reader.ttt", []byte("// Read nothing\nreader = #InputReader_1; reader.Uri = $uri | dummy;"), 0644)
+
+	server := httptest.NewServer(NewJobServer(resourceDir, outDir))
+	defer server.Close()
+
+	request := func(method string, path string, body string) (int, string) {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		buf, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(buf)
+	}
+	waitJob := func(id string) JobInfo {
+		info := JobInfo{}
+		for i := 0; i < 100; i++ {
+			_, body := request(http.MethodGet, "/jobs/"+id, "")
+			json.Unmarshal([]byte(body), &info)
+			if info.State != JOB_RUNNING {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return info
+	}
+
+	status, body := request(http.MethodPost, "/jobs", `{"Name": "graph", "Graph": [{"Name": "InputReader_1", "Param": {"Uri": "dummy"}}]}`)
+	assert.Equal(t, http.StatusCreated, status)
+	info := JobInfo{}
+	json.Unmarshal([]byte(body), &info)
+	assert.Equal(t, "1", info.Id)
+	assert.Equal(t, JOB_FINISHED, waitJob("1").State)
+
+	status, _ = request(http.MethodPost, "/jobs", `{"App": "reader"}`)
+	assert.Equal(t, http.StatusCreated, status)
+	info = waitJob("2")
+	assert.Equal(t, "reader", info.Name)
+	assert.Equal(t, JOB_FINISHED, info.State)
+	assert.Contains(t, info.Status, "Plugin: InputReader_1")
+
+	// Stopping an ended job does nothing
+	status, body = request(http.MethodDelete, "/jobs/2", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"State":"finished"`)
+
+	status, body = request(http.MethodGet, "/jobs", "")
+	assert.Equal(t, http.StatusOK, status)
+	jobs := []JobInfo{}
+	json.Unmarshal([]byte(body), &jobs)
+	assert.Equal(t, 2, len(jobs))
+
+	// Invalid submissions
+	status, body = request(http.MethodPost, "/jobs", `{"App": "unknown"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "app unknown not found")
+	status, body = request(http.MethodPost, "/jobs", `{"Graph": [{"Name": "InputReader_1", "Children": ["TsDemuxer_1"]}]}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "undefined link")
+	status, _ = request(http.MethodGet, "/jobs/3", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// Output files
+	os.MkdirAll(filepath.Join(outDir, "1", "sub"), 0755)
+	os.WriteFile(filepath.Join(outDir, "1", "sub", "a.csv"), []byte("a,b\n"), 0644)
+	status, body = request(http.MethodGet, "/jobs/1/files", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"sub/a.csv"`)
+	status, body = request(http.MethodGet, "/jobs/1/files/sub/a.csv", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "a,b\n", body)
+	status, _ = request(http.MethodGet, "/jobs/1/files/..%2F2%2Fx", "")
+	assert.NotEqual(t, http.StatusOK, status)
+
+	// Only running jobs can be reconfigured
+	status, body = request(http.MethodPut, "/jobs/1/plugins/InputReader_1", `{"Uri": "dummy"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "graph is not running")
+	status, body = request(http.MethodPost, "/jobs/1/plugins", `{"Graph": [{"Name": "Unknown_2"}]}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "plugin Unknown_2 does not match any plugin type")
+	status, _ = request(http.MethodDelete, "/jobs/1/plugins?name=InputReader_1", "")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = request(http.MethodDelete, "/jobs/3/plugins?name=InputReader_1", "")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestSetPropertyValidation(t *testing.T) {
+	builder := NewPluginBuilder()
+	builder.SetName("OutputMonitor_1")
+	assert.Nil(t, builder.SetProperty("Redundancy.TimeRef", NewProperty("vitc")))
+	assert.NotNil(t, builder.SetProperty("Redundancy.TimeRef", NewProperty("utc")))
+	assert.NotNil(t, builder.SetProperty("Unknown", NewProperty("1")))
+	assert.Equal(t, "{\"Redundancy\":{\"TimeRef\":\"vitc\"}}", builder.getPropertyString())
+
+	builder = NewPluginBuilder()
+	builder.SetName("InputReader_1")
+	assert.Nil(t, builder.SetProperty("dumpRawInput", NewProperty("true")))
+	assert.NotNil(t, builder.SetProperty("SkipCnt", NewProperty("abc")))
+
+	script := "demux = #TsDemuxer_1; demux.Mode = _DEMUX_ALL;"
+	ctrl := newController()
+	ctrl.parser.buildParams(script, []string{}, -1)
+	_, err := ctrl.getGraphParams()
+	errs, ok := err.(tttKernel.GraphErrors)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, tttKernel.INVALID_PARAMETER, errs[0].Kind)
+	assert.Contains(t, errs[0].Msg, "expecting one of _DEMUX_DUMMY, _DEMUX_FULL")
+	assert.Contains(t, DescribePlugins(), "TsDemuxer: ")
+}
+
+func TestElseAndComparison(t *testing.T) {
+	script := "if $x == 1; y = one; elif $x >= 2; y = many; else; y = none; end; if $z != abc; z = $z | na; end;"
+	for input, expected := range map[string]string{"1": "one", "2.5": "many", "0": "none"} {
+		ctrl := newController()
+		ctrl.parser.buildParams(script, []string{"-x", input, "-z", "abc"}, -1)
+		assert.Equal(t, 1, len(ctrl.parser.variables))
+		assert.Equal(t, expected, ctrl.parser.variables[0].value, "Wrong branch for x = "+input)
+	}
+
+	build := func(script string, input ...string) func() {
+		return func() {
+			ctrl := newController()
+			ctrl.parser.buildParams(script, input, -1)
+		}
+	}
+	assert.Panics(t, build("if $x < abc; end;", "-x", "1"))
+	assert.Panics(t, build("if $x; else; elif $y; end;"))
+	assert.Panics(t, build("if $x; x = 1;"))
+	assert.Panics(t, build("end;"))
+
+	// Blocks cut by the line limit are closed
+	ctrl := newController()
+	ctrl.parser.buildParams("// Test; if $x; x = 1; end;", []string{}, 2)
+	assert.Equal(t, "Test", ctrl.parser.description)
+}
+
+func TestNestedLoopAndArray(t *testing.T) {
+	script := "for i in 0..2; for j, s in [a, $s]; v_${i}_$j = $s; end; w_$i = $w; end; x.list = [1, b]; for a in $addr; link($a, x); end;"
+	ctrl := newController()
+	ctrl.parser.buildParams(script, []string{"-s", "src", "-w_1", "one", "-addr", "p,q"}, -1)
+
+	values := map[string]string{}
+	for _, v := range ctrl.parser.variables {
+		values[v.name] = v.value
+	}
+	assert.Equal(t, map[string]string{"v_0_0": "a", "v_0_1": "src", "w_0": "", "v_1_0": "a", "v_1_1": "src", "w_1": "one", "x": ""}, values)
+	assert.Equal(t, "{\"list\":[1,\"b\"]}", ctrl.parser.variables[6].getAttributeStr())
+	assert.Equal(t, []string{"x"}, ctrl.parser.edgeMap["p"])
+	assert.Equal(t, []string{"x"}, ctrl.parser.edgeMap["q"])
+}
+
+func TestTsMonitorScript(t *testing.T) {
+	script := `// Monitor TS inputs
+	global.outDir = $o | output;
+	global.metrics = $metrics;
+	monitor = #OutputMonitor_0;
+	if $redundancy;
+		monitor.Redundancy.TimeRef = $redundancy;
+	end;
+	for i, addr in $addr;
+		reader_$i = #InputReader_$i;
+		reader_$i.Uri = $addr;
+		reader_$i.Protocols = TS;
+		reader_$i.SkipCnt = $skipCnt | 0;
+		demux_$i = #TsDemuxer_$i;
+		demux_$i.Mode = _DEMUX_FULL;
+		handler_$i = #DataHandler_$i;
+		link(reader_$i, demux_$i);
+		link(demux_$i, handler_$i);
+		link(handler_$i, monitor);
+	end;`
+	ctrl := newController()
+	ctrl.parser.buildParams(script, []string{"-addr", "file://a.ts,udp://b:1234", "-redundancy", "vitc", "-metrics", ":9100"}, -1)
+	params, err := ctrl.getGraphParams()
+
+	assert.Nil(t, err)
+	assert.Equal(t, tttKernel.Resource{OutDir: "output", MetricsAddr: ":9100"}, ctrl.parser.env)
+	assert.Equal(t, []tttKernel.OverallParams{
+		tttKernel.ConstructOverallParam("OutputMonitor_0", "{\"Redundancy\":{\"TimeRef\":\"vitc\"}}", nil),
+		tttKernel.ConstructOverallParam("InputReader_0", "{\"Uri\":\"file://a.ts\",\"Protocols\":\"TS\",\"SkipCnt\":0}", []string{"TsDemuxer_0"}),
+		tttKernel.ConstructOverallParam("TsDemuxer_0", "{\"Mode\":\"_DEMUX_FULL\"}", []string{"DataHandler_0"}),
+		tttKernel.ConstructOverallParam("DataHandler_0", "{}", []string{"OutputMonitor_0"}),
+		tttKernel.ConstructOverallParam("InputReader_1", "{\"Uri\":\"udp://b:1234\",\"Protocols\":\"TS\",\"SkipCnt\":0}", []string{"TsDemuxer_1"}),
+		tttKernel.ConstructOverallParam("TsDemuxer_1", "{\"Mode\":\"_DEMUX_FULL\"}", []string{"DataHandler_1"}),
+		tttKernel.ConstructOverallParam("DataHandler_1", "{}", []string{"OutputMonitor_0"}),
+	}, params)
+}
+
+func TestGraphFile(t *testing.T) {
+	resourceDir := t.TempDir() + "/"
+	os.WriteFile(resourceDir+"reader.yaml", []byte(`description: Read a TS file
+args:
+  - name: uri
+    default: file://a.ts
+  - name: skip
+    type: int
+  - name: ref
+global:
+  outDir: out
+plugins:
+  - name: InputReader_1
+    properties:
+      Uri: $uri
+      SkipCnt: $skip
+      Protocols: TS
+  - name: TsDemuxer_1
+    properties:
+      Mode: _DEMUX_FULL
+  - name: OutputMonitor_1
+    properties:
+      Redundancy:
+        TimeRef: $ref
+edges:
+  - {from: InputReader_1, to: TsDemuxer_1}
+  - {from: TsDemuxer_1, to: OutputMonitor_1}
+`), 0644)
+	os.WriteFile(resourceDir+"loop.ttt", []byte("// Read files; merger = #Merger_1; for i, f in $f; reader_$i = #InputReader_$i; reader_$i.Uri = $f; link(reader_$i, merger); end;"), 0644)
+
+	input := []string{"-skip", "2", "-ref", "pts"}
+	expected := []tttKernel.OverallParams{
+		tttKernel.ConstructOverallParam("InputReader_1", "{\"Protocols\":\"TS\",\"SkipCnt\":2,\"Uri\":\"file://a.ts\"}", []string{"TsDemuxer_1"}),
+		tttKernel.ConstructOverallParam("TsDemuxer_1", "{\"Mode\":\"_DEMUX_FULL\"}", []string{"OutputMonitor_1"}),
+		tttKernel.ConstructOverallParam("OutputMonitor_1", "{\"Redundancy\":{\"TimeRef\":\"pts\"}}", nil),
+	}
+	params, env, err := loadApp(resourceDir, "reader", input)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, params)
+	assert.Equal(t, "out", env.OutDir)
+
+	_, _, err = loadApp(resourceDir, "reader", []string{"-skip", "abc"})
+	assert.Equal(t, "reader.yaml: InputReader_1.SkipCnt: invalid int argument skip: abc", err.Error())
+
+	// Formats are converted to each other with the same graph
+	for format, file := range map[string]string{"ttt": "reader_ttt.ttt", "json": "reader_json.json", "yaml": "reader_yaml.yml"} {
+		converted, err := ConvertApp(resourceDir, "reader", format, []string{})
+		assert.Nil(t, err)
+		os.WriteFile(resourceDir+file, []byte(converted), 0644)
+		params, env, err = loadApp(resourceDir, strings.Split(file, ".")[0], input)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, params, "Graph converted to "+format+" differs")
+		assert.Equal(t, "out", env.OutDir)
+	}
+	script, _ := ConvertApp(resourceDir, "reader", "ttt", []string{})
+	assert.Contains(t, script, "// Read a TS file;\nglobal.outDir = out;\nInputReader_1 = #InputReader_1;\nInputReader_1.Protocols = TS;\nInputReader_1.SkipCnt = $skip;\nInputReader_1.Uri = $uri | file://a.ts;\n")
+
+	converted, err := ConvertApp(resourceDir, "loop", "yaml", []string{"-f", "a.ts,b.ts"})
+	assert.Nil(t, err)
+	assert.Equal(t, "description: Read files\nplugins:\n  - name: Merger_1\n  - name: InputReader_0\n    properties:\n      Uri: a.ts\n  - name: InputReader_1\n    properties:\n      Uri: b.ts\nedges:\n  - from: InputReader_0\n    to: Merger_1\n  - from: InputReader_1\n    to: Merger_1\n", converted)
+
+	os.WriteFile(resourceDir+"bad.json", []byte(`{"plugins": [{"name": "InputReader_1", "param": {}}]}`), 0644)
+	_, _, err = loadApp(resourceDir, "bad", []string{})
+	assert.Contains(t, err.Error(), "unknown field \"param\"")
+	_, err = ConvertApp(resourceDir, "reader", "xml", []string{})
+	assert.NotNil(t, err)
+}
+
+func TestScriptDiagnostics(t *testing.T) {
+	script := "// Test\nreader = #InputReader_1;\nlink(reader, demux, x);\n  if $n >= 2;\nelse; end x;\nfor i in 0..;\nend;\nreader.Uri $f;\nend;"
+	ctrl := newController()
+	ctrl.parser.file = "test.ttt"
+	ctrl.parser.checkScript(script, []string{}, -1)
+
+	msgs := []string{}
+	for _, diag := range ctrl.parser.diags {
+		msgs = append(msgs, diag.Error())
+	}
+	assert.Equal(t, []string{
+		"test.ttt:3:1: error: link expects 2 plugins, got 3 (hint: link(parent, child))",
+		"test.ttt:5:11: error: unexpected x after end (hint: put it on a new line)",
+		"test.ttt:6:10: error: malformed range 0.. (hint: <from>..<to> excluding <to>, e.g. 0..3)",
+		"test.ttt:8:1: error: unknown statement reader.Uri (hint: <name> = <value>, or a statement such as link, alias, if or for)",
+		"test.ttt:9:1: error: end without if, for or def (hint: remove it, or add the block it closes)",
+	}, msgs)
+	assert.Panics(t, func() { ctrl.parser.buildParams(script, []string{}, -1) })
+
+	// Problems found when running the script
+	script = "alias(file, f); alias(o, out); x = #Unknown_1; link(x, y); y.a = 1; if $n < abc; end; for i in 0..$n; end;"
+	ctrl = newController()
+	ctrl.parser.checkScript(script, []string{"-n", "a"}, -1)
+	msgs = []string{}
+	for _, diag := range ctrl.parser.diags {
+		msgs = append(msgs, diag.Error())
+	}
+	assert.Equal(t, []string{
+		"1:13: warning: alias f of -file is never read (hint: read it with $f, or remove the alias)",
+		"1:26: warning: alias out of -o is never read (hint: read it with $out, or remove the alias)",
+		"1:36: warning: unknown plugin type of Unknown_1 (hint: run ttt plugins to list plugins)",
+		"1:56: warning: undefined variable y (hint: declare it before the link, e.g. y = #Plugin_1)",
+		"1:60: warning: undefined variable y (hint: declare it first, e.g. y = #Plugin_1)",
+		"1:75: error: cannot compare \"a\" < \"abc\", which are not numbers (hint: only == and != compare values other than numbers)",
+		"1:96: error: invalid range 0..$n (hint: bounds of a range should be integers)",
+	}, msgs)
+}
+
+func TestCheckApps(t *testing.T) {
+	resourceDir := t.TempDir() + "/"
+	os.WriteFile(resourceDir+"good.ttt", []byte("// Good\nreader = #InputReader_1; demux = #TsDemuxer_1; link(reader, demux);"), 0644)
+	os.WriteFile(resourceDir+"bad.ttt", []byte("reader = #InputReader_1;\nlink(reader);"), 0644)
+	os.WriteFile(resourceDir+"graph.json", []byte(`{"plugins": [{"name": "InputReader_1"}], "edges": [{"from": "InputReader_1", "to": "TsDemuxer_1"}]}`), 0644)
+
+	report, ok := CheckApps(resourceDir, []string{}, []string{})
+	assert.Equal(t, false, ok)
+	assert.Equal(t, "bad.ttt:2:1: error: link expects 2 plugins, got 1 (hint: link(parent, child))\n"+
+		"graph.json: error: undefined link: Plugin InputReader_1 links to undefined plugin \"TsDemuxer_1\"\n"+
+		"Apps checked: 3, with errors: 2\n", report)
+
+	report, ok = CheckApps(resourceDir, []string{"good"}, []string{})
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "Apps checked: 1, with errors: 0\n", report)
+}
+
+func TestMacroAndInclude(t *testing.T) {
+	resourceDir := t.TempDir() + "/"
+	os.Mkdir(resourceDir+"lib", 0755)
+	os.WriteFile(resourceDir+"lib/chains.ttt", []byte("def tsChain(uri, monitor);\n  reader = #InputReader;\n  reader.Uri = $uri;\n  demux = #TsDemuxer;\n  link(reader, demux);\n  link(demux, monitor);\n  return reader;\nend;"), 0644)
+	os.WriteFile(resourceDir+"multi.ttt", []byte("// Monitor streams\ninclude(lib/chains);\nmonitor = #OutputMonitor_1;\nfor i, addr in $i;\n  chain_$i = @tsChain($addr, monitor);\nend;"), 0644)
+	os.WriteFile(resourceDir+"single.ttt", []byte("include(lib/chains);\nmonitor = #OutputMonitor_1;\nchain = @tsChain(file://a.ts, monitor);\nhandler = #DataHandler_1;\nlink(chain, handler);"), 0644)
+	os.WriteFile(resourceDir+"cycle.ttt", []byte("include(cycle);"), 0644)
+	os.WriteFile(resourceDir+"undefined.ttt", []byte("chain = @tsChain(a, b);"), 0644)
+	os.WriteFile(resourceDir+"count.ttt", []byte("include(lib/chains);\nchain = @tsChain(a);"), 0644)
+	os.WriteFile(resourceDir+"lib/demux.ttt", []byte("demux = #TsDemuxer_1;"), 0644)
+	os.WriteFile(resourceDir+"before.ttt", []byte("include(lib/chains);\nmonitor = #OutputMonitor_1;\nchain = @tsChain(file://a.ts, monitor);\nreader = #InputReader_1;\ninclude(lib/demux);\nlink(reader, demux);"), 0644)
+
+	params, _, err := loadApp(resourceDir, "multi", []string{"-i", "udp://a:1234,udp://b:1234"})
+	assert.Nil(t, err)
+	assert.Equal(t, []tttKernel.OverallParams{
+		tttKernel.ConstructOverallParam("OutputMonitor_1", "{}", nil),
+		tttKernel.ConstructOverallParam("InputReader_1", "{\"Uri\":\"udp://a:1234\"}", []string{"TsDemuxer_1"}),
+		tttKernel.ConstructOverallParam("TsDemuxer_1", "{}", []string{"OutputMonitor_1"}),
+		tttKernel.ConstructOverallParam("InputReader_2", "{\"Uri\":\"udp://b:1234\"}", []string{"TsDemuxer_2"}),
+		tttKernel.ConstructOverallParam("TsDemuxer_2", "{}", []string{"OutputMonitor_1"}),
+	}, params)
+
+	// An instance is linked by the plugin returned
+	params, _, err = loadApp(resourceDir, "single", []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, []tttKernel.OverallParams{
+		tttKernel.ConstructOverallParam("OutputMonitor_1", "{}", nil),
+		tttKernel.ConstructOverallParam("InputReader_1", "{\"Uri\":\"file://a.ts\"}", []string{"TsDemuxer_1", "DataHandler_1"}),
+		tttKernel.ConstructOverallParam("TsDemuxer_1", "{}", []string{"OutputMonitor_1"}),
+		tttKernel.ConstructOverallParam("DataHandler_1", "{}", nil),
+	}, params)
+
+	// Names declared later, also in includes, are not taken by macros
+	params, _, err = loadApp(resourceDir, "before", []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, []tttKernel.OverallParams{
+		tttKernel.ConstructOverallParam("OutputMonitor_1", "{}", nil),
+		tttKernel.ConstructOverallParam("InputReader_2", "{\"Uri\":\"file://a.ts\"}", []string{"TsDemuxer_2"}),
+		tttKernel.ConstructOverallParam("TsDemuxer_2", "{}", []string{"OutputMonitor_1"}),
+		tttKernel.ConstructOverallParam("InputReader_1", "{}", []string{"TsDemuxer_1"}),
+		tttKernel.ConstructOverallParam("TsDemuxer_1", "{}", nil),
+	}, params)
+
+	report, ok := CheckApps(resourceDir, []string{"cycle", "undefined", "count"}, []string{})
+	assert.Equal(t, false, ok)
+	assert.Equal(t, "cycle.ttt:1:9: error: cycle.ttt includes itself (hint: remove the include)\n"+
+		"undefined.ttt:1:9: error: undefined macro tsChain (hint: define it with def tsChain(...) before use, or include the script defining it)\n"+
+		"count.ttt:2:9: error: macro tsChain expects 2 arguments, got 1 (hint: def tsChain(uri, monitor))\n"+
+		"Apps checked: 3, with errors: 3\n", report)
+}
+
+func TestGraphApp(t *testing.T) {
+	resourceDir := t.TempDir() + "/"
+	os.WriteFile(resourceDir+"reader.ttt", []byte("r = #InputReader_1; d = #TsDemuxer_1; link(r, d);"), 0644)
+	os.WriteFile(resourceDir+"undefined.ttt", []byte("r = #InputReader_1; link(r, dd);"), 0644)
+	os.WriteFile(resourceDir+"bad.ttt", []byte("r = #InputReader_1;\nlink(r);"), 0644)
+
+	dot, err := GraphApp(resourceDir, "reader", []string{})
+	assert.Nil(t, err)
+	assert.Contains(t, dot, "\"InputReader_1\" -> \"TsDemuxer_1\"")
+
+	// Only the undefined link is reported
+	_, err = GraphApp(resourceDir, "undefined", []string{})
+	assert.Equal(t, 1, len(err.(tttKernel.GraphErrors)))
+	assert.Equal(t, tttKernel.UNDEFINED_LINK, err.(tttKernel.GraphErrors)[0].Kind)
+
+	dot, err = GraphApp(resourceDir, "missing", []string{})
+	assert.Equal(t, "", dot)
+	assert.Equal(t, "app missing not found", err.Error())
+
+	dot, err = GraphApp(resourceDir, "bad", []string{})
+	assert.Equal(t, "", dot)
+	assert.Equal(t, "bad.ttt:2:1: error: link expects 2 plugins, got 1 (hint: link(parent, child))", err.Error())
+}
+
+func TestAppNames(t *testing.T) {
+	resourceDir := t.TempDir() + "/"
+	os.WriteFile(resourceDir+"reader.ttt", []byte("include(common);\nr = #InputReader_1; r.Uri = $u;"), 0644)
+	os.WriteFile(resourceDir+"common.ttt", []byte("alias(uri, u);"), 0644)
+	os.WriteFile(resourceDir+"dup.ttt", []byte("r = #InputReader_1;"), 0644)
+	os.WriteFile(resourceDir+"dup.json", []byte(`{"plugins": [{"name": "InputReader_1"}]}`), 0644)
+
+	// Included scripts are not apps, and an app in two formats is reported once
+	report, ok := CheckApps(resourceDir, []string{}, []string{})
+	assert.Equal(t, false, ok)
+	assert.Equal(t, "dup: error: app dup is defined by both dup.json and dup.ttt\n"+
+		"Apps checked: 2, with errors: 1\n", report)
+
+	_, _, err := loadApp(resourceDir, "dup", []string{})
+	assert.Equal(t, "app dup is defined by both dup.json and dup.ttt", err.Error())
+}
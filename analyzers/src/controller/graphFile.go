@@ -0,0 +1,449 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of an app
+const (
+	_FORMAT_TTT  = "ttt"
+	_FORMAT_JSON = "json"
+	_FORMAT_YAML = "yaml"
+)
+
+// A graph defined in JSON or YAML, as an alternative to a ttt script
+type GraphFile struct {
+	Description string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Args        []GraphArg    `json:"args,omitempty" yaml:"args,omitempty"`
+	Global      *GraphGlobal  `json:"global,omitempty" yaml:"global,omitempty"`
+	Plugins     []GraphPlugin `json:"plugins" yaml:"plugins"`
+	Edges       []GraphEdge   `json:"edges,omitempty" yaml:"edges,omitempty"`
+}
+
+// An argument given as -<name> <value>, referred as "$<name>" by values
+type GraphArg struct {
+	Name        string      `json:"name" yaml:"name"`
+	Type        string      `json:"type,omitempty" yaml:"type,omitempty"` // string, int, float or bool, default to be string
+	Default     interface{} `json:"default,omitempty" yaml:"default,omitempty"`
+	Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// Resource of the graph, same as global.* in a ttt script
+type GraphGlobal struct {
+	OutDir  string   `json:"outDir,omitempty" yaml:"outDir,omitempty"`
+	Metrics string   `json:"metrics,omitempty" yaml:"metrics,omitempty"`
+	Record  []string `json:"record,omitempty" yaml:"record,omitempty"`
+}
+
+type GraphPlugin struct {
+	Name       string                 `json:"name" yaml:"name"`
+	Properties map[string]interface{} `json:"properties,omitempty" yaml:"properties,omitempty"`
+}
+
+type GraphEdge struct {
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+}
+
+// Format of an app file from its extension
+func getAppFormat(fileName string) string {
+	switch {
+	case strings.HasSuffix(fileName, ".json"):
+		return _FORMAT_JSON
+	case strings.HasSuffix(fileName, ".yaml"), strings.HasSuffix(fileName, ".yml"):
+		return _FORMAT_YAML
+	}
+	return _FORMAT_TTT
+}
+
+func parseGraphFile(content string, format string) (GraphFile, error) {
+	gf := GraphFile{}
+	var err error
+	switch format {
+	case _FORMAT_JSON:
+		decoder := json.NewDecoder(strings.NewReader(content))
+		decoder.UseNumber()
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&gf)
+	case _FORMAT_YAML:
+		decoder := yaml.NewDecoder(strings.NewReader(content))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&gf)
+	default:
+		err = fmt.Errorf("unknown graph format %s", format)
+	}
+	if err != nil {
+		return gf, fmt.Errorf("fail to parse %s graph: %s", format, err.Error())
+	}
+
+	for idx, arg := range gf.Args {
+		if arg.Name == "" {
+			return gf, fmt.Errorf("argument #%d has no name", idx)
+		}
+		if !graphArgTypes[arg.Type] {
+			return gf, fmt.Errorf("argument %s has unknown type %s", arg.Name, arg.Type)
+		}
+	}
+	for idx, plugin := range gf.Plugins {
+		if plugin.Name == "" {
+			return gf, fmt.Errorf("plugin #%d has no name", idx)
+		}
+		for key, value := range plugin.Properties {
+			plugin.Properties[key] = normalizeValue(value)
+		}
+	}
+	return gf, nil
+}
+
+func (gf GraphFile) getGlobal() GraphGlobal {
+	if gf.Global == nil {
+		return GraphGlobal{}
+	}
+	return *gf.Global
+}
+
+func (gf GraphFile) encode(format string) (string, error) {
+	switch format {
+	case _FORMAT_JSON:
+		buf, err := json.MarshalIndent(gf, "", "  ")
+		return string(buf) + "\n", err
+	case _FORMAT_YAML:
+		buf := bytes.Buffer{}
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		err := encoder.Encode(gf)
+		encoder.Close()
+		return buf.String(), err
+	}
+	return "", fmt.Errorf("unknown graph format %s", format)
+}
+
+// Write the graph as a ttt script. Arguments are read with their defaults where they are referred.
+func (gf GraphFile) toScript() (string, error) {
+	args := map[string]GraphArg{}
+	for _, arg := range gf.Args {
+		args[arg.Name] = arg
+	}
+
+	lines := []string{}
+	if strings.ContainsAny(gf.Description, ";\n") {
+		return "", fmt.Errorf("description with ; or new line cannot be written in ttt")
+	}
+	if gf.Description != "" {
+		lines = append(lines, "// "+gf.Description)
+	}
+
+	var writeValue func(name string, value interface{}) error
+	writeValue = func(name string, value interface{}) error {
+		switch v := value.(type) {
+		case nil:
+			return nil
+		case map[string]interface{}:
+			if len(v) == 0 {
+				return fmt.Errorf("empty object %s cannot be written in ttt", name)
+			}
+			for _, key := range sortedKeys(v) {
+				if err := writeValue(name+"."+key, v[key]); err != nil {
+					return err
+				}
+			}
+			return nil
+		case []interface{}:
+			items := []string{}
+			for _, item := range v {
+				s, err := getScriptValue(item, args, false)
+				if err != nil {
+					return fmt.Errorf("%s: %s", name, err.Error())
+				}
+				items = append(items, s)
+			}
+			lines = append(lines, fmt.Sprintf("%s = [%s]", name, strings.Join(items, ", ")))
+			return nil
+		}
+		s, err := getScriptValue(value, args, true)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s", name, s))
+		return nil
+	}
+
+	global := gf.getGlobal()
+	if global.OutDir != "" {
+		if err := writeValue("global.outDir", global.OutDir); err != nil {
+			return "", err
+		}
+	}
+	if global.Metrics != "" {
+		if err := writeValue("global.metrics", global.Metrics); err != nil {
+			return "", err
+		}
+	}
+	for _, edge := range global.Record {
+		if err := writeValue("global.record", edge); err != nil {
+			return "", err
+		}
+	}
+
+	for _, plugin := range gf.Plugins {
+		lines = append(lines, fmt.Sprintf("%s = #%s", plugin.Name, plugin.Name))
+		for _, key := range sortedKeys(plugin.Properties) {
+			if err := writeValue(plugin.Name+"."+key, plugin.Properties[key]); err != nil {
+				return "", err
+			}
+		}
+	}
+	for _, edge := range gf.Edges {
+		lines = append(lines, fmt.Sprintf("link(%s, %s)", edge.From, edge.To))
+	}
+
+	return strings.Join(lines, ";\n") + ";\n", nil
+}
+
+// Value in a ttt script, which is an argument with an optional default or a plain value
+func getScriptValue(value interface{}, args map[string]GraphArg, withDefault bool) (string, error) {
+	if name, ok := getArgRef(value); ok {
+		arg, hasArg := args[name]
+		if !hasArg {
+			return "", fmt.Errorf("undefined argument %s", name)
+		}
+		if !withDefault || arg.Default == nil {
+			return "$" + name, nil
+		}
+		def, err := getScriptValue(arg.Default, args, false)
+		if err != nil {
+			return "", err
+		}
+		return "$" + name + " | " + def, nil
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("nested value %v cannot be written in ttt", v)
+	}
+	s := fmt.Sprintf("%v", value)
+	if s == "" || strings.ContainsAny(s, "();,|=[] \t\n") || strings.HasPrefix(s, "#") || strings.HasPrefix(s, "$") {
+		return "", fmt.Errorf("value \"%s\" cannot be written in ttt", s)
+	}
+	return s, nil
+}
+
+// Name of the argument if the value is in the form of "$<name>"
+func getArgRef(value interface{}) (string, bool) {
+	s, ok := value.(string)
+	if !ok || !strings.HasPrefix(s, "$") || len(s) == 1 {
+		return "", false
+	}
+	return s[1:], true
+}
+
+var graphArgTypes = map[string]bool{"": true, "string": true, "int": true, "float": true, "bool": true}
+
+// Convert an argument from input
+func (arg GraphArg) parse(value string) (interface{}, error) {
+	var rv interface{}
+	var err error
+	switch arg.Type {
+	case "int":
+		rv, err = strconv.ParseInt(value, 10, 64)
+	case "float":
+		rv, err = strconv.ParseFloat(value, 64)
+	case "bool":
+		rv, err = strconv.ParseBool(value)
+	default:
+		return value, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s argument %s: %s", arg.Type, arg.Name, value)
+	}
+	return rv, nil
+}
+
+// Keep numbers from JSON as integers if possible, so that they are written back as they are
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeValue(item)
+		}
+	case []interface{}:
+		for idx, item := range v {
+			v[idx] = normalizeValue(item)
+		}
+	}
+	return value
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+ * Load a graph file as if it were a script. Each plugin is a variable of its own name.
+ * A value "$<name>" is replaced by the argument. It is dropped if the argument is not
+ * given and has no default.
+ */
+func (sp *scriptParser) loadGraphFile(gf GraphFile, input []string) error {
+	args := map[string]GraphArg{}
+	for _, arg := range gf.Args {
+		args[arg.Name] = arg
+	}
+
+	var resolve func(value interface{}) (interface{}, bool, error)
+	resolve = func(value interface{}) (interface{}, bool, error) {
+		if name, ok := getArgRef(value); ok {
+			arg, hasArg := args[name]
+			if !hasArg {
+				return nil, false, fmt.Errorf("undefined argument %s", name)
+			}
+			if s := sp.getValueWithName(input, name); s != "" {
+				v, err := arg.parse(s)
+				return v, true, err
+			}
+			return arg.Default, arg.Default != nil, nil
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			rv := map[string]interface{}{}
+			for key, item := range v {
+				resolved, ok, err := resolve(item)
+				if err != nil {
+					return nil, false, err
+				}
+				if ok {
+					rv[key] = resolved
+				}
+			}
+			return rv, true, nil
+		case []interface{}:
+			rv := []interface{}{}
+			for _, item := range v {
+				resolved, ok, err := resolve(item)
+				if err != nil {
+					return nil, false, err
+				}
+				if ok {
+					rv = append(rv, resolved)
+				}
+			}
+			return rv, true, nil
+		}
+		return value, value != nil, nil
+	}
+	resolveString := func(value string) (string, error) {
+		resolved, ok, err := resolve(value)
+		if !ok || err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v", resolved), nil
+	}
+
+	sp.description = gf.Description
+
+	global := gf.getGlobal()
+	globalValues := map[string][]string{"outDir": {global.OutDir}, "metrics": {global.Metrics}, "record": global.Record}
+	for _, name := range []string{"outDir", "metrics", "record"} {
+		for _, value := range globalValues[name] {
+			if value == "" {
+				continue
+			}
+			s, err := resolveString(value)
+			if err != nil {
+				return err
+			}
+			if s != "" {
+				sp.setGlobalParam(name, s)
+			}
+		}
+	}
+
+	for _, plugin := range gf.Plugins {
+		sp.declarePlugin(plugin.Name, plugin.Name)
+		pluginVar := sp.variables[len(sp.variables)-1]
+		for _, key := range sortedKeys(plugin.Properties) {
+			value, ok, err := resolve(plugin.Properties[key])
+			if err != nil {
+				return fmt.Errorf("%s.%s: %s", plugin.Name, key, err.Error())
+			}
+			if !ok {
+				continue
+			}
+			buf, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %s", plugin.Name, key, err.Error())
+			}
+			v := sp.newVariable(key, string(buf))
+			v.isJson = true
+			pluginVar.attributes = append(pluginVar.attributes, &v)
+		}
+	}
+
+	for _, edge := range gf.Edges {
+		sp.linkPlugins(edge.From, edge.To)
+	}
+	return nil
+}
+
+// Graph built by a script, which should be valid. Control flow and arguments are expanded.
+func (sp *scriptParser) toGraphFile() (GraphFile, error) {
+	gf := GraphFile{
+		Description: sp.description,
+		Plugins:     []GraphPlugin{},
+	}
+	global := GraphGlobal{
+		Metrics: sp.env.MetricsAddr,
+		Record:  sp.env.Record,
+	}
+	if sp.env.OutDir != newScriptParser().env.OutDir {
+		global.OutDir = sp.env.OutDir
+	}
+	if global.OutDir != "" || global.Metrics != "" || len(global.Record) != 0 {
+		gf.Global = &global
+	}
+
+	for _, v := range sp.variables {
+		if v.varType != _VAR_PLUGIN {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(v.getAttributeStr()))
+		decoder.UseNumber()
+		properties := map[string]interface{}{}
+		if err := decoder.Decode(&properties); err != nil {
+			return gf, fmt.Errorf("%s: %s", v.value, err.Error())
+		}
+		plugin := GraphPlugin{Name: v.value}
+		if len(properties) != 0 {
+			plugin.Properties = normalizeValue(properties).(map[string]interface{})
+		}
+		gf.Plugins = append(gf.Plugins, plugin)
+	}
+
+	for _, v := range sp.variables {
+		if v.varType != _VAR_PLUGIN {
+			continue
+		}
+		for _, child := range sp.edgeMap[v.name] {
+			gf.Edges = append(gf.Edges, GraphEdge{From: v.value, To: child})
+		}
+	}
+	return gf, nil
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -23,6 +24,24 @@ func (p *Property) AddValue(key string, value Property) {
 	p.values[key] = value
 }
 
+// Return a copy of the property with the value set at the path of keys
+func (p Property) withValue(path []string, value Property) Property {
+	rv := NewProperty(p.Name)
+	for k, v := range p.values {
+		rv.values[k] = v
+	}
+	if len(path) == 1 {
+		rv.values[path[0]] = value
+		return rv
+	}
+	child, ok := p.values[path[0]]
+	if !ok {
+		child = NewProperty(path[0])
+	}
+	rv.values[path[0]] = child.withValue(path[1:], value)
+	return rv
+}
+
 func (p *Property) toString() string {
 	if len(p.values) == 0 {
 		_, err := strconv.Atoi(p.Name)
@@ -60,8 +79,22 @@ func (pb *PluginBuilder) SetName(name string) {
 	pb.name = name
 }
 
-func (pb *PluginBuilder) SetProperty(key string, value Property) {
-	pb.properties[key] = value
+/*
+ * Set a property of the plugin. Dotted keys such as Redundancy.TimeRef set
+ * nested properties. If the plugin type is registered, the property is
+ * checked against its parameters and not set if invalid.
+ */
+func (pb *PluginBuilder) SetProperty(key string, value Property) error {
+	path := strings.Split(key, ".")
+	updated := Property{values: pb.properties}.withValue(path, value)
+	if info, ok := tttKernel.GetPluginInfo(pb.name); ok {
+		prop := updated.values[path[0]]
+		if err := info.ValidateField(path[0], prop.toString()); err != nil {
+			return fmt.Errorf("%s: %s", pb.name, err.Error())
+		}
+	}
+	pb.properties = updated.values
+	return nil
 }
 
 func (pb *PluginBuilder) AddChild(child string) {
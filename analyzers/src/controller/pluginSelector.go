@@ -4,30 +4,34 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/tony-507/analyzers/src/plugins/avContainer/tsdemux"
-	"github.com/tony-507/analyzers/src/plugins/baseband"
-	"github.com/tony-507/analyzers/src/plugins/dataHandler"
-	"github.com/tony-507/analyzers/src/plugins/ioUtils"
-	"github.com/tony-507/analyzers/src/plugins/monitor"
+	// Plugins register themselves to the kernel on import
+	_ "github.com/tony-507/analyzers/src/plugins/avContainer/tsdemux"
+	_ "github.com/tony-507/analyzers/src/plugins/avContainer/tsmux"
+	_ "github.com/tony-507/analyzers/src/plugins/baseband"
+	_ "github.com/tony-507/analyzers/src/plugins/dataHandler"
+	_ "github.com/tony-507/analyzers/src/plugins/ioUtils"
+	_ "github.com/tony-507/analyzers/src/plugins/monitor"
 	"github.com/tony-507/analyzers/src/tttKernel"
 )
 
-func selectPlugin(inputName string) tttKernel.IPlugin {
-	// Deduce the type of plugin by name
-	splitName := strings.Split(inputName, "_")
+func isPluginType(inputName string) bool {
+	_, ok := tttKernel.GetPluginInfo(inputName)
+	return ok
+}
 
-	switch splitName[0] {
-	case "InputReader":
-		return ioUtils.InputReader(inputName)
-	case "TsDemuxer":
-		return tsdemux.TsDemuxer(inputName)
-	case "BasebandProcessor":
-		return baseband.BasebandProcessor(inputName)
-	case "DataHandler":
-		return dataHandler.DataHandlerFactory(inputName)
-	case "OutputMonitor":
-		return monitor.OutputMonitor(inputName)
-	default:
+func selectPlugin(inputName string) tttKernel.IPlugin {
+	info, ok := tttKernel.GetPluginInfo(inputName)
+	if !ok {
 		panic(fmt.Sprintf("Unknown plugin name: %s", inputName))
 	}
+	return info.Constructor(inputName)
+}
+
+// Describe all available plugins and their parameters
+func DescribePlugins() string {
+	descriptions := []string{}
+	for _, info := range tttKernel.ListPlugins() {
+		descriptions = append(descriptions, info.Describe())
+	}
+	return strings.Join(descriptions, "\n")
 }
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A statement of a script, i.e. text between semi-colons or new lines. Lines and columns count from 1.
+type scriptLine struct {
+	text string
+	file string
+	line int
+	col  int // Column of the first character of text
+}
+
+// A token of a statement with its position
+type scriptToken struct {
+	text string
+	file string
+	line int
+	col  int
+}
+
+// Split a script into statements, keeping their positions. Empty statements are dropped.
+func lexScript(script string, file string) []scriptLine {
+	lines := []scriptLine{}
+	cur := strings.Builder{}
+	line, col := 1, 1
+	start := scriptLine{file: file, line: 1, col: 1}
+	for _, r := range script {
+		if r == ';' || r == '\n' {
+			if strings.TrimSpace(cur.String()) != "" {
+				start.text = cur.String()
+				lines = append(lines, start)
+			}
+			cur.Reset()
+			if r == '\n' {
+				line++
+				col = 0
+			}
+			start = scriptLine{file: file, line: line, col: col + 1}
+		} else {
+			cur.WriteRune(r)
+		}
+		col++
+	}
+	if strings.TrimSpace(cur.String()) != "" {
+		start.text = cur.String()
+		lines = append(lines, start)
+	}
+	return lines
+}
+
+// Split a statement into tokens. A list [a, b, c] is kept as one token.
+func lexLine(l scriptLine) []scriptToken {
+	tokens := []scriptToken{}
+	cur := strings.Builder{}
+	curCol := l.col
+	depth := 0
+	col := l.col
+	flush := func() {
+		if cur.Len() != 0 {
+			tokens = append(tokens, scriptToken{text: cur.String(), file: l.file, line: l.line, col: curCol})
+			cur.Reset()
+		}
+	}
+	for _, r := range l.text {
+		if r == '[' {
+			depth++
+		} else if r == ']' && depth > 0 {
+			depth--
+		}
+		if depth == 0 && (r == '(' || r == ',' || r == ')' || r == '|' || r == '=' || r == ' ' || r == '\t' || r == '\r') {
+			flush()
+		} else {
+			if cur.Len() == 0 {
+				curCol = col
+			}
+			cur.WriteRune(r)
+		}
+		col++
+	}
+	flush()
+	return tokens
+}
+
+// A problem found in a script, at the token causing it
+type ScriptDiagnostic struct {
+	File    string
+	Line    int
+	Col     int
+	Msg     string
+	Hint    string // How to fix the problem
+	Warning bool   // Warnings do not stop the script from running
+}
+
+func (d ScriptDiagnostic) Error() string {
+	s := fmt.Sprintf("%d:%d: ", d.Line, d.Col)
+	if d.File != "" {
+		s = d.File + ":" + s
+	}
+	if d.Warning {
+		s += "warning: "
+	} else {
+		s += "error: "
+	}
+	s += d.Msg
+	if d.Hint != "" {
+		s += " (hint: " + d.Hint + ")"
+	}
+	return s
+}
+
+// All problems found in a script
+type ScriptDiagnostics []ScriptDiagnostic
+
+func (d ScriptDiagnostics) Error() string {
+	msgs := []string{}
+	for _, diag := range d {
+		msgs = append(msgs, diag.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (d ScriptDiagnostics) errors() ScriptDiagnostics {
+	errs := ScriptDiagnostics{}
+	for _, diag := range d {
+		if !diag.Warning {
+			errs = append(errs, diag)
+		}
+	}
+	return errs
+}
@@ -2,6 +2,9 @@ package controller
 
 import (
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -20,30 +23,44 @@ const (
 type scriptVar struct {
 	name       string
 	varType    _VARTYPE
-	value      string // Array value not supported
+	value      string
+	isArray    bool
+	items      []string // Elements of an array value
+	isJson     bool     // Value is in JSON already, e.g. from a graph file
 	attributes []*scriptVar
 }
 
 func (v *scriptVar) getAttributeStr() string {
 	s := ""
-	if len(v.attributes) != 0 || v.varType == _VAR_PLUGIN {
+	if v.isJson {
+		s = v.value
+	} else if len(v.attributes) != 0 || v.varType == _VAR_PLUGIN {
 		s = "{"
 		fieldArr := make([]string, 0)
 		for _, field := range v.attributes {
 			fieldArr = append(fieldArr, "\""+field.name+"\":"+field.getAttributeStr())
 		}
 		s += strings.Join(fieldArr, ",") + "}"
-	} else {
-		_, err := strconv.Atoi(v.value)
-		if err != nil && v.value != "true" && v.value != "false" {
-			s = "\"" + v.value + "\""
-		} else {
-			s = v.value
+	} else if v.isArray {
+		itemArr := make([]string, 0)
+		for _, item := range v.items {
+			itemArr = append(itemArr, getValueStr(item))
 		}
+		s = "[" + strings.Join(itemArr, ",") + "]"
+	} else {
+		s = getValueStr(v.value)
 	}
 	return s
 }
 
+func getValueStr(value string) string {
+	_, err := strconv.Atoi(value)
+	if err != nil && value != "true" && value != "false" {
+		return "\"" + value + "\""
+	}
+	return value
+}
+
 // Script parsing
 
 type scriptParser struct {
@@ -52,6 +69,13 @@ type scriptParser struct {
 	edgeMap     map[string][]string // Graph topology
 	variables   []*scriptVar        // Variables
 	env         tttKernel.Resource
+	file        string            // Script file name for diagnostics
+	diags       ScriptDiagnostics // Problems found in the script
+	includeDir  string            // Directory of included scripts, include is not allowed if empty
+	includes    []string          // Scripts being included, to detect cycles
+	macros      map[string]*scriptStmt
+	instances   map[string]string // Macro instances and the variables of the plugins they return
+	reserved    map[string]bool   // Plugin names declared explicitly anywhere in the script, not taken by macros
 }
 
 func newScriptParser() scriptParser {
@@ -59,108 +83,716 @@ func newScriptParser() scriptParser {
 		aliasMap: map[string]string{},
 		edgeMap: map[string][]string{},
 		variables: []*scriptVar{},
+		macros:    map[string]*scriptStmt{},
+		instances: map[string]string{},
+		reserved:  map[string]bool{},
 		env: tttKernel.Resource{
 			OutDir: "output",
 		},
 	}
 }
 
-// Read from script and input to prepare plugins and the respective parameters
+// Read from script and input to prepare plugins and the respective parameters.
+// Panic with ScriptDiagnostics if the script has errors.
 func (sp *scriptParser) buildParams(script string, input []string, lim int) {
-	lines := strings.FieldsFunc(script, func(r rune) bool { return r == ';' || r == '\n' })
-	syntaxErrLine := 0
-	msg := ""
+	sp.checkScript(script, input, lim)
+	if errs := sp.diags.errors(); len(errs) != 0 {
+		panic(errs)
+	}
+}
 
-	lNum := 0
-	shouldRun := true
+// Parse and run a script, collecting all problems found in sp.diags
+func (sp *scriptParser) checkScript(script string, input []string, lim int) {
+	lines := lexScript(script, sp.file)
+
+	// Blocks cut by the limit are closed implicitly
+	partial := lim >= 0 && lim < len(lines)
+	if partial {
+		lines = lines[:lim]
+	}
 
-	// For loop handling
-	loopVar := ""
-	loopMax := -1
-	loopIdx := -1
+	stmts := sp.parseScript(lines, partial)
+	sp.checkAliases(stmts)
+	sp.reservePluginNames(stmts)
 
-	if lim < 0 {
-		lim = len(lines)
+	if len(sp.diags.errors()) == 0 {
+		sp.run(stmts, input, []scriptLoop{}, nil)
 	}
+}
 
-	for lNum < len(lines) {
-		if lNum >= lim {
-			break
+// Parse all lines of a script, reporting lines closing blocks that are not opened
+func (sp *scriptParser) parseScript(lines []scriptLine, partial bool) []*scriptStmt {
+	lNum := 0
+	stmts, last := sp.parseBlock(lines, &lNum, partial)
+	for last != nil {
+		sp.report(last.toks[0], false, "remove it, or add the block it closes", "%s without if, for or def", last.tokens[0])
+		more, next := sp.parseBlock(lines, &lNum, partial)
+		stmts = append(stmts, more...)
+		last = next
+	}
+	return stmts
+}
+
+// Parse an included script, whose statements run in place of the include
+func (sp *scriptParser) includeScript(stmt *scriptStmt) []*scriptStmt {
+	if len(stmt.tokens) != 2 {
+		return nil
+	}
+	name := stmt.tokens[1]
+	if filepath.Ext(name) == "" {
+		name += ".ttt"
+	}
+	if sp.includeDir == "" {
+		sp.report(stmt.toks[1], false, "run the script as an app in the resource directory", "cannot include %s without a resource directory", name)
+		return nil
+	}
+	for _, file := range append([]string{sp.file}, sp.includes...) {
+		if file == name {
+			sp.report(stmt.toks[1], false, "remove the include", "%s includes itself", name)
+			return nil
 		}
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(sp.includeDir, name))
+	if err != nil {
+		sp.report(stmt.toks[1], false, "include a script in the resource directory, e.g. include(lib/chains)", "cannot include %s: %s", name, err.Error())
+		return nil
+	}
 
-		tokens := parseLine(lines[lNum])
+	sp.includes = append(sp.includes, name)
+	defer func() { sp.includes = sp.includes[:len(sp.includes)-1] }()
+	return sp.parseScript(lexScript(string(buf), name), false)
+}
+
+// Report a problem at a token
+func (sp *scriptParser) report(tok scriptToken, warning bool, hint string, format string, a ...interface{}) {
+	file := tok.file
+	if file == "" {
+		file = sp.file
+	}
+	sp.diags = append(sp.diags, ScriptDiagnostic{
+		File:    file,
+		Line:    tok.line,
+		Col:     tok.col,
+		Msg:     fmt.Sprintf(format, a...),
+		Hint:    hint,
+		Warning: warning,
+	})
+}
 
-		if len(tokens) == 0 || (!shouldRun && tokens[0] != "end") {
-			lNum++
+// A statement of script, with the blocks it controls
+type scriptStmt struct {
+	scriptLine
+	tokens   []string
+	toks     []scriptToken  // Tokens with positions
+	branches []scriptBranch // if, elif and else blocks of an if statement
+	body     []*scriptStmt  // Body of a for loop or a macro
+}
+
+type scriptBranch struct {
+	cond *scriptStmt // The if, elif or else statement
+	body []*scriptStmt
+}
+
+// Current value of a for loop
+type scriptLoop struct {
+	names []string // Variable names of the index and the value, or of the value only
+	value string
+	pos   int // Index of the value
+	idx   int // Index to read $opt_$idx arguments, which is the value itself for a range
+}
+
+/*
+ * Group lines into statements until the end of the script or a line closing the block, i.e. elif, else and end.
+ * The closing line is returned as well. Missing end is allowed if the script is partial.
+ */
+func (sp *scriptParser) parseBlock(lines []scriptLine, lNum *int, partial bool) ([]*scriptStmt, *scriptStmt) {
+	stmts := []*scriptStmt{}
+	for *lNum < len(lines) {
+		stmt := &scriptStmt{scriptLine: lines[*lNum], toks: lexLine(lines[*lNum])}
+		*lNum++
+
+		if len(stmt.toks) == 0 {
+			continue
+		}
+		for _, tok := range stmt.toks {
+			stmt.tokens = append(stmt.tokens, tok.text)
+		}
+		sp.checkStmt(stmt)
+
+		switch stmt.tokens[0] {
+		case "elif", "else", "end":
+			return stmts, stmt
+		case "include":
+			stmts = append(stmts, sp.includeScript(stmt)...)
 			continue
+		case "if":
+			cond := stmt
+			for {
+				body, next := sp.parseBlock(lines, lNum, partial)
+				stmt.branches = append(stmt.branches, scriptBranch{cond: cond, body: body})
+				if next == nil {
+					if !partial {
+						sp.report(stmt.toks[0], false, "close the block with end", "if without end")
+					}
+					break
+				}
+				if next.tokens[0] == "end" {
+					break
+				}
+				if cond.tokens[0] == "else" {
+					sp.report(next.toks[0], false, "move it before else", "%s after else", next.tokens[0])
+				}
+				cond = next
+			}
+		case "for", "def":
+			body, next := sp.parseBlock(lines, lNum, partial)
+			if next == nil && !partial {
+				sp.report(stmt.toks[0], false, "close the block with end", "%s without end", stmt.tokens[0])
+			}
+			if next != nil && next.tokens[0] != "end" {
+				sp.report(next.toks[0], false, "close the "+stmt.tokens[0]+" block with end first", "%s without if", next.tokens[0])
+			}
+			stmt.body = body
+		}
+
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+// Check syntax of a statement without running it
+func (sp *scriptParser) checkStmt(stmt *scriptStmt) {
+	toks := stmt.toks
+	switch toks[0].text {
+	case "link":
+		if len(toks) != 3 {
+			sp.report(toks[0], false, "link(parent, child)", "link expects 2 plugins, got %d", len(toks)-1)
+		}
+	case "alias":
+		if len(toks) != 3 {
+			sp.report(toks[0], false, "alias(option, name) reads -option as $name", "alias expects 2 names, got %d", len(toks)-1)
+		}
+	case "include":
+		if len(toks) != 2 {
+			sp.report(toks[0], false, "include(file), e.g. include(lib/chains)", "include expects 1 script, got %d", len(toks)-1)
+		}
+	case "def":
+		if len(toks) < 2 {
+			sp.report(toks[0], false, "def name(param, ...)", "missing macro name")
+		}
+		for _, tok := range toks[1:] {
+			if !isIdentifier(tok.text) {
+				sp.report(tok, false, "use letters, digits and _", "invalid macro or parameter name %s", tok.text)
+			}
+		}
+	case "return":
+		if len(toks) != 2 {
+			sp.report(toks[0], false, "return a plugin variable, e.g. return handler", "return expects 1 variable, got %d", len(toks)-1)
+		}
+	case "if", "elif":
+		cond := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(stmt.text), toks[0].text))
+		loc := comparisonPattern.FindStringIndex(cond)
+		if loc == nil {
+			if len(parseLine(cond)) == 0 {
+				sp.report(toks[0], false, "e.g. if $f or if $n >= 2", "missing condition")
+			}
+		} else if len(parseLine(cond[:loc[0]])) == 0 || len(parseLine(cond[loc[1]:])) == 0 {
+			sp.report(toks[0], false, "compare two values, e.g. if $n >= 2", "missing operand of %s", cond[loc[0]:loc[1]])
+		}
+	case "else", "end":
+		if len(toks) != 1 {
+			sp.report(toks[1], false, "put it on a new line", "unexpected %s after %s", toks[1].text, toks[0].text)
+		}
+	case "for":
+		inPos := -1
+		for i, tok := range toks {
+			if tok.text == "in" {
+				inPos = i
+				break
+			}
+		}
+		if (inPos != 2 && inPos != 3) || len(toks) == inPos+1 {
+			sp.report(toks[0], false, "for <var> in <values> or for <idx>, <var> in <values>", "malformed for loop")
+			return
+		}
+		for _, tok := range toks[1:inPos] {
+			if !isIdentifier(tok.text) {
+				sp.report(tok, false, "use letters, digits and _", "invalid loop variable %s", tok.text)
+			}
+		}
+		values := toks[inPos+1]
+		if strings.Contains(values.text, "..") && !strings.HasPrefix(values.text, "[") {
+			split := strings.SplitN(values.text, "..", 2)
+			if split[0] == "" || split[1] == "" {
+				sp.report(values, false, "<from>..<to> excluding <to>, e.g. 0..3", "malformed range %s", values.text)
+			}
+		}
+		sp.checkValue(values)
+	default:
+		if strings.HasPrefix(toks[0].text, "//") {
+			return
+		}
+		if !strings.Contains(stmt.text, "=") {
+			sp.report(toks[0], false, "<name> = <value>, or a statement such as link, alias, if or for", "unknown statement %s", toks[0].text)
+			return
+		}
+		if !isVariableName(toks[0].text) {
+			sp.report(toks[0], false, "use letters, digits and _, with . for attributes", "invalid variable name %s", toks[0].text)
+		}
+		if len(toks) < 2 {
+			sp.report(toks[0], false, "<name> = <value>", "missing value of %s", toks[0].text)
+			return
+		}
+		if len(toks) > 3 && !strings.HasPrefix(toks[1].text, "@") {
+			sp.report(toks[3], false, "values cannot contain spaces, commas, brackets, | or =", "unexpected %s", toks[3].text)
+		}
+		for _, tok := range toks[1:] {
+			sp.checkValue(tok)
+		}
+	}
+}
+
+func (sp *scriptParser) checkValue(tok scriptToken) {
+	switch {
+	case tok.text == "#":
+		sp.report(tok, false, "e.g. #InputReader_1", "missing plugin name")
+	case tok.text == "$":
+		sp.report(tok, false, "e.g. $f to read -f", "missing argument name")
+	case tok.text == "@":
+		sp.report(tok, false, "e.g. @tsChain($f)", "missing macro name")
+	case strings.HasPrefix(tok.text, "[") && !strings.HasSuffix(tok.text, "]"):
+		sp.report(tok, false, "close the list with ]", "unterminated list %s", tok.text)
+	}
+}
+
+// Warn aliases whose names are never read as arguments
+func (sp *scriptParser) checkAliases(stmts []*scriptStmt) {
+	refs := map[string]bool{}
+	aliases := []*scriptStmt{}
+	var walk func(stmts []*scriptStmt)
+	walk = func(stmts []*scriptStmt) {
+		for _, stmt := range stmts {
+			for _, match := range argRefPattern.FindAllStringSubmatch(stmt.text, -1) {
+				refs[match[1]] = true
+			}
+			if stmt.tokens[0] == "alias" && len(stmt.tokens) == 3 {
+				aliases = append(aliases, stmt)
+			}
+			for _, branch := range stmt.branches {
+				// The first condition is the statement itself
+				for _, match := range argRefPattern.FindAllStringSubmatch(branch.cond.text, -1) {
+					refs[match[1]] = true
+				}
+				walk(branch.body)
+			}
+			walk(stmt.body)
+		}
+	}
+	walk(stmts)
+
+	for _, stmt := range aliases {
+		if !refs[stmt.tokens[2]] {
+			sp.report(stmt.toks[2], true, fmt.Sprintf("read it with $%s, or remove the alias", stmt.tokens[2]), "alias %s of -%s is never read", stmt.tokens[2], stmt.tokens[1])
+		}
+	}
+}
+
+var argRefPattern = regexp.MustCompile(`\$\{?([A-Za-z0-9_]+)`)
+
+func isIdentifier(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if !isIdentChar(name[i]) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Variable names may have attributes and loop variables, e.g. reader_$i.Uri
+func isVariableName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if !isIdentChar(name[i]) && !strings.ContainsRune(".${}", rune(name[i])) {
+			return false
+		}
+	}
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, ".")
+}
+
+// Run statements with the values of the enclosing loops, in a macro if call is not nil
+func (sp *scriptParser) run(stmts []*scriptStmt, input []string, loops []scriptLoop, call *macroCall) {
+	idx := -1
+	if len(loops) != 0 {
+		idx = loops[len(loops)-1].idx
+	}
+
+	for _, stmt := range stmts {
+		tokens := make([]string, len(stmt.tokens))
+		for i, token := range stmt.tokens {
+			tokens[i] = substituteLoopVars(token, loops)
 		}
 
 		switch tokens[0] {
-		case "//":
-			// Description
-			sp.description = strings.Join(tokens[1:], " ")
 		case "link":
 			// Link two plugins
-			if len(tokens) != 3 {
-				panic(fmt.Sprintf("Wrong number of input: %d, %v", len(tokens), tokens))
+			if len(tokens) == 3 {
+				for i := 1; i < 3; i++ {
+					tokens[i] = sp.getLinkVar(stmt.toks[i], call.rename(tokens[i]))
+					if v := sp.getVariable([]string{tokens[i]}, false); v == nil {
+						sp.report(stmt.toks[i], true, "declare it before the link, e.g. "+tokens[i]+" = #Plugin_1", "undefined variable %s", tokens[i])
+					} else if v.varType != _VAR_PLUGIN {
+						sp.report(stmt.toks[i], true, "only plugins can be linked", "%s is not a plugin", tokens[i])
+					}
+				}
+				sp.linkPlugins(tokens[1], tokens[2])
 			}
-			sp.linkPlugins(tokens[1], tokens[2])
 		case "alias":
 			// Set alias to a variable
-			sp.setAlias(tokens[1], tokens[2])
+			if len(tokens) == 3 {
+				sp.setAlias(tokens[1], tokens[2])
+			}
 		case "if":
-			// Conditional
-			initFrom := []rune(tokens[1])
-			switch initFrom[0] {
-			case '$':
-				if len(tokens) != 3 {
-					tokens = append(tokens, "")
+			// Conditional, run the first branch whose condition holds
+			for _, branch := range stmt.branches {
+				if branch.cond.tokens[0] == "else" || sp.checkCondition(branch.cond, input, loops, idx) {
+					sp.run(branch.body, input, loops, call)
+					break
 				}
-				shouldRun = sp.getValueFromArgs(input, string(initFrom[1:]), tokens[2], loopIdx) != ""
-			default:
-				syntaxErrLine = lNum
-				msg = "Unknown condition for if block"
-				break
 			}
 		case "for":
 			// For loop
-			loopVar = tokens[1]
-
-			input = append(input, loopVar)
-			input = append(input, strconv.Itoa(loopIdx))
-
-			split := strings.Split(tokens[3], "..")
-			loopIdx, _ = strconv.Atoi(split[0])
-			loopMax, _ = strconv.Atoi(split[1])
-		case "end":
-			// End of control flow
-			shouldRun = true
-			if loopMax != -1 {
-				loopIdx++
-
-				input = input[:len(input)-2]
-
-				if loopIdx == loopMax {
-					loopVar = ""
-					loopMax = -1
-					loopIdx = -1
-				} else {
-					input = append(input, loopVar)
-					input = append(input, strconv.Itoa(loopIdx))
+			inPos := 2
+			if tokens[2] != "in" {
+				inPos = 3
+			}
+			values, isRange := sp.getLoopValues(stmt.toks[inPos+1], tokens[inPos+1:], input, idx)
+			for i, value := range values {
+				loop := scriptLoop{names: tokens[1:inPos], value: value, pos: i, idx: i}
+				if isRange {
+					loop.idx, _ = strconv.Atoi(value)
 				}
+				sp.run(stmt.body, input, append(loops[:len(loops):len(loops)], loop), call)
+			}
+		case "def":
+			// Macro definition
+			if len(tokens) > 1 {
+				sp.macros[tokens[1]] = stmt
+			}
+		case "return":
+			// Plugin returned by a macro
+			if call == nil {
+				sp.report(stmt.toks[0], false, "use return in def blocks only", "return outside of a macro")
+			} else if len(tokens) == 2 {
+				call.ret = call.rename(tokens[1])
 			}
-
 		default:
-			// Declaration
-			sp.declare(tokens, input, loopIdx)
+			if strings.HasPrefix(tokens[0], "//") {
+				// Description
+				sp.description = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(stmt.text), "//"))
+				continue
+			}
+			// Declaration, with names local to the macro if any
+			if call != nil && !strings.Contains(tokens[0], ".") {
+				call.locals[tokens[0]] = true
+			}
+			tokens[0] = call.rename(tokens[0])
+			if strings.HasPrefix(tokens[1], "@") {
+				sp.expandMacro(stmt, tokens, input, loops, call)
+				continue
+			}
+			if !strings.ContainsAny(tokens[1][:1], "#$[") {
+				tokens[1] = call.rename(tokens[1])
+			}
+			if call != nil && strings.HasPrefix(tokens[1], "#") && !strings.Contains(tokens[1], "_") {
+				tokens[1] = "#" + sp.getUniquePluginName(tokens[1][1:])
+			}
+
+			if splitName := strings.Split(tokens[0], "."); len(splitName) > 1 && splitName[0] != "global" {
+				if sp.getVariable(splitName[:1], false) == nil {
+					sp.report(stmt.toks[0], true, "declare it first, e.g. "+splitName[0]+" = #Plugin_1", "undefined variable %s", splitName[0])
+				}
+			}
+			if strings.HasPrefix(tokens[1], "#") && !isPluginType(tokens[1][1:]) {
+				sp.report(stmt.toks[1], true, "run ttt plugins to list plugins", "unknown plugin type of %s", tokens[1][1:])
+			}
+			sp.declare(tokens, input, idx)
 		}
+	}
+}
+
+// Expansion of a macro. Variables declared in the macro are local, and prefixed by the instance name.
+type macroCall struct {
+	prefix string
+	locals map[string]bool
+	ret    string // Variable of the plugin returned
+	depth  int
+}
+
+const maxMacroDepth = 16
+
+// Name of a variable in the macro, e.g. reader.Uri is chain_reader.Uri in instance chain
+func (c *macroCall) rename(name string) string {
+	if c == nil {
+		return name
+	}
+	split := strings.SplitN(name, ".", 2)
+	if !c.locals[split[0]] {
+		return name
+	}
+	return c.prefix + "_" + name
+}
 
-		lNum++
+/*
+ * Expand a macro in the form of
+ *   name = @macro(arg, ...)
+ * Arguments are read like RHS, except that plugin variables are passed as they are
+ * so that they can be linked in the macro.
+ */
+func (sp *scriptParser) expandMacro(stmt *scriptStmt, tokens []string, input []string, loops []scriptLoop, call *macroCall) {
+	name := tokens[1][1:]
+	def, ok := sp.macros[name]
+	if !ok {
+		sp.report(stmt.toks[1], false, "define it with def "+name+"(...) before use, or include the script defining it", "undefined macro %s", name)
+		return
+	}
+	params, args := def.tokens[2:], tokens[2:]
+	if len(params) != len(args) {
+		sp.report(stmt.toks[1], false, fmt.Sprintf("def %s(%s)", name, strings.Join(params, ", ")), "macro %s expects %d arguments, got %d", name, len(params), len(args))
+		return
+	}
+
+	depth := 0
+	if call != nil {
+		depth = call.depth + 1
+	}
+	if depth >= maxMacroDepth {
+		sp.report(stmt.toks[1], false, "a macro cannot expand itself", "macro %s is nested too deep", name)
+		return
+	}
+
+	idx := -1
+	if len(loops) != 0 {
+		idx = loops[len(loops)-1].idx
+	}
+	scope := loops[:len(loops):len(loops)]
+	for i, arg := range args {
+		value := ""
+		if strings.HasPrefix(arg, "$") {
+			value = sp.getValueFromArgs(input, arg[1:], "", idx)
+		} else if v := sp.getVariable([]string{call.rename(arg)}, false); v != nil && v.varType == _VAR_PLUGIN {
+			value = call.rename(arg)
+		} else {
+			value = sp.resolveRHS(call.rename(arg))
+		}
+		scope = append(scope, scriptLoop{names: params[i : i+1], value: value, pos: idx, idx: idx})
+	}
+
+	instance := &macroCall{prefix: tokens[0], locals: map[string]bool{}, depth: depth}
+	sp.run(def.body, input, scope, instance)
+	sp.instances[tokens[0]] = instance.ret
+}
+
+// Variable to link, which is the plugin returned if it is a macro instance
+func (sp *scriptParser) getLinkVar(tok scriptToken, name string) string {
+	ret, isInstance := sp.instances[name]
+	if !isInstance {
+		return name
+	}
+	if ret == "" {
+		sp.report(tok, true, "add return <plugin> to the macro", "macro instance %s returns no plugin", name)
+		return name
+	}
+	return ret
+}
+
+// Reserve plugin names like #InputReader_1 in all statements, including included scripts and macros,
+// so that a macro expanded before the declaration does not take the name
+func (sp *scriptParser) reservePluginNames(stmts []*scriptStmt) {
+	for _, stmt := range stmts {
+		for _, token := range stmt.tokens {
+			if strings.HasPrefix(token, "#") && strings.Contains(token, "_") && !strings.ContainsAny(token, "${") {
+				sp.reserved[token[1:]] = true
+			}
+		}
+		for _, branch := range stmt.branches {
+			sp.reservePluginNames(branch.body)
+		}
+		sp.reservePluginNames(stmt.body)
+	}
+}
+
+// Number a plugin declared in a macro, e.g. InputReader_2 if InputReader_1 exists or is reserved
+func (sp *scriptParser) getUniquePluginName(pluginType string) string {
+	used := map[string]bool{}
+	for name := range sp.reserved {
+		used[name] = true
+	}
+	for _, v := range sp.variables {
+		if v.varType == _VAR_PLUGIN {
+			used[v.value] = true
+		}
+	}
+	for n := 1; ; n++ {
+		if name := fmt.Sprintf("%s_%d", pluginType, n); !used[name] {
+			return name
+		}
+	}
+}
+
+// Check condition of an if or elif line, which is a value or a comparison of two values
+func (sp *scriptParser) checkCondition(stmt *scriptStmt, input []string, loops []scriptLoop, idx int) bool {
+	cond := strings.TrimSpace(substituteLoopVars(stmt.text, loops))
+	cond = strings.TrimSpace(strings.TrimPrefix(cond, stmt.tokens[0]))
+
+	loc := comparisonPattern.FindStringIndex(cond)
+	if loc == nil {
+		return sp.resolveOperand(parseLine(cond), input, idx) != ""
+	}
+
+	rv, err := compareValues(sp.resolveOperand(parseLine(cond[:loc[0]]), input, idx), cond[loc[0]:loc[1]], sp.resolveOperand(parseLine(cond[loc[1]:]), input, idx))
+	if err != nil {
+		// Point at the operator in the original line
+		tok := stmt.toks[0]
+		if opLoc := comparisonPattern.FindStringIndex(stmt.text); opLoc != nil {
+			tok.col = stmt.col + len([]rune(stmt.text[:opLoc[0]]))
+		}
+		sp.report(tok, false, "only == and != compare values other than numbers", "%s", err.Error())
+	}
+	return rv
+}
+
+var comparisonPattern = regexp.MustCompile("==|!=|<=|>=|<|>")
+
+// Compare as numbers if both values are numbers, else as strings
+func compareValues(lhs string, op string, rhs string) (bool, error) {
+	x, errX := strconv.ParseFloat(lhs, 64)
+	y, errY := strconv.ParseFloat(rhs, 64)
+	if errX == nil && errY == nil {
+		switch op {
+		case "==":
+			return x == y, nil
+		case "!=":
+			return x != y, nil
+		case "<":
+			return x < y, nil
+		case "<=":
+			return x <= y, nil
+		case ">":
+			return x > y, nil
+		case ">=":
+			return x >= y, nil
+		}
 	}
 
-	if syntaxErrLine > 0 {
-		panic(fmt.Sprintf("Line %d: %s", syntaxErrLine+1, msg))
+	switch op {
+	case "==":
+		return lhs == rhs, nil
+	case "!=":
+		return lhs != rhs, nil
 	}
+	return false, fmt.Errorf("cannot compare \"%s\" %s \"%s\", which are not numbers", lhs, op, rhs)
+}
+
+// Value of an operand in the form of [value, default]
+func (sp *scriptParser) resolveOperand(operand []string, input []string, idx int) string {
+	if len(operand) == 0 {
+		return ""
+	}
+	def := ""
+	if len(operand) > 1 {
+		def = operand[1]
+	}
+	if strings.HasPrefix(operand[0], "$") {
+		return sp.getValueFromArgs(input, operand[0][1:], def, idx)
+	}
+	return sp.resolveRHS(operand[0])
+}
+
+/*
+ * Values to iterate in a for loop, which can be
+ * - a range m..n, excluding n
+ * - a list [a, b, c]
+ * - a comma-separated argument $opt, with an optional default
+ * - a list variable
+ * The second return value tells if the values are from a range.
+ */
+func (sp *scriptParser) getLoopValues(tok scriptToken, operand []string, input []string, idx int) ([]string, bool) {
+	target := operand[0]
+	switch {
+	case strings.HasPrefix(target, "["):
+		return sp.resolveList(target, input, idx), false
+	case strings.HasPrefix(target, "$"):
+		return splitList(sp.resolveOperand(operand, input, idx)), false
+	case strings.Contains(target, ".."):
+		split := strings.SplitN(target, "..", 2)
+		from, errFrom := strconv.Atoi(sp.resolveOperand([]string{split[0]}, input, idx))
+		to, errTo := strconv.Atoi(sp.resolveOperand([]string{split[1]}, input, idx))
+		if errFrom != nil || errTo != nil {
+			sp.report(tok, false, "bounds of a range should be integers", "invalid range %s", target)
+			return []string{}, true
+		}
+		values := []string{}
+		for i := from; i < to; i++ {
+			values = append(values, strconv.Itoa(i))
+		}
+		return values, true
+	}
+
+	if v := sp.getVariable([]string{target}, false); v != nil && v.isArray {
+		return v.items, false
+	}
+	return splitList(sp.resolveRHS(target)), false
+}
+
+// Replace $name or ${name} of loop variables in a token, e.g. reader_$i. Inner loops take precedence.
+func substituteLoopVars(token string, loops []scriptLoop) string {
+	if len(loops) == 0 || !strings.Contains(token, "$") {
+		return token
+	}
+
+	sb := strings.Builder{}
+	for pos := 0; pos < len(token); {
+		if token[pos] != '$' {
+			sb.WriteByte(token[pos])
+			pos++
+			continue
+		}
+		end := pos + 1
+		for end < len(token) && isIdentChar(token[end]) {
+			end++
+		}
+		name := token[pos+1 : end]
+		if end == pos+1 && strings.HasPrefix(token[end:], "{") {
+			if brace := strings.Index(token[end:], "}"); brace > 0 {
+				name = token[end+1 : end+brace]
+				end += brace + 1
+			}
+		}
+		value, ok := getLoopVar(name, loops)
+		if ok {
+			sb.WriteString(value)
+		} else {
+			sb.WriteString(token[pos:end])
+		}
+		pos = end
+	}
+	return sb.String()
+}
+
+func getLoopVar(name string, loops []scriptLoop) (string, bool) {
+	for i := len(loops) - 1; i >= 0; i-- {
+		names := loops[i].names
+		if names[len(names)-1] == name {
+			return loops[i].value, true
+		}
+		if len(names) == 2 && names[0] == name {
+			return strconv.Itoa(loops[i].pos), true
+		}
+	}
+	return "", false
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
 }
 
 func (sp *scriptParser) setAlias(alias string, orig string) {
@@ -168,10 +800,15 @@ func (sp *scriptParser) setAlias(alias string, orig string) {
 }
 
 func (sp *scriptParser) linkPlugins(parent string, child string) {
+	// Keep the variable name if it is undefined so that it can be reported
+	childName := sp.getValueFromName(child)
+	if childName == "" {
+		childName = child
+	}
 	if _, hasKey := sp.edgeMap[parent]; hasKey {
-		sp.edgeMap[parent] = append(sp.edgeMap[parent], sp.getValueFromName(child))
+		sp.edgeMap[parent] = append(sp.edgeMap[parent], childName)
 	} else {
-		sp.edgeMap[parent] = []string{sp.getValueFromName(child)}
+		sp.edgeMap[parent] = []string{childName}
 	}
 }
 
@@ -198,8 +835,14 @@ func (sp *scriptParser) declare(tokens []string, input []string, idx int) {
 	case '$':
 		// User input
 		sp.declareVariable(name, sp.getValueFromArgs(input, target, def, idx))
+	case '[':
+		sp.declareArray(name, sp.resolveList(value, input, idx))
 	default:
-		sp.declareVariable(name, sp.resolveRHS(tokens[1]))
+		if v := sp.getVariable([]string{value}, false); v != nil && v.isArray {
+			sp.declareArray(name, v.items)
+		} else {
+			sp.declareVariable(name, sp.resolveRHS(value))
+		}
 	}
 }
 
@@ -218,6 +861,8 @@ func (sp *scriptParser) declareVariable(fullname string, value string) {
 	existVar := sp.getVariable(splitName, false)
 	if existVar != nil {
 		existVar.value = value
+		existVar.isArray = false
+		existVar.items = nil
 	} else {
 		if len(splitName) > 1 {
 			if splitName[0] == "global" {
@@ -234,6 +879,20 @@ func (sp *scriptParser) declareVariable(fullname string, value string) {
 	}
 }
 
+func (sp *scriptParser) declareArray(fullname string, items []string) {
+	splitName := strings.Split(fullname, ".")
+	if len(splitName) > 1 && splitName[0] == "global" {
+		for _, item := range items {
+			sp.setGlobalParam(splitName[1], item)
+		}
+		return
+	}
+	v := sp.getVariable(splitName, true)
+	v.value = ""
+	v.isArray = true
+	v.items = items
+}
+
 func (sp *scriptParser) newVariable(name string, value string) scriptVar {
 	return scriptVar{
 		name: name,
@@ -247,6 +906,12 @@ func (sp *scriptParser) setGlobalParam(name string, value string) {
 	switch (name) {
 	case "outDir":
 		sp.env.OutDir = value
+	case "metrics":
+		sp.env.MetricsAddr = value
+	case "record":
+		if value != "" {
+			sp.env.Record = append(sp.env.Record, value)
+		}
 	}
 }
 
@@ -321,6 +986,33 @@ func (sp *scriptParser) getValueFromName(name string) string {
 	return ""
 }
 
+// Elements of a list [a, b, c]. Each element is resolved like RHS, or read from input arguments if it begins with $
+func (sp *scriptParser) resolveList(list string, input []string, idx int) []string {
+	items := []string{}
+	for _, item := range splitList(strings.TrimSuffix(strings.TrimPrefix(list, "["), "]")) {
+		if strings.HasPrefix(item, "$") {
+			item = sp.getValueFromArgs(input, item[1:], "", idx)
+		} else {
+			item = sp.resolveRHS(item)
+		}
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Split a comma-separated value, ignoring empty elements
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // Determine if RHS is another variable
 // If yes, return value of the variable (no recursion)
 // else, return the string itself
@@ -331,21 +1023,11 @@ func (sp *scriptParser) resolveRHS(rhs string) string {
 	return rhs
 }
 
+// Split a line into tokens, e.g. a part of a statement. A list [a, b, c] is kept as one token.
 func parseLine(line string) []string {
-	origTokens := strings.FieldsFunc(
-		line,
-		func(r rune) bool {
-			return r == '(' || r == ',' || r == ')' || r == '|' || r == '=' || r == ' '
-		},
-	)
-
 	tokens := make([]string, 0)
-	for idx := range origTokens {
-		origTokens[idx] = strings.TrimSpace(origTokens[idx])
-		if len(origTokens[idx]) > 0 {
-			tokens = append(tokens, origTokens[idx])
-		}
+	for _, tok := range lexLine(scriptLine{text: line, line: 1, col: 1}) {
+		tokens = append(tokens, tok.text)
 	}
-
 	return tokens
 }
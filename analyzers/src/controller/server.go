@@ -0,0 +1,410 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tony-507/analyzers/src/logging"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+type JOB_STATE string
+
+const (
+	JOB_RUNNING  JOB_STATE = "running"
+	JOB_STOPPING JOB_STATE = "stopping"
+	JOB_FINISHED JOB_STATE = "finished"
+	JOB_FAILED   JOB_STATE = "failed"
+)
+
+// A plugin of a submitted graph
+type JobPlugin struct {
+	Name     string
+	Param    json.RawMessage
+	Children []string
+}
+
+func toParams(graph []JobPlugin) []tttKernel.OverallParams {
+	pluginParams := []tttKernel.OverallParams{}
+	for _, plugin := range graph {
+		param := "{}"
+		if len(plugin.Param) != 0 {
+			param = string(plugin.Param)
+		}
+		pluginParams = append(pluginParams, tttKernel.ConstructOverallParam(plugin.Name, param, plugin.Children))
+	}
+	return pluginParams
+}
+
+// Body of a job submission. Either Graph or App is given.
+type JobRequest struct {
+	Name  string
+	Graph []JobPlugin
+	App   string
+	Args  []string
+}
+
+// A job as reported by the API
+type JobInfo struct {
+	Id        string
+	Name      string
+	State     JOB_STATE
+	Error     string `json:",omitempty"`
+	StartTime time.Time
+	EndTime   *time.Time `json:",omitempty"`
+	Status    string     `json:",omitempty"` // Diagnostics of plugins, only for a single job
+}
+
+type job struct {
+	info   JobInfo
+	worker *tttKernel.Worker
+	outDir string
+}
+
+/*
+ * A server running graphs as jobs, controlled over HTTP with JSON:
+ *   POST   /jobs                     Submit a job
+ *   GET    /jobs                     List jobs
+ *   GET    /jobs/<id>                Query a job with plugin diagnostics
+ *   DELETE /jobs/<id>[?force=true]   Stop a job
+ *   GET    /jobs/<id>/files          List output files
+ *   GET    /jobs/<id>/files/<path>   Fetch an output file
+ *   PUT    /jobs/<id>/plugins/<name> Restart a plugin with the parameters in the body
+ *   POST   /jobs/<id>/plugins        Add plugins given in Graph of the body
+ *   DELETE /jobs/<id>/plugins?name=  Remove the named plugins
+ * Outputs of each job are written to a separate directory under outDir.
+ */
+type JobServer struct {
+	logger      logging.Log
+	resourceDir string
+	outDir      string
+	jobs        map[string]*job
+	nextId      int
+	mtx         sync.Mutex
+	wg          sync.WaitGroup
+}
+
+func NewJobServer(resourceDir string, outDir string) *JobServer {
+	return &JobServer{
+		logger:      logging.CreateLogger("JobServer"),
+		resourceDir: resourceDir,
+		outDir:      outDir,
+		jobs:        map[string]*job{},
+		nextId:      1,
+	}
+}
+
+// Serve the API until SIGINT or SIGTERM. Running jobs receive the signal as well, and the server waits for them to end.
+func Serve(addr string, resourceDir string, outDir string) error {
+	server := NewJobServer(resourceDir, outDir)
+	httpServer := &http.Server{Addr: addr, Handler: server}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- httpServer.ListenAndServe()
+	}()
+	server.logger.Info("Serving jobs at %s", addr)
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-sig:
+	}
+
+	server.logger.Info("Waiting for all jobs to end")
+	httpServer.Close()
+	server.Wait()
+	return nil
+}
+
+func (s *JobServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if path[0] != "jobs" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %s", req.URL.Path))
+		return
+	}
+
+	switch {
+	case len(path) == 1 && req.Method == http.MethodPost:
+		s.handleSubmit(w, req)
+	case len(path) == 1 && req.Method == http.MethodGet:
+		writeJson(w, http.StatusOK, s.List())
+	case len(path) == 2 && req.Method == http.MethodGet:
+		info, ok := s.Query(path[1])
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", path[1]))
+			return
+		}
+		writeJson(w, http.StatusOK, info)
+	case len(path) == 2 && req.Method == http.MethodDelete:
+		force, _ := strconv.ParseBool(req.URL.Query().Get("force"))
+		info, ok := s.Stop(path[1], force)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", path[1]))
+			return
+		}
+		writeJson(w, http.StatusOK, info)
+	case len(path) >= 3 && path[2] == "files" && req.Method == http.MethodGet:
+		s.handleFiles(w, req, path[1], strings.Join(path[3:], "/"))
+	case len(path) == 4 && path[2] == "plugins" && req.Method == http.MethodPut:
+		param, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.handleReconfig(w, path[1], func(worker *tttKernel.Worker) error {
+			return worker.UpdateParameter(path[3], string(param))
+		})
+	case len(path) == 3 && path[2] == "plugins" && req.Method == http.MethodPost:
+		var jobReq JobRequest
+		if err := json.NewDecoder(req.Body).Decode(&jobReq); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.handleReconfig(w, path[1], func(worker *tttKernel.Worker) error {
+			return AddBranch(worker, toParams(jobReq.Graph))
+		})
+	case len(path) == 3 && path[2] == "plugins" && req.Method == http.MethodDelete:
+		s.handleReconfig(w, path[1], func(worker *tttKernel.Worker) error {
+			return worker.RemoveBranch(req.URL.Query()["name"])
+		})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", req.Method, req.URL.Path))
+	}
+}
+
+func (s *JobServer) handleSubmit(w http.ResponseWriter, req *http.Request) {
+	var jobReq JobRequest
+	if err := json.NewDecoder(req.Body).Decode(&jobReq); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	info, err := s.Submit(jobReq)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJson(w, http.StatusCreated, info)
+}
+
+func (s *JobServer) handleFiles(w http.ResponseWriter, req *http.Request, id string, name string) {
+	s.mtx.Lock()
+	j, ok := s.jobs[id]
+	s.mtx.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+		return
+	}
+
+	if name == "" {
+		files := []string{}
+		filepath.Walk(j.outDir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				rel, _ := filepath.Rel(j.outDir, path)
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		sort.Strings(files)
+		writeJson(w, http.StatusOK, files)
+		return
+	}
+
+	// Only files in the output directory of the job can be fetched
+	path := filepath.Join(j.outDir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(j.outDir, path); err != nil || strings.HasPrefix(rel, "..") {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid file %s", name))
+		return
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		writeError(w, http.StatusNotFound, fmt.Errorf("file %s not found", name))
+		return
+	}
+	http.ServeFile(w, req, path)
+}
+
+// Reconfigure a running job and reply with its status
+func (s *JobServer) handleReconfig(w http.ResponseWriter, id string, reconfig func(*tttKernel.Worker) error) {
+	s.mtx.Lock()
+	j, ok := s.jobs[id]
+	s.mtx.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+		return
+	}
+	if err := reconfig(j.worker); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	info, _ := s.Query(id)
+	writeJson(w, http.StatusOK, info)
+}
+
+// Validate and start a job
+func (s *JobServer) Submit(jobReq JobRequest) (JobInfo, error) {
+	var pluginParams []tttKernel.OverallParams
+	var env tttKernel.Resource
+	var err error
+
+	switch {
+	case len(jobReq.Graph) != 0 && jobReq.App != "":
+		return JobInfo{}, fmt.Errorf("only one of Graph and App can be given")
+	case len(jobReq.Graph) != 0:
+		pluginParams = toParams(jobReq.Graph)
+		err = tttKernel.ValidateGraph(pluginParams, isPluginType)
+	case jobReq.App != "":
+		pluginParams, env, err = loadApp(s.resourceDir, jobReq.App, jobReq.Args)
+	default:
+		return JobInfo{}, fmt.Errorf("no Graph or App is given")
+	}
+	if err != nil {
+		return JobInfo{}, err
+	}
+
+	s.mtx.Lock()
+	id := strconv.Itoa(s.nextId)
+	s.nextId++
+	name := jobReq.Name
+	if name == "" {
+		name = jobReq.App
+	}
+	worker := tttKernel.NewWorker()
+	j := &job{
+		info: JobInfo{
+			Id:        id,
+			Name:      name,
+			State:     JOB_RUNNING,
+			StartTime: time.Now(),
+		},
+		worker: &worker,
+		outDir: filepath.Join(s.outDir, id),
+	}
+	s.jobs[id] = j
+	s.mtx.Unlock()
+
+	env.OutDir = j.outDir + string(filepath.Separator)
+	j.worker.UpdateResource(env)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		err := runJob(j.worker, pluginParams)
+
+		s.mtx.Lock()
+		endTime := time.Now()
+		j.info.EndTime = &endTime
+		j.info.State = JOB_FINISHED
+		if err != nil {
+			j.info.State = JOB_FAILED
+			j.info.Error = err.Error()
+		}
+		state := j.info.State
+		s.mtx.Unlock()
+		s.logger.Info("Job %s (%s) %s", id, name, state)
+	}()
+
+	s.logger.Info("Job %s (%s) started", id, name)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return j.info, nil
+}
+
+// Plugins may panic on invalid parameters, which should not take down the server
+func runJob(worker *tttKernel.Worker, pluginParams []tttKernel.OverallParams) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return worker.StartService(pluginParams, selectPlugin)
+}
+
+// All jobs sorted by ID
+func (s *JobServer) List() []JobInfo {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	infos := []JobInfo{}
+	for _, j := range s.jobs {
+		infos = append(infos, j.info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		a, _ := strconv.Atoi(infos[i].Id)
+		b, _ := strconv.Atoi(infos[j].Id)
+		return a < b
+	})
+	return infos
+}
+
+func (s *JobServer) Query(id string) (JobInfo, bool) {
+	s.mtx.Lock()
+	j, ok := s.jobs[id]
+	if !ok {
+		s.mtx.Unlock()
+		return JobInfo{}, false
+	}
+	info := j.info
+	s.mtx.Unlock()
+
+	info.Status = j.worker.Info()
+	return info, true
+}
+
+// End a job gracefully, or force it to stop. Stopping an ended job does nothing.
+func (s *JobServer) Stop(id string, force bool) (JobInfo, bool) {
+	s.mtx.Lock()
+	j, ok := s.jobs[id]
+	if !ok {
+		s.mtx.Unlock()
+		return JobInfo{}, false
+	}
+	switch {
+	case j.info.State == JOB_FINISHED || j.info.State == JOB_FAILED:
+		s.mtx.Unlock()
+		return j.info, true
+	case j.info.State == JOB_STOPPING && !force:
+		// Interrupting again would force the job to stop
+		s.mtx.Unlock()
+		return j.info, true
+	}
+	j.info.State = JOB_STOPPING
+	info := j.info
+	s.mtx.Unlock()
+
+	// A job ending takes the lock, so the worker is stopped without it
+	if force {
+		j.worker.StopGraph()
+	} else {
+		j.worker.Interrupt()
+	}
+	return info, true
+}
+
+// Wait for all jobs to end
+func (s *JobServer) Wait() {
+	s.wg.Wait()
+}
+
+func writeJson(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(obj)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, map[string]string{"Error": err.Error()})
+}
@@ -40,24 +40,29 @@ func (l *Log) Fatal(msg string, param ...interface{}) {
 }
 
 func (l *Log) _log(level int, msg string, param ...interface{}) {
+	// Loggers of concurrent jobs read the setting at the same time
+	configMtx.RLock()
+	logLevel, msgPrefix := globalConfig.logLevel, globalConfig.msgPrefix
+	configMtx.RUnlock()
+
 	// Check if user has setting
-	if globalConfig.logLevel == 0 {
-		globalConfig.logLevel = _LOG_TRACE
+	if logLevel == 0 {
+		logLevel = _LOG_TRACE
 	}
-	if globalConfig.msgPrefix == "" {
-		globalConfig.msgPrefix = "[%l]"
+	if msgPrefix == "" {
+		msgPrefix = "[%l]"
 	}
 
-	if globalConfig.logLevel == _LOG_DISABLED {
+	if logLevel == _LOG_DISABLED {
 		return
 	}
 
-	if level >= globalConfig.logLevel {
+	if level >= logLevel {
 		sb := "[" + l.id + "] "
 
 		// Use a string builder pattern to build the message
 		bNextIsOpt := false
-		for _, chr := range globalConfig.msgPrefix {
+		for _, chr := range msgPrefix {
 			// Start of an option
 			if chr == '%' {
 				bNextIsOpt = true
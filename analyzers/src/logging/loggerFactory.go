@@ -3,6 +3,7 @@ package logging
 import (
 	"log"
 	"os"
+	"sync"
 )
 
 const (
@@ -23,9 +24,12 @@ type loggerConfig struct {
 }
 
 var globalConfig loggerConfig
+var configMtx sync.RWMutex
 
 // Setting a property for the global logger
 func SetLoggingProperty(key string, val string) {
+	configMtx.Lock()
+	defer configMtx.Unlock()
 	switch key {
 	case "level":
 		switch val {
@@ -60,6 +64,8 @@ func SetLoggingProperty(key string, val string) {
 }
 
 func CleanUp() {
+	configMtx.Lock()
+	defer configMtx.Unlock()
 	if globalConfig.logFile != nil {
 		globalConfig.logFile.Close()
 	}
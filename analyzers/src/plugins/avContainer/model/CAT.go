@@ -0,0 +1,59 @@
+package model
+
+// Parsing of CAT
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+type catStruct struct {
+	siSection
+	schema *CatSchema
+}
+
+type CatSchema struct {
+	PktCnt      int
+	Version     int
+	Descriptors []SiDescriptor
+	Crc32       int
+}
+
+func (c *catStruct) Process() error {
+	r := io.GetBufferReader(c.payload)
+
+	h := readLongSectionHeader(&r)
+	c.schema.Version = h.Version
+	if !c.callback.UpdateSiVersion(siVersionKey(1, c.tableId, 0, h.SectionNumber), h.Version) {
+		return nil
+	}
+
+	descLen := c.sectionLen - 9
+	if descLen < 0 {
+		return errors.New("Something wrong with section length")
+	}
+	c.schema.Descriptors = readSiDescriptors(&r, descLen)
+	c.schema.Crc32 = r.ReadBits(32)
+
+	jsonBytes, _ := json.MarshalIndent(c.schema, "", "\t")
+	c.callback.PsiUpdateFinished(1, c.schema.Version, jsonBytes)
+
+	return nil
+}
+
+func (c *catStruct) GetField(str string) (int, error) {
+	return resolveHeaderField(c, str)
+}
+
+func (c *catStruct) GetName() string {
+	return "CAT"
+}
+
+func CatTable(manager PsiManager, pktCnt int, buf []byte) (DataStruct, error) {
+	rv := &catStruct{siSection: siSection{callback: manager, pktCnt: pktCnt, tableId: 1}}
+	rv.schema = &CatSchema{PktCnt: pktCnt, Version: -1, Descriptors: make([]SiDescriptor, 0), Crc32: -1}
+	err := rv.setBuffer(buf, rv.GetName(), 1)
+	return rv, err
+}
@@ -0,0 +1,96 @@
+package model
+
+// Parsing of EIT (present/following and schedule)
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+type eitStruct struct {
+	siSection
+	schema *EitSchema
+}
+
+type EitEvent struct {
+	EventId       int
+	StartTime     string
+	Duration      string
+	RunningStatus int
+	FreeCaMode    bool
+	Descriptors   []SiDescriptor
+}
+
+type EitSchema struct {
+	PktCnt                   int
+	TableId                  int
+	ServiceId                int
+	TransportStreamId        int
+	OrigNetworkId            int
+	Version                  int
+	SectionNumber            int
+	LastSectionNumber        int
+	SegmentLastSectionNumber int
+	LastTableId              int
+	Events                   []EitEvent
+	Crc32                    int
+}
+
+func (e *eitStruct) Process() error {
+	r := io.GetBufferReader(e.payload)
+
+	h := readLongSectionHeader(&r)
+	e.schema.ServiceId = h.TableIdExt
+	e.schema.Version = h.Version
+	e.schema.SectionNumber = h.SectionNumber
+	e.schema.LastSectionNumber = h.LastSectionNumber
+	if !e.callback.UpdateSiVersion(siVersionKey(0x12, e.tableId, h.TableIdExt, h.SectionNumber), h.Version) {
+		return nil
+	}
+
+	e.schema.TransportStreamId = r.ReadBits(16)
+	e.schema.OrigNetworkId = r.ReadBits(16)
+	e.schema.SegmentLastSectionNumber = r.ReadBits(8)
+	e.schema.LastTableId = r.ReadBits(8)
+
+	remainedLen := e.sectionLen - 11
+	for remainedLen > 4 {
+		event := EitEvent{}
+		event.EventId = r.ReadBits(16)
+		event.StartTime = decodeUtcTime(r.ReadBits(40)).Format(time.RFC3339)
+		event.Duration = decodeBcdTime(r.ReadBits(24), 3)
+		event.RunningStatus = r.ReadBits(3)
+		event.FreeCaMode = r.ReadBits(1) == 1
+		descLen := r.ReadBits(12)
+		event.Descriptors = readSiDescriptors(&r, descLen)
+		e.schema.Events = append(e.schema.Events, event)
+		remainedLen -= 12 + descLen
+	}
+	if remainedLen != 4 {
+		return errors.New("Something wrong with section length")
+	}
+	e.schema.Crc32 = r.ReadBits(32)
+
+	jsonBytes, _ := json.MarshalIndent(e.schema, "", "\t")
+	e.callback.PsiUpdateFinished(0x12, -1, jsonBytes)
+
+	return nil
+}
+
+func (e *eitStruct) GetField(str string) (int, error) {
+	return resolveHeaderField(e, str)
+}
+
+func (e *eitStruct) GetName() string {
+	return "EIT"
+}
+
+func EitTable(manager PsiManager, pktCnt int, tableId int, buf []byte) (DataStruct, error) {
+	rv := &eitStruct{siSection: siSection{callback: manager, pktCnt: pktCnt, tableId: tableId}}
+	rv.schema = &EitSchema{PktCnt: pktCnt, TableId: tableId, Version: -1, Events: make([]EitEvent, 0), Crc32: -1}
+	err := rv.setBuffer(buf, rv.GetName(), 1)
+	return rv, err
+}
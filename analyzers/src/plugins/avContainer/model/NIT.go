@@ -0,0 +1,88 @@
+package model
+
+// Parsing of NIT (actual and other network)
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+type nitStruct struct {
+	siSection
+	schema *NitSchema
+}
+
+type NitTransportStream struct {
+	TransportStreamId int
+	OrigNetworkId     int
+	Descriptors       []SiDescriptor
+}
+
+type NitSchema struct {
+	PktCnt            int
+	TableId           int
+	NetworkId         int
+	Version           int
+	SectionNumber     int
+	LastSectionNumber int
+	Descriptors       []SiDescriptor
+	TransportStreams  []NitTransportStream
+	Crc32             int
+}
+
+func (n *nitStruct) Process() error {
+	r := io.GetBufferReader(n.payload)
+
+	h := readLongSectionHeader(&r)
+	n.schema.NetworkId = h.TableIdExt
+	n.schema.Version = h.Version
+	n.schema.SectionNumber = h.SectionNumber
+	n.schema.LastSectionNumber = h.LastSectionNumber
+	if !n.callback.UpdateSiVersion(siVersionKey(0x10, n.tableId, h.TableIdExt, h.SectionNumber), h.Version) {
+		return nil
+	}
+
+	r.ReadBits(4) // reserved_future_use
+	networkDescLen := r.ReadBits(12)
+	n.schema.Descriptors = readSiDescriptors(&r, networkDescLen)
+	r.ReadBits(4) // reserved_future_use
+	remainedLen := r.ReadBits(12)
+	if 13+networkDescLen+remainedLen != n.sectionLen {
+		return errors.New("Something wrong with section length")
+	}
+
+	for remainedLen > 0 {
+		ts := NitTransportStream{}
+		ts.TransportStreamId = r.ReadBits(16)
+		ts.OrigNetworkId = r.ReadBits(16)
+		r.ReadBits(4) // reserved_future_use
+		descLen := r.ReadBits(12)
+		ts.Descriptors = readSiDescriptors(&r, descLen)
+		n.schema.TransportStreams = append(n.schema.TransportStreams, ts)
+		remainedLen -= 6 + descLen
+	}
+	n.schema.Crc32 = r.ReadBits(32)
+
+	jsonBytes, _ := json.MarshalIndent(n.schema, "", "\t")
+	n.callback.PsiUpdateFinished(0x10, -1, jsonBytes)
+
+	return nil
+}
+
+func (n *nitStruct) GetField(str string) (int, error) {
+	return resolveHeaderField(n, str)
+}
+
+func (n *nitStruct) GetName() string {
+	return "NIT"
+}
+
+func NitTable(manager PsiManager, pktCnt int, tableId int, buf []byte) (DataStruct, error) {
+	rv := &nitStruct{siSection: siSection{callback: manager, pktCnt: pktCnt, tableId: tableId}}
+	rv.schema = &NitSchema{PktCnt: pktCnt, TableId: tableId, Version: -1, Descriptors: make([]SiDescriptor, 0),
+		TransportStreams: make([]NitTransportStream, 0), Crc32: -1}
+	err := rv.setBuffer(buf, rv.GetName(), 1)
+	return rv, err
+}
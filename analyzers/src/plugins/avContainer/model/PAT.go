@@ -1,8 +1,6 @@
 package model
 
 // Parsing and writing of PAT
-// Known issue:
-// * Not support PAT with size > 1 TS packet
 
 import (
 	"encoding/json"
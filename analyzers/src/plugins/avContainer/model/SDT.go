@@ -0,0 +1,91 @@
+package model
+
+// Parsing of SDT (actual and other transport stream)
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+type sdtStruct struct {
+	siSection
+	schema *SdtSchema
+}
+
+type SdtService struct {
+	ServiceId           int
+	EitSchedule         bool
+	EitPresentFollowing bool
+	RunningStatus       int
+	FreeCaMode          bool
+	Descriptors         []SiDescriptor
+}
+
+type SdtSchema struct {
+	PktCnt            int
+	TableId           int
+	TransportStreamId int
+	OrigNetworkId     int
+	Version           int
+	SectionNumber     int
+	LastSectionNumber int
+	Services          []SdtService
+	Crc32             int
+}
+
+func (s *sdtStruct) Process() error {
+	r := io.GetBufferReader(s.payload)
+
+	h := readLongSectionHeader(&r)
+	s.schema.TransportStreamId = h.TableIdExt
+	s.schema.Version = h.Version
+	s.schema.SectionNumber = h.SectionNumber
+	s.schema.LastSectionNumber = h.LastSectionNumber
+	if !s.callback.UpdateSiVersion(siVersionKey(0x11, s.tableId, h.TableIdExt, h.SectionNumber), h.Version) {
+		return nil
+	}
+
+	s.schema.OrigNetworkId = r.ReadBits(16)
+	r.ReadBits(8) // reserved_future_use
+
+	remainedLen := s.sectionLen - 8
+	for remainedLen > 4 {
+		service := SdtService{}
+		service.ServiceId = r.ReadBits(16)
+		r.ReadBits(6) // reserved_future_use
+		service.EitSchedule = r.ReadBits(1) == 1
+		service.EitPresentFollowing = r.ReadBits(1) == 1
+		service.RunningStatus = r.ReadBits(3)
+		service.FreeCaMode = r.ReadBits(1) == 1
+		descLen := r.ReadBits(12)
+		service.Descriptors = readSiDescriptors(&r, descLen)
+		s.schema.Services = append(s.schema.Services, service)
+		remainedLen -= 5 + descLen
+	}
+	if remainedLen != 4 {
+		return errors.New("Something wrong with section length")
+	}
+	s.schema.Crc32 = r.ReadBits(32)
+
+	jsonBytes, _ := json.MarshalIndent(s.schema, "", "\t")
+	s.callback.PsiUpdateFinished(0x11, -1, jsonBytes)
+
+	return nil
+}
+
+func (s *sdtStruct) GetField(str string) (int, error) {
+	return resolveHeaderField(s, str)
+}
+
+func (s *sdtStruct) GetName() string {
+	return "SDT"
+}
+
+func SdtTable(manager PsiManager, pktCnt int, tableId int, buf []byte) (DataStruct, error) {
+	rv := &sdtStruct{siSection: siSection{callback: manager, pktCnt: pktCnt, tableId: tableId}}
+	rv.schema = &SdtSchema{PktCnt: pktCnt, TableId: tableId, Version: -1, Services: make([]SdtService, 0), Crc32: -1}
+	err := rv.setBuffer(buf, rv.GetName(), 1)
+	return rv, err
+}
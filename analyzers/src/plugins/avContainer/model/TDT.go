@@ -0,0 +1,66 @@
+package model
+
+// Parsing of TDT and TOT
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+type tdtStruct struct {
+	siSection
+	schema *TdtSchema
+}
+
+type TdtSchema struct {
+	PktCnt      int
+	TableId     int
+	UtcTime     string
+	Descriptors []SiDescriptor `json:",omitempty"`
+	Crc32       int            `json:",omitempty"`
+}
+
+func (t *tdtStruct) Process() error {
+	r := io.GetBufferReader(t.payload)
+
+	utc := decodeUtcTime(r.ReadBits(40))
+	t.schema.UtcTime = utc.Format(time.RFC3339)
+
+	if t.tableId == 0x73 {
+		r.ReadBits(4) // reserved
+		descLen := r.ReadBits(12)
+		if 11+descLen != t.sectionLen {
+			return errors.New("Something wrong with section length")
+		}
+		t.schema.Descriptors = readSiDescriptors(&r, descLen)
+		t.schema.Crc32 = r.ReadBits(32)
+	}
+
+	t.callback.StreamTimeReceived(utc, t.pktCnt)
+
+	jsonBytes, _ := json.MarshalIndent(t.schema, "", "\t")
+	t.callback.PsiUpdateFinished(0x14, -1, jsonBytes)
+
+	return nil
+}
+
+func (t *tdtStruct) GetField(str string) (int, error) {
+	return resolveHeaderField(t, str)
+}
+
+func (t *tdtStruct) GetName() string {
+	if t.tableId == 0x73 {
+		return "TOT"
+	}
+	return "TDT"
+}
+
+func TdtTable(manager PsiManager, pktCnt int, tableId int, buf []byte) (DataStruct, error) {
+	rv := &tdtStruct{siSection: siSection{callback: manager, pktCnt: pktCnt, tableId: tableId}}
+	rv.schema = &TdtSchema{PktCnt: pktCnt, TableId: tableId}
+	err := rv.setBuffer(buf, rv.GetName(), 0)
+	return rv, err
+}
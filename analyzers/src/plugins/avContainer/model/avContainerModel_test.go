@@ -1,9 +1,12 @@
 package model
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
 	"github.com/tony-507/analyzers/src/tttKernel"
 )
 
@@ -15,6 +18,8 @@ type dummyManagerStruct struct {
 	psiJsons           map[int][]byte
 	scte35SplicePTS    []int
 	receivedSpliceNull bool
+	siVersions         map[string]int
+	streamTime         time.Time
 }
 
 func (m *dummyManagerStruct) AddStream(version int, progNum int, streamPid int, streamType int) {
@@ -42,7 +47,7 @@ func (m *dummyManagerStruct) PsiUpdateFinished(pid int, version int, jsonBytes [
 	m.psiJsons[pid] = jsonBytes
 }
 
-func (m *dummyManagerStruct) SpliceEventReceived(dpiPid int, spliceCmdTypeStr string, splicePTS []int, pktCnt int) {
+func (m *dummyManagerStruct) SpliceEventReceived(dpiPid int, spliceCmdTypeStr string, splicePTS []int, descriptors []Splice_descriptor, pktCnt int) {
 	if spliceCmdTypeStr == "splice_null" {
 		m.receivedSpliceNull = true
 	} else {
@@ -50,6 +55,18 @@ func (m *dummyManagerStruct) SpliceEventReceived(dpiPid int, spliceCmdTypeStr st
 	}
 }
 
+func (m *dummyManagerStruct) StreamTimeReceived(utc time.Time, pktCnt int) {
+	m.streamTime = utc
+}
+
+func (m *dummyManagerStruct) UpdateSiVersion(key string, version int) bool {
+	if oldVersion, ok := m.siVersions[key]; ok && oldVersion == version {
+		return false
+	}
+	m.siVersions[key] = version
+	return true
+}
+
 func dummyManager() *dummyManagerStruct {
 	rv := &dummyManagerStruct{}
 	rv.programRecords = make(map[int]int, 0)
@@ -59,6 +76,7 @@ func dummyManager() *dummyManagerStruct {
 	rv.psiJsons = make(map[int][]byte, 0)
 	rv.scte35SplicePTS = make([]int, 0)
 	rv.receivedSpliceNull = false
+	rv.siVersions = make(map[string]int, 0)
 
 	return rv
 }
@@ -67,8 +85,9 @@ type dummyPesCallbackStruct struct {
 	outputQueue []tttKernel.CmBuf
 }
 
-func (p *dummyPesCallbackStruct) PesPacketReady(buf tttKernel.CmBuf, pid int) {
+func (p *dummyPesCallbackStruct) PesPacketReady(buf tttKernel.CmBuf, pid int) error {
 	p.outputQueue = append(p.outputQueue, buf)
+	return nil
 }
 
 func dummyPesCallback() pesHandle {
@@ -143,6 +162,102 @@ func TestReadPMT(t *testing.T) {
 		0x36, 0x38, 0x37, 0x39, 0x34, 0xa, 0x7d}, manager.psiJsons[258], "PMT content not match")
 }
 
+func TestReadSDT(t *testing.T) {
+	dummySDT := []byte{0x00, 0x42, 0xb0, 0x1d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x02,
+		0xff, 0x00, 0x64, 0xfd, 0x80, 0x0c, 0x48, 0x0a, 0x01, 0x03, 0x74, 0x74, 0x74,
+		0x04, 0x4e, 0x65, 0x77, 0x73, 0x07, 0x0e, 0xeb, 0xcf}
+	manager := dummyManager()
+
+	table, err := PsiTable(manager, 0, 0x11, dummySDT)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, "SDT", table.GetName(), "Table should be SDT")
+	assert.Equal(t, true, table.Ready(), "SDT should be ready for parsing")
+	if parseErr := table.Process(); parseErr != nil {
+		panic(parseErr)
+	}
+
+	schema := SdtSchema{}
+	if jsonErr := json.Unmarshal(manager.psiJsons[0x11], &schema); jsonErr != nil {
+		panic(jsonErr)
+	}
+	assert.Equal(t, 1, schema.TransportStreamId, "Transport stream id not match")
+	assert.Equal(t, 2, schema.OrigNetworkId, "Original network id not match")
+	assert.Equal(t, 1, len(schema.Services), "SDT should have one service")
+	assert.Equal(t, 100, schema.Services[0].ServiceId, "Service id not match")
+	assert.Equal(t, true, schema.Services[0].EitPresentFollowing, "EIT p/f flag not match")
+	assert.Equal(t, 4, schema.Services[0].RunningStatus, "Running status not match")
+	assert.Equal(t, map[string]interface{}{"ServiceType": float64(1), "ProviderName": "ttt", "ServiceName": "News"},
+		schema.Services[0].Descriptors[0].Decoded, "Service descriptor not match")
+
+	// Same version should not be written again
+	delete(manager.psiJsons, 0x11)
+	table, _ = PsiTable(manager, 1, 0x11, dummySDT)
+	table.Process()
+	_, written := manager.psiJsons[0x11]
+	assert.Equal(t, false, written, "SDT with same version should be skipped")
+}
+
+func TestReadEIT(t *testing.T) {
+	dummyEIT := []byte{0x00, 0x4e, 0xb0, 0x2b, 0x00, 0x64, 0xc1, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 0x00, 0x4e, 0x12, 0x34, 0xea, 0x29, 0x12, 0x30, 0x00, 0x01, 0x00,
+		0x00, 0x80, 0x10, 0x4d, 0x0e, 0x65, 0x6e, 0x67, 0x04, 0x53, 0x68, 0x6f, 0x77,
+		0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0xe9, 0xe6, 0x9c, 0xe0}
+	manager := dummyManager()
+
+	table, err := PsiTable(manager, 0, 0x12, dummyEIT)
+	if err != nil {
+		panic(err)
+	}
+	if parseErr := table.Process(); parseErr != nil {
+		panic(parseErr)
+	}
+
+	schema := EitSchema{}
+	if jsonErr := json.Unmarshal(manager.psiJsons[0x12], &schema); jsonErr != nil {
+		panic(jsonErr)
+	}
+	assert.Equal(t, 100, schema.ServiceId, "Service id not match")
+	assert.Equal(t, 1, len(schema.Events), "EIT should have one event")
+	assert.Equal(t, 0x1234, schema.Events[0].EventId, "Event id not match")
+	assert.Equal(t, "2023-01-01T12:30:00Z", schema.Events[0].StartTime, "Start time not match")
+	assert.Equal(t, "01:00:00", schema.Events[0].Duration, "Duration not match")
+	assert.Equal(t, map[string]interface{}{"Language": "eng", "EventName": "Show", "Text": "Hello"},
+		schema.Events[0].Descriptors[0].Decoded, "Short event descriptor not match")
+}
+
+func TestReadTDT(t *testing.T) {
+	dummyTDT := []byte{0x00, 0x70, 0x70, 0x05, 0xea, 0x29, 0x12, 0x30, 0x00}
+	manager := dummyManager()
+
+	table, err := PsiTable(manager, 0, 0x14, dummyTDT)
+	if err != nil {
+		panic(err)
+	}
+	if parseErr := table.Process(); parseErr != nil {
+		panic(parseErr)
+	}
+	assert.Equal(t, time.Date(2023, 1, 1, 12, 30, 0, 0, time.UTC), manager.streamTime, "UTC time not match")
+}
+
+func TestReadTruncatedSiDescriptors(t *testing.T) {
+	// Network name descriptor followed by one claiming 10 bytes
+	buf := []byte{0x40, 0x03, 0x41, 0x42, 0x43, 0x40, 0x0a, 0x44, 0x45}
+
+	// Truncated buffer
+	r := io.GetBufferReader(buf)
+	descriptors := readSiDescriptors(&r, 15)
+	assert.Equal(t, 1, len(descriptors), "Only the complete descriptor should be parsed")
+	assert.Equal(t, NetworkNameDescriptor{NetworkName: "ABC"}, descriptors[0].Decoded, "Network name not match")
+
+	// Descriptor exceeding the loop, the reader stops at the end of the loop
+	r = io.GetBufferReader(append(buf, 0xff, 0xff, 0xff))
+	descriptors = readSiDescriptors(&r, 9)
+	assert.Equal(t, 1, len(descriptors), "Only the complete descriptor should be parsed")
+	assert.Equal(t, 9, r.GetPos(), "Reader should skip to the end of the loop")
+}
+
 func TestAdaptationFieldIO(t *testing.T) {
 	caseName := []string{
 		"Empty",
@@ -296,6 +411,160 @@ func TestSCTE35IO(t *testing.T) {
 	}
 }
 
+func TestSCTE35Descriptors(t *testing.T) {
+	manager := dummyManager()
+
+	// SCTE-35 2019a 14.2 time_signal with segmentation descriptor
+	tiSpec := []byte{
+		0x00, 0xfc, 0x30, 0x2f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xf0, 0x05, 0x06, 0xfe,
+		0x74, 0x62, 0x90, 0xa0, 0x00, 0x19, 0x02, 0x17, 0x43, 0x55, 0x45, 0x49, 0x48, 0x00, 0x00, 0x8e,
+		0x7f, 0x9f, 0x08, 0x08, 0x00, 0x00, 0x00, 0x00, 0x2c, 0xa0, 0xa1, 0x8a, 0x35, 0x02, 0x00, 0xa9,
+		0xcc, 0x67, 0x58,
+	}
+	table, err := PsiTable(manager, 0, 35, tiSpec)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, table.Process())
+
+	schema := table.(*scte35Struct).schema
+	assert.Equal(t, 1, len(schema.SpliceDescriptors))
+	descriptor := schema.SpliceDescriptors[0]
+	assert.Equal(t, "segmentation_descriptor", descriptor.DescriptorTypeStr)
+	assert.Equal(t, "CUEI", descriptor.Identifier)
+	seg := descriptor.Content.(Segmentation_descriptor)
+	assert.Equal(t, 0x4800008e, seg.EventId)
+	assert.Equal(t, true, seg.ProgramSegmentationFlag)
+	assert.Equal(t, false, seg.DeliveryNotRestrictedFlag)
+	assert.Equal(t, true, seg.WebDeliveryAllowedFlag)
+	assert.Equal(t, true, seg.NoRegionalBlackoutFlag)
+	assert.Equal(t, true, seg.ArchiveAllowedFlag)
+	assert.Equal(t, "None", seg.DeviceRestrictions)
+	assert.Equal(t, -1, seg.SegmentationDuration)
+	assert.Equal(t, Segmentation_upid{UpidType: 0x08, UpidTypeStr: "TI", Upid: "748724618"}, seg.Upid)
+	assert.Equal(t, "Provider Placement Opportunity End", seg.SegmentationTypeStr)
+	assert.Equal(t, 2, seg.SegmentNum)
+	assert.Equal(t, 0, seg.SegmentsExpected)
+	assert.Equal(t, -1, seg.SubSegmentNum)
+
+	// time_signal with avail, DTMF, time and two segmentation descriptors
+	allDescriptors := []byte{
+		0x00, 0xfc, 0x30, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xf0, 0x05, 0x06, 0xfe,
+		0x00, 0x00, 0x01, 0x00, 0x00, 0x7a, 0x00, 0x08, 0x43, 0x55, 0x45, 0x49, 0x12, 0x34, 0x56, 0x78,
+		0x01, 0x09, 0x43, 0x55, 0x45, 0x49, 0x32, 0x7f, 0x31, 0x32, 0x33, 0x03, 0x10, 0x43, 0x55, 0x45,
+		0x49, 0x00, 0x00, 0x65, 0x53, 0xf1, 0x00, 0x00, 0x00, 0x01, 0xf4, 0x00, 0x25, 0x02, 0x20, 0x43,
+		0x55, 0x45, 0x49, 0x00, 0x00, 0x00, 0x01, 0x7f, 0xff, 0x00, 0x00, 0x29, 0x32, 0xe0, 0x09, 0x0a,
+		0x53, 0x49, 0x47, 0x4e, 0x41, 0x4c, 0x3a, 0x61, 0x62, 0x63, 0x34, 0x01, 0x02, 0x03, 0x04, 0x02,
+		0x2f, 0x43, 0x55, 0x45, 0x49, 0x00, 0x00, 0x00, 0x02, 0x7f, 0x96, 0x0d, 0x20, 0x03, 0x0c, 0x41,
+		0x42, 0x43, 0x44, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x10, 0x10, 0x00, 0x01, 0x02,
+		0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x30, 0x00, 0x00,
+		0xc2, 0x37, 0x57, 0x3e,
+	}
+	table, err = PsiTable(manager, 0, 35, allDescriptors)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, table.Process())
+
+	descriptors := table.(*scte35Struct).schema.SpliceDescriptors
+	assert.Equal(t, 5, len(descriptors))
+	assert.Equal(t, Avail_descriptor{ProviderAvailId: 0x12345678}, descriptors[0].Content)
+	assert.Equal(t, Dtmf_descriptor{Preroll: 50, DtmfChar: "123"}, descriptors[1].Content)
+	assert.Equal(t, Time_descriptor{TaiSeconds: 1700000000, TaiNs: 500, UtcOffset: 37}, descriptors[2].Content)
+
+	seg = descriptors[3].Content.(Segmentation_descriptor)
+	assert.Equal(t, 2700000, seg.SegmentationDuration)
+	assert.Equal(t, true, seg.DeliveryNotRestrictedFlag)
+	assert.Equal(t, "SIGNAL:abc", seg.Upid.Upid)
+	assert.Equal(t, "ADI", seg.Upid.UpidTypeStr)
+	assert.Equal(t, 0x34, seg.SegmentationTypeId)
+	assert.Equal(t, []int{1, 2, 3, 4}, []int{seg.SegmentNum, seg.SegmentsExpected, seg.SubSegmentNum, seg.SubSegmentsExpected})
+
+	seg = descriptors[4].Content.(Segmentation_descriptor)
+	assert.Equal(t, false, seg.DeliveryNotRestrictedFlag)
+	assert.Equal(t, true, seg.WebDeliveryAllowedFlag)
+	assert.Equal(t, false, seg.NoRegionalBlackoutFlag)
+	assert.Equal(t, "Restrict Group 2", seg.DeviceRestrictions)
+	assert.Equal(t, "MID", seg.Upid.UpidTypeStr)
+	assert.Equal(t, 2, len(seg.Upid.Upids))
+	assert.Equal(t, "Ad-ID:ABCD01234567; UUID:00010203-0405-0607-0809-0a0b0c0d0e0f", seg.Upid.Upid)
+	// Legacy encoders may omit sub-segment fields
+	assert.Equal(t, -1, seg.SubSegmentNum)
+
+	assert.Contains(t, string(manager.psiJsons[35]), "Provider Placement Opportunity Start")
+}
+
+func TestSCTE35Encoder(t *testing.T) {
+	// Same as the time_signal example of SCTE-35 2019a 14.2
+	cueJson := `{
+		"SpliceCmdTypeStr": "time_signal",
+		"SpliceCmd": {"SpliceTime": 1952616608},
+		"SpliceDescriptors": [{
+			"DescriptorTypeStr": "segmentation_descriptor",
+			"Content": {
+				"EventId": 1207959694,
+				"ProgramSegmentationFlag": true,
+				"WebDeliveryAllowedFlag": true,
+				"NoRegionalBlackoutFlag": true,
+				"ArchiveAllowedFlag": true,
+				"DeviceRestrictions": "None",
+				"Upid": {"UpidType": 8, "Upid": "748724618"},
+				"SegmentationTypeId": 53,
+				"SegmentNum": 2
+			}
+		}]
+	}`
+	expected := []byte{
+		0xfc, 0x30, 0x2f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xf0, 0x05, 0x06, 0xfe, 0x74,
+		0x62, 0x90, 0xa0, 0x00, 0x19, 0x02, 0x17, 0x43, 0x55, 0x45, 0x49, 0x48, 0x00, 0x00, 0x8e, 0x7f,
+		0x9f, 0x08, 0x08, 0x00, 0x00, 0x00, 0x00, 0x2c, 0xa0, 0xa1, 0x8a, 0x35, 0x02, 0x00, 0xa9, 0xcc,
+		0x67, 0x58,
+	}
+	cue, err := ParseScte35Cue([]byte(cueJson))
+	assert.Equal(t, nil, err)
+	section, err := EncodeScte35Section(&cue)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, section)
+
+	// Round trip of splice_insert with a MID segmentation UPID
+	cue = Scte35Cue{
+		Tier:             0xfff,
+		SpliceCmdTypeStr: "splice_insert",
+		SpliceCmd: Splice_event{
+			EventId: 1, OutOfNetworkIdr: true, ProgramSpliceFlag: true, DurationFlag: true,
+			SpliceTime: 900000, BreakDuration: Break_duration{AutoReturn: true, Duration: 2700000},
+			UniqueProgramId: 10, AvailNum: 1, AvailsExpected: 2,
+		},
+		SpliceDescriptors: []Splice_descriptor{{
+			Tag: 0x02,
+			Content: Segmentation_descriptor{
+				EventId: 2, ProgramSegmentationFlag: true, SegmentationDurationFlag: true, DeliveryNotRestrictedFlag: true,
+				SegmentationDuration: 2700000, SegmentationTypeId: 0x34, SegmentNum: 1, SegmentsExpected: 1,
+				SubSegmentNum: 0, SubSegmentsExpected: 0,
+				Upid: Segmentation_upid{UpidType: 0x0d, Upids: []Segmentation_upid{
+					{UpidType: 0x03, Upid: "ABCD01234567"},
+					{UpidType: 0x0a, Upid: "10.5240/0E4F-892E-442F-6AE5-F1E2"},
+				}},
+			},
+		}},
+	}
+	section, err = EncodeScte35Section(&cue)
+	assert.Equal(t, nil, err)
+
+	manager := dummyManager()
+	table, err := PsiTable(manager, 0, 35, append([]byte{0x00}, section...))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, table.Process())
+
+	schema := table.(*scte35Struct).schema
+	event := schema.SpliceCmd.(Splice_event)
+	assert.Equal(t, 900000, event.SpliceTime)
+	assert.Equal(t, Break_duration{AutoReturn: true, Duration: 2700000}, event.BreakDuration)
+	assert.Equal(t, []int{10, 1, 2}, []int{event.UniqueProgramId, event.AvailNum, event.AvailsExpected})
+
+	seg := schema.SpliceDescriptors[0].Content.(Segmentation_descriptor)
+	assert.Equal(t, "CUEI", schema.SpliceDescriptors[0].Identifier)
+	assert.Equal(t, 2700000, seg.SegmentationDuration)
+	assert.Equal(t, "Ad-ID:ABCD01234567; EIDR:10.5240/0E4F-892E-442F-6AE5-F1E2", seg.Upid.Upid)
+	assert.Equal(t, []int{0x34, 1, 1, 0, 0}, []int{seg.SegmentationTypeId, seg.SegmentNum, seg.SegmentsExpected, seg.SubSegmentNum, seg.SubSegmentsExpected})
+}
+
 func TestTsHeaderIO(t *testing.T) {
 	headerBytes := []byte{0x47, 0x03, 0x8f, 0x1f}
 	rawTsPacket := append(headerBytes, make([]byte, 184)...)
@@ -3,6 +3,7 @@ package model
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/tony-507/analyzers/src/tttKernel"
 )
@@ -25,19 +26,20 @@ type PsiManager interface {
 	GetPmtVersion(int) int
 	GetPmtPidByProgNum(int) int
 	PsiUpdateFinished(int, int, []byte)
-	SpliceEventReceived(dpiPid int, spliceCmdTypeStr string, splicePTS []int, pktCnt int)
+	SpliceEventReceived(dpiPid int, spliceCmdTypeStr string, splicePTS []int, descriptors []Splice_descriptor, pktCnt int)
+	StreamTimeReceived(utc time.Time, pktCnt int)
+	UpdateSiVersion(key string, version int) bool // Return true if the sub-table version changes
 }
 
 type pesHandle interface {
-	PesPacketReady(buf tttKernel.CmBuf, pid int)
+	PesPacketReady(buf tttKernel.CmBuf, pid int) error
 }
 
 func resolveHeaderField(d DataStruct, str string) (int, error) {
-	fieldStr, ok := d.GetHeader().GetField(str)
-	if !ok {
+	if _, ok := d.GetHeader().GetField(str); !ok {
 		return 0, errors.New(fmt.Sprintf("%s does not exist in %s", str, d.GetName()))
 	}
-	rv, isInt := fieldStr.(int)
+	rv, isInt := tttKernel.GetBufFieldAsInt(d.GetHeader(), str)
 	if !isInt {
 		return 0, errors.New(fmt.Sprintf("%s is not an integer in %s", str, d.GetName()))
 	}
@@ -17,20 +17,45 @@ type pesPacketStruct struct {
 	callback          pesHandle
 }
 
+// PES header, with PCR and delay stamped by the demuxer
+var pesHeaderSchema = tttKernel.NewSchema("PesHeader",
+	tttKernel.Field("pktCnt", tttKernel.FIELD_INT64),
+	tttKernel.Field("size", tttKernel.FIELD_INT64),
+	tttKernel.HiddenField("streamId", tttKernel.FIELD_INT64),
+	tttKernel.HiddenField("priority", tttKernel.FIELD_INT64),
+	tttKernel.HiddenField("copyright", tttKernel.FIELD_INT64),
+	tttKernel.HiddenField("original", tttKernel.FIELD_INT64),
+	tttKernel.Field("pts", tttKernel.FIELD_INT64),
+	tttKernel.Field("dts", tttKernel.FIELD_INT64),
+	tttKernel.HiddenField("escr", tttKernel.FIELD_INT64),
+	tttKernel.HiddenField("esRate", tttKernel.FIELD_INT64),
+	tttKernel.HiddenField("progNum", tttKernel.FIELD_INT64),
+	tttKernel.HiddenField("streamType", tttKernel.FIELD_INT64),
+	tttKernel.HiddenField("pid", tttKernel.FIELD_INT64),
+	tttKernel.Field("pcr", tttKernel.FIELD_INT64),
+	tttKernel.Field("delay", tttKernel.FIELD_INT64),
+)
+
 func (p *pesPacketStruct) setBuffer(inBuf []byte, pktCnt int) error {
 	buf := inBuf[:6]
 	r := io.GetBufferReader(buf)
-	p.header = tttKernel.MakeSimpleBuf(buf)
+	p.header = tttKernel.MakeSchemaBuf(pesHeaderSchema, buf)
 
-	p.header.SetField("pktCnt", pktCnt, false)
-	p.header.SetField("size", -1, false)
+	if err := p.header.SetField("pktCnt", pktCnt, false); err != nil {
+		return err
+	}
+	if err := p.header.SetField("size", -1, false); err != nil {
+		return err
+	}
 
 	if r.ReadBits(24) != 0x000001 {
 		return errors.New("PES prefix start code not match")
 	}
 
 	streamId := r.ReadBits(8)
-	p.header.SetField("streamId", streamId, true)
+	if err := p.header.SetField("streamId", streamId, true); err != nil {
+		return err
+	}
 	sectionLen := r.ReadBits(16) // Can be zero
 	readLen := 6
 	optionalHeaderLength := 0
@@ -73,10 +98,16 @@ func (p *pesPacketStruct) readOptionalHeader(buf []byte) (int, error) {
 	if r.ReadBits(2) != 0 {
 		return 0, errors.New("PES packet is scrambled")
 	}
-	p.header.SetField("priority", r.ReadBits(1), true)
+	if err := p.header.SetField("priority", r.ReadBits(1), true); err != nil {
+		return 0, err
+	}
 	r.ReadBits(1) // Data alignment indicator
-	p.header.SetField("copyright", r.ReadBits(1), true)
-	p.header.SetField("original", r.ReadBits(1), true)
+	if err := p.header.SetField("copyright", r.ReadBits(1), true); err != nil {
+		return 0, err
+	}
+	if err := p.header.SetField("original", r.ReadBits(1), true); err != nil {
+		return 0, err
+	}
 
 	pts := -1
 	dts := -1
@@ -137,8 +168,12 @@ func (p *pesPacketStruct) readOptionalHeader(buf []byte) (int, error) {
 		errMsg := fmt.Sprintf("Forbidden timestamp flag: flag=%d", ptsDtsIdr)
 		return 0, errors.New(errMsg)
 	}
-	p.header.SetField("pts", pts, false)
-	p.header.SetField("dts", dts, false)
+	if err := p.header.SetField("pts", pts, false); err != nil {
+		return 0, err
+	}
+	if err := p.header.SetField("dts", dts, false); err != nil {
+		return 0, err
+	}
 
 	if hasEscr {
 		r.ReadBits(2)
@@ -152,7 +187,9 @@ func (p *pesPacketStruct) readOptionalHeader(buf []byte) (int, error) {
 		r.ReadBits(1)
 		remained -= 6
 	}
-	p.header.SetField("escr", escr, true)
+	if err := p.header.SetField("escr", escr, true); err != nil {
+		return 0, err
+	}
 
 	if hasEsRate {
 		r.ReadBits(1)
@@ -160,7 +197,9 @@ func (p *pesPacketStruct) readOptionalHeader(buf []byte) (int, error) {
 		r.ReadBits(1)
 		remained -= 3
 	}
-	p.header.SetField("esRate", esRate, true)
+	if err := p.header.SetField("esRate", esRate, true); err != nil {
+		return 0, err
+	}
 
 	if isDsmTrickMode {
 		control := r.ReadBits(3)
@@ -212,10 +251,11 @@ func (p *pesPacketStruct) readOptionalHeader(buf []byte) (int, error) {
 }
 
 func (p *pesPacketStruct) Process() error {
-	p.header.SetField("size", len(p.payload), false)
+	if err := p.header.SetField("size", len(p.payload), false); err != nil {
+		return err
+	}
 	p.header.ResetBuf(p.payload)
-	p.callback.PesPacketReady(p.header, p.pid)
-	return nil
+	return p.callback.PesPacketReady(p.header, p.pid)
 }
 
 func (p *pesPacketStruct) Append(buf []byte) {
@@ -253,10 +293,14 @@ func (p *pesPacketStruct) Serialize() []byte {
 
 func PesPacket(callback pesHandle, buf []byte, pid int, pktCnt int, progNum int, streamType int) (DataStruct, error) {
 	rv := &pesPacketStruct{pid: pid, hasOptionalHeader: false, payload: make([]byte, 0), callback: callback}
-	err := rv.setBuffer(buf, pktCnt)
-
-	rv.header.SetField("progNum", progNum, true)
-	rv.header.SetField("streamType", streamType, true)
-
-	return rv, err
+	if err := rv.setBuffer(buf, pktCnt); err != nil {
+		return rv, err
+	}
+	if err := rv.header.SetField("progNum", progNum, true); err != nil {
+		return rv, err
+	}
+	if err := rv.header.SetField("streamType", streamType, true); err != nil {
+		return rv, err
+	}
+	return rv, nil
 }
@@ -14,9 +14,20 @@ func PsiTable(manager PsiManager, pktCnt int, pid int, inBuf []byte) (DataStruct
 		return PatTable(manager, pktCnt, buf)
 	case 2:
 		return PmtTable(manager, pktCnt, buf)
+	case 0x01:
+		return CatTable(manager, pktCnt, buf)
+	case 0x40, 0x41:
+		return NitTable(manager, pktCnt, tableId, buf)
+	case 0x42, 0x46:
+		return SdtTable(manager, pktCnt, tableId, buf)
+	case 0x70, 0x73:
+		return TdtTable(manager, pktCnt, tableId, buf)
 	case 0xfc:
 		return Scte35Table(manager, pktCnt, pid, buf)
 	default:
+		if tableId >= 0x4e && tableId <= 0x6f {
+			return EitTable(manager, pktCnt, tableId, buf)
+		}
 		return nil, errors.New(fmt.Sprintf("Table with tableId %d is not implemented", tableId))
 	}
 }
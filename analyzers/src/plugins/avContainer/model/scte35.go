@@ -24,8 +24,9 @@ type Scte35Schema struct {
 	CwIdx            int
 	Tier             int
 	SpliceCmdLen     int
-	SpliceCmdTypeStr string
-	SpliceCmd        Splice_command
+	SpliceCmdTypeStr  string
+	SpliceCmd         Splice_command
+	SpliceDescriptors []Splice_descriptor
 }
 
 func (s *scte35Struct) Append(buf []byte) {
@@ -94,6 +95,17 @@ func (s *scte35Struct) Process() error {
 	schema.SpliceCmdLen = r.ReadBits(12)
 
 	spliceCmdType := r.ReadBits(8)
+
+	// Use a separate reader for the splice command unless the legacy length 0xfff is used
+	cmdStart := r.GetPos()
+	cmdReader := &r
+	if schema.SpliceCmdLen != 0xfff {
+		if cmdStart+schema.SpliceCmdLen > len(s.payload) {
+			return errors.New(fmt.Sprintf("SCTE-35 splice command length %d exceeds section", schema.SpliceCmdLen))
+		}
+		cr := io.GetBufferReader(s.payload[cmdStart:(cmdStart + schema.SpliceCmdLen)])
+		cmdReader = &cr
+	}
 	spliceCmdTypeStr := "Unknown"
 	var spliceCmd Splice_command
 
@@ -105,40 +117,47 @@ func (s *scte35Struct) Process() error {
 	case 0x04:
 		// Splice schedule
 		spliceCmdTypeStr = "splice_schedule"
-		spliceCmd = readSpliceSchedule(&r)
+		spliceCmd = readSpliceSchedule(cmdReader)
 	case 0x05:
 		// Splice insert
 		spliceCmdTypeStr = "splice_insert"
-		spliceCmd = readSpliceEvent(&r, true)
+		spliceCmd = readSpliceEvent(cmdReader, true)
 	case 0x06:
 		// Time signal
 		spliceCmdTypeStr = "time_signal"
-		spliceCmd = readTimeSignal(&r)
+		spliceCmd = readTimeSignal(cmdReader)
 	case 0x07:
 		// Bandwidth reservation
 		spliceCmdTypeStr = "bandwidth_reservation"
+		spliceCmd = Splice_null{}
 	case 0xff:
 		// Private command
 		spliceCmdTypeStr = "private_command"
-		spliceCmd = readPrivateCommand(&r)
+		spliceCmd = readPrivateCommand(cmdReader)
 	default:
 		msg := fmt.Sprintf("unknown splice command type %d received", spliceCmdType)
 		return errors.New(msg)
 	}
 
-	s.callback.SpliceEventReceived(s.pid, spliceCmdTypeStr, spliceCmd.GetSplicePTS(), s.schema.PktCnt)
+	descReader := r
+	if schema.SpliceCmdLen != 0xfff {
+		descReader = io.GetBufferReader(s.payload[(cmdStart + schema.SpliceCmdLen):])
+	}
+	// Report the splice command even if the descriptors are broken
+	descriptors, descErr := readSpliceDescriptors(&descReader)
+
+	s.callback.SpliceEventReceived(s.pid, spliceCmdTypeStr, spliceCmd.GetSplicePTS(), descriptors, s.schema.PktCnt)
 
 	schema.SpliceCmdTypeStr = spliceCmdTypeStr
 	schema.SpliceCmd = spliceCmd
+	schema.SpliceDescriptors = descriptors
 
 	s.schema = schema
 
-	// TODO Continue...
-
 	jsonBytes, _ := json.MarshalIndent(s.schema, "", "\t")
 	s.callback.PsiUpdateFinished(s.pid, -1, jsonBytes)
 
-	return nil
+	return descErr
 }
 
 func Scte35Table(manager PsiManager, pktCnt int, pid int, buf []byte) (DataStruct, error) {
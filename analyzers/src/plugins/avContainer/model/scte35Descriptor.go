@@ -0,0 +1,396 @@
+package model
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+// SCTE-35 2019a section 10.1
+type Splice_descriptor struct {
+	Tag               int
+	DescriptorTypeStr string
+	Identifier        string
+	Content           interface{} // Decoded descriptor, or hex string if not supported
+}
+
+// SCTE-35 2019a section 10.3.1
+type Avail_descriptor struct {
+	ProviderAvailId int
+}
+
+// SCTE-35 2019a section 10.3.2
+type Dtmf_descriptor struct {
+	Preroll  int // In tenths of a second
+	DtmfChar string
+}
+
+// SCTE-35 2019a section 10.3.3
+type Segmentation_descriptor struct {
+	EventId                   int
+	EventCancelIdr            bool
+	ProgramSegmentationFlag   bool
+	SegmentationDurationFlag  bool
+	DeliveryNotRestrictedFlag bool
+	WebDeliveryAllowedFlag    bool
+	NoRegionalBlackoutFlag    bool
+	ArchiveAllowedFlag        bool
+	DeviceRestrictions        string
+	Components                []Segmentation_component
+	SegmentationDuration      int // In 90 kHz ticks, -1 if absent
+	Upid                      Segmentation_upid
+	SegmentationTypeId        int
+	SegmentationTypeStr       string
+	SegmentNum                int
+	SegmentsExpected          int
+	SubSegmentNum             int // -1 if absent
+	SubSegmentsExpected       int // -1 if absent
+}
+
+type Segmentation_component struct {
+	ComponentTag int
+	PtsOffset    int
+}
+
+// SCTE-35 2019a section 10.3.3.1
+type Segmentation_upid struct {
+	UpidType    int
+	UpidTypeStr string
+	Upid        string
+	Upids       []Segmentation_upid // Only for MID
+}
+
+// SCTE-35 2019a section 10.3.4
+type Time_descriptor struct {
+	TaiSeconds int
+	TaiNs      int
+	UtcOffset  int
+}
+
+// SCTE-35 2019a Table 22
+var segmentationTypes = map[int]string{
+	0x00: "Not Indicated",
+	0x01: "Content Identification",
+	0x10: "Program Start",
+	0x11: "Program End",
+	0x12: "Program Early Termination",
+	0x13: "Program Breakaway",
+	0x14: "Program Resumption",
+	0x15: "Program Runover Planned",
+	0x16: "Program Runover Unplanned",
+	0x17: "Program Overlap Start",
+	0x18: "Program Blackout Override",
+	0x19: "Program Start - In Progress",
+	0x20: "Chapter Start",
+	0x21: "Chapter End",
+	0x22: "Break Start",
+	0x23: "Break End",
+	0x24: "Opening Credit Start",
+	0x25: "Opening Credit End",
+	0x26: "Closing Credit Start",
+	0x27: "Closing Credit End",
+	0x30: "Provider Advertisement Start",
+	0x31: "Provider Advertisement End",
+	0x32: "Distributor Advertisement Start",
+	0x33: "Distributor Advertisement End",
+	0x34: "Provider Placement Opportunity Start",
+	0x35: "Provider Placement Opportunity End",
+	0x36: "Distributor Placement Opportunity Start",
+	0x37: "Distributor Placement Opportunity End",
+	0x38: "Provider Overlay Placement Opportunity Start",
+	0x39: "Provider Overlay Placement Opportunity End",
+	0x3a: "Distributor Overlay Placement Opportunity Start",
+	0x3b: "Distributor Overlay Placement Opportunity End",
+	0x3c: "Provider Promo Start",
+	0x3d: "Provider Promo End",
+	0x3e: "Distributor Promo Start",
+	0x3f: "Distributor Promo End",
+	0x40: "Unscheduled Event Start",
+	0x41: "Unscheduled Event End",
+	0x42: "Alternate Content Opportunity Start",
+	0x43: "Alternate Content Opportunity End",
+	0x44: "Provider Ad Block Start",
+	0x45: "Provider Ad Block End",
+	0x46: "Distributor Ad Block Start",
+	0x47: "Distributor Ad Block End",
+	0x50: "Network Start",
+	0x51: "Network End",
+}
+
+// SCTE-35 2019a Table 21
+var upidTypes = map[int]string{
+	0x00: "Not Used",
+	0x01: "User Defined",
+	0x02: "ISCI",
+	0x03: "Ad-ID",
+	0x04: "UMID",
+	0x05: "ISAN (Deprecated)",
+	0x06: "ISAN",
+	0x07: "TID",
+	0x08: "TI",
+	0x09: "ADI",
+	0x0a: "EIDR",
+	0x0b: "ATSC Content Identifier",
+	0x0c: "MPU",
+	0x0d: "MID",
+	0x0e: "ADS Information",
+	0x0f: "URI",
+	0x10: "UUID",
+	0x11: "SCR",
+}
+
+var deviceRestrictions = []string{"Restrict Group 0", "Restrict Group 1", "Restrict Group 2", "None"}
+
+func checkRemainedBytes(r *io.BsReader, n int, field string) error {
+	if r.GetPos()+n > r.GetSize() {
+		return errors.New(fmt.Sprintf("%s exceeds splice descriptor, %d bytes needed but %d remained", field, n, r.GetSize()-r.GetPos()))
+	}
+	return nil
+}
+
+// Parse the descriptor loop after the splice command
+func readSpliceDescriptors(r *io.BsReader) ([]Splice_descriptor, error) {
+	descriptors := []Splice_descriptor{}
+	if err := checkRemainedBytes(r, 2, "descriptor_loop_length"); err != nil {
+		return descriptors, err
+	}
+	loopLen := r.ReadBits(16)
+	if err := checkRemainedBytes(r, loopLen, "Descriptor loop"); err != nil {
+		return descriptors, err
+	}
+
+	for loopLen > 0 {
+		if loopLen < 2 {
+			return descriptors, errors.New("Incomplete splice descriptor in descriptor loop")
+		}
+		tag := r.ReadBits(8)
+		length := r.ReadBits(8)
+		if length+2 > loopLen || length < 4 {
+			return descriptors, errors.New(fmt.Sprintf("Invalid length %d of splice descriptor with tag %d", length, tag))
+		}
+		loopLen -= length + 2
+
+		dr := io.GetBufferReader(r.GetRemainedBuffer()[:length])
+		for i := 0; i < length; i++ {
+			r.ReadBits(8)
+		}
+
+		descriptor, err := readSpliceDescriptor(&dr, tag, length)
+		if err != nil {
+			return descriptors, err
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+	return descriptors, nil
+}
+
+func readSpliceDescriptor(r *io.BsReader, tag int, length int) (Splice_descriptor, error) {
+	descriptor := Splice_descriptor{Tag: tag, DescriptorTypeStr: "Unknown"}
+	descriptor.Identifier = r.ReadChar(4)
+
+	var err error
+	switch tag {
+	case 0x00:
+		descriptor.DescriptorTypeStr = "avail_descriptor"
+		if err = checkRemainedBytes(r, 4, "provider_avail_id"); err == nil {
+			descriptor.Content = Avail_descriptor{ProviderAvailId: r.ReadBits(32)}
+		}
+	case 0x01:
+		descriptor.DescriptorTypeStr = "DTMF_descriptor"
+		descriptor.Content, err = readDtmfDescriptor(r)
+	case 0x02:
+		descriptor.DescriptorTypeStr = "segmentation_descriptor"
+		descriptor.Content, err = readSegmentationDescriptor(r)
+	case 0x03:
+		descriptor.DescriptorTypeStr = "time_descriptor"
+		if err = checkRemainedBytes(r, 12, "time_descriptor"); err == nil {
+			descriptor.Content = Time_descriptor{TaiSeconds: r.ReadBits(48), TaiNs: r.ReadBits(32), UtcOffset: r.ReadBits(16)}
+		}
+	default:
+		descriptor.Content = r.ReadHex(length - 4)
+	}
+	return descriptor, err
+}
+
+func readDtmfDescriptor(r *io.BsReader) (Dtmf_descriptor, error) {
+	if err := checkRemainedBytes(r, 2, "DTMF_descriptor"); err != nil {
+		return Dtmf_descriptor{}, err
+	}
+	preroll := r.ReadBits(8)
+	dtmfCount := r.ReadBits(3)
+	r.ReadBits(5)
+	if err := checkRemainedBytes(r, dtmfCount, "DTMF_char"); err != nil {
+		return Dtmf_descriptor{}, err
+	}
+	return Dtmf_descriptor{Preroll: preroll, DtmfChar: r.ReadChar(dtmfCount)}, nil
+}
+
+func readSegmentationDescriptor(r *io.BsReader) (Segmentation_descriptor, error) {
+	seg := Segmentation_descriptor{SegmentationDuration: -1, SubSegmentNum: -1, SubSegmentsExpected: -1}
+	if err := checkRemainedBytes(r, 5, "segmentation_event_id"); err != nil {
+		return seg, err
+	}
+	seg.EventId = r.ReadBits(32)
+	seg.EventCancelIdr = r.ReadBits(1) != 0
+	r.ReadBits(7)
+	if seg.EventCancelIdr {
+		return seg, nil
+	}
+
+	if err := checkRemainedBytes(r, 1, "Segmentation flags"); err != nil {
+		return seg, err
+	}
+	seg.ProgramSegmentationFlag = r.ReadBits(1) != 0
+	seg.SegmentationDurationFlag = r.ReadBits(1) != 0
+	seg.DeliveryNotRestrictedFlag = r.ReadBits(1) != 0
+	if !seg.DeliveryNotRestrictedFlag {
+		seg.WebDeliveryAllowedFlag = r.ReadBits(1) != 0
+		seg.NoRegionalBlackoutFlag = r.ReadBits(1) != 0
+		seg.ArchiveAllowedFlag = r.ReadBits(1) != 0
+		seg.DeviceRestrictions = deviceRestrictions[r.ReadBits(2)]
+	} else {
+		r.ReadBits(5)
+	}
+
+	if !seg.ProgramSegmentationFlag {
+		if err := checkRemainedBytes(r, 1, "component_count"); err != nil {
+			return seg, err
+		}
+		componentCnt := r.ReadBits(8)
+		if err := checkRemainedBytes(r, componentCnt*6, "Segmentation components"); err != nil {
+			return seg, err
+		}
+		for i := 0; i < componentCnt; i++ {
+			tag := r.ReadBits(8)
+			r.ReadBits(7)
+			seg.Components = append(seg.Components, Segmentation_component{ComponentTag: tag, PtsOffset: r.ReadBits(33)})
+		}
+	}
+
+	if seg.SegmentationDurationFlag {
+		if err := checkRemainedBytes(r, 5, "segmentation_duration"); err != nil {
+			return seg, err
+		}
+		seg.SegmentationDuration = r.ReadBits(40)
+	}
+
+	if err := checkRemainedBytes(r, 2, "segmentation_upid_type"); err != nil {
+		return seg, err
+	}
+	upidType := r.ReadBits(8)
+	upidLen := r.ReadBits(8)
+	if err := checkRemainedBytes(r, upidLen+3, "segmentation_upid"); err != nil {
+		return seg, err
+	}
+	upid, err := readSegmentationUpid(r.GetRemainedBuffer()[:upidLen], upidType)
+	if err != nil {
+		return seg, err
+	}
+	seg.Upid = upid
+	for i := 0; i < upidLen; i++ {
+		r.ReadBits(8)
+	}
+
+	seg.SegmentationTypeId = r.ReadBits(8)
+	seg.SegmentationTypeStr = "Reserved"
+	if typeStr, ok := segmentationTypes[seg.SegmentationTypeId]; ok {
+		seg.SegmentationTypeStr = typeStr
+	}
+	seg.SegmentNum = r.ReadBits(8)
+	seg.SegmentsExpected = r.ReadBits(8)
+
+	// Sub-segment fields are only present for some placement opportunities and may be omitted by legacy encoders
+	switch seg.SegmentationTypeId {
+	case 0x30, 0x32, 0x34, 0x36, 0x38, 0x3a, 0x44, 0x46:
+		if r.GetSize()-r.GetPos() >= 2 {
+			seg.SubSegmentNum = r.ReadBits(8)
+			seg.SubSegmentsExpected = r.ReadBits(8)
+		}
+	}
+	return seg, nil
+}
+
+// Format bytes as hex groups of the given size joined by sep
+func formatHexGroups(buf []byte, groupSize int, sep string) string {
+	groups := []string{}
+	for i := 0; i < len(buf); i += groupSize {
+		end := i + groupSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		groups = append(groups, strings.ToUpper(hex.EncodeToString(buf[i:end])))
+	}
+	return strings.Join(groups, sep)
+}
+
+func readSegmentationUpid(buf []byte, upidType int) (Segmentation_upid, error) {
+	upid := Segmentation_upid{UpidType: upidType, UpidTypeStr: "Reserved"}
+	if typeStr, ok := upidTypes[upidType]; ok {
+		upid.UpidTypeStr = typeStr
+	}
+
+	switch upidType {
+	case 0x00:
+		// No UPID
+	case 0x02, 0x03, 0x07, 0x09, 0x0e, 0x0f, 0x11:
+		upid.Upid = string(buf)
+	case 0x04:
+		upid.Upid = formatHexGroups(buf, 4, ".")
+	case 0x05, 0x06:
+		upid.Upid = formatHexGroups(buf, 2, "-")
+	case 0x08:
+		ti := 0
+		for _, b := range buf {
+			ti = (ti << 8) | int(b)
+		}
+		upid.Upid = fmt.Sprintf("%d", ti)
+	case 0x0a:
+		// Compact EIDR without the check character
+		if len(buf) != 12 {
+			return upid, errors.New(fmt.Sprintf("EIDR should have 12 bytes, got %d", len(buf)))
+		}
+		upid.Upid = fmt.Sprintf("10.%d/%s", (int(buf[0])<<8)|int(buf[1]), formatHexGroups(buf[2:], 2, "-"))
+	case 0x0b:
+		if len(buf) < 4 {
+			return upid, errors.New(fmt.Sprintf("ATSC content identifier too short with %d bytes", len(buf)))
+		}
+		r := io.GetBufferReader(buf)
+		tsid := r.ReadBits(16)
+		r.ReadBits(2)
+		endOfDay := r.ReadBits(5)
+		uniqueFor := r.ReadBits(9)
+		upid.Upid = fmt.Sprintf("TSID=%d, end_of_day=%d, unique_for=%d, content_id=%s", tsid, endOfDay, uniqueFor, string(buf[4:]))
+	case 0x0c:
+		if len(buf) < 4 {
+			return upid, errors.New(fmt.Sprintf("MPU too short with %d bytes", len(buf)))
+		}
+		upid.Upid = fmt.Sprintf("%s:%s", string(buf[:4]), strings.ToUpper(hex.EncodeToString(buf[4:])))
+	case 0x0d:
+		parts := []string{}
+		for len(buf) > 0 {
+			if len(buf) < 2 || int(buf[1])+2 > len(buf) {
+				return upid, errors.New("Incomplete UPID in MID")
+			}
+			subUpid, err := readSegmentationUpid(buf[2:(2+int(buf[1]))], int(buf[0]))
+			if err != nil {
+				return upid, err
+			}
+			upid.Upids = append(upid.Upids, subUpid)
+			parts = append(parts, fmt.Sprintf("%s:%s", subUpid.UpidTypeStr, subUpid.Upid))
+			buf = buf[(2 + int(buf[1])):]
+		}
+		upid.Upid = strings.Join(parts, "; ")
+	case 0x10:
+		if len(buf) != 16 {
+			return upid, errors.New(fmt.Sprintf("UUID should have 16 bytes, got %d", len(buf)))
+		}
+		h := hex.EncodeToString(buf)
+		upid.Upid = fmt.Sprintf("%s-%s-%s-%s-%s", h[:8], h[8:12], h[12:16], h[16:20], h[20:])
+	default:
+		upid.Upid = strings.ToUpper(hex.EncodeToString(buf))
+	}
+	return upid, nil
+}
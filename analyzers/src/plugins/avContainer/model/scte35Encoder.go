@@ -0,0 +1,420 @@
+package model
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+/*
+ * SCTE-35 section encoder. The input follows the JSON written by the decoder,
+ * so that a decoded cue can be edited and encoded again.
+ */
+
+type Scte35Cue struct {
+	PtsAdjustment     int
+	Tier              int
+	SpliceCmdTypeStr  string // splice_null, splice_insert or time_signal
+	SpliceCmd         Splice_command
+	SpliceDescriptors []Splice_descriptor
+}
+
+type scte35CueJson struct {
+	PtsAdjustment     int
+	Tier              int
+	SpliceCmdTypeStr  string
+	SpliceCmd         json.RawMessage
+	SpliceDescriptors []struct {
+		DescriptorTypeStr string
+		Identifier        string
+		Content           json.RawMessage
+	}
+}
+
+// Parse a cue from JSON. Absent splice time and durations are treated as not specified.
+func ParseScte35Cue(jsonBytes []byte) (Scte35Cue, error) {
+	raw := scte35CueJson{Tier: 0xfff}
+	if err := json.Unmarshal(jsonBytes, &raw); err != nil {
+		return Scte35Cue{}, err
+	}
+	cue := Scte35Cue{PtsAdjustment: raw.PtsAdjustment, Tier: raw.Tier, SpliceCmdTypeStr: raw.SpliceCmdTypeStr}
+
+	switch raw.SpliceCmdTypeStr {
+	case "splice_null":
+		cue.SpliceCmd = Splice_null{}
+	case "splice_insert":
+		event := Splice_event{SpliceTime: -1}
+		if err := unmarshalIfPresent(raw.SpliceCmd, &event); err != nil {
+			return cue, err
+		}
+		cue.SpliceCmd = event
+	case "time_signal":
+		timeSignal := Time_signal{SpliceTime: -1}
+		if err := unmarshalIfPresent(raw.SpliceCmd, &timeSignal); err != nil {
+			return cue, err
+		}
+		cue.SpliceCmd = timeSignal
+	default:
+		return cue, errors.New(fmt.Sprintf("Encoding of splice command %s is not supported", raw.SpliceCmdTypeStr))
+	}
+
+	for _, rawDesc := range raw.SpliceDescriptors {
+		descriptor := Splice_descriptor{DescriptorTypeStr: rawDesc.DescriptorTypeStr, Identifier: rawDesc.Identifier}
+		var err error
+		switch rawDesc.DescriptorTypeStr {
+		case "avail_descriptor":
+			content := Avail_descriptor{}
+			err = unmarshalIfPresent(rawDesc.Content, &content)
+			descriptor.Tag, descriptor.Content = 0x00, content
+		case "DTMF_descriptor":
+			content := Dtmf_descriptor{}
+			err = unmarshalIfPresent(rawDesc.Content, &content)
+			descriptor.Tag, descriptor.Content = 0x01, content
+		case "segmentation_descriptor":
+			content := Segmentation_descriptor{SegmentationDuration: -1, SubSegmentNum: -1, SubSegmentsExpected: -1}
+			err = unmarshalIfPresent(rawDesc.Content, &content)
+			descriptor.Tag, descriptor.Content = 0x02, content
+		case "time_descriptor":
+			content := Time_descriptor{}
+			err = unmarshalIfPresent(rawDesc.Content, &content)
+			descriptor.Tag, descriptor.Content = 0x03, content
+		default:
+			err = errors.New(fmt.Sprintf("Encoding of splice descriptor %s is not supported", rawDesc.DescriptorTypeStr))
+		}
+		if err != nil {
+			return cue, err
+		}
+		cue.SpliceDescriptors = append(cue.SpliceDescriptors, descriptor)
+	}
+	return cue, nil
+}
+
+func unmarshalIfPresent(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// Build a splice_info_section including CRC32, without pointer_field
+func EncodeScte35Section(cue *Scte35Cue) ([]byte, error) {
+	cmdType := 0
+	var cmd []byte
+	switch spliceCmd := cue.SpliceCmd.(type) {
+	case Splice_null:
+		cmdType = 0x00
+	case Splice_event:
+		cmdType = 0x05
+		cmd = encodeSpliceInsert(&spliceCmd)
+	case Time_signal:
+		cmdType = 0x06
+		cmd = encodeSpliceTime(spliceCmd.SpliceTime)
+	default:
+		return nil, errors.New(fmt.Sprintf("Encoding of splice command %s is not supported", cue.SpliceCmdTypeStr))
+	}
+
+	descriptors := []byte{}
+	for idx := range cue.SpliceDescriptors {
+		descriptor, err := encodeSpliceDescriptor(&cue.SpliceDescriptors[idx])
+		if err != nil {
+			return nil, err
+		}
+		descriptors = append(descriptors, descriptor...)
+	}
+
+	// 11 bytes from protocol_version to splice_command_type, 2 bytes descriptor_loop_length and 4 bytes CRC
+	sectionLen := 11 + len(cmd) + 2 + len(descriptors) + 4
+	if sectionLen > 4093 {
+		return nil, errors.New(fmt.Sprintf("SCTE-35 section length %d exceeds 4093", sectionLen))
+	}
+
+	w := io.GetBufferWriter(3 + 11)
+	w.WriteByte(0xfc)
+	w.Write(0, 1) // section_syntax_indicator
+	w.Write(0, 1) // private_indicator
+	w.Write(3, 2) // sap_type, not specified
+	w.Write(sectionLen, 12)
+	w.WriteByte(0) // protocol_version
+	w.Write(0, 1)  // encrypted_packet
+	w.Write(0, 6)  // encryption_algorithm
+	w.Write(cue.PtsAdjustment, 33)
+	w.WriteByte(0xff) // cw_index, unused without encryption
+	w.Write(cue.Tier, 12)
+	w.Write(len(cmd), 12)
+	w.WriteByte(cmdType)
+
+	section := append(w.GetBuf(), cmd...)
+	section = append(section, byte(len(descriptors)>>8), byte(len(descriptors)))
+	section = append(section, descriptors...)
+	crc := io.Crc32Mpeg2(section)
+	return append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc)), nil
+}
+
+func spliceTimeSize(spliceTime int) int {
+	if spliceTime >= 0 {
+		return 5
+	}
+	return 1
+}
+
+func writeSpliceTime(w *io.BsWriter, spliceTime int) {
+	if spliceTime >= 0 {
+		w.Write(1, 1) // time_specified_flag
+		w.Write(0x3f, 6)
+		w.Write(spliceTime, 33)
+	} else {
+		w.Write(0, 1)
+		w.Write(0x7f, 7)
+	}
+}
+
+func encodeSpliceTime(spliceTime int) []byte {
+	w := io.GetBufferWriter(spliceTimeSize(spliceTime))
+	writeSpliceTime(&w, spliceTime)
+	return w.GetBuf()
+}
+
+func encodeSpliceInsert(event *Splice_event) []byte {
+	size := 5
+	if !event.EventCancelIdr {
+		size += 1 + 4
+		if event.ProgramSpliceFlag && !event.SpliceImmediateFlag {
+			size += spliceTimeSize(event.SpliceTime)
+		}
+		if !event.ProgramSpliceFlag {
+			size += 1
+			for _, component := range event.Components {
+				size += 1
+				if !event.SpliceImmediateFlag {
+					size += spliceTimeSize(component.SpliceTime)
+				}
+			}
+		}
+		if event.DurationFlag {
+			size += 5
+		}
+	}
+
+	w := io.GetBufferWriter(size)
+	w.WriteInt(event.EventId)
+	writeFlag(&w, event.EventCancelIdr)
+	w.Write(0x7f, 7)
+	if event.EventCancelIdr {
+		return w.GetBuf()
+	}
+
+	writeFlag(&w, event.OutOfNetworkIdr)
+	writeFlag(&w, event.ProgramSpliceFlag)
+	writeFlag(&w, event.DurationFlag)
+	writeFlag(&w, event.SpliceImmediateFlag)
+	w.Write(0xf, 4)
+	if event.ProgramSpliceFlag && !event.SpliceImmediateFlag {
+		writeSpliceTime(&w, event.SpliceTime)
+	}
+	if !event.ProgramSpliceFlag {
+		w.WriteByte(len(event.Components))
+		for _, component := range event.Components {
+			w.WriteByte(component.ComponentTag)
+			if !event.SpliceImmediateFlag {
+				writeSpliceTime(&w, component.SpliceTime)
+			}
+		}
+	}
+	if event.DurationFlag {
+		writeFlag(&w, event.BreakDuration.AutoReturn)
+		w.Write(0x3f, 6)
+		w.Write(event.BreakDuration.Duration, 33)
+	}
+	w.WriteShort(event.UniqueProgramId)
+	w.WriteByte(event.AvailNum)
+	w.WriteByte(event.AvailsExpected)
+	return w.GetBuf()
+}
+
+func writeFlag(w *io.BsWriter, flag bool) {
+	if flag {
+		w.Write(1, 1)
+	} else {
+		w.Write(0, 1)
+	}
+}
+
+// Encode a splice descriptor including splice_descriptor_tag and descriptor_length
+func encodeSpliceDescriptor(descriptor *Splice_descriptor) ([]byte, error) {
+	var content []byte
+	switch desc := descriptor.Content.(type) {
+	case Avail_descriptor:
+		w := io.GetBufferWriter(4)
+		w.WriteInt(desc.ProviderAvailId)
+		content = w.GetBuf()
+	case Dtmf_descriptor:
+		if len(desc.DtmfChar) > 7 {
+			return nil, errors.New(fmt.Sprintf("Too many DTMF characters %s", desc.DtmfChar))
+		}
+		content = append([]byte{byte(desc.Preroll), byte(len(desc.DtmfChar)<<5) | 0x1f}, []byte(desc.DtmfChar)...)
+	case Segmentation_descriptor:
+		var err error
+		if content, err = encodeSegmentationDescriptor(&desc); err != nil {
+			return nil, err
+		}
+	case Time_descriptor:
+		w := io.GetBufferWriter(12)
+		w.Write(desc.TaiSeconds, 48)
+		w.WriteInt(desc.TaiNs)
+		w.WriteShort(desc.UtcOffset)
+		content = w.GetBuf()
+	default:
+		return nil, errors.New(fmt.Sprintf("Encoding of splice descriptor %s is not supported", descriptor.DescriptorTypeStr))
+	}
+
+	identifier := descriptor.Identifier
+	if identifier == "" {
+		identifier = "CUEI"
+	}
+	if len(identifier) != 4 {
+		return nil, errors.New(fmt.Sprintf("Splice descriptor identifier %s should have 4 characters", identifier))
+	}
+	if 4+len(content) > 0xff {
+		return nil, errors.New(fmt.Sprintf("Splice descriptor %s is too long", descriptor.DescriptorTypeStr))
+	}
+
+	rv := []byte{byte(descriptor.Tag), byte(4 + len(content))}
+	rv = append(rv, []byte(identifier)...)
+	return append(rv, content...), nil
+}
+
+func encodeSegmentationDescriptor(seg *Segmentation_descriptor) ([]byte, error) {
+	if seg.EventCancelIdr {
+		w := io.GetBufferWriter(5)
+		w.WriteInt(seg.EventId)
+		w.Write(1, 1)
+		w.Write(0x7f, 7)
+		return w.GetBuf(), nil
+	}
+
+	upid, err := encodeSegmentationUpid(&seg.Upid)
+	if err != nil {
+		return nil, err
+	}
+	if len(upid) > 0xff {
+		return nil, errors.New(fmt.Sprintf("Segmentation UPID with %d bytes is too long", len(upid)))
+	}
+
+	// Fields before segmentation_upid()
+	size := 5 + 1 + 2
+	if !seg.ProgramSegmentationFlag {
+		size += 1 + 6*len(seg.Components)
+	}
+	if seg.SegmentationDurationFlag {
+		size += 5
+	}
+
+	w := io.GetBufferWriter(size)
+	w.WriteInt(seg.EventId)
+	w.Write(0, 1) // segmentation_event_cancel_indicator
+	w.Write(0x7f, 7)
+	writeFlag(&w, seg.ProgramSegmentationFlag)
+	writeFlag(&w, seg.SegmentationDurationFlag)
+	writeFlag(&w, seg.DeliveryNotRestrictedFlag)
+	if !seg.DeliveryNotRestrictedFlag {
+		writeFlag(&w, seg.WebDeliveryAllowedFlag)
+		writeFlag(&w, seg.NoRegionalBlackoutFlag)
+		writeFlag(&w, seg.ArchiveAllowedFlag)
+		restriction := len(deviceRestrictions) - 1
+		for idx, str := range deviceRestrictions {
+			if str == seg.DeviceRestrictions {
+				restriction = idx
+			}
+		}
+		w.Write(restriction, 2)
+	} else {
+		w.Write(0x1f, 5)
+	}
+	if !seg.ProgramSegmentationFlag {
+		w.WriteByte(len(seg.Components))
+		for _, component := range seg.Components {
+			w.WriteByte(component.ComponentTag)
+			w.Write(0x7f, 7)
+			w.Write(component.PtsOffset, 33)
+		}
+	}
+	if seg.SegmentationDurationFlag {
+		w.Write(seg.SegmentationDuration, 40)
+	}
+	w.WriteByte(seg.Upid.UpidType)
+	w.WriteByte(len(upid))
+	buf := append(w.GetBuf(), upid...)
+
+	buf = append(buf, byte(seg.SegmentationTypeId), byte(seg.SegmentNum), byte(seg.SegmentsExpected))
+	if seg.SubSegmentNum >= 0 && seg.SubSegmentsExpected >= 0 {
+		buf = append(buf, byte(seg.SubSegmentNum), byte(seg.SubSegmentsExpected))
+	}
+	return buf, nil
+}
+
+func encodeSegmentationUpid(upid *Segmentation_upid) ([]byte, error) {
+	switch upid.UpidType {
+	case 0x00:
+		return []byte{}, nil
+	case 0x02, 0x03, 0x07, 0x09, 0x0e, 0x0f, 0x11:
+		return []byte(upid.Upid), nil
+	case 0x08:
+		ti, err := strconv.ParseUint(upid.Upid, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 8)
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(ti)
+			ti >>= 8
+		}
+		return buf, nil
+	case 0x0a:
+		var subPrefix int
+		var suffix string
+		if _, err := fmt.Sscanf(upid.Upid, "10.%d/%s", &subPrefix, &suffix); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid EIDR %s", upid.Upid))
+		}
+		// Drop the check character if present
+		groups := strings.Split(suffix, "-")
+		if len(groups) == 6 {
+			groups = groups[:5]
+		}
+		id, err := hex.DecodeString(strings.Join(groups, ""))
+		if err != nil || len(id) != 10 {
+			return nil, errors.New(fmt.Sprintf("Invalid EIDR %s", upid.Upid))
+		}
+		return append([]byte{byte(subPrefix >> 8), byte(subPrefix)}, id...), nil
+	case 0x0b:
+		return nil, errors.New("Encoding of ATSC content identifier is not supported")
+	case 0x0c:
+		parts := strings.SplitN(upid.Upid, ":", 2)
+		if len(parts) != 2 || len(parts[0]) != 4 {
+			return nil, errors.New(fmt.Sprintf("Invalid MPU %s, should be <format_identifier>:<hex>", upid.Upid))
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(parts[0]), data...), nil
+	case 0x0d:
+		buf := []byte{}
+		for idx := range upid.Upids {
+			subUpid, err := encodeSegmentationUpid(&upid.Upids[idx])
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, byte(upid.Upids[idx].UpidType), byte(len(subUpid)))
+			buf = append(buf, subUpid...)
+		}
+		return buf, nil
+	default:
+		// Hex representations, e.g. UMID, ISAN and UUID
+		return hex.DecodeString(strings.NewReplacer(".", "", "-", "", " ", "").Replace(upid.Upid))
+	}
+}
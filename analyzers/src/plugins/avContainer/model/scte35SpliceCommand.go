@@ -163,7 +163,7 @@ func readTimeSignal(r *io.BsReader) Time_signal {
 }
 
 func readPrivateCommand(r *io.BsReader) Private_command {
-	identifier := (*r).ReadChar(32)
+	identifier := (*r).ReadChar(4)
 
 	return Private_command{Identifier: identifier, PrivateBytes: (*r).ReadHex(len((*r).GetRemainedBuffer()))}
 }
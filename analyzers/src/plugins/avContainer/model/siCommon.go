@@ -0,0 +1,280 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+// Common parsing for CAT and DVB SI tables (ETSI EN 300 468)
+
+type siSection struct {
+	callback   PsiManager
+	header     tttKernel.CmBuf
+	payload    []byte
+	pktCnt     int
+	tableId    int
+	sectionLen int
+}
+
+func (s *siSection) setBuffer(inBuf []byte, name string, syntaxIdr int) error {
+	buf := inBuf[:2]
+	r := io.GetBufferReader(buf)
+	s.header = tttKernel.MakeSimpleBuf(buf)
+	if r.ReadBits(1) != syntaxIdr {
+		return errors.New(fmt.Sprintf("Section syntax indicator of %s is not set to %d", name, syntaxIdr))
+	}
+	r.ReadBits(1) // reserved_future_use
+	r.ReadBits(2) // reserved
+	s.sectionLen = r.ReadBits(12)
+	s.header.SetField("sectionLength", s.sectionLen, true)
+	s.header.SetField("tableId", s.tableId, true)
+
+	s.payload = inBuf[2:]
+	return nil
+}
+
+func (s *siSection) Append(buf []byte) {
+	s.payload = append(s.payload, buf...)
+}
+
+func (s *siSection) GetHeader() tttKernel.CmBuf {
+	return s.header
+}
+
+func (s *siSection) GetPayload() []byte {
+	return s.payload
+}
+
+func (s *siSection) Ready() bool {
+	return len(s.payload) >= s.sectionLen
+}
+
+func (s *siSection) Serialize() []byte {
+	// TODO
+	return []byte{}
+}
+
+// Fields between section_length and the table body of a long section
+type longSectionHeader struct {
+	TableIdExt        int
+	Version           int
+	SectionNumber     int
+	LastSectionNumber int
+}
+
+func readLongSectionHeader(r *io.BsReader) longSectionHeader {
+	h := longSectionHeader{}
+	h.TableIdExt = r.ReadBits(16)
+	r.ReadBits(2) // reserved
+	h.Version = r.ReadBits(5)
+	r.ReadBits(1) // current_next_indicator
+	h.SectionNumber = r.ReadBits(8)
+	h.LastSectionNumber = r.ReadBits(8)
+	return h
+}
+
+// Key to identify a sub-table for version tracking
+func siVersionKey(pid int, tableId int, tableIdExt int, sectionNumber int) string {
+	return fmt.Sprintf("%d/%d/%d/%d", pid, tableId, tableIdExt, sectionNumber)
+}
+
+// Descriptors
+
+type SiDescriptor struct {
+	Tag     int
+	Content string      `json:",omitempty"` // Hex string for descriptors not decoded
+	Decoded interface{} `json:",omitempty"`
+}
+
+type NetworkNameDescriptor struct {
+	NetworkName string
+}
+
+type ServiceDescriptor struct {
+	ServiceType  int
+	ProviderName string
+	ServiceName  string
+}
+
+type ShortEventDescriptor struct {
+	Language  string
+	EventName string
+	Text      string
+}
+
+type CaDescriptor struct {
+	CaSystemId  int
+	CaPid       int
+	PrivateData string
+}
+
+type LocalTimeOffset struct {
+	CountryCode     string
+	RegionId        int
+	Polarity        int
+	LocalTimeOffset string
+	TimeOfChange    string
+	NextTimeOffset  string
+}
+
+type LocalTimeOffsetDescriptor struct {
+	Offsets []LocalTimeOffset
+}
+
+// Read a descriptor loop of length l. Parsing stops at a descriptor exceeding
+// the loop or the buffer, and the descriptors before it are returned.
+func readSiDescriptors(r *io.BsReader, l int) []SiDescriptor {
+	descriptors := []SiDescriptor{}
+	for l >= 2 && r.GetSize()-r.GetPos() >= 2 {
+		tag := r.ReadBits(8)
+		descLen := r.ReadBits(8)
+		l -= 2
+		if descLen > l || descLen > r.GetSize()-r.GetPos() {
+			break
+		}
+		l -= descLen
+		descBuf := make([]byte, descLen)
+		for i := range descBuf {
+			descBuf[i] = byte(r.ReadBits(8))
+		}
+		descriptors = append(descriptors, parseSiDescriptor(tag, descBuf))
+	}
+	// Skip the malformed rest so that the caller reads on from the end of the loop
+	for ; l > 0 && r.GetPos() < r.GetSize(); l-- {
+		r.ReadBits(8)
+	}
+	return descriptors
+}
+
+func parseSiDescriptor(tag int, buf []byte) SiDescriptor {
+	desc := SiDescriptor{Tag: tag}
+	r := io.GetBufferReader(buf)
+	switch tag {
+	case 0x09:
+		if len(buf) < 4 {
+			break
+		}
+		ca := CaDescriptor{}
+		ca.CaSystemId = r.ReadBits(16)
+		r.ReadBits(3)
+		ca.CaPid = r.ReadBits(13)
+		ca.PrivateData = r.ReadHex(len(buf) - 4)
+		desc.Decoded = ca
+	case 0x40:
+		desc.Decoded = NetworkNameDescriptor{NetworkName: decodeDvbString(buf)}
+	case 0x48:
+		if len(buf) < 3 {
+			break
+		}
+		service := ServiceDescriptor{}
+		service.ServiceType = int(buf[0])
+		providerLen := int(buf[1])
+		if 2+providerLen >= len(buf) {
+			break
+		}
+		service.ProviderName = decodeDvbString(buf[2:(2 + providerLen)])
+		serviceLen := int(buf[2+providerLen])
+		if 3+providerLen+serviceLen > len(buf) {
+			break
+		}
+		service.ServiceName = decodeDvbString(buf[(3 + providerLen):(3 + providerLen + serviceLen)])
+		desc.Decoded = service
+	case 0x4d:
+		if len(buf) < 5 {
+			break
+		}
+		event := ShortEventDescriptor{}
+		event.Language = string(buf[:3])
+		nameLen := int(buf[3])
+		if 4+nameLen >= len(buf) {
+			break
+		}
+		event.EventName = decodeDvbString(buf[4:(4 + nameLen)])
+		textLen := int(buf[4+nameLen])
+		if 5+nameLen+textLen > len(buf) {
+			break
+		}
+		event.Text = decodeDvbString(buf[(5 + nameLen):(5 + nameLen + textLen)])
+		desc.Decoded = event
+	case 0x58:
+		offsets := []LocalTimeOffset{}
+		for len(r.GetRemainedBuffer()) >= 13 {
+			offset := LocalTimeOffset{}
+			offset.CountryCode = r.ReadChar(3)
+			offset.RegionId = r.ReadBits(6)
+			r.ReadBits(1)
+			offset.Polarity = r.ReadBits(1)
+			offset.LocalTimeOffset = decodeBcdTime(r.ReadBits(16), 2)
+			offset.TimeOfChange = decodeUtcTime(r.ReadBits(40)).Format(time.RFC3339)
+			offset.NextTimeOffset = decodeBcdTime(r.ReadBits(16), 2)
+			offsets = append(offsets, offset)
+		}
+		desc.Decoded = LocalTimeOffsetDescriptor{Offsets: offsets}
+	}
+
+	if desc.Decoded == nil {
+		hexReader := io.GetBufferReader(buf)
+		desc.Content = hexReader.ReadHex(len(buf))
+	}
+	return desc
+}
+
+// Annex A: Strip the character table selector and treat the rest as ISO/IEC 6937 compatible text
+func decodeDvbString(buf []byte) string {
+	if len(buf) == 0 {
+		return ""
+	}
+	switch {
+	case buf[0] == 0x10:
+		if len(buf) < 3 {
+			return ""
+		}
+		buf = buf[3:]
+	case buf[0] == 0x1f:
+		if len(buf) < 2 {
+			return ""
+		}
+		buf = buf[2:]
+	case buf[0] < 0x20:
+		buf = buf[1:]
+	}
+	rv := []rune{}
+	for _, b := range buf {
+		// Skip control codes such as emphasis on/off and CR/LF
+		if b >= 0x80 && b < 0xa0 {
+			continue
+		}
+		rv = append(rv, rune(b))
+	}
+	return string(rv)
+}
+
+func bcdToInt(bcd int) int {
+	return (bcd>>4)*10 + bcd&0x0f
+}
+
+// Decode n bytes of BCD into hh:mm(:ss)
+func decodeBcdTime(bcd int, n int) string {
+	rv := ""
+	for i := n - 1; i >= 0; i-- {
+		if rv != "" {
+			rv += ":"
+		}
+		rv += fmt.Sprintf("%02d", bcdToInt((bcd>>(8*i))&0xff))
+	}
+	return rv
+}
+
+// 16-bit MJD followed by 24-bit BCD time
+func decodeUtcTime(raw int) time.Time {
+	mjd := raw >> 24
+	bcd := raw & 0xffffff
+	date := time.Date(1858, 11, 17, 0, 0, 0, 0, time.UTC).AddDate(0, 0, mjd)
+	return date.Add(time.Duration(bcdToInt(bcd>>16))*time.Hour +
+		time.Duration(bcdToInt((bcd>>8)&0xff))*time.Minute +
+		time.Duration(bcdToInt(bcd&0xff))*time.Second)
+}
@@ -2,8 +2,10 @@ package tsdemux
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/tony-507/analyzers/src/tttKernel"
 )
@@ -15,7 +17,11 @@ const (
 	UNKNOWN PKT_TYPE = "undefined"
 	PAT     PKT_TYPE = "PAT"
 	PMT     PKT_TYPE = "PMT"
+	CAT     PKT_TYPE = "CAT"
+	NIT     PKT_TYPE = "NIT"
 	SDT     PKT_TYPE = "SDT"
+	EIT     PKT_TYPE = "EIT"
+	TDT     PKT_TYPE = "TDT"
 	VIDEO   PKT_TYPE = "video"
 	AUDIO   PKT_TYPE = "audio"
 	DATA    PKT_TYPE = "data"
@@ -29,8 +35,20 @@ type demuxController struct {
 	outputQueueLen int
 	progClkMap     map[int]*programSrcClk // progNum -> srcClk
 	pktCntMap      map[int]int            // pid -> # of packets
+	rcvCntMap      map[int]int            // pid -> # of packets received since metrics are last collected
 	resourceLoader *tttKernel.ResourceLoader
+	streamTime     time.Time              // Latest UTC time from TDT/TOT
+	streamTimeCnt  int                    // Packet count at which streamTime is received
 	mtx            sync.Mutex
+	metrics        demuxMetrics
+}
+
+type demuxMetrics struct {
+	name       string
+	packets    *tttKernel.Counter
+	ccErrors   *tttKernel.Counter
+	indicators *tttKernel.Counter
+	queueLen   *tttKernel.Gauge
 }
 
 func (dc *demuxController) printInfo(sb *strings.Builder) {
@@ -38,6 +56,9 @@ func (dc *demuxController) printInfo(sb *strings.Builder) {
 	sb.WriteString(fmt.Sprintf("\tCurrent count: %d\n", dc.inputCnt))
 	sb.WriteString(fmt.Sprintf("\tisRunning: %v\n", dc.isRunning))
 	sb.WriteString(fmt.Sprintf("\tOutput queue length: %d\n", dc.outputQueueLen))
+	if !dc.streamTime.IsZero() {
+		sb.WriteString(fmt.Sprintf("\tStream UTC time: %s (#%d)\n", dc.streamTime.Format(time.RFC3339), dc.streamTimeCnt))
+	}
 	sb.WriteString("\tPacket statistics map:\n")
 	for pid, cnt := range dc.pktCntMap {
 		sb.WriteString(fmt.Sprintf("\t\t%3d: %7d\n", pid, cnt))
@@ -64,8 +85,41 @@ func (dc *demuxController) dataParsed(pid int) {
 	dc.mtx.Unlock()
 }
 
+// Counted locally and exported on collection, as this is called on every packet
+func (dc *demuxController) packetReceived(pid int) {
+	dc.mtx.Lock()
+	dc.rcvCntMap[pid] += 1
+	dc.mtx.Unlock()
+}
+
+// Export the counts accumulated since the last collection
+func (dc *demuxController) collectMetrics() {
+	dc.mtx.Lock()
+	for pid, cnt := range dc.rcvCntMap {
+		dc.metrics.packets.Add(float64(cnt), dc.metrics.name, strconv.Itoa(pid))
+	}
+	dc.rcvCntMap = make(map[int]int, 0)
+	dc.mtx.Unlock()
+}
+
+// Called by input monitor when a TR 101 290 indicator is raised
+func (dc *demuxController) indicatorRaised(indicator int, pid int) {
+	dc.metrics.indicators.Inc(dc.metrics.name, indicatorNames[indicator])
+	if indicator == _CC_ERROR {
+		dc.metrics.ccErrors.Inc(dc.metrics.name, strconv.Itoa(pid))
+	}
+}
+
+func (dc *demuxController) streamTimeReceived(utc time.Time, pktCnt int) {
+	dc.mtx.Lock()
+	dc.streamTime = utc
+	dc.streamTimeCnt = pktCnt
+	dc.mtx.Unlock()
+}
+
 func (dc *demuxController) outputUnitAdded() {
 	dc.outputQueueLen += 1
+	dc.metrics.queueLen.Set(float64(dc.outputQueueLen), dc.metrics.name)
 }
 
 func (dc *demuxController) updateSrcClk(progNum int) *programSrcClk {
@@ -77,8 +131,18 @@ func (dc *demuxController) updateSrcClk(progNum int) *programSrcClk {
 	return dc.progClkMap[progNum]
 }
 
-func (dc *demuxController) setResource(resourceLoader *tttKernel.ResourceLoader) {
+func (dc *demuxController) setResource(resourceLoader *tttKernel.ResourceLoader, name string) {
 	dc.resourceLoader = resourceLoader
+
+	metrics := resourceLoader.Metrics()
+	dc.metrics = demuxMetrics{
+		name:       name,
+		packets:    metrics.Counter("ttt_ts_packets_total", "TS packets received per PID", "plugin", "pid"),
+		ccErrors:   metrics.Counter("ttt_ts_cc_errors_total", "Continuity count errors per PID", "plugin", "pid"),
+		indicators: metrics.Counter("ttt_tr101290_errors_total", "TR 101 290 indicators raised", "plugin", "indicator"),
+		queueLen:   metrics.Gauge("ttt_demux_output_queue_length", "Units waiting to be fetched from the demuxer", "plugin"),
+	}
+	metrics.OnCollect(dc.collectMetrics)
 }
 
 func (dc *demuxController) queryStreamType(typeNum int) string {
@@ -95,6 +159,7 @@ func (dc *demuxController) stop() {
 
 func (dc *demuxController) outputUnitFetched() {
 	dc.outputQueueLen -= 1
+	dc.metrics.queueLen.Set(float64(dc.outputQueueLen), dc.metrics.name)
 }
 
 func getControl() *demuxController {
@@ -104,6 +169,7 @@ func getControl() *demuxController {
 		outputQueueLen:     0,
 		progClkMap: make(map[int]*programSrcClk, 0),
 		pktCntMap:  make(map[int]int, 0),
+		rcvCntMap:  make(map[int]int, 0),
 	}
 	return &rv
 }
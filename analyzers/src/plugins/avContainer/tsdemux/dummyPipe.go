@@ -1,6 +1,8 @@
 package tsdemux
 
 import (
+	"strings"
+
 	"github.com/tony-507/analyzers/src/plugins/common"
 	"github.com/tony-507/analyzers/src/tttKernel"
 )
@@ -43,6 +45,8 @@ func (dp *dummyPipe) getOutputUnit() tttKernel.CmUnit {
 	return outUnit
 }
 
+func (dp *dummyPipe) printInfo(sb *strings.Builder) {}
+
 func (dp *dummyPipe) start() {}
 
 func (dp *dummyPipe) stop() {}
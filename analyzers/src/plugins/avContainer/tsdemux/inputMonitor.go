@@ -1,34 +1,542 @@
 package tsdemux
 
+// Measurement of ETSI TR 101 290 priority 1, 2 and 3 indicators
+// Known issue:
+// * Buffer_error (3.3) is not measured as it requires a T-STD model
+// * Intervals are measured on a clock interpolated from the first PCR pid, so they are skipped before two PCRs are received
+
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
 	"github.com/tony-507/analyzers/src/logging"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+const (
+	_TS_SYNC_LOSS int = iota
+	_SYNC_BYTE_ERROR
+	_PAT_ERROR
+	_CC_ERROR
+	_PMT_ERROR
+	_PID_ERROR
+	_TRANSPORT_ERROR
+	_CRC_ERROR
+	_PCR_REPETITION_ERROR
+	_PCR_DISCONTINUITY_ERROR
+	_PCR_ACCURACY_ERROR
+	_PTS_ERROR
+	_CAT_ERROR
+	_NIT_ERROR
+	_UNREFERENCED_PID
+	_SDT_ERROR
+	_EIT_ERROR
+	_RST_ERROR
+	_TDT_ERROR
+	_INDICATOR_COUNT
 )
 
+// Time constants in 27MHz ticks
 const (
-	_CC_ERROR int = 0
+	_TICKS_PER_MS    int64 = 27000
+	_PCR_WRAP        int64 = (1 << 33) * 300
+	_PCR_ACCURACY    int64 = 14 // 500ns
+	_TIMEOUT_PERIOD  int64 = 100 * _TICKS_PER_MS
+	_REPORT_FILENAME       = "%s-tr101290.json" // Prefixed by the plugin name
 )
 
+var indicatorNames = [_INDICATOR_COUNT]string{
+	"1.1 TS_sync_loss",
+	"1.2 Sync_byte_error",
+	"1.3 PAT_error",
+	"1.4 Continuity_count_error",
+	"1.5 PMT_error",
+	"1.6 PID_error",
+	"2.1 Transport_error",
+	"2.2 CRC_error",
+	"2.3a PCR_repetition_error",
+	"2.3b PCR_discontinuity_indicator_error",
+	"2.4 PCR_accuracy_error",
+	"2.5 PTS_error",
+	"2.6 CAT_error",
+	"3.1 NIT_error",
+	"3.4 Unreferenced_PID",
+	"3.5 SDT_error",
+	"3.6 EIT_error",
+	"3.7 RST_error",
+	"3.8 TDT_error",
+}
+
+type tr101290Indicator struct {
+	Name        string
+	Priority    int
+	Count       int
+	FirstPktCnt int
+	LastPktCnt  int
+	Pids        map[int]int // pid -> count
+}
+
+// Check that something occurs at least once every limit
+type repetitionCheck struct {
+	indicator int
+	pid       int
+	limit     int64
+	last      int64 // -1 if the check has not started
+	reported  bool
+}
+
+type pcrRecord struct {
+	pcr      int64
+	pktCnt   int
+	basePcr  int64 // For PCR accuracy, reset on discontinuity
+	baseCnt  int
+	numOfPcr int
+}
+
+// Clock interpolated from PCR of the reference pid. It keeps increasing across PCR discontinuities
+type monitorClock struct {
+	refPid      int
+	pcr         int64
+	pktCnt      int
+	time        int64
+	ticksPerPkt float64
+}
+
+func (c *monitorClock) valid() bool {
+	return c.ticksPerPkt > 0
+}
+
+func (c *monitorClock) now(pktCnt int) int64 {
+	return c.time + int64(float64(pktCnt-c.pktCnt)*c.ticksPerPkt)
+}
+
+func (c *monitorClock) update(pcr int64, discontinuity bool, pktCnt int) {
+	if c.refPid == -1 {
+		c.pcr = pcr
+		c.pktCnt = pktCnt
+		return
+	}
+	newTime := c.now(pktCnt)
+	diff := pcrDiff(pcr, c.pcr)
+	if !discontinuity && diff > 0 && pktCnt > c.pktCnt && diff <= 100*_TICKS_PER_MS {
+		c.ticksPerPkt = float64(diff) / float64(pktCnt-c.pktCnt)
+	}
+	if !c.valid() {
+		newTime = 0
+	}
+	c.time = newTime
+	c.pcr = pcr
+	c.pktCnt = pktCnt
+}
+
 // A struct that monitors the input source
 // It looks for error in headers
 type inputMonitor struct {
-	logger logging.Log
-	ccMap  map[int]int // pid -> cc
+	logger        logging.Log
+	indicators    [_INDICATOR_COUNT]tr101290Indicator
+	ccMap         map[int]int  // pid -> cc
+	dupMap        map[int]bool // pid -> whether last packet is a duplicate
+	pcrMap        map[int]*pcrRecord
+	ptsMap        map[int]int64 // pid -> time of last PTS
+	checks        map[string]*repetitionCheck
+	pmtPids       map[int]bool
+	esPids        map[int]bool
+	unrefPids     map[int]int64 // pid -> first occurrence
+	scrambledPids map[int]bool
+	clk           monitorClock
+	lastTimeout   int64
+	badSyncCnt    int
+	goodSyncCnt   int
+	syncLost      bool
+	catReceived   bool
+	mtx           *sync.Mutex
+	onRaise       func(indicator int, pid int) // Optional, e.g. for metrics
+}
+
+func (tm *inputMonitor) raise(indicator int, pid int, pktCnt int, msg string, param ...interface{}) {
+	tm.mtx.Lock()
+	ind := &tm.indicators[indicator]
+	if ind.Count == 0 {
+		ind.FirstPktCnt = pktCnt
+	}
+	ind.Count += 1
+	ind.LastPktCnt = pktCnt
+	if pid != -1 {
+		ind.Pids[pid] += 1
+	}
+	tm.mtx.Unlock()
+
+	if tm.onRaise != nil {
+		tm.onRaise(indicator, pid)
+	}
+
+	logMsg := fmt.Sprintf("[%s] At pkt#%d, %s", ind.Name, pktCnt, fmt.Sprintf(msg, param...))
+	if ind.Priority == 1 {
+		tm.logger.Error(logMsg)
+	} else {
+		tm.logger.Warn(logMsg)
+	}
 }
 
-func (tm *inputMonitor) checkTsHeader(pid int, afc int, cc int, pktCnt int) {
+// Return false if the packet should be discarded
+func (tm *inputMonitor) checkSyncByte(buf []byte, pktCnt int) bool {
+	if len(buf) > 0 && buf[0] == 0x47 {
+		tm.badSyncCnt = 0
+		tm.goodSyncCnt += 1
+		if tm.syncLost && tm.goodSyncCnt >= 5 {
+			tm.logger.Info("Sync regained at pkt#%d", pktCnt)
+			tm.syncLost = false
+		}
+		return true
+	}
+
+	tm.goodSyncCnt = 0
+	tm.badSyncCnt += 1
+	tm.raise(_SYNC_BYTE_ERROR, -1, pktCnt, "sync byte is not 0x47")
+	if tm.badSyncCnt >= 2 && !tm.syncLost {
+		tm.syncLost = true
+		tm.raise(_TS_SYNC_LOSS, -1, pktCnt, "%d consecutive corrupted sync bytes", tm.badSyncCnt)
+	}
+	return false
+}
+
+func (tm *inputMonitor) checkTsHeader(pid int, tei bool, tsc int, afc int, cc int, discontinuity bool, pktCnt int) {
+	if tei {
+		tm.raise(_TRANSPORT_ERROR, pid, pktCnt, "transport_error_indicator is set")
+	}
+
+	if tsc != 0 {
+		switch {
+		case pid == 0:
+			tm.raise(_PAT_ERROR, pid, pktCnt, "PAT is scrambled")
+		case tm.pmtPids[pid]:
+			tm.raise(_PMT_ERROR, pid, pktCnt, "PMT is scrambled")
+		case !tm.catReceived && !tm.scrambledPids[pid]:
+			tm.raise(_CAT_ERROR, pid, pktCnt, "scrambled packets found without CAT")
+		}
+		tm.scrambledPids[pid] = true
+	}
+
 	// Look for CC error
-	if currCC, hasKey := tm.ccMap[pid]; hasKey {
-		hasCcError := (afc != 2 && cc != (currCC+1)%16) || (afc == 2 && cc != currCC)
-		hasCcError = pid != 8191 && hasCcError
+	if currCC, hasKey := tm.ccMap[pid]; hasKey && pid != 8191 && !discontinuity {
+		hasPayload := afc&0x01 != 0
+		isDuplicate := hasPayload && cc == currCC && !tm.dupMap[pid]
+		hasCcError := (hasPayload && cc != (currCC+1)%16 && !isDuplicate) || (!hasPayload && cc != currCC)
 		if hasCcError {
-			tm.logger.Error("CC error for pid %d at pkt#%d. Expected %d, but got %d", pid, pktCnt, (currCC+1)%16, cc)
+			tm.raise(_CC_ERROR, pid, pktCnt, "pid %d expected %d, but got %d", pid, (currCC+1)%16, cc)
 		}
+		tm.dupMap[pid] = isDuplicate
 	}
 	tm.ccMap[pid] = cc
+
+	if !tm.clk.valid() {
+		return
+	}
+	now := tm.clk.now(pktCnt)
+
+	if check, hasKey := tm.checks[fmt.Sprintf("PID/%d", pid)]; hasKey {
+		tm.occur(check, now, pktCnt)
+	}
+
+	if !tm.isReferenced(pid) {
+		if first, hasKey := tm.unrefPids[pid]; !hasKey {
+			tm.unrefPids[pid] = now
+		} else if first != -1 && now-first > 500*_TICKS_PER_MS {
+			tm.raise(_UNREFERENCED_PID, pid, pktCnt, "pid %d is not referenced", pid)
+			tm.unrefPids[pid] = -1
+		}
+	}
+
+	if now-tm.lastTimeout >= _TIMEOUT_PERIOD {
+		tm.lastTimeout = now
+		for _, check := range tm.checks {
+			tm.checkTimeout(check, now, pktCnt)
+		}
+	}
+}
+
+func (tm *inputMonitor) checkPcr(pid int, pcr int64, discontinuity bool, pktCnt int) {
+	if tm.clk.refPid == -1 || tm.clk.refPid == pid {
+		tm.clk.update(pcr, discontinuity, pktCnt)
+		tm.clk.refPid = pid
+	}
+
+	record, hasKey := tm.pcrMap[pid]
+	if !hasKey {
+		tm.pcrMap[pid] = &pcrRecord{pcr: pcr, pktCnt: pktCnt, basePcr: pcr, baseCnt: pktCnt, numOfPcr: 1}
+		return
+	}
+
+	if tm.clk.valid() {
+		interval := int64(float64(pktCnt-record.pktCnt) * tm.clk.ticksPerPkt)
+		if interval > 100*_TICKS_PER_MS {
+			tm.raise(_PCR_REPETITION_ERROR, pid, pktCnt, "PCR interval of pid %d is %dms", pid, interval/_TICKS_PER_MS)
+		}
+	}
+
+	diff := pcrDiff(pcr, record.pcr)
+	if discontinuity {
+		record.basePcr = pcr
+		record.baseCnt = pktCnt
+		record.numOfPcr = 0
+	} else {
+		if diff < 0 || diff > 100*_TICKS_PER_MS {
+			tm.raise(_PCR_DISCONTINUITY_ERROR, pid, pktCnt, "PCR of pid %d jumps by %d without discontinuity_indicator", pid, diff)
+			record.basePcr = pcr
+			record.baseCnt = pktCnt
+			record.numOfPcr = 0
+		} else if record.numOfPcr >= 2 {
+			rate := float64(pcrDiff(record.pcr, record.basePcr)) / float64(record.pktCnt-record.baseCnt)
+			expected := record.pcr + int64(rate*float64(pktCnt-record.pktCnt))
+			if inaccuracy := pcrDiff(pcr, expected); inaccuracy > _PCR_ACCURACY || inaccuracy < -_PCR_ACCURACY {
+				tm.raise(_PCR_ACCURACY_ERROR, pid, pktCnt, "PCR of pid %d is off by %dns", pid, inaccuracy*1000/27)
+			}
+		}
+	}
+	record.pcr = pcr
+	record.pktCnt = pktCnt
+	record.numOfPcr += 1
+}
+
+func (tm *inputMonitor) checkPts(pid int, pktCnt int) {
+	if !tm.clk.valid() {
+		return
+	}
+	now := tm.clk.now(pktCnt)
+	if last, hasKey := tm.ptsMap[pid]; hasKey && now-last > 700*_TICKS_PER_MS {
+		tm.raise(_PTS_ERROR, pid, pktCnt, "PTS interval of pid %d is %dms", pid, (now-last)/_TICKS_PER_MS)
+	}
+	tm.ptsMap[pid] = now
+}
+
+// Check the section starting in a packet with payload_unit_start_indicator set
+func (tm *inputMonitor) checkSection(pid int, payload []byte, pktCnt int) {
+	if pid >= 0x20 && !tm.pmtPids[pid] {
+		return
+	}
+	if len(payload) == 0 || int(payload[0])+4 > len(payload) {
+		return
+	}
+	section := payload[(int(payload[0]) + 1):]
+	tableId := int(section[0])
+	if tableId == 0xff {
+		return
+	}
+
+	indicator := -1
+	occurred := ""
+	limit := int64(0) // SI repetition is only checked once the table is seen
+	valid := true
+	switch {
+	case pid == 0:
+		indicator = _PAT_ERROR
+		valid = tableId == 0x00
+		occurred = "PAT"
+	case pid == 1:
+		indicator = _CAT_ERROR
+		valid = tableId == 0x01
+		tm.catReceived = tm.catReceived || valid
+	case tm.pmtPids[pid]:
+		indicator = _PMT_ERROR
+		valid = tableId == 0x02
+		occurred = fmt.Sprintf("PMT/%d", pid)
+	case pid == 0x10:
+		indicator = _NIT_ERROR
+		valid = tableId == 0x40 || tableId == 0x41 || tableId == 0x72
+		if tableId == 0x40 {
+			occurred = "NIT"
+			limit = 10000
+		}
+	case pid == 0x11:
+		indicator = _SDT_ERROR
+		valid = tableId == 0x42 || tableId == 0x46 || tableId == 0x4a || tableId == 0x72
+		if tableId == 0x42 {
+			occurred = "SDT"
+			limit = 2000
+		}
+	case pid == 0x12:
+		indicator = _EIT_ERROR
+		valid = (tableId >= 0x4e && tableId <= 0x6f) || tableId == 0x72
+		if tableId == 0x4e {
+			occurred = "EIT"
+			limit = 2000
+		}
+	case pid == 0x13:
+		indicator = _RST_ERROR
+		valid = tableId == 0x71 || tableId == 0x72
+	case pid == 0x14:
+		indicator = _TDT_ERROR
+		valid = tableId == 0x70 || tableId == 0x72 || tableId == 0x73
+		if tableId == 0x70 {
+			occurred = "TDT"
+			limit = 30000
+		}
+	default:
+		return
+	}
+
+	if !valid {
+		tm.raise(indicator, pid, pktCnt, "table_id %d is not allowed on pid %d", tableId, pid)
+		return
+	}
+
+	if limit != 0 {
+		tm.addCheck(occurred, indicator, pid, limit)
+	}
+	if check, hasKey := tm.checks[occurred]; hasKey && tm.clk.valid() {
+		tm.occur(check, tm.clk.now(pktCnt), pktCnt)
+	}
+}
+
+// Verify CRC32 of a complete section. Return false if it mismatches
+func (tm *inputMonitor) checkCrc(pid int, section []byte, pktCnt int) bool {
+	tableId := int(section[0])
+	// Sections without CRC
+	if tableId == 0x70 || tableId == 0x71 || tableId == 0x72 {
+		return true
+	}
+	if io.Crc32Mpeg2(section) != 0 {
+		tm.raise(_CRC_ERROR, pid, pktCnt, "CRC of table %d on pid %d mismatches", tableId, pid)
+		return false
+	}
+	return true
+}
+
+func (tm *inputMonitor) occur(check *repetitionCheck, now int64, pktCnt int) {
+	if check.last >= 0 && !check.reported && now-check.last > check.limit {
+		tm.raiseTimeout(check, now, pktCnt)
+	}
+	check.last = now
+	check.reported = false
+}
+
+func (tm *inputMonitor) checkTimeout(check *repetitionCheck, now int64, pktCnt int) {
+	if check.last < 0 {
+		check.last = now
+		return
+	}
+	if !check.reported && now-check.last > check.limit {
+		tm.raiseTimeout(check, now, pktCnt)
+		check.reported = true
+	}
+}
+
+func (tm *inputMonitor) raiseTimeout(check *repetitionCheck, now int64, pktCnt int) {
+	tm.raise(check.indicator, check.pid, pktCnt, "pid %d does not occur for %dms", check.pid, (now-check.last)/_TICKS_PER_MS)
+}
+
+func (tm *inputMonitor) addCheck(key string, indicator int, pid int, limitInMs int64) {
+	if _, hasKey := tm.checks[key]; !hasKey {
+		tm.checks[key] = &repetitionCheck{indicator: indicator, pid: pid, limit: limitInMs * _TICKS_PER_MS, last: -1}
+	}
+}
+
+func (tm *inputMonitor) addPmtPid(pid int) {
+	tm.pmtPids[pid] = true
+	tm.addCheck(fmt.Sprintf("PMT/%d", pid), _PMT_ERROR, pid, 500)
+}
+
+func (tm *inputMonitor) addEsPid(pid int) {
+	tm.esPids[pid] = true
+	tm.addCheck(fmt.Sprintf("PID/%d", pid), _PID_ERROR, pid, 5000)
+}
+
+func (tm *inputMonitor) isReferenced(pid int) bool {
+	if pid < 0x20 || pid == 8191 || tm.pmtPids[pid] || tm.esPids[pid] {
+		return true
+	}
+	// PCR pid is referenced in PMT but not recorded in stream records
+	_, isPcrPid := tm.pcrMap[pid]
+	return isPcrPid
+}
+
+func (tm *inputMonitor) getIndicators() []tr101290Indicator {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+	rv := make([]tr101290Indicator, 0)
+	for _, ind := range tm.indicators {
+		pids := map[int]int{}
+		for pid, cnt := range ind.Pids {
+			pids[pid] = cnt
+		}
+		ind.Pids = pids
+		rv = append(rv, ind)
+	}
+	return rv
+}
+
+func (tm *inputMonitor) printInfo(sb *strings.Builder) {
+	sb.WriteString("\tTR 101 290:\n")
+	for _, ind := range tm.getIndicators() {
+		sb.WriteString(fmt.Sprintf("\t\t%-40s %7d", ind.Name, ind.Count))
+		if len(ind.Pids) != 0 {
+			pids := []int{}
+			for pid := range ind.Pids {
+				pids = append(pids, pid)
+			}
+			sort.Ints(pids)
+			pidStrs := []string{}
+			for _, pid := range pids {
+				pidStrs = append(pidStrs, fmt.Sprintf("%d: %d", pid, ind.Pids[pid]))
+			}
+			sb.WriteString(fmt.Sprintf(" (%s)", strings.Join(pidStrs, ", ")))
+		}
+		sb.WriteString("\n")
+	}
+}
+
+func (tm *inputMonitor) writeReport(outDir string, name string) error {
+	fname := fmt.Sprintf(_REPORT_FILENAME, name)
+	os.Remove(path.Join(outDir, fname))
+	jsonBytes, _ := json.MarshalIndent(tm.getIndicators(), "", "\t")
+	writer := io.RawWriter(outDir, fname)
+	if err := writer.Open(); err != nil {
+		return err
+	}
+	writer.Write(tttKernel.MakeSimpleBuf(jsonBytes))
+	return writer.Close()
+}
+
+func pcrDiff(a int64, b int64) int64 {
+	diff := a - b
+	if diff < -_PCR_WRAP/2 {
+		diff += _PCR_WRAP
+	} else if diff > _PCR_WRAP/2 {
+		diff -= _PCR_WRAP
+	}
+	return diff
 }
 
 func setupInputMonitor() inputMonitor {
-	tsMon := inputMonitor{ccMap: map[int]int{}, logger: logging.CreateLogger("inputMonitor")}
+	tsMon := inputMonitor{
+		ccMap:         map[int]int{},
+		dupMap:        map[int]bool{},
+		pcrMap:        map[int]*pcrRecord{},
+		ptsMap:        map[int]int64{},
+		checks:        map[string]*repetitionCheck{},
+		pmtPids:       map[int]bool{},
+		esPids:        map[int]bool{},
+		unrefPids:     map[int]int64{},
+		scrambledPids: map[int]bool{},
+		clk:           monitorClock{refPid: -1},
+		logger:        logging.CreateLogger("inputMonitor"),
+		mtx:           &sync.Mutex{},
+	}
+	for idx := range tsMon.indicators {
+		tsMon.indicators[idx] = tr101290Indicator{
+			Name:     indicatorNames[idx],
+			Priority: int(indicatorNames[idx][0] - '0'),
+			Pids:     map[int]int{},
+		}
+	}
+
+	tsMon.addCheck("PAT", _PAT_ERROR, 0, 500)
 
 	return tsMon
 }
@@ -0,0 +1,77 @@
+package tsdemux
+
+// Reassemble PSI sections across TS packets
+
+type psiSection struct {
+	pktCnt int // Packet count where the section starts
+	buf    []byte
+}
+
+type sectionAssembler struct {
+	buf    []byte // Pending section, nil if no section is being collected
+	pktCnt int
+	cc     int
+}
+
+// Feed the payload of a TS packet and return the completed sections
+func (sa *sectionAssembler) feed(payload []byte, pusi bool, cc int, pktCnt int) []psiSection {
+	rv := []psiSection{}
+
+	if sa.cc != -1 {
+		if cc == sa.cc {
+			// Duplicate packet
+			return rv
+		}
+		if cc != (sa.cc+1)%16 {
+			// The pending section is corrupted
+			sa.buf = nil
+		}
+	}
+	sa.cc = cc
+
+	if pusi {
+		if len(payload) == 0 || int(payload[0])+1 > len(payload) {
+			sa.buf = nil
+			return rv
+		}
+		pointer := int(payload[0])
+		if sa.buf != nil {
+			sa.buf = append(sa.buf, payload[1:(1+pointer)]...)
+			rv = append(rv, sa.extract()...)
+		}
+		sa.buf = append([]byte{}, payload[(1+pointer):]...)
+		sa.pktCnt = pktCnt
+	} else if sa.buf != nil {
+		sa.buf = append(sa.buf, payload...)
+	}
+
+	return append(rv, sa.extract()...)
+}
+
+func (sa *sectionAssembler) extract() []psiSection {
+	rv := []psiSection{}
+	for len(sa.buf) > 0 {
+		if sa.buf[0] == 0xff {
+			// Stuffing bytes until the end of packet
+			sa.buf = nil
+			break
+		}
+		if len(sa.buf) < 3 {
+			break
+		}
+		sectionLen := 3 + (int(sa.buf[1]&0x0f)<<8 | int(sa.buf[2]))
+		if len(sa.buf) < sectionLen {
+			break
+		}
+		rv = append(rv, psiSection{pktCnt: sa.pktCnt, buf: sa.buf[:sectionLen]})
+		sa.buf = sa.buf[sectionLen:]
+	}
+	if len(sa.buf) == 0 {
+		sa.buf = nil
+	}
+	return rv
+}
+
+func newSectionAssembler() *sectionAssembler {
+	return &sectionAssembler{cc: -1}
+}
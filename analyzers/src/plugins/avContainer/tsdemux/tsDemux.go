@@ -12,6 +12,7 @@ import (
 type IDemuxPipe interface {
 	getDuration() int
 	getOutputUnit() tttKernel.CmUnit
+	printInfo(*strings.Builder)
 	processUnit([]byte, int) error
 	start()
 	stop()
@@ -53,7 +54,7 @@ func (m_pMux *tsDemuxerPlugin) SetParameter(m_parameter string) {
 }
 
 func (m_pMux *tsDemuxerPlugin) SetResource(resourceLoader *tttKernel.ResourceLoader) {
-	m_pMux.control.setResource(resourceLoader)
+	m_pMux.control.setResource(resourceLoader, m_pMux.name)
 }
 
 func (m_pMux *tsDemuxerPlugin) _setup() {
@@ -88,7 +89,8 @@ func (m_pMux *tsDemuxerPlugin) DeliverUnit(inUnit tttKernel.CmUnit, intputId str
 	buf := tttKernel.GetBytesInBuf(inUnit)
 	procErr := m_pMux.impl.processUnit(buf, m_pMux.control.getInputCount())
 	if procErr != nil {
-		m_pMux.logger.Error("At pkt#%d, %s", m_pMux.control.getInputCount(), procErr)
+		tttKernel.Report_error(m_pMux.callback, m_pMux.name, tttKernel.SEVERITY_ERROR,
+			fmt.Sprintf("pkt#%d", m_pMux.control.getInputCount()), procErr)
 	}
 }
 
@@ -97,6 +99,7 @@ func (m_pMux *tsDemuxerPlugin) DeliverStatus(unit tttKernel.CmUnit) {}
 func (m_pMux *tsDemuxerPlugin) PrintInfo(sb *strings.Builder) {
 	sb.WriteString(fmt.Sprintf("\tDuration: %f", float64(m_pMux.impl.getDuration()) / 27000000))
 	m_pMux.control.printInfo(sb)
+	m_pMux.impl.printInfo(sb)
 }
 
 func (m_pMux *tsDemuxerPlugin) Name() string {
@@ -118,3 +121,14 @@ func TsDemuxer(name string) tttKernel.IPlugin {
 	}
 	return &rv
 }
+
+func init() {
+	tttKernel.RegisterPlugin(tttKernel.PluginInfo{
+		Type:        "TsDemuxer",
+		Constructor: TsDemuxer,
+		Description: "Demux MPEG-TS packets into PSI, PES and timing data",
+		Params: []tttKernel.ParamDef{
+			{Name: "Mode", Type: tttKernel.FIELD_STRING, Default: "_DEMUX_DUMMY", Allowed: []string{"_DEMUX_DUMMY", "_DEMUX_FULL", "_DEMUX_PSI"}, Description: "Pass packets through without parsing, demux everything, or demux PSI only"},
+		},
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/tony-507/analyzers/src/logging"
 	"github.com/tony-507/analyzers/src/plugins/avContainer/model"
@@ -19,6 +20,7 @@ type IDemuxCallback interface {
 }
 
 type tsDemuxPipe struct {
+	name            string
 	logger          logging.Log
 	callback        IDemuxCallback
 	control         *demuxController // Controller from demuxer
@@ -28,6 +30,8 @@ type tsDemuxPipe struct {
 	programRecords  map[int]int // PAT
 	streamRecords   map[int]int // Stream pid => stream type
 	streamTree      map[int]int // Stream pid => program number
+	siVersions      map[string]int // SI sub-table => version
+	assemblers      map[int]*sectionAssembler // pid => section assembler
 	patVersion      int
 	pmtVersions     map[int]int     // Program number => version
 	outputQueue     []tttKernel.CmUnit // Outputs to other plugins
@@ -41,11 +45,17 @@ func (m_pMux *tsDemuxPipe) _setup() {
 	m_pMux.dataStructs = make(map[int]model.DataStruct, 0)
 	m_pMux.patVersion = -1
 	m_pMux.pmtVersions = make(map[int]int, 0)
+	m_pMux.siVersions = make(map[string]int, 0)
+	m_pMux.assemblers = make(map[int]*sectionAssembler, 0)
 }
 
 func (m_pMux *tsDemuxPipe) start() {}
 
 func (m_pMux *tsDemuxPipe) stop() {
+	if err := m_pMux.inputMon.writeReport(m_pMux.callback.getOutDir(), m_pMux.name); err != nil {
+		m_pMux.logger.Error("Fail to write TR 101 290 report: %s", err.Error())
+	}
+
 	for fileType, writers := range m_pMux.fileWriters {
 		for pid, writer := range writers {
 			err := writer.Close()
@@ -56,8 +66,20 @@ func (m_pMux *tsDemuxPipe) stop() {
 	}
 }
 
+// A row of <pid>-tspriv.csv. Data may be JSON, so it is quoted in CSV.
+var privateDataSchema = tttKernel.NewSchema("TsPrivateData",
+	tttKernel.Field("pktCnt", tttKernel.FIELD_INT64),
+	tttKernel.Field("tag", tttKernel.FIELD_INT64),
+	tttKernel.Field("length", tttKernel.FIELD_INT64),
+	tttKernel.Field("data", tttKernel.FIELD_STRING),
+)
+
 // Handle incoming data from demuxer
 func (m_pMux *tsDemuxPipe) processUnit(buf []byte, pktCnt int) error {
+	if !m_pMux.inputMon.checkSyncByte(buf, pktCnt) {
+		return nil
+	}
+
 	pkt, tsErr := model.TsPacket(buf)
 
 	if tsErr != nil {
@@ -65,23 +87,32 @@ func (m_pMux *tsDemuxPipe) processUnit(buf []byte, pktCnt int) error {
 	}
 	buf = pkt.GetPayload()
 
-	// If scrambled, throw away
-	if pkt.GetHeader().Tsc != 0 {
-		return errors.New("the packet is scrambled")
-	}
-
 	// Determine the type of the unit
 	pid := pkt.GetHeader().Pid
+	m_pMux.control.packetReceived(pid)
 	pusi := pkt.GetHeader().Pusi
 	afc := pkt.GetHeader().Afc
 	cc := pkt.GetHeader().Cc
+	discontinuity := pkt.HasAdaptationField() && pkt.GetAdaptationField().Discontinuity
+
+	m_pMux.inputMon.checkTsHeader(pid, pkt.GetHeader().Tei, pkt.GetHeader().Tsc, afc, cc, discontinuity, pktCnt)
 
-	m_pMux.inputMon.checkTsHeader(pid, afc, cc, pktCnt)
+	// If scrambled, throw away
+	if pkt.GetHeader().Tsc != 0 {
+		return errors.New("the packet is scrambled")
+	}
+
+	if pusi {
+		m_pMux.inputMon.checkSection(pid, buf, pktCnt)
+	}
 
 	pcr := -1
 
 	if pkt.HasAdaptationField() {
 		pcr = int(pkt.GetAdaptationField().Pcr)
+		if pcr != -1 {
+			m_pMux.inputMon.checkPcr(pid, int64(pcr), discontinuity, pktCnt)
+		}
 
 		spliceCountdown := pkt.GetAdaptationField().SpliceCountdown
 		if spliceCountdown != -1 {
@@ -104,20 +135,28 @@ func (m_pMux *tsDemuxPipe) processUnit(buf []byte, pktCnt int) error {
 			}
 			writer := m_pMux.fileWriters["tspriv"][pid]
 			for _, pd := range privateData {
-				buf := tttKernel.MakeSimpleBuf([]byte{})
-				buf.SetField("pktCnt", pktCnt, false)
-				buf.SetField("tag", pd.Tag, false)
-				buf.SetField("length", pd.Length, false)
-				buf.SetField("data", pd.Data, false)
+				buf := tttKernel.MakeSchemaBuf(privateDataSchema, []byte{})
+				if err := buf.SetField("pktCnt", pktCnt, false); err != nil {
+					return err
+				}
+				if err := buf.SetField("tag", pd.Tag, false); err != nil {
+					return err
+				}
+				if err := buf.SetField("length", pd.Length, false); err != nil {
+					return err
+				}
+				if err := buf.SetField("data", pd.Data, false); err != nil {
+					return err
+				}
 				writer.Write(buf)
 			}
 		}
 	}
 
 	switch pid {
-	case 0:
-		// PAT
-		err := m_pMux.handleData(buf, pid, pusi, pktCnt, -1, -1, pcr)
+	case 0, 1, 0x10, 0x11, 0x12, 0x14:
+		// PAT, CAT and DVB SI
+		err := m_pMux.handleData(buf, pid, pusi, cc, pktCnt, -1, -1, pcr)
 		if err != nil {
 			return err
 		}
@@ -138,7 +177,7 @@ func (m_pMux *tsDemuxPipe) processUnit(buf []byte, pktCnt int) error {
 		}
 		if hasKey {
 			// PMT
-			err := m_pMux.handleData(buf, pid, pusi, pktCnt, -1, -1, pcr)
+			err := m_pMux.handleData(buf, pid, pusi, cc, pktCnt, -1, -1, pcr)
 			if err != nil {
 				return err
 			}
@@ -149,7 +188,7 @@ func (m_pMux *tsDemuxPipe) processUnit(buf []byte, pktCnt int) error {
 
 			// Contained in PMT, continue the parsing
 			if isKnownStream {
-				err := m_pMux.handleData(buf, pid, pusi, pktCnt, progNum, streamType, pcr)
+				err := m_pMux.handleData(buf, pid, pusi, cc, pktCnt, progNum, streamType, pcr)
 				if err != nil {
 					return err
 				}
@@ -180,7 +219,7 @@ func (m_pMux *tsDemuxPipe) PsiUpdateFinished(pid int, version int, jsonBytes []b
 	writer.Close()
 }
 
-func (m_pMux *tsDemuxPipe) SpliceEventReceived(dpiPid int, spliceCmdTypeStr string, splicePTS []int, pktCnt int) {
+func (m_pMux *tsDemuxPipe) SpliceEventReceived(dpiPid int, spliceCmdTypeStr string, splicePTS []int, descriptors []model.Splice_descriptor, pktCnt int) {
 	if spliceCmdTypeStr == "splice_null" {
 		return
 	}
@@ -190,6 +229,19 @@ func (m_pMux *tsDemuxPipe) SpliceEventReceived(dpiPid int, spliceCmdTypeStr stri
 	buf.SetField("pid", dpiPid, false)
 	buf.SetField("streamType", 134, true)
 
+	// Only the first segmentation descriptor is summarized, the rest can be found in the JSON
+	segmentationTypeId := int64(-1)
+	upid := ""
+	for _, descriptor := range descriptors {
+		if seg, ok := descriptor.Content.(model.Segmentation_descriptor); ok {
+			segmentationTypeId = int64(seg.SegmentationTypeId)
+			upid = seg.Upid.Upid
+			m_pMux.logger.Info("Segmentation descriptor at #%d: event %d, %s, UPID %s %s",
+				pktCnt, seg.EventId, seg.SegmentationTypeStr, seg.Upid.UpidTypeStr, seg.Upid.Upid)
+			break
+		}
+	}
+
 	progNum := m_pMux.streamTree[dpiPid]
 	if curVideoPlayTime, ok := m_pMux.videoPlayTime[progNum]; ok {
 		for _, spliceTime := range splicePTS {
@@ -202,6 +254,8 @@ func (m_pMux *tsDemuxPipe) SpliceEventReceived(dpiPid int, spliceCmdTypeStr stri
 				}
 			}
 			data := common.NewScte35Data(int64(curVideoPlayTime), int64(spliceTime), int64(preroll))
+			data.SegmentationTypeId = segmentationTypeId
+			data.Upid = upid
 			unit := common.NewMediaUnit(buf, common.DATA_UNIT)
 			unit.Data = &data
 			m_pMux.outputQueue = append(m_pMux.outputQueue, unit)
@@ -211,6 +265,18 @@ func (m_pMux *tsDemuxPipe) SpliceEventReceived(dpiPid int, spliceCmdTypeStr stri
 	}
 }
 
+func (m_pMux *tsDemuxPipe) StreamTimeReceived(utc time.Time, pktCnt int) {
+	m_pMux.control.streamTimeReceived(utc, pktCnt)
+}
+
+func (m_pMux *tsDemuxPipe) UpdateSiVersion(key string, version int) bool {
+	if oldVersion, hasKey := m_pMux.siVersions[key]; hasKey && oldVersion == version {
+		return false
+	}
+	m_pMux.siVersions[key] = version
+	return true
+}
+
 func (m_pMux *tsDemuxPipe) GetPATVersion() int {
 	return m_pMux.patVersion
 }
@@ -229,6 +295,7 @@ func (m_pMux *tsDemuxPipe) AddProgram(version int, progNum int, pmtPid int) {
 	}
 	m_pMux.logger.Info("New program added: %d => %d", progNum, pmtPid)
 	m_pMux.programRecords[progNum] = pmtPid
+	m_pMux.inputMon.addPmtPid(pmtPid)
 
 	m_pMux.patVersion = version
 }
@@ -261,10 +328,13 @@ func (m_pMux *tsDemuxPipe) AddStream(version int, progNum int, streamPid int, st
 
 	m_pMux.streamRecords[streamPid] = streamType
 	m_pMux.streamTree[streamPid] = progNum
+	m_pMux.inputMon.addEsPid(streamPid)
 }
 
-func (m_pMux *tsDemuxPipe) PesPacketReady(buf tttKernel.CmBuf, pid int) {
-	buf.SetField("pid", pid, true)
+func (m_pMux *tsDemuxPipe) PesPacketReady(buf tttKernel.CmBuf, pid int) error {
+	if err := buf.SetField("pid", pid, true); err != nil {
+		return err
+	}
 
 	if progNum, ok := tttKernel.GetBufFieldAsInt(buf, "progNum"); ok {
 		// Stamp PCR here
@@ -273,13 +343,18 @@ func (m_pMux *tsDemuxPipe) PesPacketReady(buf tttKernel.CmBuf, pid int) {
 		if curCnt, ok := tttKernel.GetBufFieldAsInt(buf, "pktCnt"); ok {
 			pid, _ := tttKernel.GetBufFieldAsInt(buf, "pid")
 			pcr, _ := clk.requestPcr(pid, curCnt)
-			buf.SetField("pcr", pcr, false)
+			if err := buf.SetField("pcr", pcr, false); err != nil {
+				return err
+			}
 			if dts, ok := tttKernel.GetBufFieldAsInt(buf, "dts"); ok {
-				buf.SetField("delay", dts-pcr/300, false)
+				if err := buf.SetField("delay", dts-pcr/300, false); err != nil {
+					return err
+				}
 			}
 
 			if pts, ok := tttKernel.GetBufFieldAsInt(buf, "pts"); ok {
 				m_pMux.videoPlayTime[progNum] = pts
+				m_pMux.inputMon.checkPts(pid, curCnt)
 			}
 
 			// Write output
@@ -320,6 +395,7 @@ func (m_pMux *tsDemuxPipe) PesPacketReady(buf tttKernel.CmBuf, pid int) {
 	m_pMux.outputQueue = append(m_pMux.outputQueue, outUnit)
 	m_pMux.control.outputUnitAdded()
 	m_pMux.callback.outputReady()
+	return nil
 }
 
 func (m_pMux *tsDemuxPipe) GetPmtPidByProgNum(progNum int) int {
@@ -329,12 +405,24 @@ func (m_pMux *tsDemuxPipe) GetPmtPidByProgNum(progNum int) int {
 	return -1
 }
 
-func (m_pMux *tsDemuxPipe) handleData(buf []byte, pid int, pusi bool, pktCnt int, progNum int, streamType int, pcr int) error {
+func (m_pMux *tsDemuxPipe) handleData(buf []byte, pid int, pusi bool, cc int, pktCnt int, progNum int, streamType int, pcr int) error {
 	if pcr >= 0 {
 		clk := m_pMux.control.updateSrcClk(progNum)
 		clk.updatePcrRecord(pcr, pktCnt)
 	}
 
+	switch m_pMux._getPktType(pid) {
+	case PAT, PMT, CAT, NIT, SDT, EIT, TDT:
+		m_pMux.control.dataParsed(pid)
+		return m_pMux.handleSections(buf, pid, pusi, cc, pktCnt)
+	case DATA:
+		m_pMux.control.dataParsed(pid)
+		if isSectionStream(streamType) {
+			return m_pMux.handleSections(buf, pid, pusi, cc, pktCnt)
+		}
+		return nil
+	}
+
 	dataProcessed := true
 
 	if pusi {
@@ -348,12 +436,6 @@ func (m_pMux *tsDemuxPipe) handleData(buf []byte, pid int, pusi bool, pktCnt int
 		var ds model.DataStruct
 		var err error
 		switch m_pMux._getPktType(pid) {
-		case PAT:
-			fallthrough
-		case PMT:
-			fallthrough
-		case DATA:
-			ds, err = model.PsiTable(m_pMux, pktCnt, pid, buf)
 		case VIDEO:
 			fallthrough
 		case AUDIO:
@@ -393,10 +475,65 @@ func (m_pMux *tsDemuxPipe) handleData(buf []byte, pid int, pusi bool, pktCnt int
 	return nil
 }
 
+// Collect sections of PSI and other sectioned data, and parse them after CRC32 verification
+func (m_pMux *tsDemuxPipe) handleSections(buf []byte, pid int, pusi bool, cc int, pktCnt int) error {
+	assembler, hasKey := m_pMux.assemblers[pid]
+	if !hasKey {
+		assembler = newSectionAssembler()
+		m_pMux.assemblers[pid] = assembler
+	}
+
+	errMsgs := []string{}
+	for _, section := range assembler.feed(buf, pusi, cc, pktCnt) {
+		tableId := int(section.buf[0])
+		if tableId == 0x4a || tableId == 0x72 {
+			// BAT and stuffing tables are not parsed
+			continue
+		}
+		if !m_pMux.inputMon.checkCrc(pid, section.buf, section.pktCnt) {
+			errMsgs = append(errMsgs, fmt.Sprintf("CRC32 mismatch in section with table id %d on pid %d", tableId, pid))
+			continue
+		}
+		ds, err := model.PsiTable(m_pMux, section.pktCnt, pid, append([]byte{0}, section.buf...))
+		if err == nil {
+			err = ds.Process()
+		}
+		if err != nil {
+			errMsgs = append(errMsgs, err.Error())
+		}
+	}
+
+	if len(errMsgs) != 0 {
+		return errors.New(strings.Join(errMsgs, "; "))
+	}
+	return nil
+}
+
+// Stream types carried in sections instead of PES
+func isSectionStream(streamType int) bool {
+	switch streamType {
+	case 0x05, 0x0b, 0x0c, 0x0d, 0x86:
+		return true
+	default:
+		return false
+	}
+}
+
 // Return type of packets
 func (m_pMux *tsDemuxPipe) _getPktType(pid int) PKT_TYPE {
-	if pid == 0 {
+	switch pid {
+	case 0:
 		return PAT
+	case 1:
+		return CAT
+	case 0x10:
+		return NIT
+	case 0x11:
+		return SDT
+	case 0x12:
+		return EIT
+	case 0x14:
+		return TDT
 	}
 
 	// Check if PMT pid
@@ -436,6 +573,10 @@ func (m_pMux *tsDemuxPipe) getDuration() int {
 	return end - start
 }
 
+func (m_pMux *tsDemuxPipe) printInfo(sb *strings.Builder) {
+	m_pMux.inputMon.printInfo(sb)
+}
+
 func (m_pMux *tsDemuxPipe) getOutputUnit() tttKernel.CmUnit {
 	outUnit := m_pMux.outputQueue[0]
 	if len(m_pMux.outputQueue) == 1 {
@@ -448,6 +589,7 @@ func (m_pMux *tsDemuxPipe) getOutputUnit() tttKernel.CmUnit {
 
 func getDemuxPipe(callback IDemuxCallback, control *demuxController, name string) tsDemuxPipe {
 	rv := tsDemuxPipe{
+		name: name,
 		callback: callback,
 		control: control,
 		fileWriters: map[string]map[int]io.FileWriter{
@@ -458,6 +600,7 @@ func getDemuxPipe(callback IDemuxCallback, control *demuxController, name string
 		inputMon: setupInputMonitor(),
 		videoPlayTime: map[int]int{},
 	}
+	rv.inputMon.onRaise = control.indicatorRaised
 	rv._setup()
 	return rv
 }
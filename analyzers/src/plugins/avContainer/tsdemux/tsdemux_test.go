@@ -1,6 +1,8 @@
 package tsdemux
 
 import (
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -94,11 +96,11 @@ func TestMultipleTsPacketPsi(t *testing.T) {
 	impl.programRecords[10] = 480
 	impl.processUnit(pmt1, 0)
 
-	assert.Equal(t, true, impl.dataStructs[480] != nil, "non-terminating PMT should be stored")
+	assert.Equal(t, true, impl.assemblers[480].buf != nil, "non-terminating PMT should be stored")
 
 	impl.processUnit(pmt2, 1)
 
-	assert.Equal(t, true, impl.dataStructs[480] == nil, "PMT should be null now")
+	assert.Equal(t, true, impl.assemblers[480].buf == nil, "PMT should be null now")
 
 	expected := make(map[int]int, 0)
 	expected[32] = 27
@@ -132,7 +134,7 @@ func TestPesParsedOnceReady(t *testing.T) {
 	control := getControl()
 	dc := dummyCallback{}
 	r := tttKernel.CreateResourceLoader()
-	control.setResource(&r)
+	control.setResource(&r, "Dummy")
 	impl := getDemuxPipe(&dc, control, "Dummy")
 
 	impl.programRecords[10] = 480
@@ -143,4 +145,120 @@ func TestPesParsedOnceReady(t *testing.T) {
 
 	impl.processUnit(pkt2, 2)
 	assert.Equal(t, true, impl.dataStructs[32] == nil, "PES packet should be parsed")
+
+	var sb strings.Builder
+	r.Metrics().Write(&sb)
+	assert.Contains(t, sb.String(), "ttt_ts_packets_total{plugin=\"Dummy\",pid=\"32\"} 2\n")
+}
+
+func TestInputMonitorTr101290(t *testing.T) {
+	mon := setupInputMonitor()
+	mon.addEsPid(32)
+
+	// Sync
+	mon.checkSyncByte([]byte{0x00}, 1)
+	mon.checkSyncByte([]byte{0x00}, 2)
+	assert.Equal(t, 2, mon.indicators[_SYNC_BYTE_ERROR].Count, "Sync byte error count not match")
+	assert.Equal(t, 1, mon.indicators[_TS_SYNC_LOSS].Count, "Sync loss count not match")
+
+	// CC, with one duplicate packet allowed
+	mon.checkTsHeader(32, false, 0, 1, 0, false, 3)
+	mon.checkTsHeader(32, false, 0, 1, 0, false, 4)
+	assert.Equal(t, 0, mon.indicators[_CC_ERROR].Count, "Duplicate packet should not be a CC error")
+	mon.checkTsHeader(32, false, 0, 1, 0, false, 5)
+	assert.Equal(t, 1, mon.indicators[_CC_ERROR].Count, "CC error count not match")
+	mon.checkTsHeader(32, true, 0, 1, 1, false, 6)
+	assert.Equal(t, 1, mon.indicators[_TRANSPORT_ERROR].Count, "Transport error count not match")
+
+	// CRC
+	goodPAT := []byte{0x00, 0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x0a, 0xe1, 0x02,
+		0xed, 0xd7, 0xf4, 0xa2}
+	badPAT := append(append([]byte{}, goodPAT[:16]...), 0x00)
+	assert.Equal(t, true, mon.checkCrc(0, goodPAT[1:], 7), "PAT with correct CRC should pass")
+	assert.Equal(t, false, mon.checkCrc(0, badPAT[1:], 8), "PAT with wrong CRC should fail")
+	assert.Equal(t, 1, mon.indicators[_CRC_ERROR].Count, "CRC error count not match")
+
+	// PCR, 4ms per packet
+	mon.checkPcr(100, 0, false, 10)
+	mon.checkPcr(100, 1080000, false, 20)
+	mon.checkPcr(100, 2161000, false, 30)
+	assert.Equal(t, 1, mon.indicators[_PCR_ACCURACY_ERROR].Count, "PCR accuracy error count not match")
+	mon.checkPcr(100, 2161000+150*_TICKS_PER_MS, false, 40)
+	assert.Equal(t, 1, mon.indicators[_PCR_DISCONTINUITY_ERROR].Count, "PCR discontinuity error count not match")
+	mon.checkPcr(100, 8000000, true, 50)
+	assert.Equal(t, 1, mon.indicators[_PCR_DISCONTINUITY_ERROR].Count, "PCR jump with discontinuity_indicator is allowed")
+	assert.Equal(t, 0, mon.indicators[_PCR_REPETITION_ERROR].Count, "PCR repetition error count not match")
+
+	// PAT repetition
+	mon.checkTsHeader(32, false, 0, 1, 2, false, 60)
+	mon.checkTsHeader(32, false, 0, 1, 3, false, 300)
+	assert.Equal(t, 1, mon.indicators[_PAT_ERROR].Count, "PAT error count not match")
+	assert.Equal(t, 0, mon.indicators[_UNREFERENCED_PID].Count, "Pid 32 is referenced")
+
+	// Reports of demuxers sharing an output directory are kept apart
+	outDir := t.TempDir()
+	assert.Nil(t, mon.writeReport(outDir, "TsDemuxer_1"))
+	other := setupInputMonitor()
+	assert.Nil(t, other.writeReport(outDir, "TsDemuxer_2"))
+	report, err := os.ReadFile(outDir + "/TsDemuxer_1-tr101290.json")
+	assert.Nil(t, err)
+	assert.Contains(t, string(report), "\"Count\": 1")
+	_, err = os.Stat(outDir + "/TsDemuxer_2-tr101290.json")
+	assert.Nil(t, err)
+}
+
+func TestSectionAssembler(t *testing.T) {
+	pat := []byte{0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x0a, 0xe1, 0x02,
+		0xed, 0xd7, 0xf4, 0xa2}
+	sa := newSectionAssembler()
+
+	// Two sections in one packet followed by stuffing
+	payload := append([]byte{0x00}, pat...)
+	payload = append(payload, pat...)
+	payload = append(payload, 0xff, 0xff)
+	sections := sa.feed(payload, true, 0, 1)
+	assert.Equal(t, 2, len(sections), "Two sections should be extracted")
+	assert.Equal(t, pat, sections[1].buf, "Section content not match")
+	assert.Equal(t, true, sa.buf == nil, "Stuffing should be discarded")
+
+	// Section across packets, with the next section pointed by pointer_field
+	sections = sa.feed(append([]byte{0x00}, pat[:10]...), true, 1, 2)
+	assert.Equal(t, 0, len(sections), "Incomplete section should not be extracted")
+	sections = sa.feed(append(append([]byte{0x06}, pat[10:]...), pat[:5]...), true, 2, 3)
+	assert.Equal(t, 1, len(sections), "Section across packets should be extracted")
+	assert.Equal(t, 2, sections[0].pktCnt, "Section should start at the first packet")
+	assert.Equal(t, pat, sections[0].buf, "Section content not match")
+
+	// CC error drops the pending section
+	sections = sa.feed(pat[5:], false, 4, 4)
+	assert.Equal(t, 0, len(sections), "Section with missing packet should be dropped")
+}
+
+func TestCorruptedPmtReported(t *testing.T) {
+	dummyPMT := []byte{0x47, 0x41, 0x02, 0x14, 0x00, 0x02, 0xb0, 0x1d, 0x00, 0x0a, 0xc1,
+		0x00, 0x00, 0xe0, 0x20, 0xf0, 0x00, 0x02, 0xe0, 0x20,
+		0xf0, 0x00, 0x04, 0xe0, 0x21, 0xf0, 0x06, 0x0a, 0x04,
+		0x65, 0x6e, 0x67, 0x00, 0x75, 0xff, 0x59, 0x3b}
+
+	control := getControl()
+	dc := dummyCallback{}
+	impl := getDemuxPipe(&dc, control, "Dummy")
+	impl.programRecords[10] = 258
+	impl.inputMon.addPmtPid(258)
+
+	err := impl.processUnit(dummyPMT, 0)
+	assert.Equal(t, true, err != nil, "Corrupted PMT should be reported")
+	assert.Equal(t, 0, len(impl.streamRecords), "Corrupted PMT should not be parsed")
+	assert.Equal(t, 1, impl.inputMon.indicators[_CRC_ERROR].Count, "CRC error count not match")
+}
+
+func TestDemuxModes(t *testing.T) {
+	info, ok := tttKernel.GetPluginInfo("TsDemuxer_1")
+	assert.Equal(t, true, ok)
+	for _, mode := range []string{"_DEMUX_DUMMY", "_DEMUX_FULL", "_DEMUX_PSI"} {
+		assert.Nil(t, info.ValidateField("Mode", "\""+mode+"\""), mode)
+		var param demuxParams
+		assert.Nil(t, param.Mode.UnmarshalJSON([]byte("\""+mode+"\"")), mode)
+	}
+	assert.NotNil(t, info.ValidateField("Mode", "\"_DEMUX_ES\""))
 }
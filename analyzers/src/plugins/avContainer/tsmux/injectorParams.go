@@ -0,0 +1,43 @@
+package tsmux
+
+import "encoding/json"
+
+type injectorCueParam struct {
+	Pts    int             // Inject before the first PES of PtsPid with PTS at or after this value
+	PktCnt int             // Inject before the input packet with this index, counting from 0
+	File   string          // JSON file describing the cue
+	Cue    json.RawMessage // Inline cue, used if File is empty
+}
+
+type scte35InjectorParams struct {
+	OutFile string             // Output file name under outDir
+	Pid     int                // PID carrying the injected SCTE-35 sections
+	PtsPid  int                // PID whose PES PTS triggers PTS-based injection
+	PmtPid  int                // PMT to announce Pid in. The PMT is left untouched if -1
+	Cues    []injectorCueParam // Cues to be injected
+}
+
+func defaultScte35InjectorParams() scte35InjectorParams {
+	return scte35InjectorParams{
+		OutFile: "inject.ts",
+		Pid:     0x1f0,
+		PtsPid:  -1,
+		PmtPid:  -1,
+		Cues:    []injectorCueParam{},
+	}
+}
+
+func defaultInjectorCueParam() injectorCueParam {
+	return injectorCueParam{Pts: -1, PktCnt: -1}
+}
+
+// Fill in defaults for fields absent in JSON
+func (c *injectorCueParam) UnmarshalJSON(data []byte) error {
+	type rawCueParam injectorCueParam
+	raw := rawCueParam(defaultInjectorCueParam())
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*c = injectorCueParam(raw)
+	return nil
+}
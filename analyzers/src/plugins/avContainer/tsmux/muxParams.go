@@ -0,0 +1,29 @@
+package tsmux
+
+type muxStreamParam struct {
+	InPid      int // PID of the stream from upstream
+	OutPid     int // PID in the output stream, default to InPid
+	StreamType int // Override stream type in PMT, default to the upstream one
+}
+
+type muxParams struct {
+	OutFile     string           // Output file name under outDir
+	TsId        int              // transport_stream_id in PAT
+	ProgNum     int              // Program number of the output program
+	PmtPid      int              // PID of the PMT
+	PcrPid      int              // PID carrying PCR, default to the first video stream
+	PsiInterval int              // Number of output packets between PAT/PMT repetitions
+	Streams     []muxStreamParam // Streams to be muxed. All streams are muxed if empty
+}
+
+func defaultMuxParams() muxParams {
+	return muxParams{
+		OutFile:     "mux.ts",
+		TsId:        1,
+		ProgNum:     1,
+		PmtPid:      0x1000,
+		PcrPid:      -1,
+		PsiInterval: 500,
+		Streams:     []muxStreamParam{},
+	}
+}
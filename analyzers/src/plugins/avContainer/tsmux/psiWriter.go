@@ -0,0 +1,123 @@
+package tsmux
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+// Generation of PSI sections for the muxer
+
+type esInfo struct {
+	pid        int
+	streamType int
+}
+
+// Write section header up to last_section_number and return the writer
+func writeLongSectionHeader(w *io.BsWriter, tableId int, sectionLen int, tableIdExt int, version int) {
+	w.WriteByte(tableId)
+	w.Write(1, 1) // section_syntax_indicator
+	w.Write(0, 1)
+	w.Write(3, 2)
+	w.Write(sectionLen, 12)
+	w.WriteShort(tableIdExt)
+	w.Write(3, 2)
+	w.Write(version%32, 5)
+	w.Write(1, 1)  // current_next_indicator
+	w.WriteByte(0) // section_number
+	w.WriteByte(0) // last_section_number
+}
+
+func appendCrc32(section []byte) []byte {
+	crc := io.Crc32Mpeg2(section[:len(section)-4])
+	section[len(section)-4] = byte(crc >> 24)
+	section[len(section)-3] = byte(crc >> 16)
+	section[len(section)-2] = byte(crc >> 8)
+	section[len(section)-1] = byte(crc)
+	return section
+}
+
+// PAT section with a single program
+func buildPatSection(tsId int, version int, progNum int, pmtPid int) []byte {
+	// 5 bytes after section_length, 4 bytes per program, 4 bytes CRC
+	sectionLen := 5 + 4 + 4
+	w := io.GetBufferWriter(3 + sectionLen)
+	writeLongSectionHeader(&w, 0, sectionLen, tsId, version)
+	w.WriteShort(progNum)
+	w.Write(7, 3)
+	w.Write(pmtPid, 13)
+	return appendCrc32(w.GetBuf())
+}
+
+func buildPmtSection(progNum int, version int, pcrPid int, streams []esInfo) []byte {
+	sorted := append([]esInfo{}, streams...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].pid < sorted[j].pid })
+
+	sectionLen := 5 + 4 + 5*len(sorted) + 4
+	w := io.GetBufferWriter(3 + sectionLen)
+	writeLongSectionHeader(&w, 2, sectionLen, progNum, version)
+	w.Write(7, 3)
+	w.Write(pcrPid, 13)
+	w.Write(15, 4)
+	w.Write(0, 2)
+	w.Write(0, 10) // program_info_length
+	for _, es := range sorted {
+		w.WriteByte(es.streamType)
+		w.Write(7, 3)
+		w.Write(es.pid, 13)
+		w.Write(15, 4)
+		w.Write(0, 2)
+		w.Write(0, 10) // ES_info_length
+	}
+	return appendCrc32(w.GetBuf())
+}
+
+// Add a stream to an existing PMT section, with a registration descriptor in the program loop.
+// version_number is incremented if the section changes.
+func addPmtStream(section []byte, pid int, streamType int, formatId string) ([]byte, error) {
+	if len(section) < 16 || section[0] != 2 {
+		return nil, errors.New("Not a PMT section")
+	}
+	sectionLen := int(section[1]&0x0f)<<8 | int(section[2])
+	if len(section) < 3+sectionLen || sectionLen < 13 {
+		return nil, errors.New(fmt.Sprintf("PMT section length %d exceeds buffer size %d", sectionLen, len(section)))
+	}
+	body := section[:(3 + sectionLen - 4)]
+	progInfoLen := int(section[10]&0x0f)<<8 | int(section[11])
+	if 12+progInfoLen > len(body) {
+		return nil, errors.New(fmt.Sprintf("PMT program_info_length %d exceeds section", progInfoLen))
+	}
+
+	for pos := 12 + progInfoLen; pos+5 <= len(body); {
+		if int(body[pos+1]&0x1f)<<8|int(body[pos+2]) == pid {
+			// Already announced
+			return section, nil
+		}
+		pos += 5 + (int(body[pos+3]&0x0f)<<8 | int(body[pos+4]))
+	}
+
+	progInfo := body[12:(12 + progInfoLen)]
+	regDesc := append([]byte{0x05, byte(len(formatId))}, []byte(formatId)...)
+	if bytes.Contains(progInfo, regDesc) {
+		regDesc = []byte{}
+	}
+	progInfoLen += len(regDesc)
+
+	rv := append([]byte{}, body[:10]...)
+	rv = append(rv, byte(0xf0|progInfoLen>>8), byte(progInfoLen))
+	rv = append(rv, progInfo...)
+	rv = append(rv, regDesc...)
+	rv = append(rv, body[(12+len(progInfo)):]...)
+	rv = append(rv, byte(streamType), byte(0xe0|pid>>8), byte(pid), 0xf0, 0x00)
+	rv = append(rv, 0, 0, 0, 0)
+
+	sectionLen = len(rv) - 3
+	rv[1] = (rv[1] & 0xf0) | byte(sectionLen>>8)
+	rv[2] = byte(sectionLen)
+	version := (int(rv[5]>>1&0x1f) + 1) % 32
+	rv[5] = (rv[5] & 0xc1) | byte(version<<1)
+	return appendCrc32(rv), nil
+}
@@ -0,0 +1,334 @@
+package tsmux
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tony-507/analyzers/src/logging"
+	"github.com/tony-507/analyzers/src/plugins/avContainer/model"
+	"github.com/tony-507/analyzers/src/plugins/common"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+/*
+ * The injector takes a transport stream from upstream and inserts SCTE-35
+ * sections on a chosen pid. A cue is triggered either by an input packet
+ * index or by the PTS of a PES packet on a reference pid, and its sections
+ * are placed right before the triggering packet. Other packets are passed
+ * through untouched, except PMT which may be rewritten to announce the cue pid.
+ * Only a PMT carried in a single packet is rewritten.
+ */
+
+const _PTS_WRAP int = 1 << 33
+
+type injectorCue struct {
+	pts      int
+	pktCnt   int
+	section  []byte
+	injected bool
+}
+
+type injectorStat struct {
+	inPktCnt    int
+	outPktCnt   int
+	injectCnt   int
+	pmtCnt      int
+	pmtErrCnt   int // PMTs not rewritten
+	conflictCnt int
+}
+
+type scte35InjectorPlugin struct {
+	logger      logging.Log
+	callback    tttKernel.RequestHandler
+	loader      *tttKernel.ResourceLoader
+	name        string
+	param       scte35InjectorParams
+	packetizer  tsPacketizer
+	cues        []injectorCue
+	residual    []byte
+	writer      io.FileWriter
+	outputQueue []tttKernel.CmUnit
+	stat        injectorStat
+}
+
+func (ij *scte35InjectorPlugin) SetCallback(callback tttKernel.RequestHandler) {
+	ij.callback = callback
+}
+
+func (ij *scte35InjectorPlugin) SetParameter(m_parameter string) {
+	param := defaultScte35InjectorParams()
+	if err := json.Unmarshal([]byte(m_parameter), &param); err != nil {
+		panic(err)
+	}
+	ij.param = param
+
+	for idx, cueParam := range param.Cues {
+		cue, err := buildInjectorCue(cueParam)
+		if err != nil {
+			panic(fmt.Sprintf("Cue #%d: %s", idx, err.Error()))
+		}
+		ij.cues = append(ij.cues, cue)
+	}
+	ij.logger.Info("Injector created with %d cues on pid %d", len(ij.cues), param.Pid)
+}
+
+func (ij *scte35InjectorPlugin) SetResource(loader *tttKernel.ResourceLoader) {
+	ij.loader = loader
+}
+
+func (ij *scte35InjectorPlugin) StartSequence() {
+	outDir := ij.loader.Query("outDir", nil)
+	os.Remove(path.Join(outDir, ij.param.OutFile))
+
+	ij.writer = io.RawWriter(outDir, ij.param.OutFile)
+	if err := ij.writer.Open(); err != nil {
+		ij.logger.Warn("Fail to open %s for writing: %s", ij.param.OutFile, err.Error())
+	}
+}
+
+func (ij *scte35InjectorPlugin) EndSequence() {
+	for _, cue := range ij.cues {
+		if !cue.injected {
+			ij.logger.Warn("Cue with PTS %d and packet count %d is not injected", cue.pts, cue.pktCnt)
+		}
+	}
+	ij.logger.Info("Injector stopped with %d cues injected", ij.stat.injectCnt)
+	if ij.writer != nil {
+		if err := ij.writer.Close(); err != nil {
+			ij.logger.Error("Fail to close %s: %s", ij.param.OutFile, err.Error())
+		}
+	}
+	eosUnit := tttKernel.MakeReqUnit(ij.name, tttKernel.EOS_REQUEST)
+	tttKernel.Post_request(ij.callback, ij.name, eosUnit)
+}
+
+func (ij *scte35InjectorPlugin) DeliverUnit(unit tttKernel.CmUnit, inputId string) {
+	if unit == nil {
+		return
+	}
+	buf := append(ij.residual, tttKernel.GetBytesInBuf(unit)...)
+
+	pkts := [][]byte{}
+	pos := 0
+	for ; pos+_TS_PKT_SIZE <= len(buf); pos += _TS_PKT_SIZE {
+		pkts = append(pkts, ij.processPacket(buf[pos:(pos+_TS_PKT_SIZE)])...)
+	}
+	ij.residual = append([]byte{}, buf[pos:]...)
+
+	ij.output(pkts)
+}
+
+func (ij *scte35InjectorPlugin) DeliverStatus(unit tttKernel.CmUnit) {}
+
+func (ij *scte35InjectorPlugin) FetchUnit() tttKernel.CmUnit {
+	if len(ij.outputQueue) == 0 {
+		return nil
+	}
+	rv := ij.outputQueue[0]
+	if len(ij.outputQueue) == 1 {
+		ij.outputQueue = make([]tttKernel.CmUnit, 0)
+	} else {
+		ij.outputQueue = ij.outputQueue[1:]
+	}
+	return rv
+}
+
+func (ij *scte35InjectorPlugin) PrintInfo(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\tInput packet count: %d\n", ij.stat.inPktCnt))
+	sb.WriteString(fmt.Sprintf("\tOutput packet count: %d\n", ij.stat.outPktCnt))
+	sb.WriteString(fmt.Sprintf("\tInjected cues: %d/%d\n", ij.stat.injectCnt, len(ij.cues)))
+	sb.WriteString(fmt.Sprintf("\tRewritten PMT count: %d\n", ij.stat.pmtCnt))
+	if ij.stat.pmtErrCnt != 0 {
+		sb.WriteString(fmt.Sprintf("\tPMT not rewritten: %d\n", ij.stat.pmtErrCnt))
+	}
+	if ij.stat.conflictCnt != 0 {
+		sb.WriteString(fmt.Sprintf("\tInput packets on pid %d: %d\n", ij.param.Pid, ij.stat.conflictCnt))
+	}
+}
+
+func (ij *scte35InjectorPlugin) Name() string {
+	return ij.name
+}
+
+// Return the packets to be output in place of an input packet
+func (ij *scte35InjectorPlugin) processPacket(buf []byte) [][]byte {
+	pktCnt := ij.stat.inPktCnt
+	ij.stat.inPktCnt++
+
+	pkt, err := model.TsPacket(buf)
+	if err != nil {
+		ij.logger.Warn("Packet #%d: %s", pktCnt, err.Error())
+		return [][]byte{buf}
+	}
+	header := pkt.GetHeader()
+
+	pts := -1
+	if header.Pid == ij.param.PtsPid && header.Pusi {
+		pts = readPesPts(pkt.GetPayload())
+	}
+
+	rv := [][]byte{}
+	for idx := range ij.cues {
+		cue := &ij.cues[idx]
+		if cue.injected {
+			continue
+		}
+		if (cue.pktCnt >= 0 && pktCnt >= cue.pktCnt) || (cue.pts >= 0 && pts >= 0 && isPtsReached(pts, cue.pts)) {
+			ij.logger.Info("Inject cue before packet #%d with PTS %d", pktCnt, pts)
+			rv = append(rv, ij.packetizer.packetizeSection(ij.param.Pid, cue.section)...)
+			cue.injected = true
+			ij.stat.injectCnt++
+		}
+	}
+
+	switch header.Pid {
+	case ij.param.Pid:
+		if ij.stat.conflictCnt == 0 {
+			ij.logger.Warn("Input stream already carries pid %d", ij.param.Pid)
+		}
+		ij.stat.conflictCnt++
+	case ij.param.PmtPid:
+		buf = ij.rewritePmt(buf, pkt.GetPayload(), header.Pusi)
+	}
+
+	return append(rv, buf)
+}
+
+// Announce the cue pid in a PMT carried in a single packet
+func (ij *scte35InjectorPlugin) rewritePmt(buf []byte, payload []byte, pusi bool) []byte {
+	if !pusi {
+		// Rest of a PMT spanning packets, reported at its first packet
+		return buf
+	}
+	if len(payload) == 0 || payload[0] != 0 {
+		ij.pmtNotRewritten("PMT not starting at the beginning of payload")
+		return buf
+	}
+	if section := payload[1:]; len(section) >= 3 && 3+(int(section[1]&0x0f)<<8|int(section[2])) > len(section) {
+		ij.pmtNotRewritten("PMT spanning more than one packet")
+		return buf
+	}
+	section, err := addPmtStream(payload[1:], ij.param.Pid, 0x86, "CUEI")
+	if err != nil {
+		ij.pmtNotRewritten(err.Error())
+		return buf
+	}
+
+	headerLen := _TS_PKT_SIZE - len(payload)
+	if headerLen+1+len(section) > _TS_PKT_SIZE {
+		ij.pmtNotRewritten("Rewritten PMT does not fit in a packet")
+		return buf
+	}
+	rv := make([]byte, _TS_PKT_SIZE)
+	copy(rv, buf[:headerLen])
+	n := copy(rv[(headerLen+1):], section)
+	for i := headerLen + 1 + n; i < _TS_PKT_SIZE; i++ {
+		rv[i] = 0xff
+	}
+	ij.stat.pmtCnt++
+	return rv
+}
+
+// The output PMT does not announce the cue pid. Only the first one is logged, as PMT repeats.
+func (ij *scte35InjectorPlugin) pmtNotRewritten(reason string) {
+	if ij.stat.pmtErrCnt == 0 {
+		ij.logger.Error("%s, PMT is not rewritten and cue pid %d is not announced", reason, ij.param.Pid)
+	}
+	ij.stat.pmtErrCnt++
+}
+
+func (ij *scte35InjectorPlugin) output(pkts [][]byte) {
+	for _, pkt := range pkts {
+		cmBuf := tttKernel.MakeSimpleBuf(pkt)
+		if ij.writer != nil {
+			ij.writer.Write(cmBuf)
+		}
+		ij.stat.outPktCnt++
+
+		ij.outputQueue = append(ij.outputQueue, common.NewMediaUnit(cmBuf, common.UNKNOWN_UNIT))
+		reqUnit := tttKernel.MakeReqUnit(ij.name, tttKernel.FETCH_REQUEST)
+		tttKernel.Post_request(ij.callback, ij.name, reqUnit)
+	}
+}
+
+func buildInjectorCue(param injectorCueParam) (injectorCue, error) {
+	if param.Pts < 0 && param.PktCnt < 0 {
+		return injectorCue{}, errors.New("Either Pts or PktCnt should be specified")
+	}
+
+	cueJson := []byte(param.Cue)
+	if param.File != "" {
+		var err error
+		if cueJson, err = os.ReadFile(param.File); err != nil {
+			return injectorCue{}, err
+		}
+	}
+
+	cue, err := model.ParseScte35Cue(cueJson)
+	if err != nil {
+		return injectorCue{}, err
+	}
+	section, err := model.EncodeScte35Section(&cue)
+	if err != nil {
+		return injectorCue{}, err
+	}
+	return injectorCue{pts: param.Pts, pktCnt: param.PktCnt, section: section}, nil
+}
+
+// PTS in the optional header of a PES packet, or -1 if not available
+func readPesPts(payload []byte) int {
+	if len(payload) < 14 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return -1
+	}
+	if payload[7]&0x80 == 0 {
+		return -1
+	}
+	r := io.GetBufferReader(payload[9:14])
+	r.ReadBits(4)
+	pts := r.ReadBits(3) << 30
+	r.ReadBits(1)
+	pts |= r.ReadBits(15) << 15
+	r.ReadBits(1)
+	pts |= r.ReadBits(15)
+	return pts
+}
+
+// Check if pts is at or after target, with wrap around
+func isPtsReached(pts int, target int) bool {
+	diff := (pts - target + _PTS_WRAP) % _PTS_WRAP
+	return diff < _PTS_WRAP/2
+}
+
+func Scte35Injector(name string) tttKernel.IPlugin {
+	rv := scte35InjectorPlugin{
+		name:        name,
+		logger:      logging.CreateLogger(name),
+		param:       defaultScte35InjectorParams(),
+		packetizer:  newTsPacketizer(),
+		cues:        []injectorCue{},
+		residual:    []byte{},
+		outputQueue: []tttKernel.CmUnit{},
+	}
+	return &rv
+}
+
+func init() {
+	param := defaultScte35InjectorParams()
+	tttKernel.RegisterPlugin(tttKernel.PluginInfo{
+		Type:        "Scte35Injector",
+		Constructor: Scte35Injector,
+		Description: "Inject SCTE-35 cues into a transport stream",
+		Params: []tttKernel.ParamDef{
+			{Name: "OutFile", Type: tttKernel.FIELD_STRING, Default: param.OutFile, Description: "Output file name under outDir"},
+			{Name: "Pid", Type: tttKernel.FIELD_INT64, Default: param.Pid, Description: "PID carrying the injected SCTE-35 sections"},
+			{Name: "PtsPid", Type: tttKernel.FIELD_INT64, Default: param.PtsPid, Description: "PID whose PES PTS triggers PTS-based injection"},
+			{Name: "PmtPid", Type: tttKernel.FIELD_INT64, Default: param.PmtPid, Description: "PMT to announce Pid in. The PMT is left untouched if -1"},
+			{Name: "Cues", Description: "List of {Pts, PktCnt, File, Cue} to be injected"},
+		},
+	})
+}
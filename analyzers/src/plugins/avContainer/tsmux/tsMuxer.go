@@ -1 +1,362 @@
-package tsmux
+package tsmux
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tony-507/analyzers/src/logging"
+	"github.com/tony-507/analyzers/src/plugins/common"
+	"github.com/tony-507/analyzers/src/plugins/common/clock"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+/*
+ * The muxer accepts PES units from tsdemux or dataHandler and produces a single
+ * program transport stream. Output is written to a file in outDir and each TS
+ * packet is also sent to children plugins.
+ */
+
+const (
+	_NULL_PID          int   = 0x1fff
+	_DEFAULT_PCR_DELAY int   = 45000 // Delay of PCR behind DTS in 90kHz if upstream PCR is unavailable
+	_PCR_INTERVAL      int64 = int64(40 * clock.Second / 1000)
+)
+
+type muxStream struct {
+	inPid      int
+	outPid     int
+	streamType int
+	streamId   int
+	pesCnt     int
+}
+
+type muxStat struct {
+	inCnt     int
+	outPktCnt int
+	psiCnt    int
+}
+
+type tsMuxerPlugin struct {
+	logger      logging.Log
+	callback    tttKernel.RequestHandler
+	loader      *tttKernel.ResourceLoader
+	name        string
+	param       muxParams
+	packetizer  tsPacketizer
+	streams     map[int]*muxStream // Input pid -> stream. nil if the pid is filtered
+	patVersion  int                // Follows changes of the program announced, i.e. its PMT
+	pmtVersion  int
+	pcrPid      int
+	lastPcr     int64
+	psiPending  bool
+	pktSincePsi int
+	writer      io.FileWriter
+	outputQueue []tttKernel.CmUnit
+	stat        muxStat
+}
+
+func (m *tsMuxerPlugin) SetCallback(callback tttKernel.RequestHandler) {
+	m.callback = callback
+}
+
+func (m *tsMuxerPlugin) SetParameter(m_parameter string) {
+	param := defaultMuxParams()
+	if err := json.Unmarshal([]byte(m_parameter), &param); err != nil {
+		panic(err)
+	}
+	m.param = param
+	m.pcrPid = param.PcrPid
+	m.logger.Info("Muxer created with program %d on PMT pid %d", param.ProgNum, param.PmtPid)
+}
+
+func (m *tsMuxerPlugin) SetResource(loader *tttKernel.ResourceLoader) {
+	m.loader = loader
+}
+
+func (m *tsMuxerPlugin) StartSequence() {
+	outDir := m.loader.Query("outDir", nil)
+	// Avoid appending to the output of a previous run
+	os.Remove(path.Join(outDir, m.param.OutFile))
+
+	m.writer = io.RawWriter(outDir, m.param.OutFile)
+	if err := m.writer.Open(); err != nil {
+		m.logger.Warn("Fail to open %s for writing: %s", m.param.OutFile, err.Error())
+	}
+}
+
+func (m *tsMuxerPlugin) EndSequence() {
+	m.logger.Info("Muxer stopped with %d packets written", m.stat.outPktCnt)
+	if m.writer != nil {
+		if err := m.writer.Close(); err != nil {
+			m.logger.Error("Fail to close %s: %s", m.param.OutFile, err.Error())
+		}
+	}
+	eosUnit := tttKernel.MakeReqUnit(m.name, tttKernel.EOS_REQUEST)
+	tttKernel.Post_request(m.callback, m.name, eosUnit)
+}
+
+func (m *tsMuxerPlugin) DeliverUnit(unit tttKernel.CmUnit, inputId string) {
+	if unit == nil {
+		return
+	}
+	cmBuf := unit.GetBuf()
+	payload := cmBuf.GetBuf()
+	inPid, ok := tttKernel.GetBufFieldAsInt(cmBuf, "pid")
+	if !ok || len(payload) == 0 {
+		// Not a PES packet, e.g. parsed SCTE-35 data
+		return
+	}
+	m.stat.inCnt++
+
+	stream := m.getStream(inPid, cmBuf)
+	if stream == nil {
+		return
+	}
+	stream.pesCnt++
+
+	pts, hasPts := tttKernel.GetBufFieldAsInt(cmBuf, "pts")
+	if !hasPts {
+		pts = -1
+	}
+	dts, hasDts := tttKernel.GetBufFieldAsInt(cmBuf, "dts")
+	if !hasDts {
+		dts = pts
+	}
+
+	pkts := [][]byte{}
+	if m.psiPending || m.pktSincePsi >= m.param.PsiInterval {
+		pkts = append(pkts, m.getPsiPackets()...)
+	}
+
+	pcr := m.getPcr(cmBuf, dts)
+	if pcr >= 0 && stream.outPid != m.pcrPid {
+		// Dedicated PCR pid
+		if !m.isStreamPid(m.pcrPid) && pcr-m.lastPcr >= _PCR_INTERVAL {
+			pkts = append(pkts, m.packetizer.pcrPacket(m.pcrPid, pcr))
+			m.lastPcr = pcr
+		}
+		pcr = -1
+	} else if pcr >= 0 {
+		m.lastPcr = pcr
+	}
+
+	pes := buildPesPacket(stream.streamId, pts, dts, payload)
+	pkts = append(pkts, m.packetizer.packetizePes(stream.outPid, pes, pcr, isRandomAccess(unit))...)
+
+	m.output(pkts)
+}
+
+func (m *tsMuxerPlugin) DeliverStatus(unit tttKernel.CmUnit) {}
+
+func (m *tsMuxerPlugin) FetchUnit() tttKernel.CmUnit {
+	if len(m.outputQueue) == 0 {
+		return nil
+	}
+	rv := m.outputQueue[0]
+	if len(m.outputQueue) == 1 {
+		m.outputQueue = make([]tttKernel.CmUnit, 0)
+	} else {
+		m.outputQueue = m.outputQueue[1:]
+	}
+	return rv
+}
+
+func (m *tsMuxerPlugin) PrintInfo(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\tInput count: %d\n", m.stat.inCnt))
+	sb.WriteString(fmt.Sprintf("\tOutput packet count: %d\n", m.stat.outPktCnt))
+	sb.WriteString(fmt.Sprintf("\tPSI repetition count: %d\n", m.stat.psiCnt))
+	sb.WriteString(fmt.Sprintf("\tPCR pid: %d\n", m.pcrPid))
+	sb.WriteString("\tStreams:\n")
+	for inPid, stream := range m.streams {
+		if stream == nil {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("\t\t%d => %d, type %d, %d PES packets\n", inPid, stream.outPid, stream.streamType, stream.pesCnt))
+	}
+}
+
+func (m *tsMuxerPlugin) Name() string {
+	return m.name
+}
+
+// Find the stream for an input pid. Return nil if the pid should be filtered out
+func (m *tsMuxerPlugin) getStream(inPid int, cmBuf tttKernel.CmBuf) *muxStream {
+	if stream, hasKey := m.streams[inPid]; hasKey {
+		return stream
+	}
+
+	streamType, _ := tttKernel.GetBufFieldAsInt(cmBuf, "streamType")
+	stream := &muxStream{inPid: inPid, outPid: inPid, streamType: streamType}
+
+	if len(m.param.Streams) != 0 {
+		var streamParam *muxStreamParam = nil
+		for idx := range m.param.Streams {
+			if m.param.Streams[idx].InPid == inPid {
+				streamParam = &m.param.Streams[idx]
+				break
+			}
+		}
+		if streamParam == nil {
+			m.logger.Info("Pid %d is filtered", inPid)
+			m.streams[inPid] = nil
+			return nil
+		}
+		if streamParam.OutPid > 0 {
+			stream.outPid = streamParam.OutPid
+		}
+		if streamParam.StreamType > 0 {
+			stream.streamType = streamParam.StreamType
+		}
+	}
+
+	if streamId, ok := tttKernel.GetBufFieldAsInt(cmBuf, "streamId"); ok {
+		stream.streamId = streamId
+	} else {
+		stream.streamId = m.getDefaultStreamId(stream.streamType)
+	}
+
+	if m.pcrPid == -1 && m.isVideo(stream.streamType) {
+		m.pcrPid = stream.outPid
+	}
+
+	m.streams[inPid] = stream
+	m.pmtVersion = (m.pmtVersion + 1) % 32
+	m.patVersion = (m.patVersion + 1) % 32
+	m.psiPending = true
+	m.logger.Info("Add stream with pid %d => %d and type %d, PMT version %d", inPid, stream.outPid, stream.streamType, m.pmtVersion)
+
+	return stream
+}
+
+func (m *tsMuxerPlugin) getPsiPackets() [][]byte {
+	esList := []esInfo{}
+	for _, stream := range m.streams {
+		if stream != nil {
+			esList = append(esList, esInfo{pid: stream.outPid, streamType: stream.streamType})
+		}
+	}
+	pcrPid := m.pcrPid
+	if pcrPid == -1 {
+		pcrPid = _NULL_PID
+	}
+
+	pat := buildPatSection(m.param.TsId, m.patVersion, m.param.ProgNum, m.param.PmtPid)
+	pmt := buildPmtSection(m.param.ProgNum, m.pmtVersion, pcrPid, esList)
+
+	pkts := m.packetizer.packetizeSection(0, pat)
+	pkts = append(pkts, m.packetizer.packetizeSection(m.param.PmtPid, pmt)...)
+
+	m.psiPending = false
+	m.pktSincePsi = 0
+	m.stat.psiCnt++
+	return pkts
+}
+
+// Prefer the PCR stamped by the demuxer, otherwise derive it from DTS
+func (m *tsMuxerPlugin) getPcr(cmBuf tttKernel.CmBuf, dts int) int64 {
+	if m.pcrPid == -1 {
+		return -1
+	}
+	pcr := int64(-1)
+	if upstreamPcr, ok := tttKernel.GetBufFieldAsInt(cmBuf, "pcr"); ok && upstreamPcr >= 0 {
+		pcr = int64(upstreamPcr)
+	} else if dts >= _DEFAULT_PCR_DELAY {
+		pcr = int64(dts-_DEFAULT_PCR_DELAY) * 300
+	}
+	if pcr <= m.lastPcr {
+		return -1
+	}
+	return pcr
+}
+
+func (m *tsMuxerPlugin) isStreamPid(pid int) bool {
+	for _, stream := range m.streams {
+		if stream != nil && stream.outPid == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *tsMuxerPlugin) isVideo(streamType int) bool {
+	if m.loader == nil || streamType <= 0 || streamType > len(m.loader.StreamType) {
+		return false
+	}
+	return strings.Contains(m.loader.Query("streamType", streamType), "video")
+}
+
+func (m *tsMuxerPlugin) getDefaultStreamId(streamType int) int {
+	if m.isVideo(streamType) {
+		return 0xe0
+	}
+	switch streamType {
+	case 3, 4, 15, 17:
+		return 0xc0
+	default:
+		// Private stream 1, e.g. AC-3
+		return 0xbd
+	}
+}
+
+func (m *tsMuxerPlugin) output(pkts [][]byte) {
+	for _, pkt := range pkts {
+		cmBuf := tttKernel.MakeSimpleBuf(pkt)
+		if m.writer != nil {
+			m.writer.Write(cmBuf)
+		}
+		m.stat.outPktCnt++
+		m.pktSincePsi++
+
+		m.outputQueue = append(m.outputQueue, common.NewMediaUnit(cmBuf, common.UNKNOWN_UNIT))
+		reqUnit := tttKernel.MakeReqUnit(m.name, tttKernel.FETCH_REQUEST)
+		tttKernel.Post_request(m.callback, m.name, reqUnit)
+	}
+}
+
+func isRandomAccess(unit tttKernel.CmUnit) bool {
+	mUnit, ok := unit.(*common.MediaUnit)
+	if !ok || mUnit.GetType() != common.VIDEO_UNIT {
+		return false
+	}
+	vmd := mUnit.GetVideoData()
+	return vmd.Type == common.I_SLICE || vmd.Type == common.IDR_SLICE
+}
+
+func TsMuxer(name string) tttKernel.IPlugin {
+	rv := tsMuxerPlugin{
+		name:        name,
+		logger:      logging.CreateLogger(name),
+		param:       defaultMuxParams(),
+		packetizer:  newTsPacketizer(),
+		streams:     map[int]*muxStream{},
+		patVersion:  -1,
+		pmtVersion:  -1,
+		pcrPid:      -1,
+		lastPcr:     -1,
+		psiPending:  true,
+		outputQueue: []tttKernel.CmUnit{},
+	}
+	return &rv
+}
+
+func init() {
+	param := defaultMuxParams()
+	tttKernel.RegisterPlugin(tttKernel.PluginInfo{
+		Type:        "TsMuxer",
+		Constructor: TsMuxer,
+		Description: "Mux PES and PSI from upstream into a single program transport stream",
+		Params: []tttKernel.ParamDef{
+			{Name: "OutFile", Type: tttKernel.FIELD_STRING, Default: param.OutFile, Description: "Output file name under outDir"},
+			{Name: "TsId", Type: tttKernel.FIELD_INT64, Default: param.TsId, Description: "transport_stream_id in PAT"},
+			{Name: "ProgNum", Type: tttKernel.FIELD_INT64, Default: param.ProgNum, Description: "Program number of the output program"},
+			{Name: "PmtPid", Type: tttKernel.FIELD_INT64, Default: param.PmtPid, Description: "PID of the PMT"},
+			{Name: "PcrPid", Type: tttKernel.FIELD_INT64, Default: param.PcrPid, Description: "PID carrying PCR, default to the first video stream"},
+			{Name: "PsiInterval", Type: tttKernel.FIELD_INT64, Default: param.PsiInterval, Description: "Number of output packets between PAT/PMT repetitions"},
+			{Name: "Streams", Description: "List of {InPid, OutPid, StreamType} to be muxed. All streams are muxed if empty"},
+		},
+	})
+}
@@ -0,0 +1,251 @@
+package tsmux
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-507/analyzers/src/plugins/avContainer/model"
+	"github.com/tony-507/analyzers/src/plugins/common"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+type dummyPsiManager struct {
+	programs map[int]int
+	versions []int // Versions of PAT received
+	streams  map[int]int
+	splices  []string
+}
+
+func (m *dummyPsiManager) AddStream(version int, progNum int, streamPid int, streamType int) {
+	m.streams[streamPid] = streamType
+}
+
+func (m *dummyPsiManager) AddProgram(version int, progNum int, pmtPid int) {
+	m.versions = append(m.versions, version)
+	m.programs[progNum] = pmtPid
+}
+
+func (m *dummyPsiManager) GetPATVersion() int {
+	return -1
+}
+
+func (m *dummyPsiManager) GetPmtVersion(progNum int) int {
+	return -1
+}
+
+func (m *dummyPsiManager) GetPmtPidByProgNum(progNum int) int {
+	return m.programs[progNum]
+}
+
+func (m *dummyPsiManager) PsiUpdateFinished(pid int, version int, jsonBytes []byte) {}
+
+func (m *dummyPsiManager) SpliceEventReceived(dpiPid int, spliceCmdTypeStr string, splicePTS []int, descriptors []model.Splice_descriptor, pktCnt int) {
+	m.splices = append(m.splices, spliceCmdTypeStr)
+}
+
+func (m *dummyPsiManager) StreamTimeReceived(utc time.Time, pktCnt int) {}
+
+func (m *dummyPsiManager) UpdateSiVersion(key string, version int) bool {
+	return true
+}
+
+type dummyPesHandle struct {
+	bufs []tttKernel.CmBuf
+}
+
+func (h *dummyPesHandle) PesPacketReady(buf tttKernel.CmBuf, pid int) error {
+	h.bufs = append(h.bufs, buf)
+	return nil
+}
+
+func newTestMuxer(param string) *tsMuxerPlugin {
+	loader := tttKernel.CreateResourceLoader()
+	m, _ := TsMuxer("TsMuxer_test").(*tsMuxerPlugin)
+	m.SetCallback(func(string, tttKernel.WORKER_REQUEST, interface{}) {})
+	m.SetParameter(param)
+	m.SetResource(&loader)
+	return m
+}
+
+func pesUnit(pid int, streamType int, pts int, payload []byte) tttKernel.CmUnit {
+	buf := tttKernel.MakeSimpleBuf(payload)
+	buf.SetField("pid", pid, true)
+	buf.SetField("streamType", streamType, true)
+	buf.SetField("pts", pts, false)
+	buf.SetField("dts", pts, false)
+	return common.NewMediaUnit(buf, common.UNKNOWN_UNIT)
+}
+
+func TestCrc32Mpeg2(t *testing.T) {
+	// PAT from model tests with valid CRC
+	pat := []byte{0x00, 0xB0, 0x0D, 0x11, 0x11, 0xC1, 0x00, 0x00, 0x00, 0x0A, 0xE1, 0x02, 0xAA, 0x4A, 0xE2, 0xD2}
+	assert.Equal(t, uint32(0), io.Crc32Mpeg2(pat))
+	assert.Equal(t, uint32(0xAA4AE2D2), io.Crc32Mpeg2(pat[:len(pat)-4]))
+}
+
+func TestMuxPsiGeneration(t *testing.T) {
+	m := newTestMuxer("{\"PmtPid\":256,\"ProgNum\":10,\"TsId\":7}")
+	m.DeliverUnit(pesUnit(32, 2, 90000, make([]byte, 10)), "")
+	m.DeliverUnit(pesUnit(33, 4, 90000, make([]byte, 10)), "")
+
+	manager := &dummyPsiManager{programs: map[int]int{}, streams: map[int]int{}}
+	psiCnt := 0
+	for _, unit := range m.outputQueue {
+		pkt, err := model.TsPacket(tttKernel.GetBytesInBuf(unit))
+		if err != nil {
+			panic(err)
+		}
+		assert.Equal(t, 188, len(tttKernel.GetBytesInBuf(unit)))
+		pid := pkt.GetHeader().Pid
+		if pid != 0 && pid != 256 {
+			continue
+		}
+		psiCnt++
+		if pid == 0 {
+			section := pkt.GetPayload()[1:]
+			assert.Equal(t, 7, int(section[3])<<8|int(section[4]), "transport_stream_id not match")
+		}
+		ds, err := model.PsiTable(manager, 0, pid, pkt.GetPayload())
+		if err != nil {
+			panic(err)
+		}
+		if err = ds.Process(); err != nil {
+			panic(err)
+		}
+	}
+
+	// Second stream triggers a new PMT version
+	assert.Equal(t, 4, psiCnt)
+	assert.Equal(t, map[int]int{10: 256}, manager.programs)
+	assert.Equal(t, []int{0, 1}, manager.versions, "PAT version should follow the program")
+	assert.Equal(t, map[int]int{32: 2, 33: 4}, manager.streams)
+	assert.Equal(t, 32, m.pcrPid, "PCR pid should be the first video stream")
+}
+
+func TestMuxPesPacketization(t *testing.T) {
+	m := newTestMuxer("{\"Streams\":[{\"InPid\":32,\"OutPid\":100}]}")
+	payload := make([]byte, 500)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	m.DeliverUnit(pesUnit(32, 2, 180000, payload), "")
+	m.DeliverUnit(pesUnit(33, 4, 180000, payload), "") // Filtered
+
+	callback := &dummyPesHandle{}
+	var pes model.DataStruct
+	ccList := []int{}
+	for _, unit := range m.outputQueue {
+		pkt, err := model.TsPacket(tttKernel.GetBytesInBuf(unit))
+		if err != nil {
+			panic(err)
+		}
+		header := pkt.GetHeader()
+		assert.NotEqual(t, 33, header.Pid, "Filtered pid should not be muxed")
+		if header.Pid != 100 {
+			continue
+		}
+		ccList = append(ccList, header.Cc)
+		if header.Pusi {
+			assert.Equal(t, true, pkt.HasAdaptationField(), "PCR should be carried")
+			assert.Equal(t, int64(135000*300), pkt.GetAdaptationField().Pcr)
+			pes, err = model.PesPacket(callback, pkt.GetPayload(), 100, 0, 1, 2)
+			if err != nil {
+				panic(err)
+			}
+		} else {
+			pes.Append(pkt.GetPayload())
+		}
+	}
+
+	assert.Equal(t, []int{0, 1, 2}, ccList)
+	assert.Equal(t, true, pes.Ready())
+	pts, _ := pes.GetField("pts")
+	assert.Equal(t, 180000, pts)
+	assert.Equal(t, payload, pes.GetPayload())
+}
+
+func TestScte35Injection(t *testing.T) {
+	loader := tttKernel.CreateResourceLoader()
+	ij, _ := Scte35Injector("Scte35Injector_test").(*scte35InjectorPlugin)
+	ij.SetCallback(func(string, tttKernel.WORKER_REQUEST, interface{}) {})
+	ij.SetParameter(`{
+		"Pid": 500, "PtsPid": 32, "PmtPid": 256,
+		"Cues": [
+			{"PktCnt": 1, "Cue": {"SpliceCmdTypeStr": "splice_null"}},
+			{"Pts": 180000, "Cue": {
+				"SpliceCmdTypeStr": "splice_insert",
+				"SpliceCmd": {"EventId": 1, "OutOfNetworkIdr": true, "ProgramSpliceFlag": true, "SpliceTime": 540000}
+			}},
+			{"Pts": 900000, "Cue": {"SpliceCmdTypeStr": "time_signal", "SpliceCmd": {"SpliceTime": 900000}}}
+		]
+	}`)
+	ij.SetResource(&loader)
+
+	// PAT, PMT and three PES packets
+	packetizer := newTsPacketizer()
+	input := []byte{}
+	input = append(input, packetizer.packetizeSection(0, buildPatSection(1, 0, 1, 256))[0]...)
+	input = append(input, packetizer.packetizeSection(256, buildPmtSection(1, 0, 32, []esInfo{{32, 2}}))[0]...)
+	for _, pts := range []int{90000, 180000, 270000} {
+		pes := buildPesPacket(0xe0, pts, pts, make([]byte, 10))
+		input = append(input, packetizer.packetizePes(32, pes, -1, false)[0]...)
+	}
+
+	// Deliver in chunks not aligned to packet boundary
+	ij.DeliverUnit(common.NewMediaUnit(tttKernel.MakeSimpleBuf(input[:300]), common.UNKNOWN_UNIT), "")
+	ij.DeliverUnit(common.NewMediaUnit(tttKernel.MakeSimpleBuf(input[300:]), common.UNKNOWN_UNIT), "")
+
+	manager := &dummyPsiManager{programs: map[int]int{}, streams: map[int]int{}}
+	pids := []int{}
+	for _, unit := range ij.outputQueue {
+		pkt, err := model.TsPacket(tttKernel.GetBytesInBuf(unit))
+		if err != nil {
+			panic(err)
+		}
+		pid := pkt.GetHeader().Pid
+		pids = append(pids, pid)
+		if pid == 32 {
+			continue
+		}
+		ds, err := model.PsiTable(manager, 0, pid, pkt.GetPayload())
+		if err != nil {
+			panic(err)
+		}
+		if err = ds.Process(); err != nil {
+			panic(err)
+		}
+	}
+
+	assert.Equal(t, []int{0, 500, 256, 32, 500, 32, 32}, pids)
+	assert.Equal(t, []string{"splice_null", "splice_insert"}, manager.splices)
+	assert.Equal(t, map[int]int{32: 2, 500: 134}, manager.streams, "Cue pid should be announced in PMT")
+	assert.Equal(t, 2, ij.stat.injectCnt)
+}
+
+func TestScte35InjectorPmtRewrite(t *testing.T) {
+	// version_number is incremented only if the PMT changes
+	section, err := addPmtStream(buildPmtSection(1, 31, 32, []esInfo{{32, 2}}), 500, 0x86, "CUEI")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, int(section[5]>>1&0x1f), "PMT version should wrap around")
+	unchanged, err := addPmtStream(section, 500, 0x86, "CUEI")
+	assert.Nil(t, err)
+	assert.Equal(t, section, unchanged)
+
+	// PMT spanning two packets is passed through
+	ij, _ := Scte35Injector("Scte35Injector_test").(*scte35InjectorPlugin)
+	ij.SetParameter(`{"Pid": 500, "PmtPid": 256}`)
+	esList := []esInfo{}
+	for pid := 32; pid < 72; pid++ {
+		esList = append(esList, esInfo{pid, 2})
+	}
+	packetizer := newTsPacketizer()
+	pkts := packetizer.packetizeSection(256, buildPmtSection(1, 0, 32, esList))
+	assert.Equal(t, 2, len(pkts))
+	for _, pkt := range pkts {
+		assert.Equal(t, [][]byte{pkt}, ij.processPacket(pkt))
+	}
+	assert.Equal(t, 0, ij.stat.pmtCnt)
+	assert.Equal(t, 1, ij.stat.pmtErrCnt)
+}
@@ -0,0 +1,198 @@
+package tsmux
+
+import (
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+const (
+	_TS_PKT_SIZE     int = 188
+	_TS_PAYLOAD_SIZE int = 184
+	_PCR_AF_SIZE     int = 8 // adaptation_field_length, flags and PCR
+)
+
+// Build 188-byte TS packets and keep track of continuity counters
+type tsPacketizer struct {
+	ccMap map[int]int // pid -> last cc
+}
+
+func (p *tsPacketizer) nextCc(pid int) int {
+	cc, hasKey := p.ccMap[pid]
+	if !hasKey {
+		cc = -1
+	}
+	cc = (cc + 1) % 16
+	p.ccMap[pid] = cc
+	return cc
+}
+
+func writeTsHeader(w *io.BsWriter, pid int, pusi bool, afc int, cc int) {
+	w.WriteByte(0x47)
+	w.Write(0, 1) // transport_error_indicator
+	if pusi {
+		w.Write(1, 1)
+	} else {
+		w.Write(0, 1)
+	}
+	w.Write(0, 1) // transport_priority
+	w.Write(pid, 13)
+	w.Write(0, 2) // transport_scrambling_control
+	w.Write(afc, 2)
+	w.Write(cc, 4)
+}
+
+// Write adaptation field of afSize bytes including adaptation_field_length
+func writeAdaptationField(w *io.BsWriter, afSize int, pcr int64, randomAccess bool) {
+	w.WriteByte(afSize - 1)
+	if afSize == 1 {
+		return
+	}
+	w.Write(0, 1) // discontinuity_indicator
+	if randomAccess {
+		w.Write(1, 1)
+	} else {
+		w.Write(0, 1)
+	}
+	w.Write(0, 1) // elementary_stream_priority_indicator
+	if pcr >= 0 {
+		w.Write(1, 1)
+	} else {
+		w.Write(0, 1)
+	}
+	w.Write(0, 4) // OPCR, splicing point, private data and extension flags
+	written := 2
+	if pcr >= 0 {
+		w.Write(int(pcr/300), 33)
+		w.Write(0x3f, 6)
+		w.Write(int(pcr%300), 9)
+		written += 6
+	}
+	for ; written < afSize; written++ {
+		w.WriteByte(0xff)
+	}
+}
+
+// Split a PES packet into TS packets. PCR is written to the first packet if pcr >= 0.
+func (p *tsPacketizer) packetizePes(pid int, pes []byte, pcr int64, randomAccess bool) [][]byte {
+	pkts := [][]byte{}
+	pos := 0
+	for pos < len(pes) {
+		first := pos == 0
+		minAfSize := 0
+		if first && (pcr >= 0 || randomAccess) {
+			minAfSize = 2
+			if pcr >= 0 {
+				minAfSize = _PCR_AF_SIZE
+			}
+		}
+		payloadLen := len(pes) - pos
+		if payloadLen > _TS_PAYLOAD_SIZE-minAfSize {
+			payloadLen = _TS_PAYLOAD_SIZE - minAfSize
+		}
+		afSize := _TS_PAYLOAD_SIZE - payloadLen
+
+		afc := 1
+		if afSize > 0 {
+			afc = 3
+		}
+
+		w := io.GetBufferWriter(_TS_PKT_SIZE)
+		writeTsHeader(&w, pid, first, afc, p.nextCc(pid))
+		if afSize > 0 {
+			pktPcr := int64(-1)
+			if first {
+				pktPcr = pcr
+			}
+			writeAdaptationField(&w, afSize, pktPcr, first && randomAccess)
+		}
+		buf := w.GetBuf()
+		copy(buf[(4+afSize):], pes[pos:(pos+payloadLen)])
+		pkts = append(pkts, buf)
+		pos += payloadLen
+	}
+	return pkts
+}
+
+// Split a PSI section into TS packets. Remaining bytes are stuffed with 0xff.
+func (p *tsPacketizer) packetizeSection(pid int, section []byte) [][]byte {
+	payload := append([]byte{0x00}, section...) // pointer_field
+	pkts := [][]byte{}
+	for pos := 0; pos < len(payload); pos += _TS_PAYLOAD_SIZE {
+		w := io.GetBufferWriter(_TS_PKT_SIZE)
+		writeTsHeader(&w, pid, pos == 0, 1, p.nextCc(pid))
+		buf := w.GetBuf()
+		n := copy(buf[4:], payload[pos:])
+		for i := 4 + n; i < _TS_PKT_SIZE; i++ {
+			buf[i] = 0xff
+		}
+		pkts = append(pkts, buf)
+	}
+	return pkts
+}
+
+// An adaptation-field-only packet carrying PCR. CC is not incremented without payload.
+func (p *tsPacketizer) pcrPacket(pid int, pcr int64) []byte {
+	cc, hasKey := p.ccMap[pid]
+	if !hasKey {
+		cc = 0
+	}
+	w := io.GetBufferWriter(_TS_PKT_SIZE)
+	writeTsHeader(&w, pid, false, 2, cc)
+	writeAdaptationField(&w, _TS_PAYLOAD_SIZE, pcr, false)
+	return w.GetBuf()
+}
+
+func writeTimestamp(w *io.BsWriter, prefix int, ts int) {
+	w.Write(prefix, 4)
+	w.Write((ts>>30)&0x07, 3)
+	w.Write(1, 1)
+	w.Write((ts>>15)&0x7fff, 15)
+	w.Write(1, 1)
+	w.Write(ts&0x7fff, 15)
+	w.Write(1, 1)
+}
+
+// Build a PES packet with optional header. DTS is omitted if it equals PTS.
+func buildPesPacket(streamId int, pts int, dts int, payload []byte) []byte {
+	headerDataLen := 0
+	ptsDtsFlag := 0
+	if pts >= 0 {
+		ptsDtsFlag = 2
+		headerDataLen = 5
+		if dts >= 0 && dts != pts {
+			ptsDtsFlag = 3
+			headerDataLen = 10
+		}
+	}
+
+	pesLen := 3 + headerDataLen + len(payload)
+	if pesLen > 0xffff {
+		// Unbounded, only allowed for video
+		pesLen = 0
+	}
+
+	w := io.GetBufferWriter(9 + headerDataLen)
+	w.Write(0x000001, 24)
+	w.WriteByte(streamId)
+	w.WriteShort(pesLen)
+	w.Write(2, 2)
+	w.Write(0, 2) // PES_scrambling_control
+	w.Write(0, 1) // PES_priority
+	w.Write(1, 1) // data_alignment_indicator
+	w.Write(0, 2) // copyright and original_or_copy
+	w.Write(ptsDtsFlag, 2)
+	w.Write(0, 6) // ESCR, ES rate, DSM trick mode, additional copy info, CRC and extension flags
+	w.WriteByte(headerDataLen)
+	switch ptsDtsFlag {
+	case 2:
+		writeTimestamp(&w, 2, pts)
+	case 3:
+		writeTimestamp(&w, 3, pts)
+		writeTimestamp(&w, 1, dts)
+	}
+
+	return append(w.GetBuf(), payload...)
+}
+
+func newTsPacketizer() tsPacketizer {
+	return tsPacketizer{ccMap: map[int]int{}}
+}
@@ -40,7 +40,9 @@ func (bb *BasebandProcessorPlugin) EndSequence() {}
 func (bb *BasebandProcessorPlugin) DeliverStatus(status tttKernel.CmUnit) {}
 
 func (bb *BasebandProcessorPlugin) DeliverUnit(unit tttKernel.CmUnit, inputId string) {
-	bb.core.Feed(unit, inputId)
+	if err := bb.core.Feed(unit, inputId); err != nil {
+		tttKernel.Report_error(bb.callback, bb.name, tttKernel.SEVERITY_ERROR, inputId, err)
+	}
 }
 
 func (bb *BasebandProcessorPlugin) FetchUnit() tttKernel.CmUnit {
@@ -64,3 +66,15 @@ func BasebandProcessor(name string) tttKernel.IPlugin {
 		name:   name,
 	}
 }
+
+func init() {
+	tttKernel.RegisterPlugin(tttKernel.PluginInfo{
+		Type:        "BasebandProcessor",
+		Constructor: BasebandProcessor,
+		Description: "Process baseband data such as ST 2110 streams",
+		Params: []tttKernel.ParamDef{
+			{Name: "InputType", Type: tttKernel.FIELD_INT64, Default: int(def.ST_2110), Allowed: []string{"1"}, Description: "Type of input, 1 for ST 2110"},
+			{Name: "Mode", Type: tttKernel.FIELD_STRING, Description: "Not used, accepted for existing apps setting a demux mode"},
+		},
+	})
+}
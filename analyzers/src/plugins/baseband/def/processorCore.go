@@ -13,7 +13,7 @@ type ProcessorCallback interface {
 
 type ProcessorCore interface {
 	DeliverData(*common.MediaUnit)
-	Feed(tttKernel.CmUnit, string)    // Feed a unit to core
+	Feed(tttKernel.CmUnit, string) error // Feed a unit to core
 	PrintInfo(sb *strings.Builder) // Periodic debug info
 	SetCallback(ProcessorCallback) // Set plugin callback for OnDataReady
 }
@@ -24,25 +24,26 @@ func (core *St2110Core) SetCallback(callback def.ProcessorCallback) {
 	core.callback = callback
 }
 
-func (core *St2110Core) Feed(unit tttKernel.CmUnit, inputId string) {
+func (core *St2110Core) Feed(unit tttKernel.CmUnit, inputId string) error {
+	pkt, err := newRtpPacket(unit.GetBuf().GetBuf())
+	if err != nil {
+		return err
+	}
+
 	if _, hasKey := core.processors[inputId]; !hasKey {
 		core.processors[inputId] = newProcessor(core, inputId)
 	}
 
-	pkt := newRtpPacket(unit.GetBuf().GetBuf())
-
-	field, ok := unit.GetBuf().GetField("realtimeInUs")
-	var realtime int64
-
+	realtime, ok := tttKernel.GetBufFieldAsInt64(unit.GetBuf(), "realtimeInUs")
 	if !ok {
 		realtime = time.Now().UnixMilli()
 	} else {
-		realtime, _ = field.(int64)
 		realtime /= 1000
 	}
 
 	core.processors[inputId].tick(time.Duration(realtime) * time.Millisecond)
 	core.processors[inputId].feed(&pkt)
+	return nil
 }
 
 func (core *St2110Core) PrintInfo(sb *strings.Builder) {
@@ -9,9 +9,13 @@ type rtpPacket struct {
 	marker      bool
 }
 
-func newRtpPacket(rawBuffer []byte) rtpPacket {
+func newRtpPacket(rawBuffer []byte) (rtpPacket, error) {
 	parser := protocol.GetParser(protocol.PROT_RTP)
-	res := parser.Parse(&protocol.ParseResult{Buffer: rawBuffer})[0]
+	resList, err := parser.Parse(&protocol.ParseResult{Buffer: rawBuffer})
+	if err != nil {
+		return rtpPacket{}, err
+	}
+	res := resList[0]
 
 	pt, _ := res.GetField("payloadType")
 	rtp, _ := res.GetField("timestamp")
@@ -22,5 +26,5 @@ func newRtpPacket(rawBuffer []byte) rtpPacket {
 		payloadType: int(pt),
 		timestamp: uint32(rtp),
 		marker: marker != 0,
-	}
+	}, nil
 }
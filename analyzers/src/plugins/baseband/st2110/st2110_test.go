@@ -13,7 +13,7 @@ import (
 
 type dummyCallback struct {}
 
-func (cb *dummyCallback) Feed(tttKernel.CmUnit, string) {}
+func (cb *dummyCallback) Feed(tttKernel.CmUnit, string) error { return nil }
 
 func (cb *dummyCallback) PrintInfo(*strings.Builder) {}
 
@@ -104,8 +104,8 @@ func TestMediaUnitWithSimpleBuf(t *testing.T) {
 	extractedBuffer.SetField("dummy", 100, false)
 
 	if field, hasField := extractedBuffer.GetField("dummy"); hasField {
-		if v, isInt := field.(int); isInt {
-			assert.Equal(t, v, 100, "dummy field should be 100")
+		if v, isInt := field.(int64); isInt {
+			assert.Equal(t, v, int64(100), "dummy field should be 100")
 		} else {
 			panic(fmt.Sprintf("Data not int but %T", field))
 		}
@@ -24,7 +24,7 @@ func (w *BsWriter) writeBits(x int, n int) {
 		}
 
 		consumedLen := n - w.offset
-		mask := 1<<(consumedLen+1) - 1
+		mask := 1<<consumedLen - 1
 
 		// Write to current byte first
 		w.writeBits(x>>consumedLen, w.offset)
@@ -0,0 +1,31 @@
+package io
+
+// CRC-32/MPEG-2 as used by PSI sections (ISO/IEC 13818-1 Annex A)
+// Polynomial 0x04C11DB7, initial value 0xFFFFFFFF, no reflection, no final xor
+
+var crc32Table = makeCrc32Table()
+
+func makeCrc32Table() [256]uint32 {
+	table := [256]uint32{}
+	for i := 0; i < 256; i++ {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = (crc << 1) ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// Compute CRC32 of buf. A section including its CRC_32 field yields 0 if it is intact.
+func Crc32Mpeg2(buf []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range buf {
+		crc = (crc << 8) ^ crc32Table[byte(crc>>24)^b]
+	}
+	return crc
+}
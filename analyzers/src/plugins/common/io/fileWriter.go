@@ -13,10 +13,12 @@ type FileWriter interface {
 	Close() error
 }
 
+// The header is fixed by the first row. Fields not in it are dropped from later rows, and missing ones are left empty.
 type CsvWriterStruct struct {
 	fHandle *os.File
 	fname   string
 	hasHead bool
+	columns []string // Columns of the header, so that rows stay aligned with it
 	outDir  string
 }
 
@@ -38,9 +40,10 @@ func (csv *CsvWriterStruct) Write(cmBuf tttKernel.CmBuf) {
 	}
 	if !csv.hasHead {
 		csv.fHandle.WriteString(cmBuf.GetFieldAsString())
+		csv.columns = cmBuf.Fields()
 		csv.hasHead = true
 	}
-	csv.fHandle.WriteString(cmBuf.ToString())
+	csv.fHandle.WriteString(cmBuf.ToCsv(csv.columns))
 }
 
 func (csv *CsvWriterStruct) Close() error {
@@ -0,0 +1,23 @@
+package io
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBsWriterCrossByte(t *testing.T) {
+	// Bits after the current byte are masked before being written to the next bytes
+	w := GetBufferWriter(4)
+	w.Write(0x5, 3)
+	w.Write(0x1ffff, 17)
+	w.Write(0x3, 4)
+	w.WriteByte(0xa5)
+	assert.Equal(t, []byte{0xbf, 0xff, 0xf3, 0xa5}, w.GetBuf())
+
+	r := GetBufferReader(w.GetBuf())
+	assert.Equal(t, 0x5, r.ReadBits(3))
+	assert.Equal(t, 0x1ffff, r.ReadBits(17))
+	assert.Equal(t, 0x3, r.ReadBits(4))
+	assert.Equal(t, 0xa5, r.ReadBits(8))
+}
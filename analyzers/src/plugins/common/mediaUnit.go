@@ -1,6 +1,7 @@
 package common
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/tony-507/analyzers/src/tttKernel"
@@ -83,6 +84,7 @@ const (
 type mediaData interface {
 	GetType() _DATA_TYPE
 	GetField(name string) int64
+	GetString(name string) string
 }
 
 type _DATA_TYPE int
@@ -91,9 +93,11 @@ const (
 )
 
 type scte35Data struct {
-	Playtime   int64
-	SpliceTime int64
-	Preroll    int64
+	Playtime           int64
+	SpliceTime         int64
+	Preroll            int64
+	SegmentationTypeId int64  // From the first segmentation descriptor, -1 if absent
+	Upid               string // From the first segmentation descriptor
 }
 
 func (data *scte35Data) GetType() _DATA_TYPE {
@@ -108,6 +112,17 @@ func (data *scte35Data) GetField(name string) int64 {
 		return data.SpliceTime
 	case "preroll":
 		return data.Preroll
+	case "segmentationTypeId":
+		return data.SegmentationTypeId
+	default:
+		panic("No such field")
+	}
+}
+
+func (data *scte35Data) GetString(name string) string {
+	switch name {
+	case "upid":
+		return data.Upid
 	default:
 		panic("No such field")
 	}
@@ -118,6 +133,8 @@ func NewScte35Data(playtime int64, spliceTime int64, preroll int64) scte35Data {
 		Playtime: playtime,
 		SpliceTime: spliceTime,
 		Preroll: preroll,
+		SegmentationTypeId: -1,
+		Upid: "",
 	}
 }
 
@@ -170,3 +187,48 @@ func (m *MediaUnit) GetVideoData() *videoMetaData {
 	}
 	return m.vmd
 }
+
+// Fields of a media unit kept in traces
+type mediaUnitFields struct {
+	Type         _MEDIA_TYPE
+	Video        *videoMetaData `json:",omitempty"`
+	FrameRateNum int            `json:",omitempty"`
+	FrameRateDen int            `json:",omitempty"`
+	Scte35       *scte35Data    `json:",omitempty"`
+}
+
+func (m *MediaUnit) UnitType() string {
+	return "MediaUnit"
+}
+
+func (m *MediaUnit) MarshalFields() ([]byte, error) {
+	fields := mediaUnitFields{Type: m.unitType, Video: m.vmd}
+	if m.vmd != nil {
+		fields.FrameRateNum = m.vmd.FrameRate.num
+		fields.FrameRateDen = m.vmd.FrameRate.den
+	}
+	if data, ok := m.Data.(*scte35Data); ok {
+		fields.Scte35 = data
+	}
+	return json.Marshal(fields)
+}
+
+func unmarshalMediaUnit(buf tttKernel.CmBuf, data []byte) (tttKernel.CmUnit, error) {
+	var fields mediaUnitFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	unit := NewMediaUnit(buf, fields.Type)
+	if fields.Video != nil {
+		unit.vmd = fields.Video
+		unit.vmd.FrameRate = FrameRate(fields.FrameRateNum, fields.FrameRateDen)
+	}
+	if fields.Scte35 != nil {
+		unit.Data = fields.Scte35
+	}
+	return unit, nil
+}
+
+func init() {
+	tttKernel.RegisterUnitType("MediaUnit", unmarshalMediaUnit)
+}
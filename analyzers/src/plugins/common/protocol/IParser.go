@@ -6,7 +6,7 @@ import (
 )
 
 type IParser interface {
-	Parse(*ParseResult) []ParseResult // Parse given data
+	Parse(*ParseResult) ([]ParseResult, error) // Parse given data
 }
 
 type PROTOCOL int
@@ -32,6 +32,7 @@ type ParseResult struct {
 	Buffer  []byte
 	Fields  map[string]int64
 	IsEmpty bool
+	Err     error // Set if the data cannot be parsed
 }
 
 func (res *ParseResult) GetBuffer() []byte {
@@ -49,8 +50,9 @@ func EmptyResult() ParseResult {
 	}
 }
 
-func AssertIntEqual(name string, expected int, actual int) {
+func CheckIntEqual(name string, expected int, actual int) error {
 	if expected != actual {
-		panic(fmt.Sprintf("Invalid value at %s. Expecting %d but got %d", name, expected, actual))
+		return fmt.Errorf("invalid value at %s. Expecting %d but got %d", name, expected, actual)
 	}
+	return nil
 }
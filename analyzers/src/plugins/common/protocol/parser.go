@@ -4,18 +4,22 @@ import (
 	"fmt"
 )
 
+// Data that cannot be parsed gives an empty result carrying the error
 func ParseWithParsers(parsers []IParser, lastRes *ParseResult) []ParseResult {
 	if len(parsers) == 0 {
 		return []ParseResult{*lastRes}
 	}
 
+	parsed, err := parsers[0].Parse(lastRes)
+	if err != nil {
+		return []ParseResult{{IsEmpty: true, Err: err}}
+	}
 	if len(parsers) == 1 {
-		return parsers[0].Parse(lastRes)
+		return parsed
 	}
 
-	parser := parsers[0]
 	res := []ParseResult{}
-	for _, item := range(parser.Parse(lastRes)) {
+	for _, item := range(parsed) {
 		// TODO: Do not discard info from other protocols
 		res = append(res, ParseWithParsers(parsers[1:], &item)...)
 	}
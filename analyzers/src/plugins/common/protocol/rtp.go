@@ -1,6 +1,8 @@
 package protocol
 
 import (
+	"fmt"
+
 	"github.com/tony-507/analyzers/src/plugins/common/io"
 	"github.com/tony-507/analyzers/src/logging"
 )
@@ -9,14 +11,22 @@ type RtpProtocolParser struct {
 	logger logging.Log
 }
 
-func (rtp *RtpProtocolParser) Parse(data *ParseResult) []ParseResult {
+const _RTP_HEADER_SIZE = 12
+
+func (rtp *RtpProtocolParser) Parse(data *ParseResult) ([]ParseResult, error) {
 	rawBuf := data.GetBuffer()
 	res := make([]ParseResult, 1)
 	fields := make(map[string]int64)
 
+	if len(rawBuf) < _RTP_HEADER_SIZE {
+		return nil, fmt.Errorf("RTP packet too short: %d bytes", len(rawBuf))
+	}
+
 	r := io.GetBufferReader(rawBuf)
 	// RTP header
-	AssertIntEqual("version", 2, r.ReadBits(2))
+	if err := CheckIntEqual("version", 2, r.ReadBits(2)); err != nil {
+		return nil, err
+	}
 	bPad := r.ReadBits(1) != 0
 	bExtension := r.ReadBits(1) != 0
 	csrcCount := r.ReadBits(4)
@@ -26,25 +36,44 @@ func (rtp *RtpProtocolParser) Parse(data *ParseResult) []ParseResult {
 	fields["timestamp"] = int64(r.ReadBits(32))
 	fields["syncId"] = int64(r.ReadBits(32))
 
+	headerSize := _RTP_HEADER_SIZE + csrcCount * 4
+	if len(rawBuf) < headerSize {
+		return nil, fmt.Errorf("RTP packet too short for %d CSRC: %d bytes", csrcCount, len(rawBuf))
+	}
 	for i := 0; i < csrcCount; i++ {
 		r.ReadBits(32)
 	}
 
 	if bExtension {
-		panic("RTP extension header not supported")
+		// Extension header is skipped, with its length in 32-bit words
+		if len(rawBuf) < headerSize + 4 {
+			return nil, fmt.Errorf("RTP extension header truncated")
+		}
+		fields["extensionProfile"] = int64(r.ReadBits(16))
+		extLen := r.ReadBits(16) * 4
+		headerSize += 4 + extLen
+		if len(rawBuf) < headerSize {
+			return nil, fmt.Errorf("RTP extension of %d bytes exceeds packet size %d", extLen, len(rawBuf))
+		}
+		for i := 0; i < extLen; i++ {
+			r.ReadBits(8)
+		}
 	}
 
 	remainedBuf := r.GetRemainedBuffer()
 	nPad := 0
-	if bPad {
+	if bPad && len(remainedBuf) > 0 {
 		nPad = int(remainedBuf[len(remainedBuf) - 1])
 	}
+	if nPad > len(remainedBuf) {
+		return nil, fmt.Errorf("RTP padding of %d bytes exceeds payload size %d", nPad, len(remainedBuf))
+	}
 	res[0] = ParseResult{
 		Buffer: remainedBuf[:(len(remainedBuf) - nPad)],
 		Fields: fields,
 	}
 
-	return res
+	return res, nil
 }
 
 func RtpParser() IParser {
@@ -13,7 +13,7 @@ type TsProtocolParser struct {
 	count    int
 }
 
-func (ts *TsProtocolParser) Parse(data *ParseResult) []ParseResult {
+func (ts *TsProtocolParser) Parse(data *ParseResult) ([]ParseResult, error) {
 	rawBuf := data.GetBuffer()
 	res := []ParseResult{}
 	nPackets := len(rawBuf) / TS_PKT_SIZE
@@ -24,7 +24,7 @@ func (ts *TsProtocolParser) Parse(data *ParseResult) []ParseResult {
 			},
 		)
 	}
-	return res
+	return res, nil
 }
 
 func TsParser() IParser {
@@ -0,0 +1,244 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tony-507/analyzers/src/logging"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+	"github.com/tony-507/analyzers/src/plugins/dataHandler/utils"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+// ISO/IEC 14496-3 Table 1.18
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+const (
+	_ADTS_HEADER_SIZE = 7
+	_LOAS_HEADER_SIZE = 3
+)
+
+type aacFrame struct {
+	codec      string
+	profile    int // Audio object type
+	sampleRate int
+	channels   int
+	frameLen   int // Including header
+	numBlocks  int // Number of raw data blocks
+}
+
+type aacHandler struct {
+	logger    logging.Log
+	pid       int
+	pesCnt    int
+	residual  []byte // Incomplete header at the end of last PES packet, counted as a frame of that packet
+	skipLen   int    // Bytes of last frame in the next PES packet
+	lastFrame aacFrame
+	nSyncErr  int
+}
+
+// ISO/IEC 13818-7 6.2
+func readAdtsHeader(buf []byte) (aacFrame, error) {
+	frame := aacFrame{codec: "AAC-ADTS"}
+	r := io.GetBufferReader(buf)
+	if r.ReadBits(12) != 0xfff {
+		return frame, errors.New("ADTS syncword not found")
+	}
+	r.ReadBits(1) // ID
+	r.ReadBits(2) // layer
+	protectionAbsent := r.ReadBits(1)
+	frame.profile = r.ReadBits(2) + 1
+	sfIdx := r.ReadBits(4)
+	if sfIdx >= len(aacSampleRates) {
+		return frame, errors.New(fmt.Sprintf("Invalid sampling_frequency_index %d", sfIdx))
+	}
+	frame.sampleRate = aacSampleRates[sfIdx]
+	r.ReadBits(1) // private_bit
+	frame.channels = r.ReadBits(3)
+	r.ReadBits(4) // original_copy, home, copyright_identification_bit and copyright_identification_start
+	frame.frameLen = r.ReadBits(13)
+	r.ReadBits(11) // adts_buffer_fullness
+	frame.numBlocks = r.ReadBits(2) + 1
+	headerLen := _ADTS_HEADER_SIZE
+	if protectionAbsent == 0 {
+		headerLen += 2
+	}
+	if frame.frameLen < headerLen {
+		return frame, errors.New(fmt.Sprintf("Invalid ADTS frame length %d", frame.frameLen))
+	}
+	return frame, nil
+}
+
+// ISO/IEC 14496-3 1.7.3 LatmGetValue
+func latmGetValue(r *io.BsReader) int {
+	bytesForValue := r.ReadBits(2)
+	value := 0
+	for i := 0; i <= bytesForValue; i++ {
+		value = (value << 8) + r.ReadBits(8)
+	}
+	return value
+}
+
+// ISO/IEC 14496-3 1.7.2 AudioSyncStream, with StreamMuxConfig parsed up to AudioSpecificConfig
+func readLoasHeader(buf []byte, last aacFrame) (aacFrame, error) {
+	frame := aacFrame{codec: "AAC-LATM", numBlocks: 1}
+	r := io.GetBufferReader(buf)
+	if r.ReadBits(11) != 0x2b7 {
+		return frame, errors.New("LOAS syncword not found")
+	}
+	frame.frameLen = r.ReadBits(13) + _LOAS_HEADER_SIZE
+
+	useSameStreamMux := r.ReadBits(1) != 0
+	if useSameStreamMux {
+		if last.sampleRate == 0 {
+			return frame, errors.New("LATM frame refers to StreamMuxConfig not yet received")
+		}
+		frame.profile = last.profile
+		frame.sampleRate = last.sampleRate
+		frame.channels = last.channels
+		return frame, nil
+	}
+
+	audioMuxVersion := r.ReadBits(1)
+	audioMuxVersionA := 0
+	if audioMuxVersion == 1 {
+		audioMuxVersionA = r.ReadBits(1)
+	}
+	if audioMuxVersionA != 0 {
+		return frame, errors.New("audioMuxVersionA 1 is not supported")
+	}
+	if audioMuxVersion == 1 {
+		latmGetValue(&r) // taraBufferFullness
+	}
+	r.ReadBits(1) // allStreamsSameTimeFraming
+	r.ReadBits(6) // numSubFrames
+	r.ReadBits(4) // numProgram
+	r.ReadBits(3) // numLayer
+	if audioMuxVersion == 1 {
+		latmGetValue(&r) // ascLen
+	}
+
+	// AudioSpecificConfig
+	frame.profile = r.ReadBits(5)
+	if frame.profile == 31 {
+		frame.profile = 32 + r.ReadBits(6)
+	}
+	sfIdx := r.ReadBits(4)
+	if sfIdx == 0xf {
+		frame.sampleRate = r.ReadBits(24)
+	} else if sfIdx < len(aacSampleRates) {
+		frame.sampleRate = aacSampleRates[sfIdx]
+	} else {
+		return frame, errors.New(fmt.Sprintf("Invalid samplingFrequencyIndex %d", sfIdx))
+	}
+	frame.channels = r.ReadBits(4)
+	return frame, nil
+}
+
+// Parse a frame header at the start of buf. Return nil error with zero frame length if the header is incomplete
+func (h *aacHandler) readFrameHeader(buf []byte) (aacFrame, error) {
+	if len(buf) < 2 {
+		return aacFrame{}, nil
+	}
+	switch {
+	case buf[0] == 0xff && buf[1]&0xf0 == 0xf0:
+		if len(buf) < _ADTS_HEADER_SIZE {
+			return aacFrame{}, nil
+		}
+		return readAdtsHeader(buf)
+	case buf[0] == 0x56 && buf[1]&0xe0 == 0xe0:
+		// StreamMuxConfig is at most a few bytes after the sync layer
+		if len(buf) < _LOAS_HEADER_SIZE+8 {
+			return aacFrame{}, nil
+		}
+		return readLoasHeader(buf, h.lastFrame)
+	default:
+		return aacFrame{}, errors.New(fmt.Sprintf("No AAC syncword found, got 0x%02x%02x", buf[0], buf[1]))
+	}
+}
+
+// Return the position of the next ADTS or LOAS syncword, or -1 if not found
+func findAacSync(buf []byte) int {
+	for i := 0; i+1 < len(buf); i++ {
+		if (buf[i] == 0xff && buf[i+1]&0xf0 == 0xf0) || (buf[i] == 0x56 && buf[i+1]&0xe0 == 0xe0) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *aacHandler) Feed(unit tttKernel.CmUnit, newData *utils.ParsedData) error {
+	h.pesCnt += 1
+	payload := tttKernel.GetBytesInBuf(unit)
+	data := newData.GetAudioData()
+
+	buf := payload
+	if h.skipLen > 0 {
+		if h.skipLen >= len(buf) {
+			h.skipLen -= len(buf)
+			buf = []byte{}
+		} else {
+			buf = buf[h.skipLen:]
+			h.skipLen = 0
+		}
+	}
+	if len(h.residual) != 0 {
+		buf = append(h.residual, buf...)
+	}
+
+	frameCnt := 0
+	if len(h.residual) != 0 {
+		// The first frame has been counted in last PES packet
+		frameCnt -= 1
+	}
+	h.residual = nil
+	for len(buf) > 0 {
+		frame, err := h.readFrameHeader(buf)
+		if err != nil {
+			h.nSyncErr++
+			h.logger.Error("[%d] At PES packet #%d, %s", h.pid, h.pesCnt, err.Error())
+			syncPos := findAacSync(buf[1:])
+			if syncPos == -1 {
+				break
+			}
+			buf = buf[(1 + syncPos):]
+			continue
+		}
+		if frame.frameLen == 0 {
+			// Header continues in the next PES packet
+			h.residual = append([]byte{}, buf...)
+			frameCnt += 1
+			break
+		}
+
+		if frame.sampleRate != h.lastFrame.sampleRate || frame.channels != h.lastFrame.channels {
+			h.logger.Info("[%d] At PES packet #%d, %s with sample rate %d and channel configuration %d",
+				h.pid, h.pesCnt, frame.codec, frame.sampleRate, frame.channels)
+		}
+		h.lastFrame = frame
+		frameCnt += frame.numBlocks
+
+		if frame.frameLen > len(buf) {
+			h.skipLen = frame.frameLen - len(buf)
+			break
+		}
+		buf = buf[frame.frameLen:]
+	}
+
+	data.Codec = h.lastFrame.codec
+	data.SampleRate = h.lastFrame.sampleRate
+	data.Channels = h.lastFrame.channels
+	data.FrameCount = frameCnt
+	data.SamplesPerFrame = 1024
+	data.Metadata["profile"] = h.lastFrame.profile
+	data.Metadata["syncErrors"] = h.nSyncErr
+	return nil
+}
+
+func AACHandler(pid int) utils.DataHandler {
+	return &aacHandler{
+		logger: logging.CreateLogger(fmt.Sprintf("AAC_%d", pid)),
+		pid:    pid,
+		pesCnt: 0,
+	}
+}
@@ -1,46 +1,368 @@
 package audio
 
 import (
+	"errors"
 	"fmt"
 
-	"github.com/tony-507/analyzers/src/tttKernel"
 	"github.com/tony-507/analyzers/src/logging"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
 	"github.com/tony-507/analyzers/src/plugins/dataHandler/utils"
+	"github.com/tony-507/analyzers/src/tttKernel"
 )
 
+// ETSI TS 102 366 Table 4.13, in kbps
+var ac3Bitrates = []int{32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 448, 512, 576, 640}
+
+var ac3SampleRates = []int{48000, 44100, 32000}
+
+// Number of full bandwidth channels indexed by acmod
+var ac3Channels = []int{2, 1, 2, 3, 3, 4, 4, 5}
+
+var eac3Blocks = []int{1, 2, 3, 6}
+
+const (
+	_AC3_SYNC_WORD     = 0x0b77
+	_AC3_SYNC_INFO_LEN = 5  // Enough to know the frame length
+	_AC3_MIN_FRAME_LEN = 64 // BSI up to bsmod always lies within
+)
+
+type ac3Frame struct {
+	codec       string
+	bsid        int
+	strmtyp     int
+	substreamid int
+	sampleRate  int
+	numBlocks   int // Number of audio blocks, each has 256 samples
+	frameLen    int
+	bitrate     int // kbps
+	bsmod       int
+	acmod       int
+	lfeon       int
+	dialnorm    int // dB
+}
+
 type ac3Handler struct {
-	logger     logging.Log
-	pid        int
-	pesCnt     int
-	curPesSize int
-	bInit      bool
+	logger    logging.Log
+	pid       int
+	pesCnt    int
+	residual  []byte // Incomplete header at the end of last PES packet, counted as a frame of that packet
+	skipLen   int    // Bytes of last frame in the next PES packet
+	lastFrame ac3Frame
+	nSyncErr  int
+	nBsmodChg int
+}
+
+// Dialogue normalization in dB, where 0 is reserved and treated as -31 dB
+func ac3Dialnorm(dialnorm int) int {
+	if dialnorm == 0 {
+		return -31
+	}
+	return -dialnorm
+}
+
+// Return frame length in bytes from the syncinfo and the start of BSI
+func ac3FrameLen(buf []byte) (int, error) {
+	if (int(buf[0])<<8)|int(buf[1]) != _AC3_SYNC_WORD {
+		return 0, errors.New(fmt.Sprintf("AC-3 syncword not found, got 0x%02x%02x", buf[0], buf[1]))
+	}
+	if int(buf[5])>>3 > 10 {
+		// E-AC-3 frmsiz
+		return ((int(buf[2]&0x07) << 8) | int(buf[3]) + 1) * 2, nil
+	}
+
+	fscod := int(buf[4]) >> 6
+	frmsizecod := int(buf[4]) & 0x3f
+	if fscod == 3 || frmsizecod >= 2*len(ac3Bitrates) {
+		return 0, errors.New(fmt.Sprintf("Invalid fscod %d or frmsizecod %d", fscod, frmsizecod))
+	}
+	bitrate := ac3Bitrates[frmsizecod>>1]
+	switch fscod {
+	case 0:
+		return bitrate * 4, nil
+	case 1:
+		return (bitrate*1000*1536/(44100*16) + (frmsizecod & 1)) * 2, nil
+	default:
+		return bitrate * 6, nil
+	}
+}
+
+// ETSI TS 102 366 5.3 and 5.4, up to dialnorm
+func readAc3Bsi(buf []byte) (ac3Frame, error) {
+	frame := ac3Frame{codec: "AC-3", numBlocks: 6}
+	r := io.GetBufferReader(buf)
+	r.ReadBits(16) // syncword
+	r.ReadBits(16) // crc1
+	fscod := r.ReadBits(2)
+	frmsizecod := r.ReadBits(6)
+	frame.sampleRate = ac3SampleRates[fscod]
+	frame.bitrate = ac3Bitrates[frmsizecod>>1]
+	frame.bsid = r.ReadBits(5)
+	frame.bsmod = r.ReadBits(3)
+	frame.acmod = r.ReadBits(3)
+	if frame.acmod&0x1 != 0 && frame.acmod != 0x1 {
+		r.ReadBits(2) // cmixlev
+	}
+	if frame.acmod&0x4 != 0 {
+		r.ReadBits(2) // surmixlev
+	}
+	if frame.acmod == 0x2 {
+		r.ReadBits(2) // dsurmod
+	}
+	frame.lfeon = r.ReadBits(1)
+	frame.dialnorm = ac3Dialnorm(r.ReadBits(5))
+	return frame, nil
+}
+
+// ETSI TS 102 366 E.1.2, up to bsmod
+func readEac3Bsi(buf []byte) (ac3Frame, error) {
+	frame := ac3Frame{codec: "E-AC-3"}
+	r := io.GetBufferReader(buf)
+	r.ReadBits(16) // syncword
+	frame.strmtyp = r.ReadBits(2)
+	frame.substreamid = r.ReadBits(3)
+	frame.frameLen = (r.ReadBits(11) + 1) * 2
+	fscod := r.ReadBits(2)
+	if fscod == 0x3 {
+		fscod2 := r.ReadBits(2)
+		if fscod2 == 0x3 {
+			return frame, errors.New("Invalid fscod2 3")
+		}
+		frame.sampleRate = ac3SampleRates[fscod2] / 2
+		frame.numBlocks = 6
+	} else {
+		frame.sampleRate = ac3SampleRates[fscod]
+		frame.numBlocks = eac3Blocks[r.ReadBits(2)]
+	}
+	frame.bitrate = frame.frameLen * 8 * frame.sampleRate / (frame.numBlocks * 256) / 1000
+	frame.acmod = r.ReadBits(3)
+	frame.lfeon = r.ReadBits(1)
+	frame.bsid = r.ReadBits(5)
+	frame.dialnorm = ac3Dialnorm(r.ReadBits(5))
+	if r.ReadBits(1) != 0 {
+		r.ReadBits(8) // compr
+	}
+	if frame.acmod == 0x0 {
+		r.ReadBits(5) // dialnorm2
+		if r.ReadBits(1) != 0 {
+			r.ReadBits(8) // compr2
+		}
+	}
+	if frame.strmtyp == 0x1 && r.ReadBits(1) != 0 {
+		r.ReadBits(16) // chanmap
+	}
+
+	// Mixing metadata
+	if r.ReadBits(1) != 0 {
+		if frame.acmod > 0x2 {
+			r.ReadBits(2) // dmixmod
+		}
+		if frame.acmod&0x1 != 0 && frame.acmod > 0x2 {
+			r.ReadBits(6) // ltrtcmixlev and lorocmixlev
+		}
+		if frame.acmod&0x4 != 0 {
+			r.ReadBits(6) // ltrtsurmixlev and lorosurmixlev
+		}
+		if frame.lfeon != 0 && r.ReadBits(1) != 0 {
+			r.ReadBits(5) // lfemixlevcod
+		}
+		if frame.strmtyp == 0x0 {
+			if r.ReadBits(1) != 0 {
+				r.ReadBits(6) // pgmscl
+			}
+			if frame.acmod == 0x0 && r.ReadBits(1) != 0 {
+				r.ReadBits(6) // pgmscl2
+			}
+			if r.ReadBits(1) != 0 {
+				r.ReadBits(6) // extpgmscl
+			}
+			switch r.ReadBits(2) {
+			case 0x1:
+				r.ReadBits(5) // premixcmpsel, drcsrc and premixcmpscl
+			case 0x2:
+				r.ReadBits(12) // mixdata
+			case 0x3:
+				mixdeflen := r.ReadBits(5)
+				if r.GetPos()+mixdeflen+2+4 > r.GetSize() {
+					return frame, errors.New(fmt.Sprintf("Invalid mixdeflen %d", mixdeflen))
+				}
+				for i := 0; i < mixdeflen+2; i++ {
+					r.ReadBits(8) // mixdata
+				}
+			}
+			if frame.acmod < 0x2 {
+				if r.ReadBits(1) != 0 {
+					r.ReadBits(14) // panmean and paninfo
+				}
+				if frame.acmod == 0x0 && r.ReadBits(1) != 0 {
+					r.ReadBits(14) // panmean2 and paninfo2
+				}
+			}
+			if r.ReadBits(1) != 0 {
+				if frame.numBlocks == 1 {
+					r.ReadBits(5) // blkmixcfginfo
+				} else {
+					for blk := 0; blk < frame.numBlocks; blk++ {
+						if r.ReadBits(1) != 0 {
+							r.ReadBits(5) // blkmixcfginfo
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Informational metadata, bsmod is unchanged if absent
+	frame.bsmod = -1
+	if r.ReadBits(1) != 0 {
+		frame.bsmod = r.ReadBits(3)
+	}
+	return frame, nil
+}
+
+// Parse a syncframe header at the start of buf. Return nil error with zero frame length if the header is incomplete
+func (h *ac3Handler) readFrameHeader(buf []byte) (ac3Frame, error) {
+	if len(buf) < _AC3_SYNC_INFO_LEN+1 {
+		return ac3Frame{}, nil
+	}
+	frameLen, err := ac3FrameLen(buf)
+	if err != nil {
+		return ac3Frame{}, err
+	}
+	if frameLen < _AC3_MIN_FRAME_LEN {
+		return ac3Frame{}, errors.New(fmt.Sprintf("Invalid syncframe length %d", frameLen))
+	}
+	if len(buf) < _AC3_MIN_FRAME_LEN {
+		return ac3Frame{}, nil
+	}
+
+	var frame ac3Frame
+	if buf[5]>>3 > 10 {
+		frame, err = readEac3Bsi(buf[:_AC3_MIN_FRAME_LEN])
+	} else {
+		frame, err = readAc3Bsi(buf[:_AC3_MIN_FRAME_LEN])
+	}
+	frame.frameLen = frameLen
+	return frame, err
+}
+
+// Return the position of the next syncword, or -1 if not found
+func findAc3Sync(buf []byte) int {
+	for i := 0; i+1 < len(buf); i++ {
+		if buf[i] == 0x0b && buf[i+1] == 0x77 {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *ac3Handler) checkFrame(frame ac3Frame) {
+	last := h.lastFrame
+	if last.sampleRate == 0 {
+		h.logger.Info("[%d] At PES packet #%d, %s with sample rate %d, acmod %d, bsmod %d and dialnorm %d dB",
+			h.pid, h.pesCnt, frame.codec, frame.sampleRate, frame.acmod, frame.bsmod, frame.dialnorm)
+		return
+	}
+	if frame.bsmod != last.bsmod {
+		h.nBsmodChg++
+		h.logger.Info("[%d] At PES packet #%d, bsmod changes from %d to %d", h.pid, h.pesCnt, last.bsmod, frame.bsmod)
+	}
+	if frame.acmod != last.acmod || frame.lfeon != last.lfeon {
+		h.logger.Info("[%d] At PES packet #%d, acmod changes from %d to %d, lfeon from %d to %d",
+			h.pid, h.pesCnt, last.acmod, frame.acmod, last.lfeon, frame.lfeon)
+	}
+	if frame.dialnorm != last.dialnorm {
+		h.logger.Info("[%d] At PES packet #%d, dialnorm changes from %d dB to %d dB", h.pid, h.pesCnt, last.dialnorm, frame.dialnorm)
+	}
+	if frame.sampleRate != last.sampleRate || frame.bitrate != last.bitrate {
+		h.logger.Info("[%d] At PES packet #%d, %s with sample rate %d and bitrate %d kbps",
+			h.pid, h.pesCnt, frame.codec, frame.sampleRate, frame.bitrate)
+	}
 }
 
 func (h *ac3Handler) Feed(unit tttKernel.CmUnit, newData *utils.ParsedData) error {
 	h.pesCnt += 1
-	cmBuf := unit.GetBuf()
-	size, ok := tttKernel.GetBufFieldAsInt(cmBuf, "size")
-	if !ok {
-		return nil
+	payload := tttKernel.GetBytesInBuf(unit)
+	data := newData.GetAudioData()
+
+	buf := payload
+	if h.skipLen > 0 {
+		if h.skipLen >= len(buf) {
+			h.skipLen -= len(buf)
+			buf = []byte{}
+		} else {
+			buf = buf[h.skipLen:]
+			h.skipLen = 0
+		}
+	}
+
+	frameCnt := 0
+	if len(h.residual) != 0 {
+		// The first frame has been counted in last PES packet
+		buf = append(h.residual, buf...)
+		frameCnt -= 1
 	}
-	if h.curPesSize != size {
-		if h.curPesSize == 0 {
-			h.curPesSize = size
+	h.residual = nil
+	for len(buf) > 0 {
+		frame, err := h.readFrameHeader(buf)
+		if err != nil {
+			h.nSyncErr++
+			h.logger.Error("[%d] At PES packet #%d, %s", h.pid, h.pesCnt, err.Error())
+			syncPos := findAc3Sync(buf[1:])
+			if syncPos == -1 {
+				break
+			}
+			buf = buf[(1 + syncPos):]
+			continue
+		}
+		if frame.frameLen == 0 {
+			// Header continues in the next PES packet
+			h.residual = append([]byte{}, buf...)
+			frameCnt += 1
+			break
 		}
-		if h.curPesSize != size {
-			h.logger.Info("[%d] At PES packet #%d, PES packet size changes from %d to %d", h.pid, h.pesCnt, h.curPesSize, size)
-			h.curPesSize = size
+
+		// Dependent substreams and additional independent substreams belong to the same audio frame
+		if frame.strmtyp != 0x1 && frame.substreamid == 0 {
+			if frame.bsmod == -1 {
+				frame.bsmod = h.lastFrame.bsmod
+			}
+			h.checkFrame(frame)
+			h.lastFrame = frame
+			frameCnt += 1
+		}
+
+		if frame.frameLen > len(buf) {
+			h.skipLen = frame.frameLen - len(buf)
+			break
 		}
+		buf = buf[frame.frameLen:]
 	}
+
+	data.Codec = h.lastFrame.codec
+	data.SampleRate = h.lastFrame.sampleRate
+	data.Channels = ac3Channels[h.lastFrame.acmod] + h.lastFrame.lfeon
+	data.FrameCount = frameCnt
+	data.SamplesPerFrame = h.lastFrame.numBlocks * 256
+	data.Metadata["bsid"] = h.lastFrame.bsid
+	data.Metadata["bsmod"] = h.lastFrame.bsmod
+	data.Metadata["acmod"] = h.lastFrame.acmod
+	data.Metadata["lfeon"] = h.lastFrame.lfeon
+	data.Metadata["dialnorm"] = h.lastFrame.dialnorm
+	data.Metadata["bitrate"] = h.lastFrame.bitrate
+	data.Metadata["syncErrors"] = h.nSyncErr
+	data.Metadata["bsmodChanges"] = h.nBsmodChg
 	return nil
 }
 
 func AC3Handler(pid int) utils.DataHandler {
 	return &ac3Handler{
-		logger: logging.CreateLogger(fmt.Sprintf("AC3_%d", pid)),
-		pid: pid,
-		pesCnt: 0,
-		curPesSize: 0,
-		bInit: false,
+		logger:    logging.CreateLogger(fmt.Sprintf("AC3_%d", pid)),
+		pid:       pid,
+		pesCnt:    0,
+		residual:  nil,
+		skipLen:   0,
+		lastFrame: ac3Frame{},
+		nSyncErr:  0,
+		nBsmodChg: 0,
 	}
 }
@@ -0,0 +1,130 @@
+package audio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-507/analyzers/src/plugins/common"
+	"github.com/tony-507/analyzers/src/plugins/dataHandler/utils"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+// AAC LC, 48 kHz, stereo, frame length 16 bytes
+var adtsFrame = []byte{
+	0xff, 0xf1, 0x4c, 0x80, 0x02, 0x1f, 0xfc, 0xaa,
+	0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa,
+}
+
+func feedAudio(handle utils.DataHandler, payload []byte) *utils.AudioDataStruct {
+	unit := common.NewMediaUnit(tttKernel.MakeSimpleBuf(payload), common.UNKNOWN_UNIT)
+	newData := utils.CreateParsedData()
+	handle.Feed(unit, &newData)
+	return newData.GetAudioData()
+}
+
+func TestAdtsAcrossPes(t *testing.T) {
+	handle := AACHandler(256)
+
+	// Two frames, with the second frame split inside its header
+	pes1 := append(append([]byte{}, adtsFrame...), adtsFrame[:3]...)
+	pes2 := append(append([]byte{}, adtsFrame[3:]...), adtsFrame...)
+	// Frame split inside its payload
+	pes3 := adtsFrame[:10]
+	pes4 := adtsFrame[10:]
+
+	expected := []int{2, 1, 1, 0}
+	for idx, pes := range [][]byte{pes1, pes2, pes3, pes4} {
+		data := feedAudio(handle, pes)
+		assert.Equal(t, expected[idx], data.FrameCount, "Wrong frame count at PES packet #%d", idx+1)
+		assert.Equal(t, "AAC-ADTS", data.Codec)
+		assert.Equal(t, 48000, data.SampleRate)
+		assert.Equal(t, 2, data.Channels)
+		assert.Equal(t, 2, data.Metadata["profile"])
+		assert.Equal(t, 0, data.Metadata["syncErrors"])
+	}
+}
+
+func TestAdtsResync(t *testing.T) {
+	handle := AACHandler(256)
+
+	pes := append([]byte{0x00, 0x01, 0x02}, adtsFrame...)
+	data := feedAudio(handle, pes)
+
+	assert.Equal(t, 1, data.FrameCount)
+	assert.Equal(t, 1, data.Metadata["syncErrors"])
+}
+
+func TestLatm(t *testing.T) {
+	handle := AACHandler(256)
+
+	// First frame carries StreamMuxConfig, second frame reuses it
+	pes := []byte{
+		0x56, 0xe0, 0x0d, 0x20, 0x00, 0x11, 0x90, 0xaa,
+		0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa,
+		0x56, 0xe0, 0x0d, 0x80, 0xaa, 0xaa, 0xaa, 0xaa,
+		0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa,
+	}
+	data := feedAudio(handle, pes)
+
+	assert.Equal(t, "AAC-LATM", data.Codec)
+	assert.Equal(t, 2, data.FrameCount)
+	assert.Equal(t, 48000, data.SampleRate)
+	assert.Equal(t, 2, data.Channels)
+	assert.Equal(t, 2, data.Metadata["profile"])
+	assert.Equal(t, 0, data.Metadata["syncErrors"])
+}
+
+func makeSyncFrame(header []byte, frameLen int) []byte {
+	frame := make([]byte, frameLen)
+	copy(frame, header)
+	return frame
+}
+
+func TestAc3SyncFrames(t *testing.T) {
+	handle := AC3Handler(257)
+
+	// 48 kHz, 32 kbps, 2/0 with bsmod 0 and dialnorm -27 dB
+	frame1 := makeSyncFrame([]byte{0x0b, 0x77, 0x00, 0x00, 0x00, 0x40, 0x43, 0x60}, 128)
+	// Same with bsmod 2 and dialnorm -20 dB
+	frame2 := makeSyncFrame([]byte{0x0b, 0x77, 0x00, 0x00, 0x00, 0x42, 0x42, 0x80}, 128)
+
+	data := feedAudio(handle, append(append([]byte{}, frame1...), frame1...))
+	assert.Equal(t, "AC-3", data.Codec)
+	assert.Equal(t, 2, data.FrameCount)
+	assert.Equal(t, 48000, data.SampleRate)
+	assert.Equal(t, 2, data.Channels)
+	assert.Equal(t, 1536, data.SamplesPerFrame)
+	assert.Equal(t, 32, data.Metadata["bitrate"])
+	assert.Equal(t, 8, data.Metadata["bsid"])
+	assert.Equal(t, 0, data.Metadata["bsmod"])
+	assert.Equal(t, -27, data.Metadata["dialnorm"])
+
+	// Garbage before the syncframe
+	data = feedAudio(handle, append([]byte{0x00, 0x0b}, frame2...))
+	assert.Equal(t, 1, data.FrameCount)
+	assert.Equal(t, 2, data.Metadata["bsmod"])
+	assert.Equal(t, -20, data.Metadata["dialnorm"])
+	assert.Equal(t, 1, data.Metadata["syncErrors"])
+	assert.Equal(t, 1, data.Metadata["bsmodChanges"])
+}
+
+func TestEac3SyncFrames(t *testing.T) {
+	handle := AC3Handler(257)
+
+	// Independent substream: 48 kHz, 6 blocks, 3/2 with LFE, dialnorm -24 dB and bsmod 7
+	indep := makeSyncFrame([]byte{0x0b, 0x77, 0x00, 0x3f, 0x3f, 0x86, 0x0f, 0x00}, 128)
+	// Dependent substream of the same frame
+	dep := makeSyncFrame([]byte{0x0b, 0x77, 0x40, 0x1f, 0x34, 0x86, 0x00, 0x00}, 64)
+
+	data := feedAudio(handle, append(append([]byte{}, indep...), dep...))
+	assert.Equal(t, "E-AC-3", data.Codec)
+	assert.Equal(t, 1, data.FrameCount)
+	assert.Equal(t, 48000, data.SampleRate)
+	assert.Equal(t, 6, data.Channels)
+	assert.Equal(t, 1536, data.SamplesPerFrame)
+	assert.Equal(t, 32, data.Metadata["bitrate"])
+	assert.Equal(t, 16, data.Metadata["bsid"])
+	assert.Equal(t, 7, data.Metadata["bsmod"])
+	assert.Equal(t, -24, data.Metadata["dialnorm"])
+	assert.Equal(t, 0, data.Metadata["syncErrors"])
+}
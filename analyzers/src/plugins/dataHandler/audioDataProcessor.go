@@ -0,0 +1,133 @@
+package dataHandler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tony-507/analyzers/src/logging"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+	"github.com/tony-507/analyzers/src/plugins/dataHandler/utils"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+const (
+	_PTS_WRAP         = 1 << 33
+	_PTS_TOLERANCE    = 2
+	_AUDIO_CLOCK_RATE = 90000
+)
+
+type audioTrack struct {
+	last           utils.AudioDataStruct
+	writer         io.FileWriter
+	nFrames        int
+	nDiscontinuity int
+}
+
+type audioDataProcessorStruct struct {
+	tracks map[int]*audioTrack
+	outDir string
+	logger logging.Log
+}
+
+func (ap *audioDataProcessorStruct) Start() error {
+	return nil
+}
+
+func (ap *audioDataProcessorStruct) Stop() error {
+	for _, track := range ap.tracks {
+		track.writer.Close()
+	}
+	return nil
+}
+
+func (ap *audioDataProcessorStruct) Process(unit tttKernel.CmUnit, parsedData *utils.ParsedData) error {
+	if parsedData.GetType() != utils.PARSED_AUDIO {
+		return nil
+	}
+
+	cmBuf := unit.GetBuf()
+	pid, _ := tttKernel.GetBufFieldAsInt(cmBuf, "pid")
+	pts, _ := tttKernel.GetBufFieldAsInt(cmBuf, "pts")
+
+	data := parsedData.GetAudioData()
+	data.Pts = pts
+
+	track, ok := ap.tracks[pid]
+	if !ok {
+		track = &audioTrack{
+			last:   utils.AudioData(),
+			writer: io.CsvWriter(ap.outDir, fmt.Sprintf("audio_%d.csv", pid)),
+		}
+		if err := track.writer.Open(); err != nil {
+			ap.logger.Warn("[%d] Skip writing audio data due to %s", pid, err.Error())
+		}
+		ap.tracks[pid] = track
+	}
+
+	if !ap.validatePts(track, data) {
+		track.nDiscontinuity++
+		ap.logger.Error("[%d] PTS discontinuity: expected %d, got %d",
+			pid, ap.nextPts(&track.last), data.Pts)
+	}
+
+	track.nFrames += data.FrameCount
+	track.last = *data
+
+	cmBuf, err := data.ToCmBuf()
+	if err != nil {
+		return err
+	}
+	track.writer.Write(cmBuf)
+	return nil
+}
+
+// Expected PTS of the next PES packet given the last one
+func (ap *audioDataProcessorStruct) nextPts(last *utils.AudioDataStruct) int {
+	if last.SampleRate == 0 {
+		return last.Pts
+	}
+	duration := last.FrameCount * last.SamplesPerFrame * _AUDIO_CLOCK_RATE / last.SampleRate
+	return (last.Pts + duration) % _PTS_WRAP
+}
+
+func (ap *audioDataProcessorStruct) validatePts(track *audioTrack, data *utils.AudioDataStruct) bool {
+	if track.last.Pts == -1 || data.Pts == -1 || track.last.SampleRate == 0 {
+		return true
+	}
+
+	diff := data.Pts - ap.nextPts(&track.last)
+	if diff > _PTS_WRAP/2 {
+		diff -= _PTS_WRAP
+	} else if diff < -_PTS_WRAP/2 {
+		diff += _PTS_WRAP
+	}
+	return diff <= _PTS_TOLERANCE && diff >= -_PTS_TOLERANCE
+}
+
+func (ap *audioDataProcessorStruct) PrintInfo(sb *strings.Builder) {
+	if len(ap.tracks) == 0 {
+		return
+	}
+	sb.WriteString("\tAudio processor:\n")
+
+	pids := []int{}
+	for pid := range ap.tracks {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+
+	for _, pid := range pids {
+		track := ap.tracks[pid]
+		sb.WriteString(fmt.Sprintf("\t\t[%d] %s, %d Hz, channel configuration %d, %d frames, PTS discontinuities: %d\n",
+			pid, track.last.Codec, track.last.SampleRate, track.last.Channels, track.nFrames, track.nDiscontinuity))
+	}
+}
+
+func audioDataProcessor(outDir string) utils.DataProcessor {
+	return &audioDataProcessorStruct{
+		tracks: map[int]*audioTrack{},
+		outDir: outDir,
+		logger: logging.CreateLogger("AudioDataProcessor"),
+	}
+}
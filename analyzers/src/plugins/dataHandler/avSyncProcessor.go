@@ -0,0 +1,210 @@
+package dataHandler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tony-507/analyzers/src/logging"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+	"github.com/tony-507/analyzers/src/plugins/dataHandler/utils"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+const _AV_SYNC_REPORT_INTERVAL = 90000 // Write a report every second of PCR
+
+/*
+ * A/V sync is measured as the difference between the buffering delays of
+ * audio and video, i.e. (PTS - PCR) of an audio PES against the latest
+ * video PES of the same program. Video uses DTS to avoid the variation from
+ * frame reordering. The threshold applies to the offset itself, or in drift
+ * mode to the drift from the first measured offset, for streams whose offset
+ * depends on encoder configuration.
+ */
+
+type avSyncPair struct {
+	progNum     int
+	audioPid    int
+	initOffset  int
+	lastReport  int
+	maxDrift    int
+	nViolation  int
+	inViolation bool
+}
+
+type avSyncVideo struct {
+	pid   int
+	delay int
+}
+
+type avSyncProcessorStruct struct {
+	videos    map[int]avSyncVideo // Latest video delay per program
+	pairs     map[int]*avSyncPair // Per audio pid
+	threshold int                 // In 90 kHz ticks
+	driftMode bool                // Apply threshold to drift instead of offset
+	writer    io.FileWriter
+	logger    logging.Log
+	metrics   dataHandlerMetrics
+}
+
+func (sp *avSyncProcessorStruct) Start() error {
+	return sp.writer.Open()
+}
+
+func (sp *avSyncProcessorStruct) Stop() error {
+	return sp.writer.Close()
+}
+
+// Difference of two 33-bit timestamps, handling wrap around
+func tsDiff(a int, b int) int {
+	diff := (a - b) % _PTS_WRAP
+	if diff >= _PTS_WRAP/2 {
+		diff -= _PTS_WRAP
+	} else if diff < -_PTS_WRAP/2 {
+		diff += _PTS_WRAP
+	}
+	return diff
+}
+
+func (sp *avSyncProcessorStruct) Process(unit tttKernel.CmUnit, parsedData *utils.ParsedData) error {
+	dType := parsedData.GetType()
+	if dType != utils.PARSED_VIDEO && dType != utils.PARSED_AUDIO {
+		return nil
+	}
+
+	cmBuf := unit.GetBuf()
+	progNum, hasProg := tttKernel.GetBufFieldAsInt(cmBuf, "progNum")
+	pid, _ := tttKernel.GetBufFieldAsInt(cmBuf, "pid")
+	pcr, hasPcr := tttKernel.GetBufFieldAsInt(cmBuf, "pcr")
+	pts, hasPts := tttKernel.GetBufFieldAsInt(cmBuf, "pts")
+	if !hasProg || !hasPcr || !hasPts || pcr < 0 || pts < 0 {
+		return nil
+	}
+	stc := pcr / 300
+
+	if dType == utils.PARSED_VIDEO {
+		if dts, ok := tttKernel.GetBufFieldAsInt(cmBuf, "dts"); ok && dts >= 0 {
+			pts = dts
+		}
+		sp.videos[progNum] = avSyncVideo{pid: pid, delay: tsDiff(pts, stc)}
+		return nil
+	}
+
+	video, hasVideo := sp.videos[progNum]
+	if !hasVideo {
+		return nil
+	}
+	audioDelay := tsDiff(pts, stc)
+	offset := audioDelay - video.delay
+
+	pair, ok := sp.pairs[pid]
+	if !ok {
+		pair = &avSyncPair{
+			progNum:    progNum,
+			audioPid:   pid,
+			initOffset: offset,
+			lastReport: stc,
+		}
+		sp.pairs[pid] = pair
+		sp.logger.Info("[%d] A/V sync reference offset against video pid %d in program %d: %d ms",
+			pid, video.pid, progNum, offset/90)
+	}
+
+	drift := offset - pair.initOffset
+	sp.metrics.avSyncDrift.Observe(float64(drift)/90, sp.metrics.name, strconv.Itoa(pid))
+	if drift < 0 && -drift > pair.maxDrift {
+		pair.maxDrift = -drift
+	} else if drift > pair.maxDrift {
+		pair.maxDrift = drift
+	}
+
+	measure, measureName := offset, "offset"
+	if sp.driftMode {
+		measure, measureName = drift, "drift"
+	}
+
+	var err error
+	exceeded := measure > sp.threshold || measure < -sp.threshold
+	if exceeded && !pair.inViolation {
+		pair.nViolation++
+		err = fmt.Errorf("[%d] A/V sync %s %d ms exceeds threshold %d ms at PTS %d",
+			pid, measureName, measure/90, sp.threshold/90, pts)
+	} else if !exceeded && pair.inViolation {
+		sp.logger.Info("[%d] A/V sync %s back to %d ms at PTS %d", pid, measureName, measure/90, pts)
+	}
+	pair.inViolation = exceeded
+
+	if !ok || tsDiff(stc, pair.lastReport) >= _AV_SYNC_REPORT_INTERVAL || exceeded {
+		buf := tttKernel.MakeSchemaBuf(avSyncSchema, []byte{})
+		if err := buf.SetField("pcr", pcr, false); err != nil {
+			return err
+		}
+		if err := buf.SetField("progNum", progNum, false); err != nil {
+			return err
+		}
+		if err := buf.SetField("videoPid", video.pid, false); err != nil {
+			return err
+		}
+		if err := buf.SetField("audioPid", pid, false); err != nil {
+			return err
+		}
+		if err := buf.SetField("videoDelay", video.delay/90, false); err != nil {
+			return err
+		}
+		if err := buf.SetField("audioDelay", audioDelay/90, false); err != nil {
+			return err
+		}
+		if err := buf.SetField("offset", offset/90, false); err != nil {
+			return err
+		}
+		if err := buf.SetField("drift", drift/90, false); err != nil {
+			return err
+		}
+		sp.writer.Write(buf)
+		pair.lastReport = stc
+	}
+	return err
+}
+
+var avSyncSchema = tttKernel.NewSchema("AvSync",
+	tttKernel.Field("pcr", tttKernel.FIELD_INT64),
+	tttKernel.Field("progNum", tttKernel.FIELD_INT64),
+	tttKernel.Field("videoPid", tttKernel.FIELD_INT64),
+	tttKernel.Field("audioPid", tttKernel.FIELD_INT64),
+	tttKernel.Field("videoDelay", tttKernel.FIELD_INT64),
+	tttKernel.Field("audioDelay", tttKernel.FIELD_INT64),
+	tttKernel.Field("offset", tttKernel.FIELD_INT64),
+	tttKernel.Field("drift", tttKernel.FIELD_INT64),
+)
+
+func (sp *avSyncProcessorStruct) PrintInfo(sb *strings.Builder) {
+	if len(sp.pairs) == 0 {
+		return
+	}
+	sb.WriteString("\tA/V sync processor:\n")
+
+	pids := []int{}
+	for pid := range sp.pairs {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+
+	for _, pid := range pids {
+		pair := sp.pairs[pid]
+		sb.WriteString(fmt.Sprintf("\t\t[%d] Program %d, reference offset %d ms, max drift %d ms, threshold violations: %d\n",
+			pid, pair.progNum, pair.initOffset/90, pair.maxDrift/90, pair.nViolation))
+	}
+}
+
+func avSyncProcessor(outDir string, threshold int, driftMode bool, metrics dataHandlerMetrics) utils.DataProcessor {
+	return &avSyncProcessorStruct{
+		videos:    map[int]avSyncVideo{},
+		pairs:     map[int]*avSyncPair{},
+		threshold: threshold * 90,
+		driftMode: driftMode,
+		writer:    io.CsvWriter(outDir, "avsync.csv"),
+		logger:    logging.CreateLogger("AvSyncProcessor"),
+		metrics:   metrics,
+	}
+}
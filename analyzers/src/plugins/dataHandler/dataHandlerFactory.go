@@ -1,6 +1,8 @@
 package dataHandler
 
 import (
+	"encoding/json"
+	"errors"
 	"strings"
 
 	"github.com/tony-507/analyzers/src/logging"
@@ -19,6 +21,22 @@ import (
  * Data processor handles parsed data from data handler
  */
 
+// Metrics updated by data processors
+type dataHandlerMetrics struct {
+	name            string
+	tcDiscontinuity *tttKernel.Counter
+	avSyncDrift     *tttKernel.Histogram
+}
+
+func newDataHandlerMetrics(name string, metrics *tttKernel.MetricsRegistry) dataHandlerMetrics {
+	return dataHandlerMetrics{
+		name:            name,
+		tcDiscontinuity: metrics.Counter("ttt_video_timecode_discontinuities_total", "Video timecode discontinuities", "plugin"),
+		avSyncDrift: metrics.Histogram("ttt_av_sync_drift_ms", "A/V sync drift from the reference offset in ms",
+			[]float64{-100, -40, -20, -10, 0, 10, 20, 40, 100}, "plugin", "pid"),
+	}
+}
+
 type DataHandlerFactoryPlugin struct {
 	logger     logging.Log
 	callback   tttKernel.RequestHandler
@@ -28,6 +46,7 @@ type DataHandlerFactoryPlugin struct {
 	name       string
 	processors []utils.DataProcessor
 	loader   *tttKernel.ResourceLoader
+	param    dataHandlerParams
 }
 
 func (df *DataHandlerFactoryPlugin) SetCallback(callback tttKernel.RequestHandler) {
@@ -35,6 +54,11 @@ func (df *DataHandlerFactoryPlugin) SetCallback(callback tttKernel.RequestHandle
 }
 
 func (df *DataHandlerFactoryPlugin) SetParameter(m_parameter string) {
+	param := defaultDataHandlerParams()
+	if err := json.Unmarshal([]byte(m_parameter), &param); err != nil {
+		panic(err)
+	}
+	df.param = param
 	df._setup()
 }
 
@@ -50,7 +74,10 @@ func (df *DataHandlerFactoryPlugin) _setup() {
 }
 
 func (df *DataHandlerFactoryPlugin) StartSequence() {
-	df.processors = append(df.processors, videoDataProcessor(df.loader.Query("outDir", nil)))
+	metrics := newDataHandlerMetrics(df.name, df.loader.Metrics())
+	df.processors = append(df.processors, videoDataProcessor(df.loader.Query("outDir", nil), metrics))
+	df.processors = append(df.processors, audioDataProcessor(df.loader.Query("outDir", nil)))
+	df.processors = append(df.processors, avSyncProcessor(df.loader.Query("outDir", nil), df.param.AvSyncThreshold, df.param.AvSyncDrift, metrics))
 
 	for _, proc := range df.processors {
 		if err := proc.Start(); err != nil {
@@ -79,7 +106,8 @@ func (df *DataHandlerFactoryPlugin) DeliverUnit(unit tttKernel.CmUnit, inputId s
 	cmBuf := unit.GetBuf()
 	pid, isPidInt := tttKernel.GetBufFieldAsInt(cmBuf, "pid")
 	if !isPidInt {
-		panic("Something wrong with the data")
+		tttKernel.Report_error(df.callback, df.name, tttKernel.SEVERITY_ERROR, inputId, errors.New("unit without pid"))
+		return
 	}
 
 	_, hasPid := df.handlers[pid]
@@ -91,8 +119,12 @@ func (df *DataHandlerFactoryPlugin) DeliverUnit(unit tttKernel.CmUnit, inputId s
 		switch dType {
 		case 2:
 			df.handlers[pid] = video.MPEG2VideoHandler(pid)
+		case 15, 17:
+			df.handlers[pid] = audio.AACHandler(pid)
 		case 27:
 			df.handlers[pid] = video.H264VideoHandler(pid)
+		case 36:
+			df.handlers[pid] = video.H265VideoHandler(pid)
 		case 129:
 			df.handlers[pid] = audio.AC3Handler(pid)
 		case 134:
@@ -110,13 +142,17 @@ func (df *DataHandlerFactoryPlugin) DeliverUnit(unit tttKernel.CmUnit, inputId s
 		switch newData.GetType() {
 		case utils.PARSED_VIDEO:
 			newUnit = common.NewMediaUnit(cmBuf, common.VIDEO_UNIT)
+		case utils.PARSED_AUDIO:
+			newUnit = common.NewMediaUnit(cmBuf, common.AUDIO_UNIT)
 		case utils.PARSED_DATA:
 			newUnit = common.NewMediaUnit(cmBuf, common.DATA_UNIT)
 		default:
 			newUnit = common.NewMediaUnit(cmBuf, common.UNKNOWN_UNIT)
 		}
 		for _, proc := range df.processors {
-			proc.Process(newUnit, &newData)
+			if err := proc.Process(newUnit, &newData); err != nil {
+				tttKernel.Report_error(df.callback, df.name, tttKernel.SEVERITY_WARNING, inputId, err)
+			}
 		}
 	}
 
@@ -160,3 +196,15 @@ func DataHandlerFactory(name string) tttKernel.IPlugin {
 	}
 	return &rv
 }
+
+func init() {
+	tttKernel.RegisterPlugin(tttKernel.PluginInfo{
+		Type:        "DataHandler",
+		Constructor: DataHandlerFactory,
+		Description: "Parse video, audio and data streams from demuxed units",
+		Params: []tttKernel.ParamDef{
+			{Name: "AvSyncThreshold", Type: tttKernel.FIELD_INT64, Default: defaultDataHandlerParams().AvSyncThreshold, Description: "Maximum A/V sync offset in ms before raising an error"},
+			{Name: "AvSyncDrift", Type: tttKernel.FIELD_BOOL, Default: defaultDataHandlerParams().AvSyncDrift, Description: "Apply AvSyncThreshold to the drift from the first offset instead"},
+		},
+	})
+}
@@ -0,0 +1,13 @@
+package dataHandler
+
+type dataHandlerParams struct {
+	AvSyncThreshold int  // Maximum A/V sync offset in ms before raising an error
+	AvSyncDrift     bool // Apply AvSyncThreshold to the drift from the first offset instead
+}
+
+func defaultDataHandlerParams() dataHandlerParams {
+	return dataHandlerParams{
+		AvSyncThreshold: 40,
+		AvSyncDrift:     false,
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/tony-507/analyzers/src/plugins/common"
 	"github.com/tony-507/analyzers/src/plugins/dataHandler/utils"
+	"github.com/tony-507/analyzers/src/tttKernel"
 )
 
 func TestScte35IDR(t *testing.T) {
@@ -15,7 +16,7 @@ func TestScte35IDR(t *testing.T) {
 	 * Drop all expired SCTE-35 splice time
 	 * Should work under splice repetition
 	 */
-	proc, _ := videoDataProcessor("dummy").(*videoDataProcessorStruct)
+	proc, _ := videoDataProcessor("dummy", dataHandlerMetrics{}).(*videoDataProcessorStruct)
 	spliceTimes := []uint64{2233567, 3344567}
 	idrPts := []uint64{1234567, 2233567, 3344577}
 	expected := []bool{true, true, false}
@@ -42,3 +43,99 @@ func TestScte35IDR(t *testing.T) {
 		assert.Equal(t, remaining[idx], len(proc.splicePTS), "Splice PTS not dropped when expired")
 	}
 }
+
+func TestAudioPtsContinuity(t *testing.T) {
+	// 48 kHz AAC has 1920 ticks per frame; PTS wraps at 2^33
+	proc, _ := audioDataProcessor("dummy").(*audioDataProcessorStruct)
+	ptsList := []int{8589931000, 248, 2168, 4089, 8000}
+	frameCnts := []int{2, 1, 1, 1, 1}
+	expected := []int{0, 0, 0, 0, 1}
+
+	for idx, pts := range ptsList {
+		cmBuf := tttKernel.MakeSimpleBuf([]byte{})
+		cmBuf.SetField("pid", 256, false)
+		cmBuf.SetField("pts", pts, false)
+		unit := common.NewMediaUnit(cmBuf, common.AUDIO_UNIT)
+
+		newData := utils.CreateParsedData()
+		data := newData.GetAudioData()
+		data.Codec = "AAC-ADTS"
+		data.SampleRate = 48000
+		data.Channels = 2
+		data.FrameCount = frameCnts[idx]
+		data.SamplesPerFrame = 1024
+		proc.Process(unit, &newData)
+
+		assert.Equal(t, expected[idx], proc.tracks[256].nDiscontinuity, "Wrong PTS discontinuity count at #%d", idx)
+	}
+	assert.Equal(t, 6, proc.tracks[256].nFrames)
+}
+
+func TestAvSyncDrift(t *testing.T) {
+	// Threshold 40 ms = 3600 ticks. Video delay stays at 9000 ticks while audio drifts
+	proc, _ := avSyncProcessor("dummy", 40, true, dataHandlerMetrics{}).(*avSyncProcessorStruct)
+	audioDelays := []int{4500, 5400, 8200, 8300, 4500}
+	expected := []int{0, 0, 1, 1, 1}
+	inViolation := []bool{false, false, true, true, false}
+
+	makeUnit := func(pid int, pts int, stc int) tttKernel.CmUnit {
+		cmBuf := tttKernel.MakeSimpleBuf([]byte{})
+		cmBuf.SetField("pid", pid, false)
+		cmBuf.SetField("progNum", 1, true)
+		cmBuf.SetField("pts", pts, false)
+		cmBuf.SetField("dts", pts, false)
+		cmBuf.SetField("pcr", stc*300, false)
+		return common.NewMediaUnit(cmBuf, common.UNKNOWN_UNIT)
+	}
+
+	for idx, audioDelay := range audioDelays {
+		stc := idx * 3000
+
+		videoData := utils.CreateParsedData()
+		videoData.GetVideoData()
+		proc.Process(makeUnit(256, stc+9000, stc), &videoData)
+
+		audioData := utils.CreateParsedData()
+		audioData.GetAudioData()
+		proc.Process(makeUnit(257, stc+audioDelay, stc), &audioData)
+
+		pair := proc.pairs[257]
+		assert.Equal(t, expected[idx], pair.nViolation, "Wrong violation count at #%d", idx)
+		assert.Equal(t, inViolation[idx], pair.inViolation, "Wrong violation state at #%d", idx)
+	}
+	assert.Equal(t, -4500, proc.pairs[257].initOffset)
+	assert.Equal(t, 3800, proc.pairs[257].maxDrift)
+}
+
+func TestAvSyncOffset(t *testing.T) {
+	// Constant 200 ms offset against a 100 ms threshold is a violation from the start
+	proc, _ := avSyncProcessor("dummy", 100, false, dataHandlerMetrics{}).(*avSyncProcessorStruct)
+
+	makeUnit := func(pid int, pts int, stc int) tttKernel.CmUnit {
+		cmBuf := tttKernel.MakeSimpleBuf([]byte{})
+		cmBuf.SetField("pid", pid, false)
+		cmBuf.SetField("progNum", 1, true)
+		cmBuf.SetField("pts", pts, false)
+		cmBuf.SetField("pcr", stc*300, false)
+		return common.NewMediaUnit(cmBuf, common.UNKNOWN_UNIT)
+	}
+
+	for idx := 0; idx < 3; idx++ {
+		stc := idx * 3000
+
+		videoData := utils.CreateParsedData()
+		videoData.GetVideoData()
+		assert.Nil(t, proc.Process(makeUnit(256, stc+9000, stc), &videoData))
+
+		audioData := utils.CreateParsedData()
+		audioData.GetAudioData()
+		err := proc.Process(makeUnit(257, stc+9000+18000, stc), &audioData)
+		if idx == 0 {
+			assert.NotNil(t, err, "Offset over threshold should raise an error")
+		} else {
+			assert.Nil(t, err, "Violation should be raised once until back in range")
+		}
+	}
+	assert.Equal(t, 1, proc.pairs[257].nViolation)
+	assert.Equal(t, 0, proc.pairs[257].maxDrift)
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"sort"
 	"strconv"
 
 	"github.com/tony-507/analyzers/src/plugins/common"
@@ -19,6 +20,7 @@ const (
 type ParsedData struct {
 	dType  PARSED_TYPE
 	vData  VideoDataStruct
+	aData  AudioDataStruct
 	data   DataStruct
 }
 
@@ -31,6 +33,11 @@ func (data *ParsedData) GetVideoData() *VideoDataStruct {
 	return &data.vData
 }
 
+func (data *ParsedData) GetAudioData() *AudioDataStruct {
+	data.dType = PARSED_AUDIO
+	return &data.aData
+}
+
 func (data *ParsedData) GetData() *DataStruct {
 	data.dType = PARSED_DATA
 	return &data.data
@@ -40,6 +47,7 @@ func CreateParsedData() ParsedData {
 	return ParsedData{
 		dType: EMPTY,
 		vData: VideoData(),
+		aData: AudioData(),
 		data:  newData(),
 	}
 }
@@ -55,16 +63,29 @@ func (d * VideoDataStruct) GetType() PARSED_TYPE {
 	return PARSED_VIDEO
 }
 
-func (d *VideoDataStruct) ToCmBuf() tttKernel.CmBuf {
-	cmBuf := tttKernel.MakeSimpleBuf([]byte{})
+var videoDataSchema = tttKernel.NewSchema("VideoData",
+	tttKernel.Field("type", tttKernel.FIELD_STRING),
+	tttKernel.Field("pts", tttKernel.FIELD_INT64),
+	tttKernel.Field("timecode", tttKernel.FIELD_STRING),
+)
+
+// Frame type and timecode are left empty if unknown
+func (d *VideoDataStruct) ToCmBuf() (tttKernel.CmBuf, error) {
+	cmBuf := tttKernel.MakeSchemaBuf(videoDataSchema, []byte{})
 	if d.Type != common.UNKNOWN_SLICE {
-		cmBuf.SetField("type", strconv.Itoa(int(d.Type)), false)
+		if err := cmBuf.SetField("type", strconv.Itoa(int(d.Type)), false); err != nil {
+			return nil, err
+		}
+	}
+	if err := cmBuf.SetField("pts", d.Pts, false); err != nil {
+		return nil, err
 	}
-	cmBuf.SetField("pts", d.Pts, false)
 	if !d.TimeCode.IsEmpty() {
-		cmBuf.SetField("timecode", d.TimeCode.ToString(), false)
+		if err := cmBuf.SetField("timecode", d.TimeCode.ToString(), false); err != nil {
+			return nil, err
+		}
 	}
-	return cmBuf
+	return cmBuf, nil
 }
 
 func VideoData() VideoDataStruct {
@@ -74,6 +95,66 @@ func VideoData() VideoDataStruct {
 	}
 }
 
+type AudioDataStruct struct {
+	Pts             int
+	Codec           string
+	SampleRate      int
+	Channels        int
+	FrameCount      int // Number of frames starting in the PES packet
+	SamplesPerFrame int
+	Metadata        map[string]interface{} // Codec specific fields, either int or string
+}
+
+func (d *AudioDataStruct) GetType() PARSED_TYPE {
+	return PARSED_AUDIO
+}
+
+var audioDataSchema = tttKernel.NewSchema("AudioData",
+	tttKernel.Field("pts", tttKernel.FIELD_INT64),
+	tttKernel.Field("codec", tttKernel.FIELD_STRING),
+	tttKernel.Field("sampleRate", tttKernel.FIELD_INT64),
+	tttKernel.Field("channels", tttKernel.FIELD_INT64),
+	tttKernel.Field("frameCount", tttKernel.FIELD_INT64),
+)
+
+// Codec specific metadata follows the declared fields
+func (d *AudioDataStruct) ToCmBuf() (tttKernel.CmBuf, error) {
+	cmBuf := tttKernel.MakeSchemaBuf(audioDataSchema, []byte{})
+	if err := cmBuf.SetField("pts", d.Pts, false); err != nil {
+		return nil, err
+	}
+	if err := cmBuf.SetField("codec", d.Codec, false); err != nil {
+		return nil, err
+	}
+	if err := cmBuf.SetField("sampleRate", d.SampleRate, false); err != nil {
+		return nil, err
+	}
+	if err := cmBuf.SetField("channels", d.Channels, false); err != nil {
+		return nil, err
+	}
+	if err := cmBuf.SetField("frameCount", d.FrameCount, false); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(d.Metadata))
+	for key := range d.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := cmBuf.SetField(key, d.Metadata[key], false); err != nil {
+			return nil, err
+		}
+	}
+	return cmBuf, nil
+}
+
+func AudioData() AudioDataStruct {
+	return AudioDataStruct{
+		Pts: -1,
+		Metadata: map[string]interface{}{},
+	}
+}
+
 type _DATA_TYPE int
 
 const (
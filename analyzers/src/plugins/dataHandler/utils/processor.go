@@ -10,5 +10,5 @@ type DataProcessor interface {
 	PrintInfo(*strings.Builder)
 	Start() error
 	Stop() error
-	Process(tttKernel.CmUnit, *ParsedData)
+	Process(tttKernel.CmUnit, *ParsedData) error // Problems found in the data, the unit is still processed
 }
\ No newline at end of file
@@ -0,0 +1,65 @@
+package h265
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-507/analyzers/src/plugins/common"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+func TestNalToRbsp(t *testing.T) {
+	nal := []byte{0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x01}
+	assert.Equal(t, []byte{0x60, 0x00, 0x00, 0x00, 0x90, 0x00, 0x00, 0x01}, NalToRbsp(nal))
+}
+
+func TestParseParameterSets(t *testing.T) {
+	vps, err := ParseVideoParameterSet([]byte{0x0C, 0x01, 0xFF, 0xFF, 0x01, 0x60, 0x00, 0x00, 0x00,
+		0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x5D, 0x80})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, VideoParameterSet{Id: 0, MaxLayers: 1, MaxSubLayers: 1,
+		Ptl: ProfileTierLevel{Profile: 1, Level: 93}}, vps)
+
+	sps, err := ParseSequenceParameterSet([]byte{0x01, 0x01, 0x60, 0x00, 0x00, 0x00, 0x90, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x5D, 0xA0, 0x03, 0xC0, 0x80, 0x10, 0xE7, 0xCB, 0xC0})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, SequenceParameterSet{Id: 0, MaxSubLayers: 1, Ptl: ProfileTierLevel{Profile: 1, Level: 93},
+		ChromaFormat: 1, Width: 1920, Height: 1080, BitDepthLuma: 8, BitDepthChroma: 8}, sps)
+
+	pps, err := ParsePictureParameterSet([]byte{0xC1})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, PictureParameterSet{}, pps)
+}
+
+func TestParseSliceHeader(t *testing.T) {
+	ppsMap := map[int]PictureParameterSet{0: {}}
+
+	idr := NalUnitHeader{Type: NAL_IDR_W_RADL}
+	slice, err := ParseSliceHeader([]byte{0xAE}, idr, ppsMap)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, SLICE_I, slice.SliceType)
+	assert.Equal(t, common.IDR_SLICE, GetFrameType(idr, slice.SliceType))
+
+	cra := NalUnitHeader{Type: NAL_CRA_NUT}
+	assert.Equal(t, true, cra.IsIrap())
+	assert.Equal(t, common.I_SLICE, GetFrameType(cra, SLICE_I))
+
+	trail := NalUnitHeader{Type: 1}
+	slice, err = ParseSliceHeader([]byte{0xD4}, trail, ppsMap)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, common.P_SLICE, GetFrameType(trail, slice.SliceType))
+
+	_, err = ParseSliceHeader([]byte{0xD4}, trail, map[int]PictureParameterSet{})
+	assert.NotEqual(t, nil, err, "Slice without PPS should fail")
+}
+
+func TestParseTimeCode(t *testing.T) {
+	seiMsgs := ParseSeiMessages([]byte{0x88, 0x06, 0x62, 0x40, 0x13, 0x82, 0x84, 0x00, 0x80})
+	assert.Equal(t, 1, len(seiMsgs))
+	assert.Equal(t, SEI_TIME_CODE, seiMsgs[0].PayloadType)
+
+	r := io.GetBufferReader(seiMsgs[0].Buffer)
+	timeCode := ParseTimeCode(&r)
+	assert.Equal(t, 1, len(timeCode.Clocks))
+	assert.Equal(t, common.TimeCode{Hour: 1, Minute: 5, Second: 28, Frame: 2, DropFrame: true}, timeCode.Clocks[0].Tc)
+}
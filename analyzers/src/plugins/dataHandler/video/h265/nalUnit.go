@@ -0,0 +1,75 @@
+package h265
+
+import (
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+// Table 7-1
+const (
+	NAL_BLA_W_LP    = 16
+	NAL_IDR_W_RADL  = 19
+	NAL_IDR_N_LP    = 20
+	NAL_CRA_NUT     = 21
+	NAL_RSV_IRAP_23 = 23
+	NAL_VPS         = 32
+	NAL_SPS         = 33
+	NAL_PPS         = 34
+	NAL_AUD         = 35
+	NAL_PREFIX_SEI  = 39
+	NAL_SUFFIX_SEI  = 40
+)
+
+type NalUnitHeader struct {
+	Type       int
+	LayerId    int
+	TemporalId int
+}
+
+// 7.3.1.2
+func ParseNalUnitHeader(r *io.BsReader) NalUnitHeader {
+	header := NalUnitHeader{}
+	r.ReadBits(1) // forbidden_zero_bit
+	header.Type = r.ReadBits(6)
+	header.LayerId = r.ReadBits(6)
+	header.TemporalId = r.ReadBits(3) - 1
+	return header
+}
+
+func (h *NalUnitHeader) IsVcl() bool {
+	return h.Type < 32
+}
+
+func (h *NalUnitHeader) IsIrap() bool {
+	return h.Type >= NAL_BLA_W_LP && h.Type <= NAL_RSV_IRAP_23
+}
+
+func (h *NalUnitHeader) IsIdr() bool {
+	return h.Type == NAL_IDR_W_RADL || h.Type == NAL_IDR_N_LP
+}
+
+func (h *NalUnitHeader) IsCra() bool {
+	return h.Type == NAL_CRA_NUT
+}
+
+func (h *NalUnitHeader) IsBla() bool {
+	return h.Type >= NAL_BLA_W_LP && h.Type < NAL_IDR_W_RADL
+}
+
+// Remove emulation_prevention_three_byte
+func NalToRbsp(nal []byte) []byte {
+	rbsp := make([]byte, 0, len(nal))
+	zeroCnt := 0
+	for _, b := range nal {
+		if zeroCnt >= 2 && b == 0x03 {
+			zeroCnt = 0
+			continue
+		}
+		if b == 0 {
+			zeroCnt++
+		} else {
+			zeroCnt = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return rbsp
+}
@@ -0,0 +1,145 @@
+package h265
+
+import (
+	"errors"
+
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+type ProfileTierLevel struct {
+	ProfileSpace int
+	Tier         int
+	Profile      int
+	Level        int
+}
+
+type VideoParameterSet struct {
+	Id           int
+	MaxLayers    int
+	MaxSubLayers int
+	Ptl          ProfileTierLevel
+}
+
+type SequenceParameterSet struct {
+	VpsId          int
+	Id             int
+	MaxSubLayers   int
+	Ptl            ProfileTierLevel
+	ChromaFormat   int
+	Width          int
+	Height         int
+	BitDepthLuma   int
+	BitDepthChroma int
+}
+
+type PictureParameterSet struct {
+	Id                            int
+	SpsId                         int
+	DependentSliceSegmentsEnabled bool
+	OutputFlagPresent             bool
+	NumExtraSliceHeaderBits       int
+}
+
+// 7.3.3
+func readProfileTierLevel(r *io.BsReader, profilePresent bool, maxSubLayersMinus1 int) ProfileTierLevel {
+	ptl := ProfileTierLevel{}
+	if profilePresent {
+		ptl.ProfileSpace = r.ReadBits(2)
+		ptl.Tier = r.ReadBits(1)
+		ptl.Profile = r.ReadBits(5)
+		r.ReadBits(32) // general_profile_compatibility_flag
+		r.ReadBits(4)  // progressive, interlaced, non_packed and frame_only constraint flags
+		r.ReadBits(32) // general_reserved_zero_43bits and general_inbld_flag
+		r.ReadBits(12)
+	}
+	ptl.Level = r.ReadBits(8)
+
+	subLayerProfilePresent := make([]bool, maxSubLayersMinus1)
+	subLayerLevelPresent := make([]bool, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		subLayerProfilePresent[i] = r.ReadBits(1) != 0
+		subLayerLevelPresent[i] = r.ReadBits(1) != 0
+	}
+	if maxSubLayersMinus1 > 0 {
+		for i := maxSubLayersMinus1; i < 8; i++ {
+			r.ReadBits(2) // reserved_zero_2bits
+		}
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if subLayerProfilePresent[i] {
+			r.ReadBits(32)
+			r.ReadBits(32)
+			r.ReadBits(24)
+		}
+		if subLayerLevelPresent[i] {
+			r.ReadBits(8)
+		}
+	}
+	return ptl
+}
+
+// 7.3.2.1
+func ParseVideoParameterSet(rbsp []byte) (VideoParameterSet, error) {
+	vps := VideoParameterSet{}
+	if len(rbsp) < 16 {
+		return vps, errors.New("VPS is too short")
+	}
+	r := io.GetBufferReader(rbsp)
+	vps.Id = r.ReadBits(4)
+	r.ReadBits(1) // vps_base_layer_internal_flag
+	r.ReadBits(1) // vps_base_layer_available_flag
+	vps.MaxLayers = r.ReadBits(6) + 1
+	maxSubLayersMinus1 := r.ReadBits(3)
+	vps.MaxSubLayers = maxSubLayersMinus1 + 1
+	r.ReadBits(1) // vps_temporal_id_nesting_flag
+	if r.ReadBits(16) != 0xffff {
+		return vps, errors.New("vps_reserved_0xffff_16bits is not 0xffff")
+	}
+	vps.Ptl = readProfileTierLevel(&r, true, maxSubLayersMinus1)
+	return vps, nil
+}
+
+// 7.3.2.2
+func ParseSequenceParameterSet(rbsp []byte) (SequenceParameterSet, error) {
+	sps := SequenceParameterSet{}
+	if len(rbsp) < 15 {
+		return sps, errors.New("SPS is too short")
+	}
+	r := io.GetBufferReader(rbsp)
+	sps.VpsId = r.ReadBits(4)
+	maxSubLayersMinus1 := r.ReadBits(3)
+	sps.MaxSubLayers = maxSubLayersMinus1 + 1
+	r.ReadBits(1) // sps_temporal_id_nesting_flag
+	sps.Ptl = readProfileTierLevel(&r, true, maxSubLayersMinus1)
+	sps.Id = r.ReadExpGolomb()
+	sps.ChromaFormat = r.ReadExpGolomb()
+	if sps.ChromaFormat == 3 {
+		r.ReadBits(1) // separate_colour_plane_flag
+	}
+	sps.Width = r.ReadExpGolomb()
+	sps.Height = r.ReadExpGolomb()
+	if r.ReadBits(1) != 0 {
+		// conformance_window_flag
+		for i := 0; i < 4; i++ {
+			r.ReadExpGolomb()
+		}
+	}
+	sps.BitDepthLuma = r.ReadExpGolomb() + 8
+	sps.BitDepthChroma = r.ReadExpGolomb() + 8
+	return sps, nil
+}
+
+// 7.3.2.3.1
+func ParsePictureParameterSet(rbsp []byte) (PictureParameterSet, error) {
+	pps := PictureParameterSet{}
+	if len(rbsp) < 1 {
+		return pps, errors.New("PPS is too short")
+	}
+	r := io.GetBufferReader(rbsp)
+	pps.Id = r.ReadExpGolomb()
+	pps.SpsId = r.ReadExpGolomb()
+	pps.DependentSliceSegmentsEnabled = r.ReadBits(1) != 0
+	pps.OutputFlagPresent = r.ReadBits(1) != 0
+	pps.NumExtraSliceHeaderBits = r.ReadBits(3)
+	return pps, nil
+}
@@ -0,0 +1,111 @@
+package h265
+
+import (
+	"github.com/tony-507/analyzers/src/plugins/common"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+const (
+	SEI_TIME_CODE = 136
+)
+
+type Sei struct {
+	PayloadType int
+	PayloadSize int
+	Buffer      []byte
+}
+
+type TimeCode struct {
+	Clocks []ClockTimestamp
+}
+
+type ClockTimestamp struct {
+	UnitsFieldBased   bool
+	CountingType      int
+	DiscontinuityFlag bool
+	CntDroppedFlag    bool
+	Tc                common.TimeCode
+}
+
+// 7.3.5
+func ParseSeiMessages(rbsp []byte) []Sei {
+	r := io.GetBufferReader(rbsp)
+	seiMsgs := []Sei{}
+	// Stop at rbsp_trailing_bits
+	for len(r.GetRemainedBuffer()) > 1 {
+		payloadType := 0
+		payloadSize := 0
+
+		for r.PeekBits(8) == 0xff {
+			r.ReadBits(8)
+			payloadType += 255
+		}
+		payloadType += r.ReadBits(8)
+
+		for r.PeekBits(8) == 0xff {
+			r.ReadBits(8)
+			payloadSize += 255
+		}
+		payloadSize += r.ReadBits(8)
+		if payloadSize > len(r.GetRemainedBuffer()) {
+			break
+		}
+		seiMsgs = append(seiMsgs, Sei{
+			PayloadType: payloadType,
+			PayloadSize: payloadSize,
+			Buffer:      r.GetRemainedBuffer()[:payloadSize],
+		})
+		r.ReadBits(payloadSize * 8)
+	}
+	return seiMsgs
+}
+
+// D.2.27
+func ParseTimeCode(r *io.BsReader) TimeCode {
+	timeCode := TimeCode{Clocks: []ClockTimestamp{}}
+	numClockTs := r.ReadBits(2)
+	for i := 0; i < numClockTs; i++ {
+		if r.ReadBits(1) == 0 {
+			// clock_timestamp_flag
+			continue
+		}
+		clock := ClockTimestamp{}
+		clock.UnitsFieldBased = r.ReadBits(1) != 0
+		clock.CountingType = r.ReadBits(5)
+		fullTimestampFlag := r.ReadBits(1) != 0
+		clock.DiscontinuityFlag = r.ReadBits(1) != 0
+		clock.CntDroppedFlag = r.ReadBits(1) != 0
+		nFrames := r.ReadBits(9)
+		seconds := -1
+		minutes := -1
+		hours := -1
+		if fullTimestampFlag {
+			seconds = r.ReadBits(6)
+			minutes = r.ReadBits(6)
+			hours = r.ReadBits(5)
+		} else {
+			if r.ReadBits(1) != 0 {
+				seconds = r.ReadBits(6)
+				if r.ReadBits(1) != 0 {
+					minutes = r.ReadBits(6)
+					if r.ReadBits(1) != 0 {
+						hours = r.ReadBits(5)
+					}
+				}
+			}
+		}
+		timeOffsetLength := r.ReadBits(5)
+		if timeOffsetLength > 0 {
+			r.ReadBits(timeOffsetLength) // time_offset_value
+		}
+		clock.Tc = common.TimeCode{
+			Hour:      hours,
+			Minute:    minutes,
+			Second:    seconds,
+			Frame:     nFrames,
+			DropFrame: clock.CountingType == 4,
+		}
+		timeCode.Clocks = append(timeCode.Clocks, clock)
+	}
+	return timeCode
+}
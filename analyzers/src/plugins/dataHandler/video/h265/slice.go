@@ -0,0 +1,67 @@
+package h265
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tony-507/analyzers/src/plugins/common"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+)
+
+// Table 7-7
+const (
+	SLICE_B = 0
+	SLICE_P = 1
+	SLICE_I = 2
+)
+
+type SliceHeader struct {
+	FirstSliceSegmentInPic bool
+	PpsId                  int
+	SliceType              int // -1 if not present
+}
+
+// 7.3.6.1, only the first slice segment of a picture is parsed up to slice_type
+func ParseSliceHeader(rbsp []byte, header NalUnitHeader, ppsMap map[int]PictureParameterSet) (SliceHeader, error) {
+	slice := SliceHeader{SliceType: -1}
+	if len(rbsp) < 1 {
+		return slice, errors.New("Slice segment header is too short")
+	}
+	r := io.GetBufferReader(rbsp)
+	slice.FirstSliceSegmentInPic = r.ReadBits(1) != 0
+	if header.IsIrap() {
+		r.ReadBits(1) // no_output_of_prior_pics_flag
+	}
+	slice.PpsId = r.ReadExpGolomb()
+	if !slice.FirstSliceSegmentInPic {
+		// slice_segment_address requires the picture size in CTBs
+		return slice, nil
+	}
+	pps, ok := ppsMap[slice.PpsId]
+	if !ok {
+		return slice, errors.New(fmt.Sprintf("PPS %d not received", slice.PpsId))
+	}
+	r.ReadBits(pps.NumExtraSliceHeaderBits) // slice_reserved_flag
+	slice.SliceType = r.ReadExpGolomb()
+	return slice, nil
+}
+
+func GetFrameType(header NalUnitHeader, sliceType int) common.FRAME_TYPE {
+	switch {
+	case header.IsIdr():
+		return common.IDR_SLICE
+	case header.IsIrap():
+		// CRA and BLA pictures are intra random access points
+		return common.I_SLICE
+	}
+	switch sliceType {
+	case SLICE_B:
+		return common.B_SLICE
+	case SLICE_P:
+		return common.P_SLICE
+	case SLICE_I:
+		return common.I_SLICE
+	default:
+		return common.UNKNOWN_SLICE
+	}
+}
@@ -0,0 +1,141 @@
+package video
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tony-507/analyzers/src/logging"
+	"github.com/tony-507/analyzers/src/plugins/common"
+	"github.com/tony-507/analyzers/src/plugins/common/io"
+	"github.com/tony-507/analyzers/src/plugins/dataHandler/utils"
+	"github.com/tony-507/analyzers/src/plugins/dataHandler/video/h265"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+type h265Handler struct {
+	logger logging.Log
+	inCnt  int
+	vpsMap map[int]h265.VideoParameterSet
+	spsMap map[int]h265.SequenceParameterSet
+	ppsMap map[int]h265.PictureParameterSet
+}
+
+// 7.3.1.1
+func (h *h265Handler) readNalUnit(nal []byte, data *utils.VideoDataStruct) error {
+	if len(nal) < 2 {
+		return errors.New(fmt.Sprintf("NAL unit of size %d is too short", len(nal)))
+	}
+	r := io.GetBufferReader(nal[:2])
+	header := h265.ParseNalUnitHeader(&r)
+	rbsp := h265.NalToRbsp(nal[2:])
+
+	switch {
+	case header.IsVcl():
+		slice, err := h265.ParseSliceHeader(rbsp, header, h.ppsMap)
+		if err != nil {
+			return err
+		}
+		if slice.FirstSliceSegmentInPic {
+			data.Type = h265.GetFrameType(header, slice.SliceType)
+		}
+	case header.Type == h265.NAL_VPS:
+		vps, err := h265.ParseVideoParameterSet(rbsp)
+		if err != nil {
+			return err
+		}
+		if _, ok := h.vpsMap[vps.Id]; !ok {
+			h.logger.Trace("VPS %d: %d layers, %d sub-layers", vps.Id, vps.MaxLayers, vps.MaxSubLayers)
+		}
+		h.vpsMap[vps.Id] = vps
+	case header.Type == h265.NAL_SPS:
+		sps, err := h265.ParseSequenceParameterSet(rbsp)
+		if err != nil {
+			return err
+		}
+		if _, ok := h.spsMap[sps.Id]; !ok {
+			h.logger.Trace("SPS %d: profile %d, level %d, resolution %d x %d", sps.Id, sps.Ptl.Profile, sps.Ptl.Level, sps.Width, sps.Height)
+		}
+		h.spsMap[sps.Id] = sps
+	case header.Type == h265.NAL_PPS:
+		pps, err := h265.ParsePictureParameterSet(rbsp)
+		if err != nil {
+			return err
+		}
+		h.ppsMap[pps.Id] = pps
+	case header.Type == h265.NAL_PREFIX_SEI || header.Type == h265.NAL_SUFFIX_SEI:
+		h.readSEI(rbsp, data)
+	default:
+		// Unhandled
+	}
+	return nil
+}
+
+func (h *h265Handler) readSEI(rbsp []byte, data *utils.VideoDataStruct) {
+	for _, sei := range h265.ParseSeiMessages(rbsp) {
+		switch sei.PayloadType {
+		case h265.SEI_TIME_CODE:
+			reader := io.GetBufferReader(sei.Buffer)
+			timeCode := h265.ParseTimeCode(&reader)
+			for _, clock := range timeCode.Clocks {
+				data.TimeCode = clock.Tc
+			}
+		}
+	}
+}
+
+// Annex B.2: Split byte stream into NAL units
+func splitNalUnits(buf []byte) [][]byte {
+	nalUnits := [][]byte{}
+	start := -1
+	i := 0
+	for i+2 < len(buf) {
+		if buf[i] == 0 && buf[i+1] == 0 && buf[i+2] == 1 {
+			if start != -1 {
+				nalUnits = append(nalUnits, trimTrailingZeros(buf[start:i]))
+			}
+			i += 3
+			start = i
+		} else {
+			i++
+		}
+	}
+	if start != -1 && start < len(buf) {
+		nalUnits = append(nalUnits, trimTrailingZeros(buf[start:]))
+	}
+	return nalUnits
+}
+
+func trimTrailingZeros(nal []byte) []byte {
+	end := len(nal)
+	for end > 0 && nal[end-1] == 0 {
+		end--
+	}
+	return nal[:end]
+}
+
+func (h *h265Handler) Feed(unit tttKernel.CmUnit, newData *utils.ParsedData) error {
+	buf := tttKernel.GetBytesInBuf(unit)
+	data := newData.GetVideoData()
+
+	h.inCnt++
+
+	for _, nal := range splitNalUnits(buf) {
+		if err := h.readNalUnit(nal, data); err != nil {
+			h.logger.Error("At PES #%d, %s", h.inCnt, err.Error())
+		}
+	}
+	if data.Type == common.UNKNOWN_SLICE {
+		h.logger.Trace("No slice found at PES #%d", h.inCnt)
+	}
+	return nil
+}
+
+func H265VideoHandler(pid int) utils.DataHandler {
+	return &h265Handler{
+		logger: logging.CreateLogger(fmt.Sprintf("H265_%d", pid)),
+		inCnt:  0,
+		vpsMap: map[int]h265.VideoParameterSet{},
+		spsMap: map[int]h265.SequenceParameterSet{},
+		ppsMap: map[int]h265.PictureParameterSet{},
+	}
+}
@@ -56,3 +56,33 @@ func TestReadMultipleSei(t *testing.T) {
 
 	assert.Equal(t, true, vData.TimeCode.Second != 0)
 }
+
+func TestH265Handler(t *testing.T) {
+	// AUD, VPS, SPS, PPS, time_code SEI and IDR slice
+	data := []byte{
+		0x00, 0x00, 0x00, 0x01, 0x46, 0x01, 0x10,
+		0x00, 0x00, 0x00, 0x01, 0x40, 0x01, 0x0C, 0x01, 0xFF, 0xFF, 0x01, 0x60, 0x00, 0x00, 0x03,
+		0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x5D, 0x80,
+		0x00, 0x00, 0x00, 0x01, 0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00,
+		0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x5D, 0xA0, 0x03, 0xC0, 0x80, 0x10, 0xE7, 0xCB, 0xC0,
+		0x00, 0x00, 0x00, 0x01, 0x44, 0x01, 0xC1,
+		0x00, 0x00, 0x00, 0x01, 0x4E, 0x01, 0x88, 0x06, 0x62, 0x40, 0x13, 0x82, 0x84, 0x00, 0x80,
+		0x00, 0x00, 0x00, 0x01, 0x26, 0x01, 0xAE, 0xAB, 0xCD,
+	}
+	handle := H265VideoHandler(256)
+	unit := common.NewMediaUnit(tttKernel.MakeSimpleBuf(data), common.UNKNOWN_UNIT)
+	newData := utils.CreateParsedData()
+	handle.Feed(unit, &newData)
+
+	assert.Equal(t, utils.PARSED_VIDEO, newData.GetType())
+	videoData := newData.GetVideoData()
+	assert.Equal(t, "01:05:28:02", videoData.TimeCode.ToString())
+	assert.Equal(t, common.IDR_SLICE, videoData.Type)
+
+	// CRA picture using the stored PPS
+	data = []byte{0x00, 0x00, 0x00, 0x01, 0x2A, 0x01, 0xAE}
+	unit = common.NewMediaUnit(tttKernel.MakeSimpleBuf(data), common.UNKNOWN_UNIT)
+	newData = utils.CreateParsedData()
+	handle.Feed(unit, &newData)
+	assert.Equal(t, common.I_SLICE, newData.GetVideoData().Type)
+}
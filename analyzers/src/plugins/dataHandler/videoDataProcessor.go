@@ -23,6 +23,7 @@ type videoDataProcessorStruct struct {
 	writer    io.FileWriter
 	logger    logging.Log
 	stat      processorStat
+	metrics   dataHandlerMetrics
 }
 
 func (vp *videoDataProcessorStruct) Start() error {
@@ -33,7 +34,7 @@ func (vp *videoDataProcessorStruct) Stop() error {
 	return vp.writer.Close()
 }
 
-func (vp *videoDataProcessorStruct) Process(unit tttKernel.CmUnit, parsedData *utils.ParsedData) {
+func (vp *videoDataProcessorStruct) Process(unit tttKernel.CmUnit, parsedData *utils.ParsedData) error {
 	switch parsedData.GetType() {
 	case utils.PARSED_VIDEO:
 		cmBuf := unit.GetBuf()
@@ -50,10 +51,15 @@ func (vp *videoDataProcessorStruct) Process(unit tttKernel.CmUnit, parsedData *u
 			// Sort data when we reach an I slice
 			sort.Slice(vp.videos, func (i, j int) bool { return vp.videos[i].Pts < vp.videos[j].Pts })
 			for _, storedData := range vp.videos {
-				vp.writer.Write(storedData.ToCmBuf())
+				if cmBuf, err := storedData.ToCmBuf(); err != nil {
+					vp.logger.Error("Fail to write video data with PTS %d: %s", storedData.Pts, err.Error())
+				} else {
+					vp.writer.Write(cmBuf)
+				}
 
 				if !vp.validateTimeCode(&storedData) {
 					vp.stat.nTcDiscontinuity++
+					vp.metrics.tcDiscontinuity.Inc(vp.metrics.name)
 				}
 				vp.validateSpliceIDR(&storedData)
 			}
@@ -79,6 +85,7 @@ func (vp *videoDataProcessorStruct) Process(unit tttKernel.CmUnit, parsedData *u
 			}
 		}
 	}
+	return nil
 }
 
 func (vp *videoDataProcessorStruct) validateTimeCode(data *utils.VideoDataStruct) bool {
@@ -124,12 +131,13 @@ func (vp *videoDataProcessorStruct) PrintInfo(sb *strings.Builder) {
 	}
 }
 
-func videoDataProcessor(outDir string) utils.DataProcessor {
+func videoDataProcessor(outDir string, metrics dataHandlerMetrics) utils.DataProcessor {
 	return &videoDataProcessorStruct{
 		videos: make([]utils.VideoDataStruct, 0, 20),
 		lastTC: common.NewTimeCode(),
 		writer: io.CsvWriter(outDir, "video.csv"),
 		logger: logging.CreateLogger("VideoDataProcessor"),
 		stat: processorStat{},
+		metrics: metrics,
 	}
 }
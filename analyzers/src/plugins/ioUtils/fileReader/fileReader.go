@@ -68,7 +68,11 @@ func (fr *FileReaderStruct) worker() {
 	for fr.running {
 		buf, err := handler.getBuffer()
 		if err != nil {
-			panic(err)
+			// Pass the error to the plugin and stop reading
+			fr.mtx.Lock()
+			fr.bufferQueue = append(fr.bufferQueue, protocol.ParseResult{IsEmpty: true, Err: err})
+			fr.mtx.Unlock()
+			break
 		}
 		if len(buf) == 0 {
 			fr.logger.Info("No more buffer from file")
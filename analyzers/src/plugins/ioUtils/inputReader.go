@@ -57,7 +57,8 @@ func (ir *inputReaderPlugin) StartSequence() {
 	if err != nil {
 		panic(err)
 	}
-	go ir.DeliverUnit(nil, "")
+	reqUnit := tttKernel.MakeReqUnit(ir.name, tttKernel.DELIVER_REQUEST)
+	tttKernel.Post_request(ir.callback, ir.name, reqUnit)
 }
 
 func (ir *inputReaderPlugin) EndSequence() {
@@ -152,6 +153,10 @@ func (ir *inputReaderPlugin) DeliverStatus(unit tttKernel.CmUnit) {}
 func (ir *inputReaderPlugin) start() {
 	// Here, we will keep delivering until EOS is signaled
 	res, ok := ir.impl.DataAvailable()
+	if res.Err != nil {
+		tttKernel.Report_error(ir.callback, ir.name, tttKernel.SEVERITY_ERROR,
+			fmt.Sprintf("input #%d", ir.stat.outCnt), res.Err)
+	}
 	if ir.param.maxInCnt != 0 && ok {
 		if !res.IsEmpty {
 			ir.stat.outCnt += 1
@@ -179,6 +184,8 @@ func (ir *inputReaderPlugin) processMetadata(cmBuf tttKernel.CmBuf, res *protoco
 	}
 
 	if timestamp, ok := res.GetField("timestamp"); ok {
+		// Kept for alignment by RTP timestamp, e.g. with a merger
+		cmBuf.SetField("rtpTimestamp", timestamp, true)
 		if ir.stat.prevTimestamp != timestamp {
 			nextTc := common.GetNextTimeCode(&ir.stat.prevTimecode, 30000, 1001, true)
 			tc, err := common.RtpTimestampToTimeCode(clock.MpegClk(timestamp) * clock.Clk90k, -1, 30000, 1001, false, 0)
@@ -248,3 +255,18 @@ func InputReader(name string) tttKernel.IPlugin {
 	}
 	return &rv
 }
+
+func init() {
+	tttKernel.RegisterPlugin(tttKernel.PluginInfo{
+		Type:        "InputReader",
+		Constructor: InputReader,
+		Description: "Read packets from a file, a UDP stream or a dummy source",
+		Params: []tttKernel.ParamDef{
+			{Name: "Uri", Type: tttKernel.FIELD_STRING, Description: "Source of input, e.g. file:///path or udp://addr:port?interface=eth0. Other schemes read nothing"},
+			{Name: "SkipCnt", Type: tttKernel.FIELD_INT64, Default: 0, Description: "Number of packets to skip at start"},
+			{Name: "MaxInCnt", Type: tttKernel.FIELD_INT64, Default: 0, Description: "Number of packets to be parsed. All packets are parsed if not positive"},
+			{Name: "DumpRawInput", Type: tttKernel.FIELD_BOOL, Default: false, Description: "Dump input data"},
+			{Name: "Protocols", Type: tttKernel.FIELD_STRING, Description: "Application protocols used, e.g. TS over RTP over SRT would be SRT,RTP,TS"},
+		},
+	})
+}
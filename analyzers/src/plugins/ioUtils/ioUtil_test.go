@@ -6,6 +6,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tony-507/analyzers/src/logging"
+	"github.com/tony-507/analyzers/src/plugins/common"
 	"github.com/tony-507/analyzers/src/plugins/common/protocol"
 	"github.com/tony-507/analyzers/src/plugins/ioUtils/fileReader"
 	"github.com/tony-507/analyzers/src/tttKernel"
@@ -66,7 +67,8 @@ func TestTsParser(t *testing.T) {
 			data[i*protocol.TS_PKT_SIZE+j] = byte(i)
 		}
 	}
-	resList := parser.Parse(&protocol.ParseResult{Buffer: data})
+	resList, err := parser.Parse(&protocol.ParseResult{Buffer: data})
+	assert.Nil(t, err)
 	for idx, res := range resList {
 		assert.Equal(t, byte(idx), res.GetBuffer()[0], "Packet value not equal")
 	}
@@ -85,6 +87,37 @@ func TestRtpParser(t *testing.T) {
 	assert.Equal(t, int64(3826970665), timestamp, "RTP timestamp not match")
 }
 
+func TestRtpParserExtension(t *testing.T) {
+	data := []byte{
+		0x90, 0x60, 0xf2, 0xf6, 0xe4, 0x1a, 0xf0, 0x29, 0xab, 0xcd, 0xab, 0xcd,
+		0xbe, 0xde, 0x00, 0x01, 0x11, 0x22, 0x33, 0x44,
+		0x01, 0x02, 0x03, 0x04, 0x05,
+	}
+	resList, err := protocol.RtpParser().Parse(&protocol.ParseResult{Buffer: data})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x01, 0x02, 0x03, 0x04, 0x05}, resList[0].GetBuffer(), "Payload not match")
+	profile, _ := resList[0].GetField("extensionProfile")
+	assert.Equal(t, int64(0xbede), profile)
+}
+
+func TestRtpParserMalformed(t *testing.T) {
+	testcases := map[string][]byte{
+		"Short":           {0x80, 0x60, 0xf2},
+		"Version":         {0x40, 0x60, 0xf2, 0xf6, 0xe4, 0x1a, 0xf0, 0x29, 0xab, 0xcd, 0xab, 0xcd},
+		"CSRC":            {0x82, 0x60, 0xf2, 0xf6, 0xe4, 0x1a, 0xf0, 0x29, 0xab, 0xcd, 0xab, 0xcd, 0x00},
+		"ExtensionLength": {0x90, 0x60, 0xf2, 0xf6, 0xe4, 0x1a, 0xf0, 0x29, 0xab, 0xcd, 0xab, 0xcd, 0xbe, 0xde, 0x00, 0x04},
+		"Padding":         {0xa0, 0x60, 0xf2, 0xf6, 0xe4, 0x1a, 0xf0, 0x29, 0xab, 0xcd, 0xab, 0xcd, 0x01, 0x09},
+	}
+	for name, data := range testcases {
+		t.Run(name, func(t *testing.T) {
+			resList := protocol.ParseWithParsers([]protocol.IParser{protocol.RtpParser()}, &protocol.ParseResult{Buffer: data})
+			assert.Equal(t, 1, len(resList))
+			assert.True(t, resList[0].IsEmpty)
+			assert.NotNil(t, resList[0].Err)
+		})
+	}
+}
+
 func TestParseWithParsers(t *testing.T) {
 	// Ensure no infinite loop or weird stuff
 	data := make([]byte, protocol.TS_PKT_SIZE*7)
@@ -98,3 +131,43 @@ func TestParseWithParsers(t *testing.T) {
 		assert.Equal(t, byte(idx), res.GetBuffer()[0], "Packet value not equal")
 	}
 }
+
+func TestTraceReplayer(t *testing.T) {
+	path := t.TempDir() + "/edge.trace"
+	writer, err := tttKernel.NewTraceWriter(path)
+	assert.Nil(t, err)
+
+	buf := tttKernel.MakeSimpleBuf([]byte{1, 2, 3})
+	buf.SetField("pid", 32, false)
+	video := common.NewMediaUnit(buf, common.VIDEO_UNIT)
+	video.GetVideoData().Type = common.IDR_SLICE
+	video.GetVideoData().FrameRate = common.FrameRate(30000, 1001)
+	data := common.NewMediaUnit(tttKernel.MakeSimpleBuf([]byte{}), common.DATA_UNIT)
+	scte35 := common.NewScte35Data(1, 2, 3)
+	data.Data = &scte35
+	assert.Nil(t, writer.Write(video, "TsDemuxer_1"))
+	assert.Nil(t, writer.Write(data, "TsDemuxer_1"))
+	assert.Nil(t, writer.Close())
+
+	tr := TraceReplayer("TraceReplayer_1")
+	requests := []tttKernel.WORKER_REQUEST{}
+	tr.SetCallback(func(s string, reqType tttKernel.WORKER_REQUEST, obj interface{}) {
+		requests = append(requests, obj.(tttKernel.CmUnit).GetField("reqType").(tttKernel.WORKER_REQUEST))
+	})
+	tr.SetParameter(fmt.Sprintf("{\"File\": \"%s\"}", path))
+	tr.StartSequence()
+	for i := 0; i < 3; i++ {
+		tr.DeliverUnit(nil, "worker")
+	}
+	assert.Equal(t, []tttKernel.WORKER_REQUEST{
+		tttKernel.DELIVER_REQUEST,
+		tttKernel.FETCH_REQUEST, tttKernel.DELIVER_REQUEST,
+		tttKernel.FETCH_REQUEST, tttKernel.DELIVER_REQUEST,
+		tttKernel.EOS_REQUEST,
+	}, requests)
+
+	assert.Equal(t, video, tr.FetchUnit())
+	assert.Equal(t, data, tr.FetchUnit())
+	assert.Nil(t, tr.FetchUnit())
+	tr.EndSequence()
+}
@@ -0,0 +1,130 @@
+package ioUtils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tony-507/analyzers/src/logging"
+	"github.com/tony-507/analyzers/src/tttKernel"
+)
+
+type traceReplayerParam struct {
+	File string // Trace recorded by the worker
+}
+
+/*
+ * Replay units recorded from an edge of a graph, in the recorded order. A
+ * unit is read only after the previous one is fetched, so the downstream
+ * plugin receives the same units in the same order on every run.
+ */
+type traceReplayerPlugin struct {
+	name        string
+	callback    tttKernel.RequestHandler
+	logger      logging.Log
+	param       traceReplayerParam
+	reader      *tttKernel.TraceReader
+	isRunning   bool
+	outputQueue []tttKernel.CmUnit
+	outCnt      int
+}
+
+func (tr *traceReplayerPlugin) SetCallback(callback tttKernel.RequestHandler) {
+	tr.callback = callback
+}
+
+func (tr *traceReplayerPlugin) SetParameter(m_parameter string) {
+	if err := json.Unmarshal([]byte(m_parameter), &tr.param); err != nil {
+		panic(err)
+	}
+}
+
+func (tr *traceReplayerPlugin) SetResource(loader *tttKernel.ResourceLoader) {}
+
+func (tr *traceReplayerPlugin) StartSequence() {
+	reader, err := tttKernel.OpenTrace(tr.param.File)
+	if err != nil {
+		tttKernel.Report_error(tr.callback, tr.name, tttKernel.SEVERITY_FATAL, "open", err)
+		return
+	}
+	tr.reader = reader
+	tr.isRunning = true
+	tr.logger.Info("Replaying %s", tr.param.File)
+
+	reqUnit := tttKernel.MakeReqUnit(tr.name, tttKernel.DELIVER_REQUEST)
+	tttKernel.Post_request(tr.callback, tr.name, reqUnit)
+}
+
+func (tr *traceReplayerPlugin) DeliverUnit(unit tttKernel.CmUnit, inputId string) {
+	if !tr.isRunning {
+		return
+	}
+	record, err := tr.reader.Next()
+	if err != nil {
+		if err != io.EOF {
+			tttKernel.Report_error(tr.callback, tr.name, tttKernel.SEVERITY_ERROR,
+				fmt.Sprintf("unit #%d", tr.outCnt), err)
+		}
+		eosUnit := tttKernel.MakeReqUnit(tr.name, tttKernel.EOS_REQUEST)
+		tttKernel.Post_request(tr.callback, tr.name, eosUnit)
+		return
+	}
+	tr.outCnt++
+	tr.outputQueue = append(tr.outputQueue, record.Unit)
+
+	reqUnit := tttKernel.MakeReqUnit(tr.name, tttKernel.FETCH_REQUEST)
+	tttKernel.Post_request(tr.callback, tr.name, reqUnit)
+	reqUnit = tttKernel.MakeReqUnit(tr.name, tttKernel.DELIVER_REQUEST)
+	tttKernel.Post_request(tr.callback, tr.name, reqUnit)
+}
+
+func (tr *traceReplayerPlugin) DeliverStatus(unit tttKernel.CmUnit) {}
+
+func (tr *traceReplayerPlugin) FetchUnit() tttKernel.CmUnit {
+	if len(tr.outputQueue) == 0 {
+		return nil
+	}
+	rv := tr.outputQueue[0]
+	tr.outputQueue = tr.outputQueue[1:]
+	return rv
+}
+
+func (tr *traceReplayerPlugin) EndSequence() {
+	tr.logger.Info("Ending sequence, replay count = %d", tr.outCnt)
+	tr.isRunning = false
+	if tr.reader != nil {
+		tr.reader.Close()
+		tr.reader = nil
+	}
+	eosUnit := tttKernel.MakeReqUnit(tr.name, tttKernel.EOS_REQUEST)
+	tttKernel.Post_request(tr.callback, tr.name, eosUnit)
+}
+
+func (tr *traceReplayerPlugin) PrintInfo(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\tOut count: %d\n", tr.outCnt))
+}
+
+func (tr *traceReplayerPlugin) Name() string {
+	return tr.name
+}
+
+func TraceReplayer(name string) tttKernel.IPlugin {
+	rv := traceReplayerPlugin{
+		name:        name,
+		logger:      logging.CreateLogger(name),
+		outputQueue: []tttKernel.CmUnit{},
+	}
+	return &rv
+}
+
+func init() {
+	tttKernel.RegisterPlugin(tttKernel.PluginInfo{
+		Type:        "TraceReplayer",
+		Constructor: TraceReplayer,
+		Description: "Replay units recorded from an edge of a graph in the recorded order",
+		Params: []tttKernel.ParamDef{
+			{Name: "File", Type: tttKernel.FIELD_STRING, Description: "Trace file written by recording an edge, e.g. output/TsDemuxer_1-DataHandler_1.trace"},
+		},
+	})
+}
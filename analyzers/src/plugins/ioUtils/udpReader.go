@@ -150,17 +150,17 @@ func (ur *udpReaderStruct) DataAvailable() (protocol.ParseResult, bool) {
 		udpBuf, err := ur.conn.read()
 
 		if err != nil {
-			msg := err.Error()
-			if strings.Contains(msg, "i/o timeout") {
-				panic(err)
-			}
-			ur.logger.Error("Fail to read buffer: %s", msg)
+			// Reported by the plugin, which decides whether to continue
+			return protocol.ParseResult{IsEmpty: true, Err: err}, true
 		}
 
 		ur.udpCount += 1
 
 		ur.bufferQueue = append(ur.bufferQueue, protocol.ParseWithParsers(ur.config.Parsers, &protocol.ParseResult{Buffer: udpBuf})...)
 	}
+	if len(ur.bufferQueue) == 0 {
+		return protocol.EmptyResult(), true
+	}
 
 	buf := ur.bufferQueue[0]
 	ur.bufferQueue = ur.bufferQueue[1:]
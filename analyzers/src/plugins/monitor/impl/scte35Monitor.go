@@ -9,7 +9,8 @@ import (
 )
 
 /*
- * This monitor displays video PTS, SCTE-35 splice time and SCTE-35 pre-roll time.
+ * This monitor displays video PTS, SCTE-35 splice time, SCTE-35 pre-roll time,
+ * segmentation type ID and segmentation UPID.
  *
  * It can be used to validate IDR insertion correctness and splice repetition.
  */
@@ -19,6 +20,8 @@ type scte35Data struct {
 	pts int64
 	spliceTime int64
 	preRoll int64
+	segmentationTypeId int64
+	upid string
 }
 
 type Scte35Monitor struct {
@@ -43,15 +46,17 @@ func (m *Scte35Monitor) Feed(unit tttKernel.CmUnit, inputId string) {
 	pts := data.GetField("playtime")
 	spliceTime := data.GetField("spliceTime")
 	preRoll := data.GetField("preroll")
+	segmentationTypeId := data.GetField("segmentationTypeId")
+	upid := data.GetString("upid")
 
 	pid, _ := tttKernel.GetBufFieldAsInt(unit.GetBuf(), "pid")
 
 	idx := m.getIdIndex(inputId)
-	m.data[idx] = append(m.data[idx], scte35Data{pid, pts, spliceTime, preRoll / 90})
+	m.data[idx] = append(m.data[idx], scte35Data{pid, pts, spliceTime, preRoll / 90, segmentationTypeId, upid})
 }
 
 func (m *Scte35Monitor) GetFields() []string {
-	return []string{"Pid", "Preroll"}
+	return []string{"Pid", "Preroll", "SegType", "UPID"}
 }
 
 func (m *Scte35Monitor) HasInputId(inputId string) bool {
@@ -72,9 +77,13 @@ func (m *Scte35Monitor) GetDisplayData() []string {
 		for idx := 0; idx < maxLength; idx++ {
 			if idx < len(m.data[id]) {
 				datum := m.data[id][idx]
-				res[idx] += fmt.Sprintf("|%15d|%15d", datum.pid, datum.preRoll)
+				segType := ""
+				if datum.segmentationTypeId != -1 {
+					segType = fmt.Sprintf("0x%02x", datum.segmentationTypeId)
+				}
+				res[idx] += fmt.Sprintf("|%15d|%15d|%15s|%15.15s", datum.pid, datum.preRoll, segType, datum.upid)
 			} else {
-				res[idx] += fmt.Sprintf("|%15s|%15s", "", "")
+				res[idx] += fmt.Sprintf("|%15s|%15s|%15s|%15s", "", "", "", "")
 			}
 		}
 	}
@@ -53,6 +53,14 @@ func (m *monitor) stop() {
 }
 
 func (m *monitor) feed(unit tttKernel.CmUnit, inputId string) {
+	// Units aligned by a merger are fed as if they come from their inputs
+	if merged, ok := unit.(*tttKernel.MergedUnit); ok {
+		for _, id := range merged.Inputs() {
+			m.feed(merged.GetUnit(id), id)
+		}
+		return
+	}
+
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
 	if !m.impl.HasInputId(inputId) {
@@ -135,7 +135,7 @@ func TestScte35Monitor(t *testing.T) {
 		data := common.NewScte35Data(spliceTime - preroll * 27_000_000, spliceTime, preroll * 90_000)
 		unit.Data = &data
 		m.Feed(unit, "abc")
-		expected = append(expected, fmt.Sprintf("|%15d|%15d", 100, preroll * 1000))
+		expected = append(expected, fmt.Sprintf("|%15d|%15d|%15s|%15s", 100, preroll * 1000, "", ""))
 	}
 
 	for idx, preroll := range preRollForDEF {
@@ -143,13 +143,16 @@ func TestScte35Monitor(t *testing.T) {
 		buf.SetField("pid", 100, false)
 		unit := common.NewMediaUnit(buf, common.DATA_UNIT)
 		data := common.NewScte35Data(spliceTime - preroll * 27_000_000, spliceTime, preroll * 90_000)
+		data.SegmentationTypeId = 0x34
+		data.Upid = "ABCD0123456789XYZ"
 		unit.Data = &data
 		m.Feed(unit, "def")
-		expected[idx] += fmt.Sprintf("|%15d|%15d", 100, preroll * 1000)
+		// UPID is truncated to the column width
+		expected[idx] += fmt.Sprintf("|%15d|%15d|%15s|%15s", 100, preroll * 1000, "0x34", "ABCD0123456789X")
 	}
 
-	expected[1] += fmt.Sprintf("|%15s|%15s", "", "")
-	expected[2] += fmt.Sprintf("|%15s|%15s", "", "")
+	expected[1] += fmt.Sprintf("|%15s|%15s|%15s|%15s", "", "", "", "")
+	expected[2] += fmt.Sprintf("|%15s|%15s|%15s|%15s", "", "", "", "")
 
 	for i := range expected {
 		expected[i] += "|"
@@ -59,4 +59,17 @@ func OutputMonitor(name string) tttKernel.IPlugin {
 		name: name,
 		monitor: newMonitor(),
 	}
-}
\ No newline at end of file
+}
+
+func init() {
+	tttKernel.RegisterPlugin(tttKernel.PluginInfo{
+		Type:        "OutputMonitor",
+		Constructor: OutputMonitor,
+		Description: "Monitor outputs of the graph, e.g. redundancy between inputs",
+		Params: []tttKernel.ParamDef{
+			{Name: "Redundancy", Type: tttKernel.FIELD_NESTED, Description: "Compare inputs for redundancy if set", Fields: []tttKernel.ParamDef{
+				{Name: "TimeRef", Type: tttKernel.FIELD_STRING, Default: "pts", Allowed: []string{"pts", "vitc"}, Description: "Time reference to align inputs"},
+			}},
+		},
+	})
+}
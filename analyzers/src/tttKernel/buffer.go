@@ -1,106 +1,339 @@
 package tttKernel
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
 
 // General buffer unit design
 /*
- * CmBuf:     The basic interface for buffer
- * simpleBuf: The simplest buffer. However, it assumes all data are integers
+ * CmBuf:    The basic interface for buffer
+ * fieldBuf: A buffer with typed fields. Fields declared in its schema come
+ *           first in declaration order, followed by other fields in insertion order.
  */
 
 type CmBuf interface {
 	GetBuf() []byte
 	ResetBuf(buf []byte)
-	ToString() string                                         // Return data as byte string
-	GetFieldAsString() string                                 // Return name of the fields as byte string
-	SetField(name string, datum interface{}, jsonIgnore bool) // Set datum to buffer. If jsonIgnore is true, the field would not appear in toString
-	GetField(name string) (interface{}, bool)                 // Return data corresponding to name and whether data can be found
+	ToString() string                                               // Return data as a CSV row
+	GetFieldAsString() string                                       // Return name of the fields as a CSV header
+	SetField(name string, datum interface{}, jsonIgnore bool) error // Set datum to buffer. If jsonIgnore is true, the field is not serialized. Ignored for declared fields, whose values of other types are dropped with an error
+	GetField(name string) (interface{}, bool)                       // Return data corresponding to name and whether data can be found
+	Fields() []string                                               // Return name of the serialized fields
+	ToCsv(columns []string) string                                  // Return data of the columns as a CSV row. Missing fields are left empty
+	MarshalJSON() ([]byte, error)                                   // Serialized fields as a JSON object
+	MarshalBinary() ([]byte, error)                                 // All fields and the buffer, restored by UnmarshalBuf
 }
 
-type simpleBuf struct {
-	dataKey    []string
-	dataVal    []interface{}
-	jsonIgnore []bool
-	buf        []byte
+type fieldBuf struct {
+	schema  *Schema
+	values  []interface{}  // Declared fields first, then undeclared fields. nil if not set.
+	dynamic []FieldDef     // Undeclared fields
+	dynIdx  map[string]int // Index of undeclared fields in values
+	buf     []byte
 }
 
-func (b *simpleBuf) GetBuf() []byte {
+func (b *fieldBuf) GetBuf() []byte {
 	return b.buf
 }
 
-func (b *simpleBuf) ResetBuf(buf []byte) {
+func (b *fieldBuf) ResetBuf(buf []byte) {
 	b.buf = buf
 }
 
-func (b *simpleBuf) ToString() string {
-	valArr := make([]string, 0)
-	for idx, datum := range b.dataVal {
-		if b.jsonIgnore[idx] {
-			continue
+func (b *fieldBuf) declaredCnt() int {
+	if b.schema == nil {
+		return 0
+	}
+	return len(b.schema.fields)
+}
+
+func (b *fieldBuf) lookup(name string) (int, bool) {
+	if b.schema != nil {
+		if idx, ok := b.schema.index[name]; ok {
+			return idx, true
 		}
-		if val, isInt := datum.(int); isInt {
-			valArr = append(valArr, strconv.Itoa(val))
-			continue
+	}
+	idx, ok := b.dynIdx[name]
+	return idx, ok
+}
+
+func (b *fieldBuf) fieldDef(idx int) FieldDef {
+	if idx < b.declaredCnt() {
+		return b.schema.fields[idx]
+	}
+	return b.dynamic[idx-b.declaredCnt()]
+}
+
+// Add a field entry to buffer.
+// Adding an existing field overwrites the value. Values of declared fields are converted to the declared type.
+func (b *fieldBuf) SetField(name string, datum interface{}, jsonIgnore bool) error {
+	idx, ok := b.lookup(name)
+	if ok && idx < b.declaredCnt() {
+		field := b.schema.fields[idx]
+		if datum == nil {
+			b.values[idx] = nil
+			return nil
 		}
-		if val, isStr := datum.(string); isStr {
-			valArr = append(valArr, val)
-			continue
+		value, isValid := toFieldValue(field.Type, datum)
+		if !isValid {
+			return fmt.Errorf("field %s of %s expects %s, but got %T", name, b.schema.name, field.Type.String(), datum)
 		}
+		b.values[idx] = value
+		return nil
 	}
-	rv := strings.Join(valArr, ",")
-	rv += "\n"
-	return rv
+
+	fieldType := inferFieldType(datum)
+	value, _ := toFieldValue(fieldType, datum)
+	field := FieldDef{Name: name, Type: fieldType, Hidden: jsonIgnore}
+	if ok {
+		b.dynamic[idx-b.declaredCnt()] = field
+		b.values[idx] = value
+		return nil
+	}
+	if b.dynIdx == nil {
+		b.dynIdx = map[string]int{}
+	}
+	b.dynIdx[name] = len(b.values)
+	b.dynamic = append(b.dynamic, field)
+	b.values = append(b.values, value)
+	return nil
+}
+
+// Integers are returned as int64 or uint64, and floats as float64
+func (b *fieldBuf) GetField(name string) (interface{}, bool) {
+	idx, ok := b.lookup(name)
+	if !ok || b.values[idx] == nil {
+		return nil, false
+	}
+	return b.values[idx], true
+}
+
+// Declared fields are always included, while undeclared fields are included once set
+func (b *fieldBuf) Fields() []string {
+	names := []string{}
+	for idx := range b.values {
+		if field := b.fieldDef(idx); !field.Hidden {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
+
+func (b *fieldBuf) ToCsv(columns []string) string {
+	cells := make([]string, len(columns))
+	for col, name := range columns {
+		if value, ok := b.GetField(name); ok {
+			cells[col] = formatCsvCell(value)
+		}
+	}
+	return strings.Join(cells, ",") + "\n"
+}
+
+func (b *fieldBuf) ToString() string {
+	return b.ToCsv(b.Fields())
 }
 
-func (b *simpleBuf) GetFieldAsString() string {
-	keyArr := make([]string, 0)
-	for idx, k := range b.dataKey {
-		if b.jsonIgnore[idx] {
+func (b *fieldBuf) GetFieldAsString() string {
+	return strings.Join(b.Fields(), ",") + "\n"
+}
+
+// Fields not set are omitted
+func (b *fieldBuf) MarshalJSON() ([]byte, error) {
+	var sb bytes.Buffer
+	sb.WriteString("{")
+	for idx, value := range b.values {
+		field := b.fieldDef(idx)
+		if field.Hidden || value == nil {
 			continue
 		}
-		keyArr = append(keyArr, k)
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if sb.Len() != 1 {
+			sb.WriteString(",")
+		}
+		name, _ := json.Marshal(field.Name)
+		sb.Write(name)
+		sb.WriteString(":")
+		sb.Write(data)
 	}
-	rv := strings.Join(keyArr, ",")
-	rv += "\n"
-	return rv
+	sb.WriteString("}")
+	return sb.Bytes(), nil
 }
 
-// Add a field entry to buffer.
-// Adding an existing field overwrites the value
-func (b *simpleBuf) SetField(name string, datum interface{}, jsonIgnore bool) {
-	fieldIdx := -1
-	for idx, f := range b.dataKey {
-		if f == name {
-			fieldIdx = idx
-			break
+/*
+ * Binary layout, with lengths and counts as uvarint:
+ *   schema name, buffer, number of fields, then for each field set:
+ *   name, type (1 byte), hidden (1 byte), value
+ * Integers are varints, floats are 8 bytes in big endian, and values of
+ * FIELD_ANY are encoded in JSON.
+ */
+func (b *fieldBuf) MarshalBinary() ([]byte, error) {
+	out := []byte{}
+	schemaName := ""
+	if b.schema != nil {
+		schemaName = b.schema.name
+	}
+	out = appendBytes(out, []byte(schemaName))
+	out = appendBytes(out, b.buf)
+
+	setCnt := 0
+	for _, value := range b.values {
+		if value != nil {
+			setCnt++
 		}
 	}
-	if fieldIdx == -1 {
-		b.dataKey = append(b.dataKey, name)
-		b.dataVal = append(b.dataVal, datum)
-		b.jsonIgnore = append(b.jsonIgnore, jsonIgnore)
-	} else {
-		b.dataVal[fieldIdx] = datum
-		b.jsonIgnore[fieldIdx] = jsonIgnore
+	out = appendUvarint(out, uint64(setCnt))
+
+	for idx, value := range b.values {
+		if value == nil {
+			continue
+		}
+		field := b.fieldDef(idx)
+		out = appendBytes(out, []byte(field.Name))
+		hidden := byte(0)
+		if field.Hidden {
+			hidden = 1
+		}
+		out = append(out, byte(field.Type), hidden)
+
+		switch field.Type {
+		case FIELD_INT64:
+			out = appendVarint(out, value.(int64))
+		case FIELD_UINT64:
+			out = appendUvarint(out, value.(uint64))
+		case FIELD_FLOAT:
+			bits := make([]byte, 8)
+			binary.BigEndian.PutUint64(bits, math.Float64bits(value.(float64)))
+			out = append(out, bits...)
+		case FIELD_STRING:
+			out = appendBytes(out, []byte(value.(string)))
+		case FIELD_BOOL:
+			if value.(bool) {
+				out = append(out, 1)
+			} else {
+				out = append(out, 0)
+			}
+		case FIELD_NESTED:
+			nested, err := value.(CmBuf).MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			out = appendBytes(out, nested)
+		default:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			out = appendBytes(out, data)
+		}
 	}
+	return out, nil
 }
 
-func (b *simpleBuf) GetField(name string) (interface{}, bool) {
-	for i := range b.dataKey {
-		if b.dataKey[i] == name {
-			return b.dataVal[i], true
+// Restore a buffer from MarshalBinary. Values of FIELD_ANY are restored as decoded JSON.
+func UnmarshalBuf(data []byte) (CmBuf, error) {
+	r := &binReader{data: data}
+	schemaName := string(r.bytes())
+	buf := r.bytes()
+
+	var b *fieldBuf
+	if schemaName == "" {
+		b = MakeSimpleBuf(buf)
+	} else if schema := GetSchema(schemaName); schema != nil {
+		b = MakeSchemaBuf(schema, buf)
+	} else {
+		return nil, fmt.Errorf("unknown schema %s", schemaName)
+	}
+
+	fieldCnt := r.uvarint()
+	for i := uint64(0); i < fieldCnt && r.err == nil; i++ {
+		name := string(r.bytes())
+		fieldType := FIELD_TYPE(r.byte())
+		hidden := r.byte() == 1
+
+		var value interface{}
+		switch fieldType {
+		case FIELD_INT64:
+			value = r.varint()
+		case FIELD_UINT64:
+			value = r.uvarint()
+		case FIELD_FLOAT:
+			value = math.Float64frombits(binary.BigEndian.Uint64(r.next(8)))
+		case FIELD_STRING:
+			value = string(r.bytes())
+		case FIELD_BOOL:
+			value = r.byte() == 1
+		case FIELD_NESTED:
+			nested, err := UnmarshalBuf(r.bytes())
+			if err != nil {
+				return nil, err
+			}
+			value = nested
+		default:
+			if err := json.Unmarshal(r.bytes(), &value); err != nil && r.err == nil {
+				r.err = err
+			}
 		}
+		if r.err == nil {
+			r.err = b.SetField(name, value, hidden)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
 	}
-	return nil, false
+	return b, nil
 }
 
+// Get an integer field of any size
 func GetBufFieldAsInt(b CmBuf, name string) (int, bool) {
+	rv, ok := GetBufFieldAsInt64(b, name)
+	return int(rv), ok
+}
+
+func GetBufFieldAsInt64(b CmBuf, name string) (int64, bool) {
+	field, ok := b.GetField(name)
+	if !ok {
+		return 0, false
+	}
+	rv, isInt := toFieldValue(FIELD_INT64, field)
+	if !isInt {
+		return 0, false
+	}
+	return rv.(int64), true
+}
+
+func GetBufFieldAsUint64(b CmBuf, name string) (uint64, bool) {
+	field, ok := b.GetField(name)
+	if !ok {
+		return 0, false
+	}
+	rv, isUint := toFieldValue(FIELD_UINT64, field)
+	if !isUint {
+		return 0, false
+	}
+	return rv.(uint64), true
+}
+
+// Integers are converted to float as well
+func GetBufFieldAsFloat(b CmBuf, name string) (float64, bool) {
 	field, ok := b.GetField(name)
-	rv, isInt := field.(int)
-	return rv, ok && isInt
+	if !ok {
+		return 0, false
+	}
+	rv, isFloat := toFieldValue(FIELD_FLOAT, field)
+	if !isFloat {
+		return 0, false
+	}
+	return rv.(float64), true
 }
 
 func GetBufFieldAsString(b CmBuf, name string) (string, bool) {
@@ -109,7 +342,116 @@ func GetBufFieldAsString(b CmBuf, name string) (string, bool) {
 	return rv, ok && isString
 }
 
-func MakeSimpleBuf(inBuf []byte) *simpleBuf {
-	rv := simpleBuf{dataKey: make([]string, 0), dataVal: make([]interface{}, 0), jsonIgnore: make([]bool, 0), buf: inBuf}
-	return &rv
+func GetBufFieldAsBool(b CmBuf, name string) (bool, bool) {
+	field, ok := b.GetField(name)
+	rv, isBool := field.(bool)
+	return rv, ok && isBool
+}
+
+// A buffer without schema. Field types are deduced from values.
+func MakeSimpleBuf(inBuf []byte) *fieldBuf {
+	return &fieldBuf{values: make([]interface{}, 0), buf: inBuf}
+}
+
+func MakeSchemaBuf(schema *Schema, inBuf []byte) *fieldBuf {
+	return &fieldBuf{schema: schema, values: make([]interface{}, len(schema.fields)), buf: inBuf}
+}
+
+// Strings are quoted if needed, and values other than numbers, strings and booleans are written in JSON
+func formatCsvCell(value interface{}) string {
+	var cell string
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		cell = v
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			data = []byte(fmt.Sprintf("%v", v))
+		}
+		cell = string(data)
+	}
+	if strings.ContainsAny(cell, ",\"\r\n") {
+		cell = "\"" + strings.ReplaceAll(cell, "\"", "\"\"") + "\""
+	}
+	return cell
+}
+
+func appendUvarint(out []byte, v uint64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	return append(out, tmp[:binary.PutUvarint(tmp, v)]...)
+}
+
+func appendVarint(out []byte, v int64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	return append(out, tmp[:binary.PutVarint(tmp, v)]...)
+}
+
+func appendBytes(out []byte, data []byte) []byte {
+	out = appendUvarint(out, uint64(len(data)))
+	return append(out, data...)
+}
+
+// Reader of MarshalBinary output. The first error is kept and later reads return zero values.
+type binReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binReader) next(n int) []byte {
+	if r.err == nil && (n < 0 || n > len(r.data)) {
+		r.err = errors.New("truncated buffer")
+	}
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	rv := r.data[:n]
+	r.data = r.data[n:]
+	return rv
+}
+
+func (r *binReader) byte() byte {
+	return r.next(1)[0]
+}
+
+func (r *binReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errors.New("invalid varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errors.New("invalid varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binReader) bytes() []byte {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.err = errors.New("truncated buffer")
+		return nil
+	}
+	return r.next(int(n))
 }
@@ -28,6 +28,33 @@ func Throw_error(h RequestHandler, name string, err error) {
 	h(name, ERROR_REQUEST, err)
 }
 
+// Report an error with severity and context. The node handles it according to its OnError policy.
+func Report_error(h RequestHandler, name string, severity ERROR_SEVERITY, context string, err error) {
+	Throw_error(h, name, NewPluginError(severity, context, err))
+}
+
+// Restart a plugin of the running graph with new parameters
+func Update_parameter(h RequestHandler, name string, plugin string, param string) {
+	body := MakeSimpleBuf([]byte{})
+	body.SetField("plugin", plugin, true)
+	body.SetField("param", param, true)
+	Post_status(h, name, MakeStatusUnit(STATUS_UPDATE_PARAMETER, body))
+}
+
+// Add plugins to the running graph
+func Add_branch(h RequestHandler, name string, params []OverallParams) {
+	body := MakeSimpleBuf([]byte{})
+	body.SetField("params", params, true)
+	Post_status(h, name, MakeStatusUnit(STATUS_ADD_BRANCH, body))
+}
+
+// Remove plugins from the running graph
+func Remove_branch(h RequestHandler, name string, plugins []string) {
+	body := MakeSimpleBuf([]byte{})
+	body.SetField("plugins", plugins, true)
+	Post_status(h, name, MakeStatusUnit(STATUS_REMOVE_BRANCH, body))
+}
+
 func Listen_msg(h RequestHandler, name string, msgId int) {
 	if h == nil {
 		panic(fmt.Sprintf("Error in registering status destination for plugin %s with id %d", name, msgId))
@@ -25,7 +25,10 @@ func GetBytesInBuf(unit CmUnit) []byte {
  * Status is assumed to be immutable and has unique id. IDs less than 10 are reserved for common use.
  */
 const (
-	STATUS_END_ROUTINE int = 1
+	STATUS_END_ROUTINE      int = 1
+	STATUS_UPDATE_PARAMETER int = 2 // Handled by worker. Body fields: plugin, param
+	STATUS_ADD_BRANCH       int = 3 // Handled by worker. Body fields: params ([]OverallParams)
+	STATUS_REMOVE_BRANCH    int = 4 // Handled by worker. Body fields: plugins ([]string)
 )
 
 type CmStatusUnit struct {
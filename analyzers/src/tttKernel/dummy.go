@@ -1,6 +1,7 @@
 package tttKernel
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/tony-507/analyzers/src/logging"
@@ -35,7 +36,8 @@ func (dp *dummyPlugin) SetResource(resourceLoader *ResourceLoader) {}
 
 func (dp *dummyPlugin) StartSequence() {
 	if dp.role == 0 {
-		go dp.DeliverUnit(nil, "")
+		reqUnit := MakeReqUnit(dp.name, DELIVER_REQUEST)
+		Post_request(dp.callback, dp.name, reqUnit)
 	}
 }
 
@@ -95,3 +97,59 @@ func dummy(name string, role int) IPlugin {
 	rv := dummyPlugin{name: name, logger: logging.CreateLogger(name), role: role}
 	return &rv
 }
+
+// A root emitting cnt units with increasing values through the deliver loop. Endless if cnt < 0.
+type dummyProducer struct {
+	dummyPlugin
+	cnt  int
+	sent int
+}
+
+func (dp *dummyProducer) StartSequence() {
+	Post_request(dp.callback, dp.name, MakeReqUnit(dp.name, DELIVER_REQUEST))
+}
+
+func (dp *dummyProducer) EndSequence() {}
+
+func (dp *dummyProducer) DeliverUnit(unit CmUnit, inputId string) {
+	if dp.sent == dp.cnt {
+		Post_request(dp.callback, dp.name, MakeReqUnit(dp.name, EOS_REQUEST))
+		return
+	}
+	dp.sent++
+	Post_request(dp.callback, dp.name, MakeReqUnit(dp.name, FETCH_REQUEST))
+	Post_request(dp.callback, dp.name, MakeReqUnit(dp.name, DELIVER_REQUEST))
+}
+
+func (dp *dummyProducer) FetchUnit() CmUnit {
+	return &dummyUnit{buf: MakeSimpleBuf([]byte{byte(dp.sent)})}
+}
+
+// A sink recording received values
+type dummyRecorder struct {
+	dummyPlugin
+	received []int
+}
+
+func (dp *dummyRecorder) DeliverUnit(unit CmUnit, inputId string) {
+	dp.received = append(dp.received, int(GetBytesInBuf(unit)[0]))
+}
+
+// A sink failing on every unit with value divisible by failEvery, by panic or by a fatal error
+type dummyFaulty struct {
+	dummyRecorder
+	failEvery int
+	fatal     bool
+}
+
+func (dp *dummyFaulty) DeliverUnit(unit CmUnit, inputId string) {
+	val := int(GetBytesInBuf(unit)[0])
+	if val%dp.failEvery == 0 {
+		if dp.fatal {
+			Report_error(dp.callback, dp.name, SEVERITY_FATAL, inputId, errors.New("fatal"))
+			return
+		}
+		panic("bad unit")
+	}
+	dp.received = append(dp.received, val)
+}
@@ -2,6 +2,8 @@ package tttKernel
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/tony-507/analyzers/src/logging"
 )
@@ -19,6 +21,10 @@ func (param OverallParams) toString() string {
 	return str
 }
 
+func (param OverallParams) Name() string {
+	return param.pluginName
+}
+
 func ConstructOverallParam(name string, params string, children []string) OverallParams {
 	return OverallParams{pluginName: name, pluginParam: params, children: children}
 }
@@ -82,3 +88,34 @@ func buildGraph(params []OverallParams, selectPlugin func(string) IPlugin) []*gr
 
 	return nodeList
 }
+
+// Export the graph in Graphviz DOT format. Undeclared plugins are drawn dashed in red.
+func ExportDot(graphName string, params []OverallParams) string {
+	var sb strings.Builder
+	declared := map[string]bool{}
+	for _, param := range params {
+		declared[param.pluginName] = true
+	}
+
+	sb.WriteString(fmt.Sprintf("digraph %s {\n", strconv.Quote(graphName)))
+	sb.WriteString("\tnode [shape=box];\n")
+	written := map[string]bool{}
+	for _, param := range params {
+		if written[param.pluginName] {
+			continue
+		}
+		written[param.pluginName] = true
+		sb.WriteString(fmt.Sprintf("\t%s [tooltip=%s];\n", strconv.Quote(param.pluginName), strconv.Quote(param.pluginParam)))
+	}
+	for _, param := range params {
+		for _, child := range param.children {
+			if !declared[child] && !written[child] {
+				written[child] = true
+				sb.WriteString(fmt.Sprintf("\t%s [style=dashed, color=red];\n", strconv.Quote(child)))
+			}
+			sb.WriteString(fmt.Sprintf("\t%s -> %s;\n", strconv.Quote(param.pluginName), strconv.Quote(child)))
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
@@ -1,8 +1,10 @@
 package tttKernel
 
 import (
+	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // A plugin serves as a graph node of operation graph
@@ -13,59 +15,427 @@ const (
 	STOPPED _PLUGIN_STATE = 1
 )
 
+// Number of units buffered between a node and each of its children. A parent
+// blocks when a child falls behind, so slow nodes throttle the whole path.
+const _NODE_INPUT_CAPACITY = 256
+
+// A unit delivered from a parent, or an end of stream signal from it
+type nodeInput struct {
+	unit    CmUnit
+	inputId string
+	eos     bool
+	detach  *graphNode // The parent is removed from the graph without ending this node
+}
+
+// A request raised by the plugin itself, or a status from other plugins
+type nodeRequest struct {
+	reqType   WORKER_REQUEST
+	unit      CmUnit
+	err       *PluginError
+	propagate bool         // Internal request to forward EOS to children
+	apply     func() error // Internal request to run in the goroutine of the node
+	result    chan error   // Result of apply, if it is waited
+}
+
+/*
+ * Each node runs in its own goroutine and is the only one calling into its
+ * plugin after start up, except for diagnostics which is guarded by mtx.
+ * Requests posted by the plugin are queued and handled after the current call
+ * returns, so plugins can post requests from any goroutine without deadlock.
+ */
 type graphNode struct {
 	impl        IPlugin
 	m_parameter string // Store plugin parameters
 	m_state     _PLUGIN_STATE
+	m_name      string
 	children    []*graphNode
 	parent      []*graphNode
 	mtx         sync.Mutex
+	inputs      chan nodeInput
+	requests    []nodeRequest
+	reqMtx      sync.Mutex
+	reqSignal   chan struct{}
+	eosCnt      int  // Number of parents reaching end of stream
+	propagated  bool // EOS forwarded to children
+	factory     func(string) IPlugin
+	loader      *ResourceLoader
+	handler     RequestHandler // Worker callback
+	generation  int32          // Incremented on restart to ignore requests from the previous plugin
+	policy      ERROR_POLICY
+	stopGraph   func()
+	errCnt      [3]int // Per severity
+	restartCnt  int
+	root        bool                    // No parent when started. Roots are ended first on shutdown.
+	removed     map[string]bool         // Plugins removed with this node. Other children are detached instead of ended.
+	exited      chan struct{}           // Closed when the goroutine of the node returns
+	recorders   map[string]*TraceWriter // Traces of units delivered from parents, by parent name
 }
 
 // Graph node control flow
-func (node *graphNode) stopPlugin() {
-	if node.m_state == STOPPED {
+func (node *graphNode) run(done <-chan struct{}) {
+	defer node.closeRecorders()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		if req, ok := node.popRequest(); ok {
+			node.handleRequest(req, done)
+			continue
+		}
+
+		if node.propagated && node.eosCnt >= len(node.parent) {
+			return
+		}
+
+		select {
+		case input := <-node.inputs:
+			node.handleInput(input)
+		case <-node.reqSignal:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (node *graphNode) handleInput(input nodeInput) {
+	if input.detach != nil {
+		node.detachParent(input.detach)
+		return
+	}
+	if input.eos {
+		node.eosCnt++
+		if node.eosCnt == len(node.parent) {
+			node.stopPlugin()
+		}
+		return
+	}
+	// Units arriving after the plugin stops are drained so that parents are not blocked
+	if node.m_state == RUNNING {
+		node.recordUnit(input.unit, input.inputId)
+		node.deliverUnit(input.unit, input.inputId)
+	}
+}
+
+// Recording stops on the first failure of a trace
+func (node *graphNode) recordUnit(unit CmUnit, inputId string) {
+	recorder, ok := node.recorders[inputId]
+	if !ok {
+		return
+	}
+	if err := recorder.Write(unit, inputId); err != nil {
+		node.report(NewPluginError(SEVERITY_WARNING, "record", err))
+		recorder.Close()
+		delete(node.recorders, inputId)
+	}
+}
+
+func (node *graphNode) closeRecorders() {
+	for inputId, recorder := range node.recorders {
+		if err := recorder.Close(); err != nil {
+			node.report(NewPluginError(SEVERITY_WARNING, "record", err))
+		}
+		delete(node.recorders, inputId)
+	}
+}
+
+func (node *graphNode) handleRequest(req nodeRequest, done <-chan struct{}) {
+	if req.apply != nil {
+		err := node.safeApply(req.apply)
+		if req.result != nil {
+			req.result <- err
+		} else if err != nil {
+			node.report(NewPluginError(SEVERITY_ERROR, "reconfiguration", err))
+		}
+		return
+	}
+
+	if req.propagate {
+		for _, child := range node.children {
+			input := nodeInput{eos: true}
+			if node.removed != nil && !node.removed[child.name()] {
+				input = nodeInput{detach: node}
+			}
+			node.send(child, input, done)
+		}
+		node.propagated = true
+		return
+	}
+
+	switch req.reqType {
+	case FETCH_REQUEST:
+		// Still served after EndSequence so that plugins can flush their output
+		if node.propagated {
+			return
+		}
+		outputUnit := node.fetchUnit()
+		if outputUnit == nil {
+			return
+		}
+		for _, child := range node.children {
+			if !node.send(child, nodeInput{unit: outputUnit, inputId: node.name()}, done) {
+				return
+			}
+		}
+	case DELIVER_REQUEST:
+		if node.m_state == RUNNING {
+			node.deliverUnit(nil, "worker")
+		}
+	case EOS_REQUEST:
+		node.stopPlugin()
+	case STATUS_REQUEST:
+		if node.m_state == RUNNING {
+			node.deliverStatus(req.unit)
+		}
+	case ERROR_REQUEST:
+		node.handleError(req.err)
+	}
+}
+
+// Apply the failure policy of the node
+func (node *graphNode) handleError(err *PluginError) {
+	node.mtx.Lock()
+	node.errCnt[err.Severity]++
+	node.mtx.Unlock()
+	node.loader.Metrics().Counter("ttt_plugin_errors_total", "Errors reported by a plugin", "plugin", "severity").
+		Inc(node.m_name, err.Severity.String())
+
+	if err.Severity == SEVERITY_WARNING {
+		return
+	}
+	policy := node.policy
+	if err.Severity == SEVERITY_FATAL && policy == SKIP_UNIT {
+		policy = STOP_GRAPH
+	}
+
+	switch policy {
+	case RESTART_NODE:
+		if node.m_state == STOPPED {
+			return
+		}
+		if node.restartCnt >= _MAX_NODE_RESTART {
+			node.report(NewPluginError(SEVERITY_WARNING, "restart", fmt.Errorf("restarted %d times, stop graph", node.restartCnt)))
+			node.stopGraph()
+			return
+		}
+		node.restart()
+	case STOP_GRAPH:
+		node.stopGraph()
+	}
+}
+
+// Remove a parent without ending this node, unless no parent is left
+func (node *graphNode) detachParent(parent *graphNode) {
+	for idx, p := range node.parent {
+		if p == parent {
+			node.parent = append(node.parent[:idx:idx], node.parent[idx+1:]...)
+			break
+		}
+	}
+	if node.eosCnt >= len(node.parent) {
+		node.stopPlugin()
+	}
+}
+
+// Restart the plugin with the same parameters after errors
+func (node *graphNode) restart() {
+	if err := node.safeApply(func() error { return node.replacePlugin(node.m_parameter) }); err != nil {
+		node.report(NewPluginError(SEVERITY_WARNING, "restart", err))
+		node.stopGraph()
 		return
-	} else {
-		node.mtx.Lock()
-		defer node.mtx.Unlock()
-		node.impl.EndSequence()
-		node.m_state = STOPPED
 	}
+	node.mtx.Lock()
+	node.restartCnt++
+	node.mtx.Unlock()
+	node.loader.Metrics().Counter("ttt_plugin_restarts_total", "Restarts of a plugin after errors", "plugin").Inc(node.m_name)
+}
+
+// Restart the plugin with new parameters. The error policy is updated as well.
+func (node *graphNode) updateParameter(param string) error {
+	if node.m_state == STOPPED {
+		return fmt.Errorf("plugin %s has ended", node.m_name)
+	}
+	policy, err := parseErrorPolicy(param)
+	if err != nil {
+		return err
+	}
+	if err := node.replacePlugin(param); err != nil {
+		return err
+	}
+	node.policy = policy
+	return nil
+}
+
+/*
+ * Replace the plugin with a new instance. The old one is kept if the new one
+ * fails to accept the parameters. Pending requests of the old one are dropped,
+ * while internal requests are kept.
+ */
+func (node *graphNode) replacePlugin(param string) error {
+	impl := node.factory(node.m_name)
+	impl.SetParameter(param)
+	impl.SetResource(node.loader)
+
+	node.mtx.Lock()
+	defer node.mtx.Unlock()
+
+	atomic.AddInt32(&node.generation, 1)
+	impl.SetCallback(node.makeCallback())
+	node.endPlugin()
+
+	node.reqMtx.Lock()
+	kept := make([]nodeRequest, 0)
+	for _, req := range node.requests {
+		if req.apply != nil || req.propagate {
+			kept = append(kept, req)
+		}
+	}
+	node.requests = kept
+	node.reqMtx.Unlock()
+
+	node.impl = impl
+	node.m_parameter = param
+	node.impl.StartSequence()
+	return nil
+}
+
+// Run a function in the goroutine of the node and wait for the result
+func (node *graphNode) call(fn func() error, done <-chan struct{}) error {
+	result := make(chan error, 1)
+	node.pushRequest(nodeRequest{apply: fn, result: result})
+	select {
+	case err := <-result:
+		return err
+	case <-node.exited:
+	case <-done:
+	}
+	// The node may return right after handling the request
+	select {
+	case err := <-result:
+		return err
+	default:
+		return fmt.Errorf("plugin %s has ended", node.m_name)
+	}
+}
+
+func (node *graphNode) safeApply(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return fn()
+}
+
+// EndSequence of a failed plugin may fail again
+func (node *graphNode) endPlugin() {
+	defer func() {
+		recover()
+	}()
+	node.impl.EndSequence()
+}
 
-	for _, child := range node.children {
-		child.impl.EndSequence()
+// Requests from a plugin replaced by restart are ignored
+func (node *graphNode) makeCallback() RequestHandler {
+	gen := atomic.LoadInt32(&node.generation)
+	return func(name string, reqType WORKER_REQUEST, obj interface{}) {
+		if atomic.LoadInt32(&node.generation) == gen {
+			node.handler(name, reqType, obj)
+		}
 	}
 }
 
+func (node *graphNode) report(err *PluginError) {
+	node.handler(node.m_name, ERROR_REQUEST, err)
+}
+
+// Turn a panic in a plugin call into an error so that one bad unit does not take down the worker
+func (node *graphNode) recoverPanic(call string) {
+	if r := recover(); r != nil {
+		node.report(NewPluginError(SEVERITY_ERROR, fmt.Sprintf("panic in %s", call), fmt.Errorf("%v", r)))
+	}
+}
+
+// Blocking send to a child. Return false if the graph is stopped meanwhile.
+func (node *graphNode) send(child *graphNode, input nodeInput, done <-chan struct{}) bool {
+	select {
+	case child.inputs <- input:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+func (node *graphNode) pushRequest(req nodeRequest) {
+	node.reqMtx.Lock()
+	node.requests = append(node.requests, req)
+	node.reqMtx.Unlock()
+
+	select {
+	case node.reqSignal <- struct{}{}:
+	default:
+	}
+}
+
+func (node *graphNode) popRequest() (nodeRequest, bool) {
+	node.reqMtx.Lock()
+	defer node.reqMtx.Unlock()
+	if len(node.requests) == 0 {
+		return nodeRequest{}, false
+	}
+	rv := node.requests[0]
+	node.requests = node.requests[1:]
+	return rv, true
+}
+
+// End the plugin and forward EOS to children after requests raised so far
+func (node *graphNode) stopPlugin() {
+	if node.m_state == STOPPED {
+		return
+	}
+	node.m_state = STOPPED
+	node.mtx.Lock()
+	node.impl.EndSequence()
+	node.mtx.Unlock()
+	node.pushRequest(nodeRequest{propagate: true})
+}
+
 func (node *graphNode) printInfo(sb *strings.Builder) {
 	node.mtx.Lock()
 	defer node.mtx.Unlock()
 	node.impl.PrintInfo(sb)
+	if node.errCnt != [3]int{} || node.restartCnt != 0 {
+		sb.WriteString(fmt.Sprintf("\tErrors: %d warnings, %d errors, %d fatal, %d restarts\n",
+			node.errCnt[SEVERITY_WARNING], node.errCnt[SEVERITY_ERROR], node.errCnt[SEVERITY_FATAL], node.restartCnt))
+	}
 }
 
 func (node *graphNode) deliverUnit(unit CmUnit, inputId string) {
+	defer node.recoverPanic("DeliverUnit")
 	node.mtx.Lock()
 	defer node.mtx.Unlock()
 	node.impl.DeliverUnit(unit, inputId)
 }
 
 func (node *graphNode) fetchUnit() CmUnit {
+	defer node.recoverPanic("FetchUnit")
 	node.mtx.Lock()
 	defer node.mtx.Unlock()
 	return node.impl.FetchUnit()
 }
 
 func (node *graphNode) deliverStatus(status CmUnit) {
+	defer node.recoverPanic("DeliverStatus")
 	node.mtx.Lock()
 	defer node.mtx.Unlock()
 	node.impl.DeliverStatus(status)
 }
 
+// Cached on creation as it is queried from plugin callbacks, where mtx may be held
 func (node *graphNode) name() string {
-	node.mtx.Lock()
-	defer node.mtx.Unlock()
-	return node.impl.Name()
+	return node.m_name
 }
 
 // Graph construction
@@ -78,10 +448,17 @@ func addPath(parent *graphNode, children []*graphNode) {
 
 func getPluginByName(inputName string, selectPlugin func(string) IPlugin) *graphNode {
 	// Deduce the type of plugin by name
+	impl := selectPlugin(inputName)
 	return &graphNode{
-		children: make([]*graphNode, 0),
-		parent: make([]*graphNode, 0),
-		m_state: RUNNING,
-		impl: selectPlugin(inputName),
+		children:  make([]*graphNode, 0),
+		parent:    make([]*graphNode, 0),
+		m_state:   RUNNING,
+		m_name:    impl.Name(),
+		impl:      impl,
+		factory:   selectPlugin,
+		inputs:    make(chan nodeInput, _NODE_INPUT_CAPACITY),
+		requests:  make([]nodeRequest, 0),
+		reqSignal: make(chan struct{}, 1),
+		exited:    make(chan struct{}),
 	}
 }
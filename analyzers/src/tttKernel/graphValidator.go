@@ -0,0 +1,208 @@
+package tttKernel
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type GRAPH_ERROR int
+
+const (
+	DUPLICATE_PLUGIN   GRAPH_ERROR = 0 // Two plugins share the same name
+	UNDEFINED_LINK     GRAPH_ERROR = 1 // A link refers to a plugin not declared
+	UNKNOWN_PLUGIN     GRAPH_ERROR = 2 // No plugin type matches the name
+	CYCLIC_GRAPH       GRAPH_ERROR = 3 // Output of a plugin flows back to itself
+	UNREACHABLE_PLUGIN GRAPH_ERROR = 4 // A plugin never receives data from a root
+	INVALID_PARAMETER  GRAPH_ERROR = 5 // Parameters do not match the schema of a registered plugin
+)
+
+func (e GRAPH_ERROR) String() string {
+	switch e {
+	case DUPLICATE_PLUGIN:
+		return "duplicate plugin"
+	case UNDEFINED_LINK:
+		return "undefined link"
+	case UNKNOWN_PLUGIN:
+		return "unknown plugin"
+	case CYCLIC_GRAPH:
+		return "cyclic graph"
+	case UNREACHABLE_PLUGIN:
+		return "unreachable plugin"
+	case INVALID_PARAMETER:
+		return "invalid parameter"
+	default:
+		return "unknown error"
+	}
+}
+
+// A problem found in a graph, with the plugins involved
+type GraphError struct {
+	Kind    GRAPH_ERROR
+	Plugins []string
+	Msg     string
+}
+
+func (e GraphError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind.String(), e.Msg)
+}
+
+// All problems found in a graph
+type GraphErrors []GraphError
+
+func (e GraphErrors) Error() string {
+	msgs := []string{}
+	for _, graphErr := range e {
+		msgs = append(msgs, graphErr.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+/*
+ * Validate a graph before building it. Plugin types are not checked if
+ * isKnownPlugin is nil, while parameters of registered plugins are always
+ * checked. Return GraphErrors if any problem is found.
+ */
+func ValidateGraph(params []OverallParams, isKnownPlugin func(string) bool) error {
+	errs := GraphErrors{}
+
+	declared := map[string]bool{}
+	for _, param := range params {
+		if declared[param.pluginName] {
+			errs = append(errs, GraphError{
+				Kind:    DUPLICATE_PLUGIN,
+				Plugins: []string{param.pluginName},
+				Msg:     fmt.Sprintf("Plugin %s is declared more than once", param.pluginName),
+			})
+			continue
+		}
+		declared[param.pluginName] = true
+		if isKnownPlugin != nil && !isKnownPlugin(param.pluginName) {
+			errs = append(errs, GraphError{
+				Kind:    UNKNOWN_PLUGIN,
+				Plugins: []string{param.pluginName},
+				Msg:     fmt.Sprintf("Plugin %s does not match any plugin type", param.pluginName),
+			})
+		}
+		if info, ok := GetPluginInfo(param.pluginName); ok {
+			if err := info.ValidateParam(param.pluginParam); err != nil {
+				errs = append(errs, GraphError{
+					Kind:    INVALID_PARAMETER,
+					Plugins: []string{param.pluginName},
+					Msg:     fmt.Sprintf("Plugin %s: %s", param.pluginName, err.Error()),
+				})
+			}
+		}
+	}
+
+	edges := map[string][]string{}
+	hasParent := map[string]bool{}
+	hasChild := map[string]bool{} // Links to undefined plugins included
+	for _, param := range params {
+		for _, child := range param.children {
+			hasChild[param.pluginName] = true
+			if !declared[child] {
+				errs = append(errs, GraphError{
+					Kind:    UNDEFINED_LINK,
+					Plugins: []string{param.pluginName, child},
+					Msg:     fmt.Sprintf("Plugin %s links to undefined plugin \"%s\"", param.pluginName, child),
+				})
+				continue
+			}
+			edges[param.pluginName] = append(edges[param.pluginName], child)
+			hasParent[child] = true
+		}
+	}
+
+	names := []string{}
+	for name := range declared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, cycle := range findCycles(names, edges) {
+		errs = append(errs, GraphError{
+			Kind:    CYCLIC_GRAPH,
+			Plugins: cycle,
+			Msg:     fmt.Sprintf("Cycle found: %s", strings.Join(cycle, " -> ")),
+		})
+	}
+
+	// Plugins only fed by a cycle never receive data, and isolated plugins do nothing
+	reached := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		if reached[name] {
+			return
+		}
+		reached[name] = true
+		for _, child := range edges[name] {
+			visit(child)
+		}
+	}
+	for _, name := range names {
+		if !hasParent[name] {
+			visit(name)
+		}
+	}
+	for _, name := range names {
+		if !reached[name] {
+			errs = append(errs, GraphError{
+				Kind:    UNREACHABLE_PLUGIN,
+				Plugins: []string{name},
+				Msg:     fmt.Sprintf("Plugin %s is not reachable from any root plugin", name),
+			})
+		} else if len(names) > 1 && !hasParent[name] && !hasChild[name] {
+			errs = append(errs, GraphError{
+				Kind:    UNREACHABLE_PLUGIN,
+				Plugins: []string{name},
+				Msg:     fmt.Sprintf("Plugin %s is not linked to any other plugin", name),
+			})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Depth-first search. Each cycle is reported once, starting from the plugin first visited.
+func findCycles(names []string, edges map[string][]string) [][]string {
+	const (
+		unvisited = 0
+		visiting  = 1
+		visited   = 2
+	)
+	state := map[string]int{}
+	path := []string{}
+	cycles := [][]string{}
+
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		path = append(path, name)
+		for _, child := range edges[name] {
+			switch state[child] {
+			case unvisited:
+				visit(child)
+			case visiting:
+				start := 0
+				for path[start] != child {
+					start++
+				}
+				cycle := append([]string{}, path[start:]...)
+				cycles = append(cycles, append(cycle, child))
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+	}
+
+	for _, name := range names {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+	return cycles
+}
@@ -0,0 +1,385 @@
+package tttKernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tony-507/analyzers/src/logging"
+)
+
+/*
+ * Merger is a plugin built in the kernel. It buffers units from several
+ * parents and releases them in groups aligned by a clock, so that children
+ * compare inputs without their own alignment, e.g. for redundancy, ABR
+ * alignment and A/B comparisons. Each input should carry a single stream.
+ *
+ * The clock is read from a field of unit buffers. Units within Tolerance of
+ * the earliest buffered unit form a group. A group is released when every
+ * input has a unit in it, or when the missing inputs have moved past it or
+ * are late for more than Timeout of stream time. Both are in ms.
+ */
+
+type mergeClock struct {
+	field      string
+	ticksPerMs int64 // Zero for VITC, which depends on frame rate
+	wrap       int64
+}
+
+var mergeClocks = map[string]mergeClock{
+	"pts":  {field: "pts", ticksPerMs: 90, wrap: 1 << 33},
+	"pcr":  {field: "pcr", ticksPerMs: 27000, wrap: (1 << 33) * 300},
+	"rtp":  {field: "rtpTimestamp", ticksPerMs: 90, wrap: 1 << 32},
+	"vitc": {field: "timecode", ticksPerMs: 0, wrap: 0},
+}
+
+var mergedUnitSchema = NewSchema("MergedUnit",
+	Field("clock", FIELD_INT64),
+	Field("inputs", FIELD_STRING),
+	Field("missing", FIELD_STRING),
+)
+
+// Units from several inputs aligned to the same clock
+type MergedUnit struct {
+	buf    CmBuf
+	clock  int64
+	inputs []string
+	units  map[string]CmUnit
+}
+
+func (m *MergedUnit) GetBuf() CmBuf {
+	return m.buf
+}
+
+// Fields of mergedUnitSchema, where inputs and missing are comma-separated
+func (m *MergedUnit) GetField(name string) interface{} {
+	value, _ := m.buf.GetField(name)
+	return value
+}
+
+// Inputs with a unit in the group, in the order they are first seen
+func (m *MergedUnit) Inputs() []string {
+	return m.inputs
+}
+
+// Nil if the input is missing in the group
+func (m *MergedUnit) GetUnit(inputId string) CmUnit {
+	return m.units[inputId]
+}
+
+type mergerParam struct {
+	Clock     string   // pts, pcr, rtp or vitc
+	Field     string   // Buffer field of the clock, default to the one of Clock
+	Tolerance int      // Maximum difference in ms between units of a group
+	Timeout   int      // Stream time in ms to wait for a missing input
+	MaxQueue  int      // Maximum number of units buffered for an input before releasing without waiting
+	Fps       int      // Frame rate to count VITC
+	Inputs    []string // Inputs expected from the start. Others are added when their first units arrive
+}
+
+func defaultMergerParam() mergerParam {
+	return mergerParam{
+		Clock:     "pts",
+		Tolerance: 0,
+		Timeout:   1000,
+		MaxQueue:  100,
+		Fps:       30,
+		Inputs:    []string{},
+	}
+}
+
+type mergeEntry struct {
+	clock int64 // Unwrapped
+	unit  CmUnit
+}
+
+type mergeInput struct {
+	id      string
+	queue   []mergeEntry
+	last    int64 // Last unwrapped clock
+	started bool
+	missCnt int
+}
+
+type mergerPlugin struct {
+	name        string
+	logger      logging.Log
+	callback    RequestHandler
+	param       mergerParam
+	clock       mergeClock
+	tolerance   int64 // In clock ticks
+	timeout     int64 // In clock ticks
+	inputs      []*mergeInput
+	latest      int64 // Latest unwrapped clock of all inputs
+	hasLatest   bool
+	outputQueue []CmUnit
+	groupCnt    int
+	partialCnt  int
+	skipCnt     int // Units without clock
+}
+
+func (m *mergerPlugin) SetCallback(callback RequestHandler) {
+	m.callback = callback
+}
+
+func (m *mergerPlugin) SetParameter(m_parameter string) {
+	param := defaultMergerParam()
+	if err := json.Unmarshal([]byte(m_parameter), &param); err != nil {
+		panic(err)
+	}
+	clock, ok := mergeClocks[param.Clock]
+	if !ok {
+		panic(fmt.Sprintf("Unknown clock %s", param.Clock))
+	}
+	if param.Field != "" {
+		clock.field = param.Field
+	}
+	m.param = param
+	m.clock = clock
+	if clock.ticksPerMs == 0 {
+		if param.Fps <= 0 {
+			panic(fmt.Sprintf("Invalid frame rate %d", param.Fps))
+		}
+		m.clock.wrap = int64(24 * 3600 * param.Fps)
+		m.tolerance = int64(param.Tolerance * param.Fps / 1000)
+		m.timeout = int64(param.Timeout * param.Fps / 1000)
+	} else {
+		m.tolerance = int64(param.Tolerance) * clock.ticksPerMs
+		m.timeout = int64(param.Timeout) * clock.ticksPerMs
+	}
+	for _, inputId := range param.Inputs {
+		m.getInput(inputId)
+	}
+}
+
+func (m *mergerPlugin) SetResource(loader *ResourceLoader) {}
+
+func (m *mergerPlugin) StartSequence() {
+	m.logger.Info("Merging inputs by %s in field %s", m.param.Clock, m.clock.field)
+}
+
+func (m *mergerPlugin) DeliverUnit(unit CmUnit, inputId string) {
+	raw, ok := m.getClock(unit)
+	if !ok {
+		m.skipCnt++
+		return
+	}
+	input := m.getInput(inputId)
+	clock := m.unwrap(input, raw)
+	input.queue = append(input.queue, mergeEntry{clock: clock, unit: unit})
+	if !m.hasLatest || clock > m.latest {
+		m.latest = clock
+		m.hasLatest = true
+	}
+	m.release(false)
+}
+
+func (m *mergerPlugin) DeliverStatus(unit CmUnit) {}
+
+func (m *mergerPlugin) FetchUnit() CmUnit {
+	if len(m.outputQueue) == 0 {
+		return nil
+	}
+	rv := m.outputQueue[0]
+	m.outputQueue = m.outputQueue[1:]
+	return rv
+}
+
+// Release everything buffered
+func (m *mergerPlugin) EndSequence() {
+	m.release(true)
+}
+
+func (m *mergerPlugin) PrintInfo(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\tGroups: %d, partial: %d, units without clock: %d\n", m.groupCnt, m.partialCnt, m.skipCnt))
+	for _, input := range m.inputs {
+		sb.WriteString(fmt.Sprintf("\t%s: %d buffered, missing in %d groups\n", input.id, len(input.queue), input.missCnt))
+	}
+}
+
+func (m *mergerPlugin) Name() string {
+	return m.name
+}
+
+func (m *mergerPlugin) getInput(inputId string) *mergeInput {
+	for _, input := range m.inputs {
+		if input.id == inputId {
+			return input
+		}
+	}
+	input := &mergeInput{id: inputId, queue: []mergeEntry{}}
+	m.inputs = append(m.inputs, input)
+	return input
+}
+
+// Negative values mean the clock is absent
+func (m *mergerPlugin) getClock(unit CmUnit) (int64, bool) {
+	buf := unit.GetBuf()
+	if buf == nil {
+		return 0, false
+	}
+	if m.clock.ticksPerMs == 0 {
+		tc, ok := GetBufFieldAsString(buf, m.clock.field)
+		if !ok {
+			return 0, false
+		}
+		return timecodeToFrames(tc, m.param.Fps)
+	}
+	clock, ok := GetBufFieldAsInt64(buf, m.clock.field)
+	return clock, ok && clock >= 0
+}
+
+// Count frames of a timecode in the form of HH:MM:SS:FF, or HH:MM:SS;FF for drop frame
+func timecodeToFrames(tc string, fps int) (int64, bool) {
+	parts := strings.FieldsFunc(tc, func(r rune) bool { return r == ':' || r == ';' })
+	if len(parts) != 4 {
+		return 0, false
+	}
+	values := [4]int64{}
+	for idx, part := range parts {
+		value, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || value < 0 {
+			return 0, false
+		}
+		values[idx] = value
+	}
+	return ((values[0]*60+values[1])*60+values[2])*int64(fps) + values[3], true
+}
+
+// Take the value nearest to the last clock of the input, or to other inputs for the first unit
+func (m *mergerPlugin) unwrap(input *mergeInput, raw int64) int64 {
+	ref := raw
+	if input.started {
+		ref = input.last
+	} else if m.hasLatest {
+		ref = m.latest
+	}
+	wrap := m.clock.wrap
+	diff := ref - raw + wrap/2
+	k := diff / wrap
+	if diff < 0 && diff%wrap != 0 {
+		k--
+	}
+	input.last = raw + k*wrap
+	input.started = true
+	return input.last
+}
+
+func (m *mergerPlugin) release(flush bool) {
+	for {
+		var earliest *mergeInput
+		for _, input := range m.inputs {
+			if len(input.queue) != 0 && (earliest == nil || input.queue[0].clock < earliest.queue[0].clock) {
+				earliest = input
+			}
+		}
+		if earliest == nil {
+			return
+		}
+		clock := earliest.queue[0].clock
+
+		members := []*mergeInput{}
+		ready := true
+		for _, input := range m.inputs {
+			if len(input.queue) != 0 && input.queue[0].clock-clock <= m.tolerance {
+				members = append(members, input)
+			} else if !m.hasMissed(input, clock) {
+				ready = false
+			}
+		}
+		// Stop waiting for missing inputs if too many units are buffered
+		if !ready && !flush && !m.isOverflow() {
+			return
+		}
+		m.emit(clock, members)
+	}
+}
+
+func (m *mergerPlugin) isOverflow() bool {
+	for _, input := range m.inputs {
+		if len(input.queue) > m.param.MaxQueue {
+			return true
+		}
+	}
+	return false
+}
+
+// The input has moved past the clock, or it is late for longer than the timeout
+func (m *mergerPlugin) hasMissed(input *mergeInput, clock int64) bool {
+	if len(input.queue) != 0 || (input.started && input.last-clock > m.tolerance) {
+		return true
+	}
+	return m.latest-clock > m.timeout
+}
+
+func (m *mergerPlugin) emit(clock int64, members []*mergeInput) {
+	rv := &MergedUnit{
+		clock:  (clock%m.clock.wrap + m.clock.wrap) % m.clock.wrap,
+		inputs: []string{},
+		units:  map[string]CmUnit{},
+	}
+	missing := []string{}
+	for _, input := range m.inputs {
+		isMember := false
+		for _, member := range members {
+			isMember = isMember || member == input
+		}
+		if isMember {
+			rv.inputs = append(rv.inputs, input.id)
+			rv.units[input.id] = input.queue[0].unit
+			input.queue = input.queue[1:]
+		} else {
+			missing = append(missing, input.id)
+			input.missCnt++
+		}
+	}
+
+	buf := MakeSchemaBuf(mergedUnitSchema, []byte{})
+	if err := buf.SetField("clock", rv.clock, false); err != nil {
+		Report_error(m.callback, m.name, SEVERITY_WARNING, "merge", err)
+	}
+	if err := buf.SetField("inputs", strings.Join(rv.inputs, ","), false); err != nil {
+		Report_error(m.callback, m.name, SEVERITY_WARNING, "merge", err)
+	}
+	if err := buf.SetField("missing", strings.Join(missing, ","), false); err != nil {
+		Report_error(m.callback, m.name, SEVERITY_WARNING, "merge", err)
+	}
+	rv.buf = buf
+
+	m.groupCnt++
+	if len(missing) != 0 {
+		m.partialCnt++
+	}
+	m.outputQueue = append(m.outputQueue, rv)
+	Post_request(m.callback, m.name, MakeReqUnit(m.name, FETCH_REQUEST))
+}
+
+func Merger(name string) IPlugin {
+	rv := mergerPlugin{
+		name:        name,
+		logger:      logging.CreateLogger(name),
+		param:       defaultMergerParam(),
+		inputs:      []*mergeInput{},
+		outputQueue: []CmUnit{},
+	}
+	return &rv
+}
+
+func init() {
+	param := defaultMergerParam()
+	RegisterPlugin(PluginInfo{
+		Type:        "Merger",
+		Constructor: Merger,
+		Description: "Buffer units from several parents and release them in groups aligned by a clock",
+		Params: []ParamDef{
+			{Name: "Clock", Type: FIELD_STRING, Default: param.Clock, Allowed: []string{"pts", "pcr", "rtp", "vitc"}, Description: "Clock to align units. rtp is read from rtpTimestamp and vitc from timecode"},
+			{Name: "Field", Type: FIELD_STRING, Description: "Buffer field of the clock, default to the one of Clock"},
+			{Name: "Tolerance", Type: FIELD_INT64, Default: param.Tolerance, Description: "Maximum difference in ms between units of a group"},
+			{Name: "Timeout", Type: FIELD_INT64, Default: param.Timeout, Description: "Stream time in ms to wait for a missing input"},
+			{Name: "MaxQueue", Type: FIELD_INT64, Default: param.MaxQueue, Description: "Maximum number of units buffered for an input before releasing without waiting"},
+			{Name: "Fps", Type: FIELD_INT64, Default: param.Fps, Description: "Frame rate to count VITC"},
+			{Name: "Inputs", Description: "List of inputs expected from the start. Others are added when their first units arrive"},
+		},
+	})
+}
@@ -0,0 +1,315 @@
+package tttKernel
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type METRIC_TYPE int
+
+const (
+	METRIC_COUNTER   METRIC_TYPE = 0
+	METRIC_GAUGE     METRIC_TYPE = 1
+	METRIC_HISTOGRAM METRIC_TYPE = 2
+)
+
+func (t METRIC_TYPE) String() string {
+	switch t {
+	case METRIC_COUNTER:
+		return "counter"
+	case METRIC_GAUGE:
+		return "gauge"
+	default:
+		return "histogram"
+	}
+}
+
+// Default histogram buckets, suitable for durations in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Values of a metric with one set of label values
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	bucketCnts  []uint64 // Histogram only, not cumulative
+	count       uint64
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	mType   METRIC_TYPE
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries // Joined label values -> series
+}
+
+/*
+ * Metrics of a worker, exported in Prometheus text format. Plugins get the
+ * registry from the resource loader. All methods are safe to call on a nil
+ * registry or metric, so plugins work the same without one.
+ */
+type MetricsRegistry struct {
+	mtx        sync.Mutex
+	families   map[string]*metricFamily
+	collectors []func()
+}
+
+// Counter only increases
+type Counter struct {
+	registry *MetricsRegistry
+	family   *metricFamily
+}
+
+// Gauge can be set to any value
+type Gauge struct {
+	registry *MetricsRegistry
+	family   *metricFamily
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	registry *MetricsRegistry
+	family   *metricFamily
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{families: map[string]*metricFamily{}}
+}
+
+// Registering an existing metric returns it, so that plugins of the same type can share metrics with different labels
+func (r *MetricsRegistry) register(name string, help string, mType METRIC_TYPE, buckets []float64, labels []string) *metricFamily {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if family, ok := r.families[name]; ok {
+		if family.mType != mType || len(family.labels) != len(labels) {
+			panic(fmt.Sprintf("Metric %s is registered with a different type or labels", name))
+		}
+		return family
+	}
+	family := &metricFamily{
+		name:    name,
+		help:    help,
+		mType:   mType,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*metricSeries{},
+	}
+	r.families[name] = family
+	return family
+}
+
+func (r *MetricsRegistry) Counter(name string, help string, labels ...string) *Counter {
+	if r == nil {
+		return nil
+	}
+	return &Counter{registry: r, family: r.register(name, help, METRIC_COUNTER, nil, labels)}
+}
+
+func (r *MetricsRegistry) Gauge(name string, help string, labels ...string) *Gauge {
+	if r == nil {
+		return nil
+	}
+	return &Gauge{registry: r, family: r.register(name, help, METRIC_GAUGE, nil, labels)}
+}
+
+// Buckets are upper bounds in increasing order
+func (r *MetricsRegistry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if r == nil {
+		return nil
+	}
+	return &Histogram{registry: r, family: r.register(name, help, METRIC_HISTOGRAM, buckets, labels)}
+}
+
+// Register a function called before each export, e.g. to set gauges from internal states
+func (r *MetricsRegistry) OnCollect(collector func()) {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	r.collectors = append(r.collectors, collector)
+	r.mtx.Unlock()
+}
+
+// Get the series of the label values. Must be called with mtx held.
+func (r *MetricsRegistry) getSeries(family *metricFamily, labelValues []string) *metricSeries {
+	if len(labelValues) != len(family.labels) {
+		panic(fmt.Sprintf("Metric %s expects %d label values, but got %d", family.name, len(family.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	series, ok := family.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: append([]string{}, labelValues...)}
+		if family.mType == METRIC_HISTOGRAM {
+			series.bucketCnts = make([]uint64, len(family.buckets))
+		}
+		family.series[key] = series
+	}
+	return series
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if c == nil {
+		return
+	}
+	if v < 0 {
+		panic(fmt.Sprintf("Counter %s cannot decrease", c.family.name))
+	}
+	c.registry.mtx.Lock()
+	c.registry.getSeries(c.family, labelValues).value += v
+	c.registry.mtx.Unlock()
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.registry.mtx.Lock()
+	g.registry.getSeries(g.family, labelValues).value = v
+	g.registry.mtx.Unlock()
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.registry.mtx.Lock()
+	g.registry.getSeries(g.family, labelValues).value += v
+	g.registry.mtx.Unlock()
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	h.registry.mtx.Lock()
+	series := h.registry.getSeries(h.family, labelValues)
+	for idx, bound := range h.family.buckets {
+		if v <= bound {
+			series.bucketCnts[idx]++
+			break
+		}
+	}
+	series.value += v
+	series.count++
+	h.registry.mtx.Unlock()
+}
+
+// Export all metrics in Prometheus text format, sorted by name and label values
+func (r *MetricsRegistry) Write(w io.Writer) error {
+	if r == nil {
+		return nil
+	}
+	r.mtx.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	r.mtx.Unlock()
+	for _, collector := range collectors {
+		collector()
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	names := []string{}
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		family := r.families[name]
+		sb.WriteString(fmt.Sprintf("# HELP %s %s\n", name, escapeHelp(family.help)))
+		sb.WriteString(fmt.Sprintf("# TYPE %s %s\n", name, family.mType.String()))
+
+		keys := []string{}
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			series := family.series[key]
+			labels := formatLabels(family.labels, series.labelValues)
+			if family.mType != METRIC_HISTOGRAM {
+				sb.WriteString(fmt.Sprintf("%s%s %s\n", name, labels, formatValue(series.value)))
+				continue
+			}
+			leNames := append(append([]string{}, family.labels...), "le")
+			leValues := append(append([]string{}, series.labelValues...), "")
+			cumulative := uint64(0)
+			for idx, bound := range family.buckets {
+				cumulative += series.bucketCnts[idx]
+				leValues[len(leValues)-1] = formatValue(bound)
+				sb.WriteString(fmt.Sprintf("%s_bucket%s %d\n", name, formatLabels(leNames, leValues), cumulative))
+			}
+			leValues[len(leValues)-1] = "+Inf"
+			sb.WriteString(fmt.Sprintf("%s_bucket%s %d\n", name, formatLabels(leNames, leValues), series.count))
+			sb.WriteString(fmt.Sprintf("%s_sum%s %s\n", name, labels, formatValue(series.value)))
+			sb.WriteString(fmt.Sprintf("%s_count%s %d\n", name, labels, series.count))
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// Serve metrics at /metrics of the address. The server's Addr is set to the address listened.
+func ServeMetrics(addr string, r *MetricsRegistry) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: mux}
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := []string{}
+	for idx, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[idx])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(s)
+}
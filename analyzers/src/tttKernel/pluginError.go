@@ -0,0 +1,90 @@
+package tttKernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ====================     error reporting     ====================
+type ERROR_SEVERITY int
+
+const (
+	SEVERITY_WARNING ERROR_SEVERITY = 0 // Unit processed with problems, only counted
+	SEVERITY_ERROR   ERROR_SEVERITY = 1 // Unit cannot be processed, handled by the node policy
+	SEVERITY_FATAL   ERROR_SEVERITY = 2 // Plugin cannot continue, the graph stops unless the node restarts
+)
+
+func (s ERROR_SEVERITY) String() string {
+	switch s {
+	case SEVERITY_WARNING:
+		return "warning"
+	case SEVERITY_ERROR:
+		return "error"
+	default:
+		return "fatal"
+	}
+}
+
+// An error raised by a plugin through ERROR_REQUEST
+type PluginError struct {
+	Severity ERROR_SEVERITY
+	Context  string // Where the error happens, e.g. packet count
+	Err      error
+}
+
+func (e *PluginError) Error() string {
+	if e.Context == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Context, e.Err.Error())
+}
+
+func (e *PluginError) Unwrap() error {
+	return e.Err
+}
+
+func NewPluginError(severity ERROR_SEVERITY, context string, err error) *PluginError {
+	return &PluginError{Severity: severity, Context: context, Err: err}
+}
+
+// Errors other than PluginError are treated as fatal
+func toPluginError(obj interface{}) *PluginError {
+	switch err := obj.(type) {
+	case *PluginError:
+		return err
+	case error:
+		return NewPluginError(SEVERITY_FATAL, "", err)
+	default:
+		return NewPluginError(SEVERITY_FATAL, "", fmt.Errorf("%v", obj))
+	}
+}
+
+// ====================     failure policy     ====================
+type ERROR_POLICY int
+
+const (
+	SKIP_UNIT    ERROR_POLICY = 0 // Drop the unit and continue
+	RESTART_NODE ERROR_POLICY = 1 // Recreate the plugin, dropping its pending output
+	STOP_GRAPH   ERROR_POLICY = 2 // End the whole graph gracefully
+)
+
+const _MAX_NODE_RESTART = 5
+
+// The policy is set with the "OnError" plugin parameter, which plugins do not parse
+func parseErrorPolicy(m_parameter string) (ERROR_POLICY, error) {
+	param := struct{ OnError string }{}
+	if err := json.Unmarshal([]byte(m_parameter), &param); err != nil {
+		return SKIP_UNIT, nil
+	}
+	switch strings.ToLower(param.OnError) {
+	case "", "skip":
+		return SKIP_UNIT, nil
+	case "restart":
+		return RESTART_NODE, nil
+	case "stop":
+		return STOP_GRAPH, nil
+	default:
+		return SKIP_UNIT, fmt.Errorf("unknown OnError policy %s, expecting skip, restart or stop", param.OnError)
+	}
+}
@@ -0,0 +1,199 @@
+package tttKernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A parameter of a plugin, in the JSON passed to SetParameter
+type ParamDef struct {
+	Name        string
+	Type        FIELD_TYPE  // FIELD_NESTED for an object of Fields, FIELD_ANY for values not checked such as lists
+	Default     interface{} // nil if there is no default
+	Allowed     []string    // Allowed values if not empty
+	Description string
+	Fields      []ParamDef // Fields of a FIELD_NESTED parameter
+}
+
+/*
+ * Plugins register themselves in init() of their packages. The type of a
+ * plugin is the prefix of its name before "_", e.g. TsDemuxer_1.
+ */
+type PluginInfo struct {
+	Type        string
+	Constructor func(string) IPlugin
+	Description string
+	Params      []ParamDef
+}
+
+var registryMtx sync.Mutex
+var pluginRegistry = map[string]PluginInfo{}
+
+// Parameters handled by the worker for every plugin
+var commonParams = []ParamDef{
+	{Name: "OnError", Type: FIELD_STRING, Default: "skip", Allowed: []string{"skip", "restart", "stop"}, Description: "Failure policy on errors"},
+}
+
+func RegisterPlugin(info PluginInfo) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	if _, ok := pluginRegistry[info.Type]; ok {
+		panic(fmt.Sprintf("Plugin type %s is registered more than once", info.Type))
+	}
+	pluginRegistry[info.Type] = info
+}
+
+// Deduce the type of plugin by name
+func PluginType(name string) string {
+	return strings.Split(name, "_")[0]
+}
+
+// Get the registered plugin of a plugin name
+func GetPluginInfo(name string) (PluginInfo, bool) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	info, ok := pluginRegistry[PluginType(name)]
+	return info, ok
+}
+
+// All registered plugins sorted by type
+func ListPlugins() []PluginInfo {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	infos := []PluginInfo{}
+	for _, info := range pluginRegistry {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Type < infos[j].Type
+	})
+	return infos
+}
+
+// Validate parameters of the plugin. Names are matched case-insensitively as in encoding/json.
+func (info PluginInfo) ValidateParam(param string) error {
+	obj := map[string]interface{}{}
+	if strings.TrimSpace(param) == "" {
+		param = "{}"
+	}
+	if err := json.Unmarshal([]byte(param), &obj); err != nil {
+		return fmt.Errorf("invalid parameters of %s: %s", info.Type, err.Error())
+	}
+	msgs := checkParams(append(append([]ParamDef{}, info.Params...), commonParams...), obj, "")
+	if len(msgs) != 0 {
+		return fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// Validate one parameter given in JSON
+func (info PluginInfo) ValidateField(name string, value string) error {
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return fmt.Errorf("invalid value of %s: %s", name, err.Error())
+	}
+	msgs := checkParams(append(append([]ParamDef{}, info.Params...), commonParams...), map[string]interface{}{name: v}, "")
+	if len(msgs) != 0 {
+		return fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// Describe the plugin and its parameters
+func (info PluginInfo) Describe() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s: %s\n", info.Type, info.Description))
+	describeParams(&sb, info.Params, "\t")
+	return sb.String()
+}
+
+func describeParams(sb *strings.Builder, params []ParamDef, indent string) {
+	for _, param := range params {
+		sb.WriteString(fmt.Sprintf("%s%s (%s", indent, param.Name, param.Type.String()))
+		if param.Default != nil {
+			sb.WriteString(fmt.Sprintf(", default %v", param.Default))
+		}
+		sb.WriteString(")")
+		if param.Description != "" {
+			sb.WriteString(" " + param.Description)
+		}
+		if len(param.Allowed) != 0 {
+			sb.WriteString(fmt.Sprintf(". One of %s", strings.Join(param.Allowed, ", ")))
+		}
+		sb.WriteString("\n")
+		describeParams(sb, param.Fields, indent+"\t")
+	}
+}
+
+// Return a message for each problem found
+func checkParams(defs []ParamDef, obj map[string]interface{}, prefix string) []string {
+	msgs := []string{}
+
+	keys := []string{}
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var def *ParamDef
+		for idx := range defs {
+			if strings.EqualFold(defs[idx].Name, key) {
+				def = &defs[idx]
+			}
+		}
+		if def == nil {
+			msgs = append(msgs, fmt.Sprintf("unknown parameter %s%s", prefix, key))
+			continue
+		}
+		msgs = append(msgs, checkParamValue(*def, obj[key], prefix+def.Name)...)
+	}
+	return msgs
+}
+
+func checkParamValue(def ParamDef, value interface{}, path string) []string {
+	isValid := true
+	switch def.Type {
+	case FIELD_INT64:
+		num, ok := value.(float64)
+		isValid = ok && num == math.Trunc(num)
+	case FIELD_UINT64:
+		num, ok := value.(float64)
+		isValid = ok && num == math.Trunc(num) && num >= 0
+	case FIELD_FLOAT:
+		_, isValid = value.(float64)
+	case FIELD_STRING:
+		_, isValid = value.(string)
+	case FIELD_BOOL:
+		_, isValid = value.(bool)
+	case FIELD_NESTED:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			isValid = false
+			break
+		}
+		return checkParams(def.Fields, obj, path+".")
+	}
+	if !isValid {
+		return []string{fmt.Sprintf("parameter %s expects %s, but got %v", path, def.Type.String(), value)}
+	}
+
+	if len(def.Allowed) != 0 {
+		str := fmt.Sprintf("%v", value)
+		if num, ok := value.(float64); ok {
+			str = strconv.FormatFloat(num, 'f', -1, 64)
+		}
+		for _, allowed := range def.Allowed {
+			if allowed == str {
+				return []string{}
+			}
+		}
+		return []string{fmt.Sprintf("parameter %s does not allow %s, expecting one of %s", path, str, strings.Join(def.Allowed, ", "))}
+	}
+	return []string{}
+}
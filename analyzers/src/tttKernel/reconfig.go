@@ -0,0 +1,275 @@
+package tttKernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+/*
+ * Reconfiguration of a running graph. Only the plugins involved are touched,
+ * so other plugins keep running with their states. Plugins can request the
+ * same operations on the status bus with the reserved status IDs.
+ */
+
+// Restart a plugin with new parameters. The plugin is replaced with a new instance, so its states are reset.
+func (w *Worker) UpdateParameter(name string, param string) error {
+	w.reconfigMtx.Lock()
+	defer w.reconfigMtx.Unlock()
+	if err := w.checkRunning(); err != nil {
+		return err
+	}
+	if !json.Valid([]byte(param)) {
+		return fmt.Errorf("invalid parameters for %s: %s", name, param)
+	}
+	if info, ok := GetPluginInfo(name); ok {
+		if err := info.ValidateParam(param); err != nil {
+			return err
+		}
+	}
+	node := w.searchNode(name)
+	if node == nil {
+		return fmt.Errorf("plugin %s not found", name)
+	}
+	if err := node.call(func() error { return node.updateParameter(param) }, w.done); err != nil {
+		return err
+	}
+	for idx := range w.params {
+		if w.params[idx].pluginName == name {
+			w.params[idx].pluginParam = param
+		}
+	}
+	w.logger.Info("Parameters of %s are updated", name)
+	return nil
+}
+
+// Add plugins to a running graph. New plugins may feed existing ones, but existing plugins cannot feed new ones.
+func (w *Worker) AddBranch(params []OverallParams) error {
+	w.reconfigMtx.Lock()
+	defer w.reconfigMtx.Unlock()
+	if err := w.checkRunning(); err != nil {
+		return err
+	}
+	graph := append(append([]OverallParams{}, w.params...), params...)
+	if err := ValidateGraph(graph, nil); err != nil {
+		return err
+	}
+
+	branch, err := w.buildBranch(params)
+	if err != nil {
+		return err
+	}
+
+	// Existing children wait for EOS from new parents before they end
+	linked := [][2]*graphNode{}
+	for _, param := range params {
+		parent := branch[param.pluginName]
+		for _, childName := range param.children {
+			if _, isNew := branch[childName]; isNew {
+				continue
+			}
+			child := w.searchNode(childName)
+			if child == nil {
+				err = fmt.Errorf("plugin %s not found", childName)
+			} else {
+				err = child.call(func() error {
+					if child.m_state == STOPPED {
+						return fmt.Errorf("plugin %s has ended", childName)
+					}
+					child.parent = append(child.parent, parent)
+					return nil
+				}, w.done)
+			}
+			if err != nil {
+				for _, link := range linked {
+					link[1].call(func() error {
+						link[1].detachParent(link[0])
+						return nil
+					}, w.done)
+				}
+				return err
+			}
+			parent.children = append(parent.children, child)
+			linked = append(linked, [2]*graphNode{parent, child})
+		}
+	}
+
+	w.nodesMtx.Lock()
+	defer w.nodesMtx.Unlock()
+	if atomic.LoadInt32(&w.isRunning) == 0 {
+		return fmt.Errorf("graph has ended")
+	}
+	names := []string{}
+	for _, param := range params {
+		node := branch[param.pluginName]
+		node.root = len(node.parent) == 0
+		w.nodes = append(w.nodes, node)
+		w.nodeMap[node.name()] = node
+		// Start the plugin in its own goroutine before any unit arrives
+		node.pushRequest(nodeRequest{apply: func() error {
+			node.mtx.Lock()
+			defer node.mtx.Unlock()
+			node.impl.StartSequence()
+			return nil
+		}})
+		w.startNode(node)
+		names = append(names, node.name())
+	}
+	w.params = graph
+	w.logger.Info("Added %v", names)
+	return nil
+}
+
+// Create and set up nodes of a new branch. Plugins may panic on invalid parameters.
+func (w *Worker) buildBranch(params []OverallParams) (branch map[string]*graphNode, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	branch = map[string]*graphNode{}
+	for _, param := range params {
+		node := getPluginByName(param.pluginName, w.selectPlugin)
+		node.m_parameter = param.pluginParam
+		branch[param.pluginName] = node
+	}
+	for _, param := range params {
+		for _, childName := range param.children {
+			if child, isNew := branch[childName]; isNew {
+				addPath(branch[param.pluginName], []*graphNode{child})
+			}
+		}
+	}
+	for _, param := range params {
+		node := branch[param.pluginName]
+		w.setupNode(node)
+		node.impl.SetParameter(node.m_parameter)
+		node.impl.SetResource(&w.resourceLoader)
+	}
+	return branch, nil
+}
+
+/*
+ * Remove plugins from a running graph. Each removed plugin is ended after its
+ * parents, while other plugins fed by the branch are detached from it and keep
+ * running. All parents of a removed plugin must be removed as well. Return
+ * when all removed plugins have ended.
+ */
+func (w *Worker) RemoveBranch(names []string) error {
+	w.reconfigMtx.Lock()
+	defer w.reconfigMtx.Unlock()
+	if err := w.checkRunning(); err != nil {
+		return err
+	}
+
+	removed := map[string]bool{}
+	nodes := []*graphNode{}
+	for _, name := range names {
+		node := w.searchNode(name)
+		if node == nil {
+			return fmt.Errorf("plugin %s not found", name)
+		}
+		if !removed[name] {
+			removed[name] = true
+			nodes = append(nodes, node)
+		}
+	}
+
+	hasParent := map[string]bool{}
+	for _, param := range w.params {
+		for _, child := range param.children {
+			if !removed[child] {
+				continue
+			}
+			if !removed[param.pluginName] {
+				return fmt.Errorf("plugin %s is fed by %s, which is not removed", child, param.pluginName)
+			}
+			hasParent[child] = true
+		}
+	}
+
+	// Requests are handled in order, so the nodes know they are removed before they end
+	for _, node := range nodes {
+		node := node
+		node.pushRequest(nodeRequest{apply: func() error {
+			node.removed = removed
+			return nil
+		}})
+		if !hasParent[node.name()] {
+			node.pushRequest(nodeRequest{reqType: EOS_REQUEST})
+		}
+	}
+	for _, node := range nodes {
+		select {
+		case <-node.exited:
+		case <-w.done:
+			return fmt.Errorf("graph is stopped")
+		}
+	}
+
+	params := []OverallParams{}
+	for _, param := range w.params {
+		if !removed[param.pluginName] {
+			params = append(params, param)
+		}
+	}
+	w.params = params
+
+	// Plugins added again later register for status themselves
+	w.statusMtx.Lock()
+	for msgId, listeners := range w.statusStore {
+		kept := []string{}
+		for _, name := range listeners {
+			if !removed[name] {
+				kept = append(kept, name)
+			}
+		}
+		w.statusStore[msgId] = kept
+	}
+	w.statusMtx.Unlock()
+
+	w.logger.Info("Removed %v", names)
+	return nil
+}
+
+func (w *Worker) checkRunning() error {
+	if atomic.LoadInt32(&w.isRunning) == 0 {
+		return fmt.Errorf("graph is not running")
+	}
+	return nil
+}
+
+// Handle reconfiguration posted on the status bus. Errors are only logged as nobody waits for them.
+func (w *Worker) reconfigure(name string, unit *CmStatusUnit) {
+	body := unit.GetBuf()
+	if body == nil {
+		w.logger.Error("Reconfiguration from %s without body", name)
+		return
+	}
+
+	var err error
+	switch unit.id {
+	case STATUS_UPDATE_PARAMETER:
+		plugin, _ := GetBufFieldAsString(body, "plugin")
+		param, _ := GetBufFieldAsString(body, "param")
+		err = w.UpdateParameter(plugin, param)
+	case STATUS_ADD_BRANCH:
+		field, _ := body.GetField("params")
+		if params, ok := field.([]OverallParams); ok {
+			err = w.AddBranch(params)
+		} else {
+			err = fmt.Errorf("invalid params %v", field)
+		}
+	case STATUS_REMOVE_BRANCH:
+		field, _ := body.GetField("plugins")
+		if plugins, ok := field.([]string); ok {
+			err = w.RemoveBranch(plugins)
+		} else {
+			err = fmt.Errorf("invalid plugins %v", field)
+		}
+	}
+	if err != nil {
+		w.logger.Error("Reconfiguration from %s failed: %s", name, err.Error())
+	}
+}
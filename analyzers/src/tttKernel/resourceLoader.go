@@ -5,13 +5,24 @@ import (
 )
 
 type Resource struct {
-	OutDir     string
+	OutDir      string
+	MetricsAddr string   // Serve metrics over HTTP if not empty, e.g. ":9100"
+	Record      []string // Edges to record units crossing, e.g. "TsDemuxer_1->DataHandler_1". Traces are written under OutDir.
 }
 
 type ResourceLoader struct {
 	IsRedundancyEnabled bool
 	StreamType          []string
 	resource            Resource
+	metrics             *MetricsRegistry
+}
+
+// Registry for plugins to export metrics. Nil if the loader is not set.
+func (r *ResourceLoader) Metrics() *MetricsRegistry {
+	if r == nil {
+		return nil
+	}
+	return r.metrics
 }
 
 func (r *ResourceLoader) Query(path string, key interface{}) string {
@@ -186,5 +197,6 @@ func CreateResourceLoader() ResourceLoader {
 		resource: Resource{
 			OutDir: "output",
 		},
+		metrics: NewMetricsRegistry(),
 	}
 }
@@ -0,0 +1,194 @@
+package tttKernel
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+type FIELD_TYPE int
+
+const (
+	FIELD_ANY    FIELD_TYPE = 0 // Any other value, serialized as JSON
+	FIELD_INT64  FIELD_TYPE = 1
+	FIELD_UINT64 FIELD_TYPE = 2
+	FIELD_FLOAT  FIELD_TYPE = 3
+	FIELD_STRING FIELD_TYPE = 4
+	FIELD_BOOL   FIELD_TYPE = 5
+	FIELD_NESTED FIELD_TYPE = 6 // Another CmBuf
+)
+
+func (t FIELD_TYPE) String() string {
+	switch t {
+	case FIELD_INT64:
+		return "int64"
+	case FIELD_UINT64:
+		return "uint64"
+	case FIELD_FLOAT:
+		return "float"
+	case FIELD_STRING:
+		return "string"
+	case FIELD_BOOL:
+		return "bool"
+	case FIELD_NESTED:
+		return "nested"
+	default:
+		return "any"
+	}
+}
+
+// A field of a unit type. Hidden fields are kept in the buffer but not serialized to CSV or JSON.
+type FieldDef struct {
+	Name   string
+	Type   FIELD_TYPE
+	Hidden bool
+}
+
+func Field(name string, fieldType FIELD_TYPE) FieldDef {
+	return FieldDef{Name: name, Type: fieldType}
+}
+
+func HiddenField(name string, fieldType FIELD_TYPE) FieldDef {
+	return FieldDef{Name: name, Type: fieldType, Hidden: true}
+}
+
+/*
+ * Schema declares the fields of a unit type. Buffers with a schema store
+ * declared fields in declaration order, so every unit of the type has the
+ * same columns. Schemas are registered by name to restore binary buffers.
+ */
+type Schema struct {
+	name   string
+	fields []FieldDef
+	index  map[string]int
+}
+
+var schemaMtx sync.Mutex
+var schemas = map[string]*Schema{}
+
+// Declare a schema. A schema declared again with the same name replaces the previous one.
+func NewSchema(name string, fields ...FieldDef) *Schema {
+	s := &Schema{name: name, fields: fields, index: map[string]int{}}
+	for idx, field := range fields {
+		if _, ok := s.index[field.Name]; ok {
+			panic(fmt.Sprintf("Field %s is declared more than once in schema %s", field.Name, name))
+		}
+		s.index[field.Name] = idx
+	}
+	schemaMtx.Lock()
+	schemas[name] = s
+	schemaMtx.Unlock()
+	return s
+}
+
+// Return nil if the schema is not declared
+func GetSchema(name string) *Schema {
+	schemaMtx.Lock()
+	defer schemaMtx.Unlock()
+	return schemas[name]
+}
+
+func (s *Schema) Name() string {
+	return s.name
+}
+
+func (s *Schema) Fields() []FieldDef {
+	return append([]FieldDef{}, s.fields...)
+}
+
+// Type of an undeclared field, deduced from its value
+func inferFieldType(datum interface{}) FIELD_TYPE {
+	switch datum.(type) {
+	case int, int8, int16, int32, int64:
+		return FIELD_INT64
+	case uint, uint8, uint16, uint32, uint64:
+		return FIELD_UINT64
+	case float32, float64:
+		return FIELD_FLOAT
+	case string:
+		return FIELD_STRING
+	case bool:
+		return FIELD_BOOL
+	case CmBuf:
+		return FIELD_NESTED
+	default:
+		return FIELD_ANY
+	}
+}
+
+// Convert a value to the field type. Integers of any size are accepted for numeric types if they fit.
+func toFieldValue(fieldType FIELD_TYPE, datum interface{}) (interface{}, bool) {
+	switch fieldType {
+	case FIELD_INT64:
+		if v, ok := toInt64(datum); ok {
+			return v, true
+		}
+		if v, ok := toUint64(datum); ok && v <= math.MaxInt64 {
+			return int64(v), true
+		}
+	case FIELD_UINT64:
+		if v, ok := toUint64(datum); ok {
+			return v, true
+		}
+		if v, ok := toInt64(datum); ok && v >= 0 {
+			return uint64(v), true
+		}
+	case FIELD_FLOAT:
+		switch v := datum.(type) {
+		case float32:
+			return float64(v), true
+		case float64:
+			return v, true
+		}
+		if v, ok := toInt64(datum); ok {
+			return float64(v), true
+		}
+		if v, ok := toUint64(datum); ok {
+			return float64(v), true
+		}
+	case FIELD_STRING:
+		v, ok := datum.(string)
+		return v, ok
+	case FIELD_BOOL:
+		v, ok := datum.(bool)
+		return v, ok
+	case FIELD_NESTED:
+		v, ok := datum.(CmBuf)
+		return v, ok
+	default:
+		return datum, true
+	}
+	return nil, false
+}
+
+func toInt64(datum interface{}) (int64, bool) {
+	switch v := datum.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+func toUint64(datum interface{}) (uint64, bool) {
+	switch v := datum.(type) {
+	case uint:
+		return uint64(v), true
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,260 @@
+package tttKernel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*
+ * A trace file keeps units crossing an edge of the graph in arrival order. It
+ * starts with _TRACE_MAGIC, followed by one record per unit, prefixed by its
+ * length. A record consists of
+ *   seq | inputId | unit type | buffer | unit fields
+ * where integers are uvarints and the rest are prefixed by their lengths. The
+ * buffer is written with MarshalBinary, so its fields are kept.
+ */
+const _TRACE_MAGIC = "TTTTRACE1"
+
+// Records larger than this are treated as corruption instead of being allocated
+const _MAX_TRACE_RECORD_SIZE = 64 << 20
+
+// Units implementing RecordableUnit keep their own fields in traces. Other units are replayed with their buffers only.
+type RecordableUnit interface {
+	CmUnit
+	UnitType() string
+	MarshalFields() ([]byte, error)
+}
+
+var unitDecoderMtx sync.Mutex
+var unitDecoders = map[string]func(CmBuf, []byte) (CmUnit, error){}
+
+// Register how to restore units of a type from MarshalFields output
+func RegisterUnitType(unitType string, decode func(buf CmBuf, fields []byte) (CmUnit, error)) {
+	unitDecoderMtx.Lock()
+	defer unitDecoderMtx.Unlock()
+	if _, ok := unitDecoders[unitType]; ok {
+		panic(fmt.Sprintf("Unit type %s is registered more than once", unitType))
+	}
+	unitDecoders[unitType] = decode
+}
+
+func getUnitDecoder(unitType string) func(CmBuf, []byte) (CmUnit, error) {
+	unitDecoderMtx.Lock()
+	defer unitDecoderMtx.Unlock()
+	return unitDecoders[unitType]
+}
+
+// A unit of a type without fields
+type traceUnit struct {
+	buf CmBuf
+}
+
+func (unit *traceUnit) GetBuf() CmBuf {
+	return unit.buf
+}
+
+func (unit *traceUnit) GetField(name string) interface{} {
+	return nil
+}
+
+// A unit read from a trace
+type TraceRecord struct {
+	Seq     int    // Arrival order, counting from 0
+	InputId string // Plugin sending the unit
+	Unit    CmUnit
+}
+
+type TraceWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	seq    int
+}
+
+func NewTraceWriter(path string) (*TraceWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	if _, err := writer.WriteString(_TRACE_MAGIC); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &TraceWriter{file: file, writer: writer, seq: 0}, nil
+}
+
+func (tw *TraceWriter) Write(unit CmUnit, inputId string) error {
+	record := appendUvarint([]byte{}, uint64(tw.seq))
+	record = appendBytes(record, []byte(inputId))
+
+	unitType := ""
+	fields := []byte{}
+	if recordable, ok := unit.(RecordableUnit); ok {
+		var err error
+		unitType = recordable.UnitType()
+		if fields, err = recordable.MarshalFields(); err != nil {
+			return err
+		}
+	}
+	record = appendBytes(record, []byte(unitType))
+
+	// A nil buffer is written as empty bytes, which MarshalBinary never produces
+	buf := []byte{}
+	if unit.GetBuf() != nil {
+		var err error
+		if buf, err = unit.GetBuf().MarshalBinary(); err != nil {
+			return err
+		}
+	}
+	record = appendBytes(record, buf)
+	record = appendBytes(record, fields)
+
+	if _, err := tw.writer.Write(appendBytes([]byte{}, record)); err != nil {
+		return err
+	}
+	tw.seq++
+	return nil
+}
+
+func (tw *TraceWriter) Close() error {
+	if err := tw.writer.Flush(); err != nil {
+		tw.file.Close()
+		return err
+	}
+	return tw.file.Close()
+}
+
+type TraceReader struct {
+	file   *os.File
+	reader *bufio.Reader
+}
+
+func OpenTrace(path string) (*TraceReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(file)
+	magic := make([]byte, len(_TRACE_MAGIC))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != _TRACE_MAGIC {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a trace file", path)
+	}
+	return &TraceReader{file: file, reader: reader}, nil
+}
+
+// Read the next unit. io.EOF is returned at the end of the trace.
+func (tr *TraceReader) Next() (TraceRecord, error) {
+	size, err := readUvarint(tr.reader)
+	if err != nil {
+		return TraceRecord{}, err
+	}
+	if size > _MAX_TRACE_RECORD_SIZE {
+		return TraceRecord{}, fmt.Errorf("trace record of %d bytes exceeds %d bytes", size, _MAX_TRACE_RECORD_SIZE)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(tr.reader, data); err != nil {
+		return TraceRecord{}, errors.New("truncated trace")
+	}
+
+	r := &binReader{data: data}
+	seq := int(r.uvarint())
+	inputId := string(r.bytes())
+	unitType := string(r.bytes())
+	bufData := r.bytes()
+	fields := r.bytes()
+	if r.err != nil {
+		return TraceRecord{}, r.err
+	}
+
+	var buf CmBuf
+	if len(bufData) != 0 {
+		if buf, err = UnmarshalBuf(bufData); err != nil {
+			return TraceRecord{}, err
+		}
+	}
+
+	var unit CmUnit = &traceUnit{buf: buf}
+	if unitType != "" {
+		decode := getUnitDecoder(unitType)
+		if decode == nil {
+			return TraceRecord{}, fmt.Errorf("unknown unit type %s", unitType)
+		}
+		if unit, err = decode(buf, fields); err != nil {
+			return TraceRecord{}, err
+		}
+	}
+	return TraceRecord{Seq: seq, InputId: inputId, Unit: unit}, nil
+}
+
+func (tr *TraceReader) Close() error {
+	return tr.file.Close()
+}
+
+// io.EOF is only returned if nothing is read
+func readUvarint(reader *bufio.Reader) (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := reader.ReadByte()
+		if err == io.EOF && shift == 0 {
+			return 0, io.EOF
+		} else if err != nil {
+			return 0, errors.New("truncated trace")
+		}
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("invalid varint")
+}
+
+// Path of the trace of an edge under the output directory
+func TracePath(outDir string, parent string, child string) string {
+	return filepath.Join(outDir, parent+"-"+child+".trace")
+}
+
+/*
+ * Record units delivered to a child from a parent for each edge in the form
+ * of "parent->child". Units are recorded by the child in arrival order.
+ */
+func (w *Worker) openRecorders() error {
+	outDir := w.resourceLoader.resource.OutDir
+	for _, edge := range w.resourceLoader.resource.Record {
+		names := strings.Split(edge, "->")
+		if len(names) != 2 {
+			return fmt.Errorf("invalid edge %s, expecting parent->child", edge)
+		}
+		parentName, childName := strings.TrimSpace(names[0]), strings.TrimSpace(names[1])
+		child := w.searchNode(childName)
+		isLinked := false
+		if child != nil {
+			for _, parent := range child.parent {
+				isLinked = isLinked || parent.name() == parentName
+			}
+		}
+		if !isLinked {
+			return fmt.Errorf("%s is not linked to %s", parentName, childName)
+		}
+
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return err
+		}
+		writer, err := NewTraceWriter(TracePath(outDir, parentName, childName))
+		if err != nil {
+			return err
+		}
+		if child.recorders == nil {
+			child.recorders = map[string]*TraceWriter{}
+		}
+		child.recorders[parentName] = writer
+		w.logger.Info("Record units from %s to %s", parentName, childName)
+	}
+	return nil
+}
@@ -1,12 +1,22 @@
 package tttKernel
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tony-507/analyzers/src/logging"
 )
 
 func TestSimpleBufOverwriteField(t *testing.T) {
@@ -19,7 +29,7 @@ func TestSimpleBufOverwriteField(t *testing.T) {
 	if !ok {
 		panic("No such field")
 	}
-	assert.Equal(t, 0, v1, "Field should be 0")
+	assert.Equal(t, int64(0), v1, "Field should be 0")
 
 	buf.SetField(field, 10, false)
 
@@ -27,7 +37,7 @@ func TestSimpleBufOverwriteField(t *testing.T) {
 	if !ok {
 		panic("No such field")
 	}
-	assert.Equal(t, 10, v2, "Field should be 10")
+	assert.Equal(t, int64(10), v2, "Field should be 10")
 }
 
 func dummySelector(inputName string) IPlugin {
@@ -163,3 +173,619 @@ func TestGraphBuilding(t *testing.T) {
 		}
 	}
 }
+
+func TestGraphBackpressure(t *testing.T) {
+	// More units than the channel capacity between the nodes
+	producer := &dummyProducer{dummyPlugin: dummyPlugin{name: "Producer_root", logger: logging.CreateLogger("Producer_root")}, cnt: 2 * _NODE_INPUT_CAPACITY}
+	recorder := &dummyRecorder{dummyPlugin: dummyPlugin{name: "Recorder_1", logger: logging.CreateLogger("Recorder_1"), role: 1}}
+	selector := func(name string) IPlugin {
+		if name == producer.name {
+			return producer
+		}
+		return recorder
+	}
+
+	root := getPluginByName(producer.name, selector)
+	sink := getPluginByName(recorder.name, selector)
+	addPath(root, []*graphNode{sink})
+
+	w := NewWorker()
+	w.setGraph([]*graphNode{root, sink})
+
+	w.runGraph()
+
+	assert.Equal(t, producer.cnt, len(recorder.received))
+	for idx, val := range recorder.received {
+		assert.Equal(t, (idx+1)%256, val, "Units should arrive in order")
+	}
+	assert.Equal(t, STOPPED, sink.m_state)
+}
+
+func TestGraphValidation(t *testing.T) {
+	isKnown := func(name string) bool { return strings.HasPrefix(name, "Dummy") }
+	valid := []OverallParams{
+		ConstructOverallParam("Dummy_1", "{}", []string{"Dummy_2", "Dummy_3"}),
+		ConstructOverallParam("Dummy_2", "{}", []string{"Dummy_3"}),
+		ConstructOverallParam("Dummy_3", "{}", []string{}),
+	}
+	assert.Equal(t, nil, ValidateGraph(valid, isKnown))
+
+	// 1 -> 2 -> 3 -> 2, 4 -> undefined, 5 isolated, 1 declared twice
+	invalid := []OverallParams{
+		ConstructOverallParam("Dummy_1", "{}", []string{"Dummy_2"}),
+		ConstructOverallParam("Dummy_2", "{}", []string{"Dummy_3"}),
+		ConstructOverallParam("Dummy_3", "{}", []string{"Dummy_2"}),
+		ConstructOverallParam("Dummy_4", "{}", []string{"Dumy_1"}),
+		ConstructOverallParam("Unknown_5", "{}", []string{}),
+		ConstructOverallParam("Dummy_1", "{}", []string{}),
+	}
+	err := ValidateGraph(invalid, isKnown)
+	errs, ok := err.(GraphErrors)
+	assert.Equal(t, true, ok)
+
+	kinds := []GRAPH_ERROR{}
+	for _, graphErr := range errs {
+		kinds = append(kinds, graphErr.Kind)
+	}
+	// Dummy_4 is only reported for its undefined link
+	assert.Equal(t, []GRAPH_ERROR{UNKNOWN_PLUGIN, DUPLICATE_PLUGIN, UNDEFINED_LINK, CYCLIC_GRAPH, UNREACHABLE_PLUGIN}, kinds)
+	assert.Equal(t, []string{"Dummy_4", "Dumy_1"}, errs[2].Plugins)
+	assert.Equal(t, []string{"Dummy_2", "Dummy_3", "Dummy_2"}, errs[3].Plugins)
+	assert.Equal(t, []string{"Unknown_5"}, errs[4].Plugins)
+
+	// Plugins only fed by a cycle are unreachable
+	err = ValidateGraph([]OverallParams{
+		ConstructOverallParam("Dummy_1", "{}", []string{"Dummy_2"}),
+		ConstructOverallParam("Dummy_2", "{}", []string{"Dummy_1"}),
+	}, nil)
+	assert.Equal(t, 3, len(err.(GraphErrors)))
+}
+
+func TestExportDot(t *testing.T) {
+	params := []OverallParams{
+		ConstructOverallParam("Dummy_1", "{\"a\":1}", []string{"Dummy_2", "Dumy_3"}),
+		ConstructOverallParam("Dummy_2", "{}", []string{}),
+	}
+	expected := "digraph \"test\" {\n" +
+		"\tnode [shape=box];\n" +
+		"\t\"Dummy_1\" [tooltip=\"{\\\"a\\\":1}\"];\n" +
+		"\t\"Dummy_2\" [tooltip=\"{}\"];\n" +
+		"\t\"Dummy_1\" -> \"Dummy_2\";\n" +
+		"\t\"Dumy_3\" [style=dashed, color=red];\n" +
+		"\t\"Dummy_1\" -> \"Dumy_3\";\n" +
+		"}\n"
+	assert.Equal(t, expected, ExportDot("test", params))
+}
+
+func TestGraphShutdown(t *testing.T) {
+	producer := &dummyProducer{dummyPlugin: dummyPlugin{name: "Producer_root", logger: logging.CreateLogger("Producer_root")}, cnt: -1}
+	recorder := &dummyRecorder{dummyPlugin: dummyPlugin{name: "Recorder_1", logger: logging.CreateLogger("Recorder_1"), role: 1}}
+	selector := func(name string) IPlugin {
+		if name == producer.name {
+			return producer
+		}
+		return recorder
+	}
+
+	root := getPluginByName(producer.name, selector)
+	sink := getPluginByName(recorder.name, selector)
+	addPath(root, []*graphNode{sink})
+
+	w := NewWorker()
+	w.setGraph([]*graphNode{root, sink})
+
+	finished := make(chan struct{})
+	go func() {
+		w.runGraph()
+		close(finished)
+	}()
+
+	// Signal handling is registered when the graph runs, so send it through the channel directly
+	time.Sleep(10 * time.Millisecond)
+	w.signals <- syscall.SIGINT
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		panic("Graph is not stopped after SIGINT")
+	}
+
+	// Everything fetched from the producer reaches the recorder before it ends
+	assert.Equal(t, STOPPED, sink.m_state)
+	assert.Equal(t, producer.sent%256, recorder.received[len(recorder.received)-1])
+	assert.Equal(t, []*graphNode{root, sink}, sortNodes([]*graphNode{sink, root}))
+}
+
+// Run a producer feeding a faulty sink with the given OnError parameter
+func runFaultyGraph(param string, cnt int, fatal bool) (*graphNode, []*dummyFaulty) {
+	producer := &dummyProducer{dummyPlugin: dummyPlugin{name: "Producer_root", logger: logging.CreateLogger("Producer_root")}, cnt: cnt}
+	instances := []*dummyFaulty{}
+	selector := func(name string) IPlugin {
+		if name == producer.name {
+			return producer
+		}
+		faulty := &dummyFaulty{
+			dummyRecorder: dummyRecorder{dummyPlugin: dummyPlugin{name: name, logger: logging.CreateLogger(name), role: 1}},
+			failEvery:     5,
+			fatal:         fatal,
+		}
+		instances = append(instances, faulty)
+		return faulty
+	}
+
+	root := getPluginByName(producer.name, selector)
+	sink := getPluginByName("Faulty_1", selector)
+	sink.m_parameter = param
+	addPath(root, []*graphNode{sink})
+
+	w := NewWorker()
+	w.setGraph([]*graphNode{root, sink})
+
+	finished := make(chan struct{})
+	go func() {
+		w.runGraph()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		panic("Graph is not stopped")
+	}
+	return sink, instances
+}
+
+func TestErrorPolicy(t *testing.T) {
+	// Panics are recovered and only the failing units are skipped
+	sink, instances := runFaultyGraph("{}", 20, false)
+	assert.Equal(t, SKIP_UNIT, sink.policy)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, 16, len(instances[0].received))
+	assert.Equal(t, [3]int{0, 4, 0}, sink.errCnt)
+
+	// A new plugin takes over after each failure
+	sink, instances = runFaultyGraph("{\"OnError\": \"restart\"}", 12, false)
+	assert.Equal(t, RESTART_NODE, sink.policy)
+	assert.Equal(t, 3, len(instances))
+	assert.Equal(t, []int{1, 2, 3, 4}, instances[0].received)
+	assert.Equal(t, []int{6, 7, 8, 9}, instances[1].received)
+	assert.Equal(t, []int{11, 12}, instances[2].received)
+	assert.Equal(t, 2, sink.restartCnt)
+
+	// The graph ends although the producer is endless
+	sink, instances = runFaultyGraph("{\"OnError\": \"stop\"}", -1, false)
+	assert.Equal(t, STOP_GRAPH, sink.policy)
+	assert.Equal(t, []int{1, 2, 3, 4}, instances[0].received[:4])
+	assert.Equal(t, STOPPED, sink.m_state)
+
+	// Fatal errors stop the graph even if units can be skipped
+	sink, _ = runFaultyGraph("{}", -1, true)
+	assert.Equal(t, 0, sink.errCnt[SEVERITY_ERROR])
+	assert.Less(t, 0, sink.errCnt[SEVERITY_FATAL])
+	assert.Equal(t, STOPPED, sink.m_state)
+
+	policy, err := parseErrorPolicy("{\"OnError\": \"retry\"}")
+	assert.Equal(t, SKIP_UNIT, policy)
+	assert.NotNil(t, err)
+}
+
+func TestPluginError(t *testing.T) {
+	base := errors.New("bad packet")
+	err := NewPluginError(SEVERITY_ERROR, "pkt#10", base)
+	assert.Equal(t, "pkt#10: bad packet", err.Error())
+	assert.True(t, errors.Is(err, base))
+	assert.Equal(t, err, toPluginError(err))
+	assert.Equal(t, SEVERITY_FATAL, toPluginError(base).Severity)
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	registry := NewMetricsRegistry()
+	packets := registry.Counter("test_packets_total", "Packets received", "pid")
+	packets.Inc("32")
+	packets.Add(2, "32")
+	packets.Inc("0")
+	// Registering again returns the same metric
+	registry.Counter("test_packets_total", "Packets received", "pid").Inc("0")
+
+	registry.Gauge("test_queue_length", "Queue \"length\"\nin units").Set(3)
+	delay := registry.Histogram("test_delay_seconds", "Delay", []float64{0.1, 1}, "plugin")
+	delay.Observe(0.05, "a\"b")
+	delay.Observe(0.5, "a\"b")
+	delay.Observe(5, "a\"b")
+
+	collected := 0
+	registry.OnCollect(func() { collected++ })
+
+	var sb strings.Builder
+	assert.Nil(t, registry.Write(&sb))
+	expected := "# HELP test_delay_seconds Delay\n" +
+		"# TYPE test_delay_seconds histogram\n" +
+		"test_delay_seconds_bucket{plugin=\"a\\\"b\",le=\"0.1\"} 1\n" +
+		"test_delay_seconds_bucket{plugin=\"a\\\"b\",le=\"1\"} 2\n" +
+		"test_delay_seconds_bucket{plugin=\"a\\\"b\",le=\"+Inf\"} 3\n" +
+		"test_delay_seconds_sum{plugin=\"a\\\"b\"} 5.55\n" +
+		"test_delay_seconds_count{plugin=\"a\\\"b\"} 3\n" +
+		"# HELP test_packets_total Packets received\n" +
+		"# TYPE test_packets_total counter\n" +
+		"test_packets_total{pid=\"0\"} 2\n" +
+		"test_packets_total{pid=\"32\"} 3\n" +
+		"# HELP test_queue_length Queue \"length\"\\nin units\n" +
+		"# TYPE test_queue_length gauge\n" +
+		"test_queue_length 3\n"
+	assert.Equal(t, expected, sb.String())
+	assert.Equal(t, 1, collected)
+
+	// Nil registry and metrics are no-op
+	var nilRegistry *MetricsRegistry
+	nilRegistry.Counter("test_nil_total", "").Inc()
+	nilRegistry.Histogram("test_nil", "", DefaultBuckets).Observe(1)
+	assert.Nil(t, nilRegistry.Write(&sb))
+
+	assert.Panics(t, func() { registry.Gauge("test_packets_total", "") })
+	assert.Panics(t, func() { packets.Inc() })
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.Counter("test_requests_total", "Requests").Inc()
+
+	srv, err := ServeMetrics("127.0.0.1:0", registry)
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", srv.Addr))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "version=0.0.4")
+	assert.Contains(t, string(body), "test_requests_total 1\n")
+}
+
+func TestWorkerInterrupt(t *testing.T) {
+	producer := &dummyProducer{dummyPlugin: dummyPlugin{name: "Producer_root", logger: logging.CreateLogger("Producer_root")}, cnt: -1}
+	w := NewWorker()
+	assert.Equal(t, "", w.Info())
+
+	// Interrupt before the graph runs ends it once it starts
+	w.Interrupt()
+	err := w.StartService([]OverallParams{ConstructOverallParam(producer.name, "{}", []string{})}, func(string) IPlugin { return producer })
+	assert.Nil(t, err)
+	assert.Equal(t, "Running nodes: 0\nPlugin: Producer_root\n", w.Info())
+}
+
+func TestGraphReconfiguration(t *testing.T) {
+	mtx := sync.Mutex{}
+	instances := map[string][]IPlugin{}
+	selector := func(name string) IPlugin {
+		var plugin IPlugin
+		if strings.HasSuffix(name, "_root") {
+			plugin = &dummyProducer{dummyPlugin: dummyPlugin{name: name, logger: logging.CreateLogger(name)}, cnt: -1}
+		} else {
+			plugin = &dummyRecorder{dummyPlugin: dummyPlugin{name: name, logger: logging.CreateLogger(name), role: 1}}
+		}
+		mtx.Lock()
+		instances[name] = append(instances[name], plugin)
+		mtx.Unlock()
+		return plugin
+	}
+	instanceCnt := func(name string) int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return len(instances[name])
+	}
+
+	w := NewWorker()
+	assert.NotNil(t, w.UpdateParameter("Recorder_1", "{}"), "Graph is not running yet")
+
+	finished := make(chan struct{})
+	go func() {
+		w.StartService([]OverallParams{
+			ConstructOverallParam("Producer_root", "{}", []string{"Recorder_1"}),
+			ConstructOverallParam("Recorder_1", "{}", []string{}),
+		}, selector)
+		close(finished)
+	}()
+	for atomic.LoadInt32(&w.isRunning) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Update parameters directly and through the status bus
+	assert.Nil(t, w.UpdateParameter("Recorder_1", "{\"OnError\": \"restart\"}"))
+	assert.Equal(t, 2, instanceCnt("Recorder_1"))
+	assert.NotNil(t, w.UpdateParameter("Recorder_1", "{"))
+	assert.NotNil(t, w.UpdateParameter("Recorder_1", "{\"OnError\": \"retry\"}"))
+	assert.NotNil(t, w.UpdateParameter("Unknown_1", "{}"))
+
+	Update_parameter(w.onRequestReceived, "Test", "Recorder_1", "{}")
+	for instanceCnt("Recorder_1") != 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A new branch feeding an existing plugin
+	assert.NotNil(t, w.AddBranch([]OverallParams{ConstructOverallParam("Recorder_1", "{}", []string{})}), "Duplicate plugin")
+	assert.NotNil(t, w.AddBranch([]OverallParams{ConstructOverallParam("Source_root", "{}", []string{"Unknown_1"})}), "Undefined link")
+	assert.Nil(t, w.AddBranch([]OverallParams{
+		ConstructOverallParam("Source_root", "{}", []string{"Sink_2", "Recorder_1"}),
+		ConstructOverallParam("Sink_2", "{}", []string{}),
+	}))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&w.isRunning))
+
+	// Removing the branch keeps the plugins it feeds running
+	assert.NotNil(t, w.RemoveBranch([]string{"Recorder_1"}), "Recorder_1 is fed by Producer_root")
+	assert.NotNil(t, w.RemoveBranch([]string{"Sink_2"}), "Sink_2 is fed by Source_root")
+	assert.Nil(t, w.RemoveBranch([]string{"Source_root", "Sink_2"}))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&w.isRunning))
+	assert.Nil(t, w.searchNode("Source_root"))
+	assert.Equal(t, 2, len(w.getNodes()))
+	assert.Equal(t, 3, instanceCnt("Recorder_1"), "Other plugins are not restarted")
+
+	// The branch can be added again
+	assert.Nil(t, w.AddBranch([]OverallParams{ConstructOverallParam("Source_root", "{}", []string{"Recorder_1"})}))
+	assert.Equal(t, 2, instanceCnt("Source_root"))
+
+	w.Interrupt()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		panic("Graph is not stopped")
+	}
+	recorder := instances["Recorder_1"][2].(*dummyRecorder)
+	assert.NotEqual(t, 0, len(recorder.received))
+	assert.NotNil(t, w.AddBranch([]OverallParams{ConstructOverallParam("Late_root", "{}", []string{})}), "Graph has ended")
+}
+
+func TestSchemaBuf(t *testing.T) {
+	schema := NewSchema("TestUnit",
+		Field("pts", FIELD_INT64),
+		HiddenField("pid", FIELD_UINT64),
+		Field("ratio", FIELD_FLOAT),
+		Field("name", FIELD_STRING),
+		Field("valid", FIELD_BOOL),
+		Field("child", FIELD_NESTED),
+	)
+	assert.Equal(t, schema, GetSchema("TestUnit"))
+
+	buf := MakeSchemaBuf(schema, []byte{1, 2})
+	buf.SetField("extra", []int{1, 2}, false)
+	buf.SetField("pts", int32(-5), false)
+	buf.SetField("pid", 256, false)
+	buf.SetField("ratio", 3, false)
+	buf.SetField("name", "a,\"b\"", false)
+
+	// Declared fields come first and are converted to the declared types
+	v, _ := buf.GetField("pts")
+	assert.Equal(t, int64(-5), v)
+	v, _ = buf.GetField("pid")
+	assert.Equal(t, uint64(256), v)
+	_, ok := buf.GetField("valid")
+	assert.False(t, ok)
+	assert.Equal(t, "pts,ratio,name,valid,child,extra\n", buf.GetFieldAsString())
+	assert.Equal(t, "-5,3,\"a,\"\"b\"\"\",,,\"[1,2]\"\n", buf.ToString())
+	assert.Equal(t, "3,,-5\n", buf.ToCsv([]string{"ratio", "unknown", "pts"}))
+	assert.NotNil(t, buf.SetField("pid", -1, false))
+	assert.NotNil(t, buf.SetField("name", 1, false))
+	v, _ = buf.GetField("pid")
+	assert.Equal(t, uint64(256), v, "Invalid value should be dropped")
+
+	pts, ok := GetBufFieldAsInt(buf, "pts")
+	assert.True(t, ok)
+	assert.Equal(t, -5, pts)
+	ratio, _ := GetBufFieldAsFloat(buf, "ratio")
+	assert.Equal(t, 3.0, ratio)
+	_, ok = GetBufFieldAsInt(buf, "name")
+	assert.False(t, ok)
+
+	child := MakeSimpleBuf([]byte{})
+	child.SetField("id", 7, false)
+	buf.SetField("child", child, false)
+	buf.SetField("valid", true, false)
+	data, err := json.Marshal(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"pts":-5,"ratio":3,"name":"a,\"b\"","valid":true,"child":{"id":7},"extra":[1,2]}`, string(data))
+
+	// Binary round trip keeps types, hidden fields and the buffer
+	data, err = buf.MarshalBinary()
+	assert.Nil(t, err)
+	restored, err := UnmarshalBuf(data)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2}, restored.GetBuf())
+	assert.Equal(t, buf.ToString(), restored.ToString())
+	v, _ = restored.GetField("pid")
+	assert.Equal(t, uint64(256), v)
+	nested, _ := restored.GetField("child")
+	id, _ := GetBufFieldAsInt64(nested.(CmBuf), "id")
+	assert.Equal(t, int64(7), id)
+
+	_, err = UnmarshalBuf(data[:len(data)-3])
+	assert.NotNil(t, err)
+}
+
+func TestPluginRegistry(t *testing.T) {
+	RegisterPlugin(PluginInfo{
+		Type:        "Registered",
+		Constructor: dummySelector,
+		Description: "A registered plugin",
+		Params: []ParamDef{
+			{Name: "Count", Type: FIELD_INT64, Default: 1},
+			{Name: "Mode", Type: FIELD_STRING, Allowed: []string{"fast", "slow"}},
+			{Name: "Nested", Type: FIELD_NESTED, Fields: []ParamDef{
+				{Name: "Enabled", Type: FIELD_BOOL},
+			}},
+		},
+	})
+	t.Cleanup(func() {
+		registryMtx.Lock()
+		defer registryMtx.Unlock()
+		delete(pluginRegistry, "Registered")
+	})
+	assert.Panics(t, func() { RegisterPlugin(PluginInfo{Type: "Registered"}) })
+
+	info, ok := GetPluginInfo("Registered_1")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "Registered_1", info.Constructor("Registered_1").Name())
+	_, ok = GetPluginInfo("Unregistered_1")
+	assert.Equal(t, false, ok)
+	assert.Contains(t, info.Describe(), "Mode (string). One of fast, slow")
+
+	assert.Nil(t, info.ValidateParam(""))
+	assert.Nil(t, info.ValidateParam(`{"count": 3, "Mode": "slow", "Nested": {"Enabled": true}, "OnError": "stop"}`))
+	assert.NotNil(t, info.ValidateParam(`{"Count": 1.5}`))
+	assert.NotNil(t, info.ValidateParam(`{"Mode": "normal"}`))
+	assert.NotNil(t, info.ValidateParam(`{"Nested": {"Enabled": "yes"}}`))
+	assert.NotNil(t, info.ValidateParam(`{"OnError": "ignore"}`))
+	assert.Equal(t, "unknown parameter Nested.Other", info.ValidateParam(`{"Nested": {"Other": 1}}`).Error())
+	assert.Nil(t, info.ValidateField("Count", "2"))
+	assert.NotNil(t, info.ValidateField("Count", `"2"`))
+
+	err := ValidateGraph([]OverallParams{
+		ConstructOverallParam("Registered_1", `{"Mode": "normal"}`, []string{}),
+	}, nil)
+	errs, ok := err.(GraphErrors)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, INVALID_PARAMETER, errs[0].Kind)
+	assert.Equal(t, []string{"Registered_1"}, errs[0].Plugins)
+}
+
+func TestUnitRecording(t *testing.T) {
+	producer := &dummyProducer{dummyPlugin: dummyPlugin{name: "Producer_root", logger: logging.CreateLogger("Producer_root")}, cnt: 5}
+	recorder := &dummyRecorder{dummyPlugin: dummyPlugin{name: "Recorder_1", logger: logging.CreateLogger("Recorder_1"), role: 1}}
+	selector := func(name string) IPlugin {
+		if name == producer.name {
+			return producer
+		}
+		return recorder
+	}
+
+	outDir := t.TempDir()
+	w := NewWorker()
+	w.UpdateResource(Resource{OutDir: outDir, Record: []string{"Producer_root->Recorder_1"}})
+	err := w.StartService([]OverallParams{
+		ConstructOverallParam("Producer_root", "{}", []string{"Recorder_1"}),
+		ConstructOverallParam("Recorder_1", "{}", []string{}),
+	}, selector)
+	assert.Nil(t, err)
+
+	reader, err := OpenTrace(TracePath(outDir, "Producer_root", "Recorder_1"))
+	assert.Nil(t, err)
+	defer reader.Close()
+	for idx := 0; idx < producer.cnt; idx++ {
+		record, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, idx, record.Seq)
+		assert.Equal(t, "Producer_root", record.InputId)
+		assert.Equal(t, []byte{byte(idx + 1)}, GetBytesInBuf(record.Unit))
+	}
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// Fields of buffers and units are kept
+	path := t.TempDir() + "/unit.trace"
+	writer, err := NewTraceWriter(path)
+	assert.Nil(t, err)
+	schema := NewSchema("TraceTest", Field("pts", FIELD_INT64))
+	buf := MakeSchemaBuf(schema, []byte{1, 2})
+	buf.SetField("pts", 100, false)
+	buf.SetField("label", "a", true)
+	assert.Nil(t, writer.Write(&dummyUnit{buf: buf}, "Dummy_1"))
+	assert.Nil(t, writer.Write(&dummyUnit{}, "Dummy_1"))
+	assert.Nil(t, writer.Close())
+
+	reader, err = OpenTrace(path)
+	assert.Nil(t, err)
+	record, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, schema, record.Unit.GetBuf().(*fieldBuf).schema)
+	assert.Equal(t, "100\n", record.Unit.GetBuf().ToString())
+	label, _ := GetBufFieldAsString(record.Unit.GetBuf(), "label")
+	assert.Equal(t, "a", label)
+	record, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Nil(t, record.Unit.GetBuf())
+
+	// Corrupted record sizes
+	for _, record := range [][]byte{{0x80, 0x80, 0x80, 0x80, 0x40}, {0x0a, 0x01, 0x02}} {
+		assert.Nil(t, os.WriteFile(path, append([]byte(_TRACE_MAGIC), record...), 0644))
+		reader, err = OpenTrace(path)
+		assert.Nil(t, err)
+		_, err = reader.Next()
+		assert.NotNil(t, err)
+		assert.NotEqual(t, io.EOF, err)
+		reader.Close()
+	}
+	assert.Equal(t, "truncated trace", err.Error())
+
+	_, err = OpenTrace(TracePath(outDir, "Recorder_1", "Producer_root"))
+	assert.NotNil(t, err)
+}
+
+func TestMerger(t *testing.T) {
+	makeUnit := func(pts int64) CmUnit {
+		buf := MakeSimpleBuf([]byte{})
+		buf.SetField("pts", pts, false)
+		return &dummyUnit{buf: buf}
+	}
+	merger := Merger("Merger_1")
+	merger.SetCallback(func(s string, reqType WORKER_REQUEST, obj interface{}) {})
+	merger.SetParameter(`{"Clock": "pts", "Tolerance": 1, "Timeout": 100, "Inputs": ["A", "B"]}`)
+	missing := []string{}
+	getGroups := func() [][]string {
+		groups := [][]string{}
+		missing = []string{}
+		for unit := merger.FetchUnit(); unit != nil; unit = merger.FetchUnit() {
+			merged := unit.(*MergedUnit)
+			assert.Equal(t, strings.Join(merged.Inputs(), ","), merged.GetField("inputs"))
+			missing = append(missing, merged.GetField("missing").(string))
+			group := []string{strconv.FormatInt(merged.GetField("clock").(int64), 10)}
+			for _, id := range merged.Inputs() {
+				pts, _ := GetBufFieldAsInt64(merged.GetUnit(id).GetBuf(), "pts")
+				group = append(group, fmt.Sprintf("%s:%d", id, pts))
+			}
+			groups = append(groups, group)
+		}
+		return groups
+	}
+
+	// Wait for B, which is expected from the start
+	merger.DeliverUnit(makeUnit(1000), "A")
+	assert.Equal(t, [][]string{}, getGroups())
+	merger.DeliverUnit(makeUnit(1050), "B")
+	assert.Equal(t, [][]string{{"1000", "A:1000", "B:1050"}}, getGroups())
+
+	// B moves past a unit of A
+	merger.DeliverUnit(makeUnit(4000), "A")
+	merger.DeliverUnit(makeUnit(7000), "A")
+	merger.DeliverUnit(makeUnit(7000), "B")
+	assert.Equal(t, [][]string{{"4000", "A:4000"}, {"7000", "A:7000", "B:7000"}}, getGroups())
+	assert.Equal(t, []string{"B", ""}, missing)
+
+	// B times out
+	merger.DeliverUnit(makeUnit(20000), "A")
+	assert.Equal(t, [][]string{}, getGroups())
+	merger.DeliverUnit(makeUnit(30000), "A")
+	assert.Equal(t, [][]string{{"20000", "A:20000"}}, getGroups())
+
+	// Remaining units are released at the end
+	merger.EndSequence()
+	assert.Equal(t, [][]string{{"30000", "A:30000"}}, getGroups())
+
+	sb := strings.Builder{}
+	merger.PrintInfo(&sb)
+	assert.Contains(t, sb.String(), "Groups: 5, partial: 3")
+
+	// Units are aligned across wrap around
+	merger = Merger("Merger_1")
+	merger.SetCallback(func(s string, reqType WORKER_REQUEST, obj interface{}) {})
+	merger.SetParameter(`{"Tolerance": 1, "Inputs": ["A", "B"]}`)
+	merger.DeliverUnit(makeUnit((1<<33)-45), "A")
+	merger.DeliverUnit(makeUnit(45), "B")
+	merger.DeliverUnit(makeUnit(3000), "A")
+	merger.EndSequence()
+	assert.Equal(t, [][]string{{strconv.Itoa((1 << 33) - 45), "A:8589934547", "B:45"}, {"3000", "A:3000"}}, getGroups())
+
+	frames, ok := timecodeToFrames("01:00:00;10", 25)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(90010), frames)
+	_, ok = timecodeToFrames("", 25)
+	assert.Equal(t, false, ok)
+	assert.Panics(t, func() { Merger("Merger_2").SetParameter(`{"Clock": "utc"}`) })
+}
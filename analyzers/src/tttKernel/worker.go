@@ -1,77 +1,106 @@
 package tttKernel
 
 import (
-	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/tony-507/analyzers/src/logging"
 )
 
-type workerRequest struct {
-	source  string
-	reqType WORKER_REQUEST
-	body    interface{}
-}
-
 // A worker runs a graph to provide a service
-// Assumption: The graph does not contain any cyclic subgraph
+// The graph is validated to be acyclic before it runs
 type Worker struct {
 	logger         logging.Log
-	isRunning      int
-	routineChan    chan struct{}
+	isRunning      int32 // Number of running nodes
+	routineChan    chan struct{} // Closed when all nodes are stopped
+	signals        chan os.Signal
+	done           chan struct{} // Closed to force all nodes to stop
+	ready          chan struct{} // Closed when the graph is set
+	stopOnce       sync.Once
 	nodes          []*graphNode
-	reqChannel     chan workerRequest
+	nodeMap        map[string]*graphNode
+	nodesMtx       sync.Mutex // Guards nodes, nodeMap and isRunning updates as the graph may be changed while running
+	params         []OverallParams
+	selectPlugin   func(string) IPlugin
+	reconfigMtx    sync.Mutex // Serializes reconfiguration, and guards params
 	resourceLoader ResourceLoader
 	statusStore    map[int][]string // Map from msgId to an array of plugin names
-	wg             sync.WaitGroup
+	statusMtx      sync.Mutex
 }
 
 /* APIs for worker */
 
 // Start ttt service
-func (w *Worker) StartService(params []OverallParams, selectPlugin func(string) IPlugin) {
+func (w *Worker) StartService(params []OverallParams, selectPlugin func(string) IPlugin) error {
+	if err := ValidateGraph(params, nil); err != nil {
+		w.logger.Error("Invalid graph:\n%s", err.Error())
+		return err
+	}
+	w.params = append([]OverallParams{}, params...)
+	w.selectPlugin = selectPlugin
 	w.setGraph(buildGraph(params, selectPlugin))
+	close(w.ready)
 
-	go w.runGraph()
-
-	for w.isRunning != 0 {}
-
-	w.wg.Wait()
+	w.runGraph()
+	return nil
 }
 
 func (w *Worker) UpdateResource(resource Resource) {
 	w.resourceLoader.resource = resource
 }
 
-func (w *Worker) StopGraph() {
-	if w.isRunning != 0 {
-		w.logger.Error("Force stop worker due to unexpected exception")
-		for _, pg := range w.nodes {
-			pg.stopPlugin()
+// End the graph gracefully. Root plugins are ended first, and each plugin is ended
+// after all of its parents, so that everything fetched so far reaches the end of the graph.
+func (w *Worker) Shutdown() {
+	for _, node := range w.getNodes() {
+		if node.root {
+			node.pushRequest(nodeRequest{reqType: EOS_REQUEST})
 		}
 	}
-	w.routineChan <- struct{}{}
+}
 
-	w.printInfo()
-	w.isRunning = 0
+// Same as receiving SIGINT, so that calling it again forces the graph to stop.
+// It takes effect when the graph starts running if it is called before.
+func (w *Worker) Interrupt() {
+	select {
+	case w.signals <- syscall.SIGINT:
+	default:
+	}
 }
 
-// Main function for running a graph
-func (w *Worker) runGraph() {
-	w.wg.Add(1)
-	defer w.wg.Done()
-	defer w.StopGraph()
+// Force all nodes to stop. Nodes not yet stopped are ended after their goroutines return.
+func (w *Worker) StopGraph() {
+	w.stopOnce.Do(func() {
+		w.logger.Info("Force stop worker on request")
+		close(w.done)
+	})
+}
 
+// Same as StopGraph, but on an unexpected error
+func (w *Worker) abortGraph() {
+	w.stopOnce.Do(func() {
+		w.logger.Error("Force stop worker due to unexpected exception")
+		close(w.done)
+	})
+}
+
+// Main function for running a graph. Return when all nodes are stopped.
+func (w *Worker) runGraph() {
 	startTime := time.Now()
 	if w.resourceLoader.IsRedundancyEnabled {
 		w.logger.Info("Redundancy monitor is enabled. Output directory %s would be deleted",
 			w.resourceLoader.resource.OutDir)
 		os.RemoveAll(w.resourceLoader.resource.OutDir)
 	}
+	if err := w.openRecorders(); err != nil {
+		w.logger.Error("Fail to record units: %s", err.Error())
+	}
 
 	for _, node := range w.nodes {
 		node.impl.SetParameter(node.m_parameter)
@@ -84,98 +113,232 @@ func (w *Worker) runGraph() {
 	}
 	w.logger.Info("Start up delay: %dms", time.Now().Sub(startTime).Milliseconds())
 
+	w.resourceLoader.Metrics().OnCollect(w.collectMetrics)
+	if addr := w.resourceLoader.resource.MetricsAddr; addr != "" {
+		srv, err := ServeMetrics(addr, w.resourceLoader.Metrics())
+		if err != nil {
+			w.logger.Error("Fail to serve metrics at %s: %s", addr, err.Error())
+		} else {
+			w.logger.Info("Metrics are served at http://%s/metrics", srv.Addr)
+			defer srv.Close()
+		}
+	}
+
+	w.nodesMtx.Lock()
+	for _, node := range w.nodes {
+		node.root = len(node.parent) == 0
+		w.startNode(node)
+	}
+	if len(w.nodes) == 0 {
+		close(w.routineChan)
+	}
+	w.nodesMtx.Unlock()
+
+	signal.Notify(w.signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(w.signals)
+
 	go w.startDiagnostics()
+	go w.handleSignals()
+
+	<-w.routineChan
+
+	for _, node := range sortNodes(w.getNodes()) {
+		if node.m_state == RUNNING {
+			node.m_state = STOPPED
+			node.impl.EndSequence()
+		}
+	}
+	w.printInfo()
+}
+
+// Run a node in its own goroutine. Must be called with nodesMtx held.
+func (w *Worker) startNode(node *graphNode) {
+	atomic.AddInt32(&w.isRunning, 1)
+	go func() {
+		node.run(w.done)
+		w.logger.Trace("Node %s stopped", node.name())
+		w.onNodeStopped(node)
+	}()
+}
+
+// The graph ends when the last node is stopped. Removed nodes are dropped from the graph.
+func (w *Worker) onNodeStopped(node *graphNode) {
+	w.nodesMtx.Lock()
+	defer w.nodesMtx.Unlock()
+	close(node.exited)
+	if node.removed != nil && node.m_state == STOPPED {
+		for idx, n := range w.nodes {
+			if n == node {
+				w.nodes = append(w.nodes[:idx:idx], w.nodes[idx+1:]...)
+				break
+			}
+		}
+		// A plugin of the same name may be added again before this one stops
+		if w.nodeMap[node.name()] == node {
+			delete(w.nodeMap, node.name())
+		}
+	}
+	if atomic.AddInt32(&w.isRunning, -1) == 0 {
+		close(w.routineChan)
+	}
+}
+
+func (w *Worker) getNodes() []*graphNode {
+	w.nodesMtx.Lock()
+	defer w.nodesMtx.Unlock()
+	return append([]*graphNode{}, w.nodes...)
+}
+
+// The first signal ends the graph gracefully, and another one forces it to stop
+func (w *Worker) handleSignals() {
+	sigCnt := 0
+	for {
+		select {
+		case sig := <-w.signals:
+			sigCnt++
+			if sigCnt == 1 {
+				w.logger.Info("Received %v, ending all plugins. Send again to force stop", sig)
+				w.Shutdown()
+			} else {
+				w.StopGraph()
+			}
+		case <-w.routineChan:
+			return
+		}
+	}
+}
 
-	w.handleRequests()
+// Sort nodes in topological order so that parents come before children
+func sortNodes(nodes []*graphNode) []*graphNode {
+	parentCnt := map[*graphNode]int{}
+	queue := []*graphNode{}
+	for _, node := range nodes {
+		parentCnt[node] = len(node.parent)
+		if len(node.parent) == 0 {
+			queue = append(queue, node)
+		}
+	}
+
+	sorted := []*graphNode{}
+	for len(queue) != 0 {
+		node := queue[0]
+		queue = queue[1:]
+		sorted = append(sorted, node)
+		for _, child := range node.children {
+			parentCnt[child]--
+			if parentCnt[child] == 0 {
+				queue = append(queue, child)
+			}
+		}
+	}
+	return sorted
 }
 
 // Diagnostics
 func (w *Worker) startDiagnostics() {
-	w.wg.Add(1)
-	defer w.wg.Done()
-
-	for w.isRunning != 0 {
+	for {
 		select {
 		case <-time.After(10 * time.Second):
 			w.printInfo()
 		case <-w.routineChan:
-			break
+			return
 		}
 	}
 }
 
-func (w *Worker) printInfo() {
-	w.logger.Info("Worker\n\tRunning nodes: %d", w.isRunning)
-	for _, node := range w.nodes {
-		var sb strings.Builder
-		sb.WriteString(fmt.Sprintf("Plugin: %s\n", node.name()))
-		node.printInfo(&sb)
-		w.logger.Info(sb.String())
+// Update metrics of the kernel on each export
+func (w *Worker) collectMetrics() {
+	metrics := w.resourceLoader.Metrics()
+	metrics.Gauge("ttt_running_nodes", "Number of running plugins").Set(float64(atomic.LoadInt32(&w.isRunning)))
+
+	inputLen := metrics.Gauge("ttt_node_input_queue_length", "Units waiting to be delivered to a plugin", "plugin")
+	requestLen := metrics.Gauge("ttt_node_request_queue_length", "Requests waiting to be handled by a plugin", "plugin")
+	for _, node := range w.getNodes() {
+		inputLen.Set(float64(len(node.inputs)), node.name())
+		node.reqMtx.Lock()
+		reqCnt := len(node.requests)
+		node.reqMtx.Unlock()
+		requestLen.Set(float64(reqCnt), node.name())
 	}
 }
 
-// Callback function
-func (w *Worker) onRequestReceived(name string, reqType WORKER_REQUEST, obj interface{}) {
-	request := workerRequest{source: name, reqType: reqType, body: obj}
-	if w.isRunning != 0 {
-		w.reqChannel <- request
+// Diagnostics of all plugins. Empty if the graph is not set yet.
+func (w *Worker) Info() string {
+	select {
+	case <-w.ready:
+	default:
+		return ""
 	}
-}
-
-// Depth-first search
-func (w *Worker) searchNode(name string, curPos *graphNode) *graphNode {
-	var node *graphNode = nil
-	for _, pg := range w.nodes {
-		if pg.name() == name {
-			node = pg
-		}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Running nodes: %d\n", atomic.LoadInt32(&w.isRunning)))
+	for _, node := range w.getNodes() {
+		sb.WriteString(fmt.Sprintf("Plugin: %s\n", node.name()))
+		node.printInfo(&sb)
 	}
-	return node
+	return sb.String()
 }
 
-func (w *Worker) handleRequests() {
-	for {
-		request := <-w.reqChannel
-		w.handleOneRequest(request.source, request.reqType, request.body)
-		if w.isRunning == 0 {
-			break
-		}
+func (w *Worker) printInfo() {
+	w.logger.Info("Worker\n\tRunning nodes: %d", atomic.LoadInt32(&w.isRunning))
+	for _, node := range w.getNodes() {
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("Plugin: %s\n", node.name()))
+		node.printInfo(&sb)
+		w.logger.Info(sb.String())
 	}
 }
 
-func (w *Worker) handleOneRequest(name string, reqType WORKER_REQUEST, obj interface{}) {
+// Callback function. It may be called from any goroutine, so requests are queued to the nodes.
+func (w *Worker) onRequestReceived(name string, reqType WORKER_REQUEST, obj interface{}) {
 	switch reqType {
 	case POST_REQUEST:
 		unit, _ := obj.(CmUnit)
 		w.postRequest(name, unit)
 	case STATUS_LISTEN_REQUEST:
 		if msgId, isInt := obj.(int); isInt {
-			if _, hasKey := w.statusStore[msgId]; hasKey {
-				w.statusStore[msgId] = append(w.statusStore[msgId], name)
-			} else {
-				w.statusStore[msgId] = make([]string, 1)
-				w.statusStore[msgId][0] = name
-			}
+			w.statusMtx.Lock()
+			w.statusStore[msgId] = append(w.statusStore[msgId], name)
+			w.statusMtx.Unlock()
 		} else {
 			panic(fmt.Sprintf("Attempt to listen to a status message with invalid ID: %v", obj))
 		}
 	case STATUS_REQUEST:
 		if unit, isValid := obj.(*CmStatusUnit); isValid {
+			if unit.id >= STATUS_UPDATE_PARAMETER && unit.id <= STATUS_REMOVE_BRANCH {
+				// Reconfiguration waits for nodes, including the one posting the status
+				go w.reconfigure(name, unit)
+				return
+			}
 			w.postStatus(unit)
 		} else {
 			w.logger.Error("Worker error: Receive a status request with invalid unit: %v", obj)
 		}
 	case ERROR_REQUEST:
-		err, _ := obj.(error)
-		w.logger.Error("From %s: %s", name, err.Error())
-		w.StopGraph()
+		err := toPluginError(obj)
+		if err.Severity == SEVERITY_WARNING {
+			w.logger.Warn("From %s: %s", name, err.Error())
+		} else {
+			w.logger.Error("From %s (%s): %s", name, err.Severity.String(), err.Error())
+		}
+		node := w.searchNode(name)
+		if node == nil {
+			w.abortGraph()
+			return
+		}
+		node.pushRequest(nodeRequest{reqType: ERROR_REQUEST, err: err})
 	default:
 		errMsg := fmt.Sprintf("Non-implemented request type %v", reqType)
 		w.logger.Error(errMsg)
-		panic(errors.New(errMsg))
+		panic(errMsg)
 	}
 }
 
+func (w *Worker) searchNode(name string) *graphNode {
+	w.nodesMtx.Lock()
+	defer w.nodesMtx.Unlock()
+	return w.nodeMap[name]
+}
+
 func (w *Worker) postRequest(name string, unit CmUnit) {
 	if unit == nil {
 		return
@@ -187,62 +350,60 @@ func (w *Worker) postRequest(name string, unit CmUnit) {
 	}
 
 	// Check which node this plugin corresponds to
-	node := w.searchNode(name, nil)
+	node := w.searchNode(name)
 	if node == nil {
 		w.logger.Error("Received POST request from unknown node %s", name)
+		return
 	}
 
-	switch reqType {
-	case FETCH_REQUEST:
-		outputUnit := node.fetchUnit()
-		for _, child := range node.children {
-			child.deliverUnit(outputUnit, node.name())
-		}
-	case DELIVER_REQUEST:
-		node.deliverUnit(nil, "worker")
-	case EOS_REQUEST:
-		w.isRunning -= 1
-		w.logger.Trace("Worker receives EOS from %s", node.name())
-		// Trigger EndSequence of children nodes
-		node.stopPlugin()
+	if reqType == EOS_REQUEST {
+		w.logger.Trace("Worker receives EOS from %s", name)
 	}
-
+	node.pushRequest(nodeRequest{reqType: reqType})
 }
 
 func (w *Worker) postStatus(unit CmUnit) {
-	if id, isInt := unit.GetField("id").(int); isInt {
-		if arr, hasKey := w.statusStore[id]; hasKey {
-			for _, name := range arr {
-				node := w.searchNode(name, nil)
-				if node == nil {
-					w.logger.Error("Fail to deliver status to unknown node %s", name)
-				}
-				w.logger.Info("Deliver a status to %s", node.name())
-				node.deliverStatus(unit)
-			}
+	id, isInt := unit.GetField("id").(int)
+	if !isInt {
+		return
+	}
+	w.statusMtx.Lock()
+	names := append([]string{}, w.statusStore[id]...)
+	w.statusMtx.Unlock()
+
+	for _, name := range names {
+		node := w.searchNode(name)
+		if node == nil {
+			w.logger.Error("Fail to deliver status to unknown node %s", name)
+			continue
 		}
+		w.logger.Info("Deliver a status to %s", name)
+		node.pushRequest(nodeRequest{reqType: STATUS_REQUEST, unit: unit})
 	}
 }
 
 func (w *Worker) setGraph(nodeList []*graphNode) {
 	w.nodes = nodeList
-	// Recursively set callback for nodes
 	for _, node := range w.nodes {
-		node.impl.SetCallback(w.onRequestReceived)
+		w.nodeMap[node.name()] = node
+		w.setupNode(node)
 		if strings.Contains(node.name(), "Monitor") {
 			w.resourceLoader.IsRedundancyEnabled = true
 		}
 	}
+}
 
-	isRunning := 0
-	for _, node := range w.nodes {
-		isRunning += len(node.children)
-		if len(node.parent) == 0 {
-			// Root node
-			isRunning += 1
-		}
+func (w *Worker) setupNode(node *graphNode) {
+	node.handler = w.onRequestReceived
+	node.loader = &w.resourceLoader
+	node.stopGraph = w.Shutdown
+	node.impl.SetCallback(node.makeCallback())
+
+	policy, err := parseErrorPolicy(node.m_parameter)
+	if err != nil {
+		w.logger.Warn("%s: %s", node.name(), err.Error())
 	}
-	w.isRunning = isRunning
+	node.policy = policy
 }
 
 func NewWorker() Worker {
@@ -250,7 +411,10 @@ func NewWorker() Worker {
 		logger:         logging.CreateLogger("Worker"),
 		isRunning:      0,
 		routineChan:    make(chan struct{}),
-		reqChannel:     make(chan workerRequest, 50),
+		signals:        make(chan os.Signal, 1),
+		done:           make(chan struct{}),
+		ready:          make(chan struct{}),
+		nodeMap:        map[string]*graphNode{},
 		resourceLoader: CreateResourceLoader(),
 		statusStore:    make(map[int][]string, 0),
 	}